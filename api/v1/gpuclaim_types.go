@@ -1,6 +1,9 @@
 package v1
 
-import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
@@ -8,6 +11,7 @@ import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 // +kubebuilder:printcolumn:name="Req",type=string,JSONPath=.spec.devices.count
 // +kubebuilder:printcolumn:name="Policy",type=string,JSONPath=.spec.devices.policy
 // +kubebuilder:printcolumn:name="Topology",type=string,JSONPath=.spec.topology.mode
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=.status.phase
 // +kubebuilder:printcolumn:name="Allocated",type=string,JSONPath=.status.allocated
 
 // GpuClaim defines a declarative GPU allocation request.
@@ -39,6 +43,12 @@ type DeviceRequest struct {
 	Policy      string `json:"policy,omitempty"`      // contiguous|spread|preferIds
 	PreferIDs   []int  `json:"preferIds,omitempty"`   // optional pinned ids
 	Exclusivity string `json:"exclusivity,omitempty"` // Exclusive|Shared|MIG
+	// Model restricts the claim to nodes labeled gpu.scheduling/model with
+	// this value, like the model= qualifier of an inline claim.
+	Model string `json:"model,omitempty"`
+	// Memory is the GPU memory needed on each device, like the mem=
+	// qualifier of an inline claim.
+	Memory *resource.Quantity `json:"memory,omitempty"`
 }
 
 // TopologyPolicy encodes NVLink bandwidth preferences.
@@ -47,6 +57,18 @@ type TopologyPolicy struct {
 	MinBandwidthGBps int    `json:"minBandwidthGBps,omitempty"`
 }
 
+// Claim phases reported in GpuClaimStatus.Phase.
+const (
+	// ClaimPending means no pod referencing the claim holds GPUs.
+	ClaimPending = "Pending"
+	// ClaimReserved means a pod holds GPU leases but is not bound yet.
+	ClaimReserved = "Reserved"
+	// ClaimBound means the pod holding the leases is bound to the node.
+	ClaimBound = "Bound"
+	// ClaimFailed means the pod holding the leases has failed.
+	ClaimFailed = "Failed"
+)
+
 // GpuClaimStatus reflects scheduler progress.
 type GpuClaimStatus struct {
 	Phase     string `json:"phase,omitempty"` // Pending|Reserved|Bound|Failed
@@ -31,10 +31,19 @@ type Device struct {
 	Island    string   `json:"island,omitempty"` // NVLink island identifier
 }
 
+// MIGInstance is one MIG partition of a device.
+type MIGInstance struct {
+	ID      int    `json:"id"`      // node-unique instance index
+	Device  int    `json:"device"`  // parent Device.ID
+	Profile string `json:"profile"` // e.g. 1g.5gb
+	UUID    string `json:"uuid"`    // MIG-... as accepted by NVIDIA_VISIBLE_DEVICES
+}
+
 // GpuNodeStatusStatus holds aggregated telemetry.
 type GpuNodeStatusStatus struct {
-	Devices []Device `json:"devices,omitempty"`
-	Total   int      `json:"total,omitempty"`
+	Devices      []Device      `json:"devices,omitempty"`
+	MIGInstances []MIGInstance `json:"migInstances,omitempty"`
+	Total        int           `json:"total,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -19,6 +19,11 @@ func (in *DeviceRequest) DeepCopyInto(out *DeviceRequest) {
 		*out = make([]int, len(*in))
 		copy(*out, *in)
 	}
+	if in.Memory != nil {
+		in, out := &in.Memory, &out.Memory
+		x := (*in).DeepCopy()
+		*out = &x
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceRequest.
@@ -220,6 +225,11 @@ func (in *GpuNodeStatusStatus) DeepCopyInto(out *GpuNodeStatusStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MIGInstances != nil {
+		in, out := &in.MIGInstances, &out.MIGInstances
+		*out = make([]MIGInstance, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GpuNodeStatusStatus.
@@ -252,6 +262,21 @@ func (in *Device) DeepCopy() *Device {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MIGInstance) DeepCopyInto(out *MIGInstance) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MIGInstance.
+func (in *MIGInstance) DeepCopy() *MIGInstance {
+	if in == nil {
+		return nil
+	}
+	out := new(MIGInstance)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSelector) DeepCopyInto(out *NodeSelector) {
 	*out = *in
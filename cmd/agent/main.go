@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,15 +11,28 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+var (
+	leaseRenewInterval = flag.Duration("lease-renew-interval", lease.DefaultRenewInterval,
+		"How often to renew the GPU leases held on this node; 0 disables renewal")
+	labelPrefix = flag.String("label-prefix", lease.DefaultLabelPrefix,
+		"Label prefix naming the scheduler instance whose GPU leases are renewed; must match the scheduler's --label-prefix")
 )
 
 func main() {
+	flag.Parse()
+	if err := lease.ValidateLabelPrefix(*labelPrefix); err != nil {
+		klog.Fatalf("%v", err)
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
@@ -44,6 +58,15 @@ func main() {
 		klog.Fatalf("NODE_NAME env missing")
 	}
 
+	// Renewals tell the scheduler's lease GC this node is still alive.
+	if *leaseRenewInterval > 0 {
+		cs, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("build clientset: %v", err)
+		}
+		lease.StartRenewer(ctx, cs.CoordinationV1(), *labelPrefix, nodeName, *leaseRenewInterval)
+	}
+
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
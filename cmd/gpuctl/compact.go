@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// compactLeases folds the per-device GPU leases the scheduler instance with
+// the given label prefix holds for each pod bound to node into one lease per
+// pod, as the scheduler now does at binding. It migrates leases taken before
+// that; running it again changes nothing.
+func compactLeases(ctx context.Context, cs kubernetes.Interface, prefix, node string, out io.Writer) error {
+	leases, err := lease.ListNode(ctx, cs.CoordinationV1(), prefix, node)
+	if err != nil {
+		return fmt.Errorf("list GPU leases: %w", err)
+	}
+	// Leases taken before they shared one namespace live in their pod's, so
+	// a pod's leases are compacted namespace by namespace.
+	type holder struct {
+		ns  string
+		pod types.NamespacedName
+	}
+	seen := map[holder]bool{}
+	var holders []holder
+	for i := range leases {
+		h := holder{ns: leases[i].Namespace, pod: lease.PodOf(&leases[i])}
+		if h.pod.Name != "" && !seen[h] {
+			seen[h] = true
+			holders = append(holders, h)
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].pod != holders[j].pod {
+			return holders[i].pod.String() < holders[j].pod.String()
+		}
+		return holders[i].ns < holders[j].ns
+	})
+
+	var errs []error
+	var total int
+	for _, h := range holders {
+		pod := h.pod
+		n, err := lease.Compact(ctx, cs.CoordinationV1(), prefix, h.ns, node, pod)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("compact leases of pod %s: %w", pod, err))
+			continue
+		}
+		if n > 0 {
+			total += n
+			fmt.Fprintf(out, "compacted %d leases of pod %s\n", n, pod)
+		}
+	}
+	if total == 0 && len(errs) == 0 {
+		fmt.Fprintf(out, "no GPU leases to compact on node %s\n", node)
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func TestCompactLeases(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+	coord := cs.CoordinationV1()
+	for _, id := range []int{0, 1, 2} {
+		if _, err := lease.TryAcquire(ctx, coord, lease.DefaultLabelPrefix, "default", "node-a", "uid-trainer", types.NamespacedName{Namespace: "default", Name: "trainer"}, id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if err := lease.Confirm(ctx, coord, lease.DefaultLabelPrefix, "default", "node-a", types.NamespacedName{Namespace: "default", Name: "trainer"}, "uid-trainer", time.Now()); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if err := lease.AcquireFraction(ctx, coord, lease.DefaultLabelPrefix, "team-b", "node-a", "uid-infer", types.NamespacedName{Namespace: "team-b", Name: "infer"}, "", 3, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := compactLeases(ctx, cs, lease.DefaultLabelPrefix, "node-a", &out); err != nil {
+		t.Fatalf("compactLeases: %v", err)
+	}
+	if out.String() != "compacted 3 leases of pod default/trainer\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	if got := nodeLeases(t, cs, "node-a"); got != 2 {
+		t.Errorf("Expected the pod lease and the share, got %d leases", got)
+	}
+	leases, _ := coord.Leases("default").List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 1 || leases.Items[0].Name != lease.PodLeaseName(lease.DefaultLabelPrefix, "node-a", types.NamespacedName{Namespace: "default", Name: "trainer"}) {
+		t.Errorf("Expected only the pod lease in default, got %v", leases.Items)
+	}
+
+	out.Reset()
+	if err := compactLeases(ctx, cs, lease.DefaultLabelPrefix, "node-a", &out); err != nil {
+		t.Fatalf("compactLeases again: %v", err)
+	}
+	if out.String() != "no GPU leases to compact on node node-a\n" {
+		t.Errorf("Unexpected output on a second run %q", out.String())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// drainGPU cordons GPU allocation on node, evicts the pods holding the GPU
+// leases of the scheduler instance with the given label prefix when evict
+// is set, and releases the leases of the pods evicted or already gone. A
+// lease whose pod is still there is kept and reported, since the pod may
+// still be using the device. Running it again on a drained node changes
+// nothing. Every step is reported on out; failures to evict or
+// release are returned together after the rest is done.
+func drainGPU(ctx context.Context, cs kubernetes.Interface, prefix, node string, evict bool, out io.Writer) error {
+	if err := setCordon(ctx, cs, node, true, out); err != nil {
+		return err
+	}
+	leases, err := lease.ListNode(ctx, cs.CoordinationV1(), prefix, node)
+	if err != nil {
+		return fmt.Errorf("list GPU leases: %w", err)
+	}
+	if len(leases) == 0 {
+		fmt.Fprintf(out, "no GPU leases on node %s\n", node)
+		return nil
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Namespace != leases[j].Namespace {
+			return leases[i].Namespace < leases[j].Namespace
+		}
+		return leases[i].Name < leases[j].Name
+	})
+
+	var errs []error
+	evicted := map[types.NamespacedName]bool{}
+	if evict {
+		tried := map[types.NamespacedName]bool{}
+		for i := range leases {
+			pod := lease.PodOf(&leases[i])
+			if pod.Name == "" || tried[pod] {
+				continue
+			}
+			tried[pod] = true
+			if err := evictPod(ctx, cs, pod); err != nil {
+				errs = append(errs, fmt.Errorf("evict pod %s: %w", pod, err))
+				continue
+			}
+			evicted[pod] = true
+			fmt.Fprintf(out, "evicted pod %s\n", pod)
+		}
+	}
+	for i := range leases {
+		l := &leases[i]
+		pod := lease.PodOf(l)
+		if pod.Name != "" && !evicted[pod] {
+			gone, err := holderGone(ctx, cs, l)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("get pod %s: %w", pod, err))
+				continue
+			}
+			if !gone {
+				fmt.Fprintf(out, "kept lease %s/%s: still held by pod %s\n", l.Namespace, l.Name, pod)
+				continue
+			}
+		}
+		if err := release(ctx, cs, l); err != nil {
+			errs = append(errs, fmt.Errorf("release lease %s/%s: %w", l.Namespace, l.Name, err))
+			continue
+		}
+		fmt.Fprintf(out, "released lease %s/%s held by pod %s\n", l.Namespace, l.Name, pod)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// uncordonGPU lets the scheduler allocate GPUs on node again.
+func uncordonGPU(ctx context.Context, cs kubernetes.Interface, node string, out io.Writer) error {
+	return setCordon(ctx, cs, node, false, out)
+}
+
+// setCordon sets or removes the node's cordon label, skipping the write when
+// the node is already in the wanted state. Uncordoning also removes
+// LabelUnschedulable, which cordons the node just the same.
+func setCordon(ctx context.Context, cs kubernetes.Interface, node string, cordon bool, out io.Writer) error {
+	n, err := cs.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get node: %w", err)
+	}
+	state := "uncordoned"
+	if cordon {
+		state = "cordoned"
+	}
+	if util.NodeCordoned(n) == cordon {
+		fmt.Fprintf(out, "node %s GPU allocation already %s\n", node, state)
+		return nil
+	}
+	labels := map[string]interface{}{util.LabelCordoned: "true"}
+	if !cordon {
+		labels = map[string]interface{}{util.LabelCordoned: nil, util.LabelUnschedulable: nil}
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if _, err := cs.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("label node: %w", err)
+	}
+	fmt.Fprintf(out, "node %s GPU allocation %s\n", node, state)
+	return nil
+}
+
+// evictPod evicts the pod through the eviction API, so PodDisruptionBudgets
+// apply. A pod that is already gone counts as evicted.
+func evictPod(ctx context.Context, cs kubernetes.Interface, pod types.NamespacedName) error {
+	err := cs.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// holderGone reports whether the pod holding l no longer exists, or was
+// recreated under the same name with another UID.
+func holderGone(ctx context.Context, cs kubernetes.Interface, l *coordv1.Lease) (bool, error) {
+	key := lease.PodOf(l)
+	pod, err := cs.CoreV1().Pods(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	holder := l.Spec.HolderIdentity
+	return holder != nil && *holder != string(pod.UID), nil
+}
+
+// release deletes the lease unless it is already gone.
+func release(ctx context.Context, cs kubernetes.Interface, l *coordv1.Lease) error {
+	err := cs.CoordinationV1().Leases(l.Namespace).Delete(ctx, l.Name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
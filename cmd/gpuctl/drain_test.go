@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// drainFixture returns a client with two nodes: node-a, where trainer holds
+// devices 0 and 1 and a share of device 2 is held by infer, and node-b, where
+// other holds device 0.
+func drainFixture(t *testing.T) *fake.Clientset {
+	t.Helper()
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+	)
+	coord := cs.CoordinationV1()
+	for _, id := range []int{0, 1} {
+		if _, err := lease.TryAcquire(ctx, coord, lease.DefaultLabelPrefix, "default", "node-a", "uid-trainer", types.NamespacedName{Namespace: "default", Name: "trainer"}, id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if err := lease.AcquireFraction(ctx, coord, lease.DefaultLabelPrefix, "team-b", "node-a", "uid-infer", types.NamespacedName{Namespace: "team-b", Name: "infer"}, "", 2, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := lease.TryAcquire(ctx, coord, lease.DefaultLabelPrefix, "default", "node-b", "uid-other", types.NamespacedName{Namespace: "default", Name: "other"}, 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	cs.ClearActions()
+	return cs
+}
+
+func evictions(cs *fake.Clientset) []string {
+	var pods []string
+	for _, a := range cs.Actions() {
+		if c, ok := a.(k8stesting.CreateAction); ok && a.GetSubresource() == "eviction" {
+			pods = append(pods, a.GetNamespace()+"/"+c.GetObject().(metav1.Object).GetName())
+		}
+	}
+	return pods
+}
+
+func nodeLeases(t *testing.T, cs *fake.Clientset, node string) int {
+	t.Helper()
+	held, err := lease.ListNode(context.Background(), cs.CoordinationV1(), lease.DefaultLabelPrefix, node)
+	if err != nil {
+		t.Fatalf("ListNode: %v", err)
+	}
+	return len(held)
+}
+
+func TestDrainGPU(t *testing.T) {
+	tests := []struct {
+		name          string
+		evict         bool
+		wantEvictions []string
+	}{
+		{name: "release only"},
+		{name: "evict holders", evict: true, wantEvictions: []string{"default/trainer", "team-b/infer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cs := drainFixture(t)
+			var out bytes.Buffer
+			if err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", tt.evict, &out); err != nil {
+				t.Fatalf("drainGPU: %v", err)
+			}
+
+			node, _ := cs.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+			if !util.NodeCordoned(node) {
+				t.Errorf("Expected node-a to carry %s=true, got labels %v", util.LabelCordoned, node.Labels)
+			}
+			if got := nodeLeases(t, cs, "node-a"); got != 0 {
+				t.Errorf("Expected node-a's leases released, got %d", got)
+			}
+			if got := nodeLeases(t, cs, "node-b"); got != 1 {
+				t.Errorf("Expected node-b's lease kept, got %d", got)
+			}
+			if got := evictions(cs); strings.Join(got, ",") != strings.Join(tt.wantEvictions, ",") {
+				t.Errorf("Expected evictions %v, got %v", tt.wantEvictions, got)
+			}
+			if got := strings.Count(out.String(), "released lease"); got != 3 {
+				t.Errorf("Expected 3 released leases reported, got %d in:\n%s", got, out.String())
+			}
+		})
+	}
+}
+
+func TestDrainGPUIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	cs := drainFixture(t)
+	if err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", true, &bytes.Buffer{}); err != nil {
+		t.Fatalf("drainGPU: %v", err)
+	}
+	cs.ClearActions()
+
+	var out bytes.Buffer
+	if err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", true, &out); err != nil {
+		t.Fatalf("second drainGPU: %v", err)
+	}
+	for _, a := range cs.Actions() {
+		if a.GetVerb() != "get" && a.GetVerb() != "list" {
+			t.Errorf("Expected a drained node to need no writes, got %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+	want := "node node-a GPU allocation already cordoned\nno GPU leases on node node-a\n"
+	if out.String() != want {
+		t.Errorf("Expected output %q, got %q", want, out.String())
+	}
+}
+
+func TestDrainGPUKeepsLeasesOfRunningPods(t *testing.T) {
+	ctx := context.Background()
+	cs := drainFixture(t)
+	// trainer still runs; infer was recreated with a new UID, so its share
+	// is not the new pod's.
+	for _, pod := range []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "default", UID: "uid-trainer"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "infer", Namespace: "team-b", UID: "uid-infer-2"}},
+	} {
+		if _, err := cs.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("create pod: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", false, &out); err != nil {
+		t.Fatalf("drainGPU: %v", err)
+	}
+	if got := nodeLeases(t, cs, "node-a"); got != 2 {
+		t.Errorf("Expected trainer's 2 leases kept, got %d", got)
+	}
+	if got := strings.Count(out.String(), "still held by pod default/trainer"); got != 2 {
+		t.Errorf("Expected trainer's 2 leases reported as held, got %d in:\n%s", got, out.String())
+	}
+	if !strings.Contains(out.String(), "held by pod team-b/infer") || strings.Count(out.String(), "released lease") != 1 {
+		t.Errorf("Expected only infer's share released, got:\n%s", out.String())
+	}
+}
+
+func TestDrainGPUBlockedEviction(t *testing.T) {
+	ctx := context.Background()
+	cs := drainFixture(t)
+	trainer := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "default", UID: "uid-trainer"}}
+	if _, err := cs.CoreV1().Pods("default").Create(ctx, trainer, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+	cs.PrependReactor("create", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "eviction" || a.GetNamespace() != "default" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewTooManyRequests("disruption budget exhausted", 10)
+	})
+
+	var out bytes.Buffer
+	err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", true, &out)
+	if err == nil || !strings.Contains(err.Error(), "default/trainer") {
+		t.Errorf("Expected the blocked eviction of default/trainer to be reported, got %v", err)
+	}
+	if !strings.Contains(out.String(), "evicted pod team-b/infer") {
+		t.Errorf("Expected the other holder to be evicted, got:\n%s", out.String())
+	}
+	if got := nodeLeases(t, cs, "node-a"); got != 2 {
+		t.Errorf("Expected the leases of default/trainer, whose eviction failed, kept, got %d leases", got)
+	}
+}
+
+func TestUncordonGPU(t *testing.T) {
+	ctx := context.Background()
+	cs := drainFixture(t)
+	if err := drainGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", false, &bytes.Buffer{}); err != nil {
+		t.Fatalf("drainGPU: %v", err)
+	}
+	var out bytes.Buffer
+	if err := uncordonGPU(ctx, cs, "node-a", &out); err != nil {
+		t.Fatalf("uncordonGPU: %v", err)
+	}
+	node, _ := cs.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+	if _, ok := node.Labels[util.LabelCordoned]; ok {
+		t.Errorf("Expected the cordon label removed, got labels %v", node.Labels)
+	}
+	if out.String() != "node node-a GPU allocation uncordoned\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestUncordonGPUClearsUnschedulable(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-a",
+		Labels: map[string]string{util.LabelUnschedulable: "true"},
+	}})
+	if err := uncordonGPU(ctx, cs, "node-a", &bytes.Buffer{}); err != nil {
+		t.Fatalf("uncordonGPU: %v", err)
+	}
+	node, _ := cs.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+	if util.NodeCordoned(node) {
+		t.Errorf("Expected the node uncordoned, got labels %v", node.Labels)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"drain"}, {"drain-gpu"}, {"drain-gpu", "node-a", "node-b"}} {
+		var stderr bytes.Buffer
+		if code := run(context.Background(), args, &bytes.Buffer{}, &stderr); code != 2 {
+			t.Errorf("%v: Expected exit code 2, got %d", args, code)
+		}
+		if !strings.Contains(stderr.String(), "Usage: gpuctl") {
+			t.Errorf("%v: Expected usage on stderr, got %q", args, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Command gpuctl runs operator tasks against the GPU scheduler's state.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+const usage = `Usage: gpuctl <command> [flags] <node>
+
+Commands:
+  drain-gpu       Cordon GPU allocation on a node and release the GPU leases of gone or evicted pods (--evict)
+  uncordon-gpu    Allow GPU allocation on a node again
+  compact-leases  Fold the per-device GPU leases of each pod on a node into one
+  reserve-gpu     Reserve GPUs on a node for a time window (--name, --window, --devices)
+`
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes one gpuctl command and returns the process exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	kubeconfig := fs.String("kubeconfig", "", "Path to the kubeconfig file; defaults to KUBECONFIG or ~/.kube/config")
+	labelPrefix := fs.String("label-prefix", lease.DefaultLabelPrefix, "Label prefix naming the scheduler instance whose GPU leases to act on; must match the scheduler's --label-prefix")
+	var evict *bool
+	var reserveName, window, devices, namespace, leaseNamespace *string
+	switch args[0] {
+	case "drain-gpu":
+		evict = fs.Bool("evict", false, "Also evict the pods holding the node's GPU leases, honoring PodDisruptionBudgets")
+	case "reserve-gpu":
+		reserveName = fs.String("name", "", "Name of the reservation, which pods name in their gpu.scheduling/reservation annotation")
+		window = fs.String("window", "", "When the GPUs are reserved: HH:MM-HH:MM daily in UTC, or <start>/<end> in RFC 3339 once")
+		devices = fs.String("devices", "", "Comma-separated ids of the GPUs to reserve")
+		namespace = fs.String("namespace", "default", "Namespace of the pods that may use the reservation")
+		leaseNamespace = fs.String("lease-namespace", lease.DefaultNamespace, "Namespace of the GPU leases; must match the scheduler's --lease-namespace")
+	case "uncordon-gpu", "compact-leases":
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(stderr, "%s takes exactly one node name\n\n%s", args[0], usage)
+		return 2
+	}
+	node := fs.Arg(0)
+	if err := lease.ValidateLabelPrefix(*labelPrefix); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+	var ids []int
+	if reserveName != nil {
+		if *reserveName == "" || *window == "" || *devices == "" {
+			fmt.Fprintf(stderr, "reserve-gpu needs --name, --window and --devices\n\n%s", usage)
+			return 2
+		}
+		var err error
+		if ids, err = util.ParseDeviceIDs(*devices); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 2
+		}
+		if _, err := lease.ParseWindow(*window); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	cs, err := newClient(*kubeconfig)
+	if err != nil {
+		fmt.Fprintf(stderr, "build client: %v\n", err)
+		return 1
+	}
+	switch {
+	case evict != nil:
+		err = drainGPU(ctx, cs, *labelPrefix, node, *evict, stdout)
+	case args[0] == "compact-leases":
+		err = compactLeases(ctx, cs, *labelPrefix, node, stdout)
+	case reserveName != nil:
+		err = reserveGPU(ctx, cs, *labelPrefix, node, *reserveName, *window, *namespace, *leaseNamespace, ids, stdout)
+	default:
+		err = uncordonGPU(ctx, cs, node, stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s %s: %v\n", args[0], node, err)
+		return 1
+	}
+	return 0
+}
+
+// newClient loads the kubeconfig the way kubectl does, unless path names one.
+func newClient(path string) (kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// reserveGPU reserves GPUs ids on node for the named reservation during
+// window, one lease per device in leaseNamespace, so that only the pods in
+// namespace annotated with the reservation's name get them meanwhile. A
+// device already reserved under the name is left as it is, so running it
+// again changes nothing.
+func reserveGPU(ctx context.Context, cs kubernetes.Interface, prefix, node, name, window, namespace, leaseNamespace string, ids []int, out io.Writer) error {
+	var errs []error
+	for _, id := range ids {
+		if err := lease.ReserveWindow(ctx, cs.CoordinationV1(), prefix, leaseNamespace, namespace, node, name, window, id); err != nil {
+			errs = append(errs, fmt.Errorf("reserve GPU %d: %w", id, err))
+			continue
+		}
+		fmt.Fprintf(out, "reserved GPU %d on node %s for %s/%s during %s\n", id, node, namespace, name, window)
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func TestReserveGPU(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+
+	var out bytes.Buffer
+	if err := reserveGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", "nightly", "02:00-06:00", "batch", lease.DefaultNamespace, []int{0, 1}, &out); err != nil {
+		t.Fatalf("reserveGPU: %v", err)
+	}
+	want := "reserved GPU 0 on node node-a for batch/nightly during 02:00-06:00\n" +
+		"reserved GPU 1 on node node-a for batch/nightly during 02:00-06:00\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	for _, id := range []int{0, 1} {
+		name := lease.ReservationLeaseName(lease.DefaultLabelPrefix, "node-a", id, "nightly")
+		if _, err := cs.CoordinationV1().Leases(lease.DefaultNamespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+			t.Errorf("Expected reservation lease %s: %v", name, err)
+		}
+	}
+
+	out.Reset()
+	if err := reserveGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", "nightly", "02:00-06:00", "batch", lease.DefaultNamespace, []int{0}, &out); err != nil {
+		t.Errorf("Expected reserving again to succeed, got %v", err)
+	}
+	if err := reserveGPU(ctx, cs, lease.DefaultLabelPrefix, "node-a", "other", "02:00-06:00", "batch", lease.DefaultNamespace, []int{0}, &out); err != nil {
+		t.Errorf("Expected a second reservation of the device to succeed next to the first, got %v", err)
+	}
+}
+
+func TestRunReserveGPUNeedsFlags(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	for _, args := range [][]string{
+		{"reserve-gpu", "--window", "02:00-06:00", "--devices", "0", "node-a"},
+		{"reserve-gpu", "--name", "nightly", "--window", "2am", "--devices", "0", "node-a"},
+		{"reserve-gpu", "--name", "nightly", "--window", "02:00-06:00", "--devices", "0,x", "node-a"},
+	} {
+		if code := run(context.Background(), args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: Expected exit code 2, got %d", args, code)
+		}
+	}
+}
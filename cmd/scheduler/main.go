@@ -1,20 +1,113 @@
 package main
 
 import (
+	"context"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/component-base/cli"
 	_ "k8s.io/component-base/logs/json/register"
+	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/cmd/kube-scheduler/app"
 
+	"github.com/restack/gpu-scheduler/internal/lease"
 	"github.com/restack/gpu-scheduler/internal/plugin/gpuclaim"
+	"github.com/restack/gpu-scheduler/internal/tracing"
+	"github.com/restack/gpu-scheduler/internal/util"
 )
 
+// traceFlushTimeout bounds how long exiting waits for buffered spans.
+const traceFlushTimeout = 5 * time.Second
+
 func main() {
+	opts := &gpuclaim.Options{}
 	command := app.NewSchedulerCommand(
-		app.WithPlugin(gpuclaim.Name, gpuclaim.New),
+		app.WithPlugin(gpuclaim.Name, gpuclaim.NewFactory(opts)),
 	)
+	command.Flags().DurationVar(&opts.LeaseGCInterval, "lease-gc-interval", lease.DefaultGCInterval,
+		"How often to delete GPU leases whose pods are gone or finished. Non-positive values use the default.")
+	command.Flags().Float64Var(&opts.LeaseGCJitter, "lease-gc-jitter", lease.DefaultGCJitter,
+		"Fraction of --lease-gc-interval by which each wait between lease GC passes varies either way, e.g. 0.1 for ±10%. 0 disables jitter.")
+	command.Flags().DurationVar(&opts.LeaseGCGrace, "lease-gc-grace", lease.DefaultGCGrace,
+		"How long a GPU lease's pod must be continuously missing before the lease is deleted. 0 deletes on the first miss.")
+	command.Flags().DurationVar(&opts.LeaseGCUnknownGrace, "lease-gc-unknown-grace", lease.DefaultGCUnknownGrace,
+		"How long a GPU lease's pod may stay in phase Unknown, usually because its node was lost, before the lease is deleted.")
+	command.Flags().Float64Var(&opts.LeaseGCDeleteQPS, "lease-gc-delete-qps", lease.DefaultDeleteQPS,
+		"Maximum rate of GPU lease deletions by the lease GC.")
+	command.Flags().IntVar(&opts.LeaseGCDeleteBurst, "lease-gc-delete-burst", lease.DefaultDeleteBurst,
+		"Burst of GPU lease deletions allowed above --lease-gc-delete-qps.")
+	command.Flags().IntVar(&opts.LeaseGCWorkers, "lease-gc-workers", lease.DefaultGCWorkers,
+		"Number of GPU leases the lease GC checks concurrently.")
+	command.Flags().BoolVar(&opts.LeaseGCLeaderElect, "lease-gc-leader-elect", true,
+		"Run the lease GC only on the scheduler replica holding the lease GC election lease.")
+	command.Flags().StringVar(&opts.LeaseGCElection.LeaseName, "lease-gc-leader-elect-lease-name", lease.DefaultElectionLeaseName,
+		"Name of the lease the lease GC elects a leader with.")
+	command.Flags().StringVar(&opts.LeaseGCElection.LeaseNamespace, "lease-gc-leader-elect-lease-namespace", lease.DefaultElectionLeaseNamespace,
+		"Namespace of the lease the lease GC elects a leader with.")
+	command.Flags().DurationVar(&opts.LeaseGCElection.LeaseDuration, "lease-gc-leader-elect-lease-duration", lease.DefaultLeaseDuration,
+		"How long a follower waits after the last renewal before taking over the lease GC.")
+	command.Flags().DurationVar(&opts.LeaseGCElection.RenewDeadline, "lease-gc-leader-elect-renew-deadline", lease.DefaultRenewDeadline,
+		"How long the lease GC leader retries renewing before it stops collecting. Must be less than the lease duration.")
+	command.Flags().DurationVar(&opts.LeaseGCElection.RetryPeriod, "lease-gc-leader-elect-retry-period", lease.DefaultRetryPeriod,
+		"How often lease GC replicas try to acquire or renew the election lease.")
+	command.Flags().BoolVar(&opts.LeaseGCDryRun, "lease-gc-dry-run", false,
+		"Log the GPU leases the lease GC would delete, and count them in gpu_lease_gc_would_delete_total, without deleting them.")
+	command.Flags().IntVar(&opts.LeaseGCStaleRenewals, "lease-gc-stale-renewals", lease.DefaultStaleRenewals,
+		"Collect a GPU lease once this many lease durations pass without the node agent renewing it and its node is NotReady or its pod is not running; 0 disables the check.")
+	command.Flags().DurationVar(&opts.LeaseGCBindTimeout, "lease-gc-bind-timeout", lease.DefaultGCBindTimeout,
+		"Collect a GPU lease whose pod is still Pending and unbound this long after Reserve; keep it above gangTimeoutSeconds. 0 disables the check.")
+	command.Flags().BoolVar(&opts.LeaseGCClearStaleAllocations, "lease-gc-clear-stale-allocations", false,
+		"When a GPU lease is collected because its pod was recreated with a new UID, also remove the old allocation annotations from the new pod, unless it holds leases of its own.")
+	command.Flags().BoolVar(&opts.LeaseGCSoftReclaim, "lease-gc-soft-reclaim", false,
+		"Before collecting a GPU lease whose pod was recreated with a new UID, mark it gpu.scheduling/reclaim-requested=true and delete it only on a later pass if still marked, so a controller can step in.")
+	command.Flags().StringVar(&opts.LeaseNamespace, "lease-namespace", lease.DefaultNamespace,
+		"Namespace the GPU leases of pods in every namespace are created in, so that each device has one lease name cluster-wide.")
+	command.Flags().StringSliceVar(&opts.LeaseGCNamespaces, "gc-namespaces", nil,
+		"Comma-separated namespaces whose GPU leases the lease GC lists and collects, for schedulers not allowed to list leases cluster-wide; the GC also collects the leases in --lease-namespace of pods in them, and the scheduler watches and lists leases only there and in --lease-namespace. Empty collects in every namespace.")
+	command.Flags().DurationVar(&opts.APICallTimeout, "api-call-timeout", lease.DefaultAPICallTimeout,
+		"How long each API server call of the plugin and the lease GC may take before it is abandoned; 0 waits as long as the scheduling cycle or GC pass allows.")
+	command.Flags().BoolVar(&opts.ClaimController, "claim-controller", true,
+		"Run the controller that reports each GpuClaim's node, devices and phase on its status. With --lease-gc-leader-elect it runs on the replica leading the lease GC.")
+	command.Flags().BoolVar(&opts.DefragRebalance, "defrag-rebalance", false,
+		"When a whole-GPU claim only fails because the free GPUs are scattered across nodes, evict pods of lower priority that fit on other nodes to gather enough GPUs on one.")
+	command.Flags().BoolVar(&opts.PodGroups, "pod-groups", false,
+		"Schedule the pods of a coscheduling PodGroup (scheduling.x-k8s.io, named by the scheduling.x-k8s.io/pod-group label, or scheduling.sigs.k8s.io, named by the legacy pod-group.scheduling.sigs.k8s.io label) as a gang of the group's minMember, instead of reading the gpu.scheduling/gang annotations.")
+	command.Flags().StringVar(&opts.DeviceSelectionPolicy, "device-selection-policy", gpuclaim.DeviceSelectionLowest,
+		"Which of a node's free GPUs Reserve takes: lowest ids, mru for the most recently freed, or lru for the least recently freed.")
+	command.Flags().StringVar(&opts.AuditLog, "audit-log", "",
+		"Record every GPU allocation and release as a JSON line appended to this file, or to stdout for -; empty records nothing.")
+	command.Flags().StringVar(&opts.GPUVendor, "gpu-vendor", util.DefaultVendor,
+		"GPU vendor whose extended resource holds node GPU capacity and container GPU requests: "+strings.Join(util.VendorNames(), ", ")+".")
+	command.Flags().StringVar(&opts.DeviceIDFormat, "device-id-format", gpuclaim.DeviceIDIndex,
+		"How containers are told their GPUs: index, or uuid to use the node's gpu.scheduling/device-uuids annotation, which stays stable when devices renumber.")
+	command.Flags().StringVar(&opts.LabelPrefix, "label-prefix", lease.DefaultLabelPrefix,
+		"Label prefix naming this scheduler instance: a non-default prefix goes in front of its GPU lease names and is the value of their gpu.scheduling/managed label. Scheduler instances sharing a cluster need distinct prefixes so they neither count nor collect each other's leases.")
+	command.Flags().IntVar(&opts.MaxGPUsPerPod, "max-gpus-per-pod", 0,
+		"Reject pods claiming more GPUs than this, in case the webhook let them through; 0 sets no limit. MIG instances are not counted.")
+	command.Flags().StringVar(&opts.SimulateAddr, "simulate-addr", "",
+		"Listen address, e.g. :10260, of the unauthenticated POST /simulate endpoint that predicts the node and devices a claim would get; empty disables it.")
+	command.Flags().StringVar(&opts.AdminAddr, "admin-addr", "",
+		"Listen address, e.g. :10261, of the unauthenticated GET /inventory endpoint that lists each node's free and leased devices as the scheduler sees them, including leases Reserve took that the informer has not reported yet; empty disables it. May equal --simulate-addr.")
+	command.Flags().BoolVar(&opts.DRAResourceClaims, "dra-resource-claims", false,
+		"Allocate GPUs for pods whose resource.k8s.io ResourceClaims request --dra-device-class, and publish the results on the claims' status.")
+	command.Flags().StringVar(&opts.DRADeviceClass, "dra-device-class", gpuclaim.DefaultDRADeviceClass,
+		"DeviceClass of the ResourceClaims allocated with --dra-resource-claims.")
+	command.Flags().StringVar(&opts.DRADriver, "dra-driver", gpuclaim.DefaultDRADriver,
+		"Driver named in the allocation results published with --dra-resource-claims.")
+
+	// Tracing is configured through the OTEL_* environment variables and is
+	// off unless an OTLP endpoint is set.
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		klog.ErrorS(err, "Tracing disabled")
+	}
 
 	code := cli.Run(command)
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	if err := shutdownTracing(ctx); err != nil {
+		klog.ErrorS(err, "Failed to flush traces")
+	}
+	cancel()
 	os.Exit(code)
 }
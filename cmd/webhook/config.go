@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// webhookSettings are the settings an operator can change without restarting
+// the webhook. They are replaced as a whole on reload, and each request loads
+// them once, so a request in flight keeps the settings it started with.
+type webhookSettings struct {
+	patchOpts patchOptions
+	nsFilter  namespaceFilter
+}
+
+var settings atomic.Pointer[webhookSettings]
+
+// currentSettings returns the settings last loaded, or zero settings before
+// the first load.
+func currentSettings() *webhookSettings {
+	if s := settings.Load(); s != nil {
+		return s
+	}
+	return &webhookSettings{}
+}
+
+// fileConfig is the content of --config-file, typically a mounted ConfigMap.
+// A field left out keeps the value of its command-line flag.
+type fileConfig struct {
+	NamespaceAllowlist []string `json:"namespaceAllowlist,omitempty"`
+	NamespaceDenylist  []string `json:"namespaceDenylist,omitempty"`
+	InjectEnvVars      []string `json:"injectEnvVars,omitempty"`
+	OnConflict         string   `json:"onConflict,omitempty"`
+}
+
+// readFileConfig parses the config file at path; an empty path is an empty
+// config. Unknown fields are rejected, so a typo is not silently ignored.
+func readFileConfig(path string) (fileConfig, error) {
+	var fc fileConfig
+	if path == "" {
+		return fc, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	if err := yaml.UnmarshalStrict(raw, &fc); err != nil {
+		return fc, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return fc, nil
+}
+
+// configReloader rebuilds the settings from the config file, on request and
+// whenever the file changes.
+type configReloader struct {
+	path string
+	// build turns the file's content into settings.
+	build func(fileConfig) (*webhookSettings, error)
+
+	// mu keeps reloads in order, so an older file never wins over a newer.
+	mu sync.Mutex
+}
+
+// reload reads the config file and swaps in the settings built from it. On
+// error the settings in use stay.
+func (r *configReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fc, err := readFileConfig(r.path)
+	if err != nil {
+		return err
+	}
+	s, err := r.build(fc)
+	if err != nil {
+		return err
+	}
+	settings.Store(s)
+	klog.InfoS("Loaded webhook configuration", "file", r.path)
+	return nil
+}
+
+// serveReload reloads the configuration on a POST to /reload.
+func (r *configReloader) serveReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		klog.ErrorS(err, "Failed to reload webhook configuration")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// watch reloads the configuration whenever the config file changes, until
+// ctx is done. The file's directory is watched, not the file: a ConfigMap
+// volume updates its files by swapping a symlink in that directory.
+func (r *configReloader) watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(r.path)); err != nil {
+		w.Close()
+		return fmt.Errorf("watch %s: %w", filepath.Dir(r.path), err)
+	}
+	name := filepath.Base(r.path)
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if base := filepath.Base(ev.Name); base != name && !strings.HasPrefix(base, "..") {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					klog.ErrorS(err, "Failed to reload webhook configuration")
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				klog.ErrorS(err, "Watching webhook configuration failed")
+			}
+		}
+	}()
+	return nil
+}
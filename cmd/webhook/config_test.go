@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	useSettings(t, webhookSettings{})
+	defer func(r crclient.Reader) { claims = r }(claims)
+
+	// Each GpuClaim lookup reports in on started and waits for release, so
+	// the test can reload the configuration while a request is in flight.
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme: %v", err)
+	}
+	claims = crfake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c crclient.WithWatch, key crclient.ObjectKey, obj crclient.Object, opts ...crclient.GetOption) error {
+			started <- struct{}{}
+			<-release
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "injectEnvVars: [OLD_DEVICES]\n")
+	config := &configReloader{path: path, build: func(fc fileConfig) (*webhookSettings, error) {
+		s, err := settingsFromFlags(fc)
+		if s != nil {
+			s.patchOpts.injectLimits = true
+		}
+		return s, err
+	}}
+	if err := config.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: map[string]string{util.AnnoClaim: "training"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+	}
+	inFlight := make(chan string)
+	go func() {
+		inFlight <- string(review(t, mutate, pod).Patch)
+	}()
+	<-started
+
+	writeConfig(t, path, "injectEnvVars: [NEW_DEVICES]\nnamespaceDenylist: [kube-system]\n")
+	rec := httptest.NewRecorder()
+	config.serveReload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected /reload to succeed, got %d %s", rec.Code, rec.Body)
+	}
+	close(release)
+
+	if patch := <-inFlight; !strings.Contains(patch, "OLD_DEVICES") || strings.Contains(patch, "NEW_DEVICES") {
+		t.Errorf("Expected the in-flight request to keep the old settings, got %s", patch)
+	}
+	if patch := string(review(t, mutate, pod).Patch); !strings.Contains(patch, "NEW_DEVICES") || strings.Contains(patch, "OLD_DEVICES") {
+		t.Errorf("Expected the next request to use the new settings, got %s", patch)
+	}
+	pod.Namespace = "kube-system"
+	if resp := review(t, mutate, pod); resp.Patch != nil {
+		t.Errorf("Expected the reloaded denylist to skip kube-system, got %s", resp.Patch)
+	}
+}
+
+func TestReloadConfigInvalid(t *testing.T) {
+	useSettings(t, webhookSettings{})
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "onConflict: skip\n")
+	config := &configReloader{path: path, build: settingsFromFlags}
+	if err := config.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	for _, content := range []string{
+		"onConflict: ignore\n",
+		"injectEnvVar: [CUDA_VISIBLE_DEVICES]\n",
+		"injectEnvVars: CUDA_VISIBLE_DEVICES: 0\n",
+	} {
+		writeConfig(t, path, content)
+		rec := httptest.NewRecorder()
+		config.serveReload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%q: Expected /reload to fail, got %d", content, rec.Code)
+		}
+		if got := currentSettings().patchOpts.onConflict; got != conflictSkip {
+			t.Errorf("%q: Expected the previous settings to stay, got on-conflict %q", content, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	config.serveReload(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /reload to be refused, got %d", rec.Code)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	useSettings(t, webhookSettings{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "namespaceAllowlist: [ml]\n")
+	config := &configReloader{path: path, build: settingsFromFlags}
+	if err := config.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err := config.watch(ctx); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	if currentSettings().nsFilter.allowed("research") {
+		t.Fatalf("Expected research to be outside the allowlist")
+	}
+
+	writeConfig(t, path, "namespaceAllowlist: [ml, research]\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for !currentSettings().nsFilter.allowed("research") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the changed file to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// namespaces reads namespaces' default claims from a synced cache. Nil
+// leaves pods asking for one unchanged.
+var namespaces corelisters.NamespaceLister
+
+// defaultClaim returns the claim a pod in namespace gets from the
+// namespace's util.AnnoDefaultClaim, or "" when the pod sets its own claim
+// or does not carry util.AnnoUseDefaultClaim. A pod asking for a default its
+// namespace does not set gets no claim and a warning instead.
+func defaultClaim(namespace string, pod *corev1.Pod) (claim, warning string, err error) {
+	if namespaces == nil || pod.Annotations[util.AnnoClaim] != "" || pod.Annotations[util.AnnoUseDefaultClaim] != "true" {
+		return "", "", nil
+	}
+	ns, err := namespaces.Get(namespace)
+	if apierrors.IsNotFound(err) {
+		return "", "", fmt.Errorf("namespace %s is not in the cache yet", namespace)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if claim = ns.Annotations[util.AnnoDefaultClaim]; claim == "" {
+		return "", fmt.Sprintf("pod asks for its namespace's default GPU claim, but namespace %s sets no %s annotation", namespace, util.AnnoDefaultClaim), nil
+	}
+	return claim, "", nil
+}
+
+// newNamespaceLister starts a namespace informer and waits for its cache to
+// sync, so a default set before the webhook started is never missed.
+func newNamespaceLister(ctx context.Context, cfg *rest.Config) (corelisters.NamespaceLister, error) {
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactory(cs, 0)
+	lister := factory.Core().V1().Namespaces().Lister()
+	factory.Start(ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("%v cache did not sync", typ)
+		}
+	}
+	return lister, nil
+}
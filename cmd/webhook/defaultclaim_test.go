@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestMutateNamespaceDefaultClaim(t *testing.T) {
+	defer func(l corelisters.NamespaceLister) { namespaces = l }(namespaces)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	_ = indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ml", Annotations: map[string]string{util.AnnoDefaultClaim: "1"}}})
+	_ = indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+	namespaces = corelisters.NewNamespaceLister(indexer)
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	marker := map[string]string{util.AnnoUseDefaultClaim: "true"}
+	tests := []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		wantClaim   string
+		wantPatched bool
+		wantWarning bool
+	}{
+		{name: "default applied", namespace: "ml", annotations: marker, wantClaim: "1", wantPatched: true, wantWarning: true},
+		{name: "empty claim gets the default", namespace: "ml", annotations: map[string]string{util.AnnoUseDefaultClaim: "true", util.AnnoClaim: ""}, wantClaim: "1", wantPatched: true, wantWarning: true},
+		{name: "explicit claim overrides", namespace: "ml", annotations: map[string]string{util.AnnoUseDefaultClaim: "true", util.AnnoClaim: "2"}, wantPatched: true},
+		{name: "no marker", namespace: "ml", annotations: map[string]string{"team": "ml"}},
+		{name: "namespace without a default", namespace: "web", annotations: marker, wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: tt.namespace, Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+			}
+			resp := review(t, mutate, pod)
+			if !resp.Allowed {
+				t.Fatalf("Expected the pod to be admitted, got %v", resp.Result)
+			}
+			if (len(resp.Warnings) > 0) != tt.wantWarning {
+				t.Errorf("Expected warning %v, got %v", tt.wantWarning, resp.Warnings)
+			}
+			if (resp.Patch != nil) != tt.wantPatched {
+				t.Fatalf("Expected patched %v, got patch %s", tt.wantPatched, resp.Patch)
+			}
+			var patch []map[string]interface{}
+			if resp.Patch != nil {
+				if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+					t.Fatalf("decode patch: %v", err)
+				}
+			}
+			i := slices.IndexFunc(patch, func(op map[string]interface{}) bool {
+				return op["path"] == "/metadata/annotations/gpu.scheduling~1claim"
+			})
+			switch {
+			case tt.wantClaim == "" && i >= 0:
+				t.Errorf("Expected the claim to be left alone, got %v", patch[i])
+			case tt.wantClaim != "" && (i < 0 || !maps.Equal(patch[i], map[string]interface{}{"op": "add", "path": "/metadata/annotations/gpu.scheduling~1claim", "value": tt.wantClaim})):
+				t.Errorf("Expected the claim %q to be added, got %v", tt.wantClaim, patch)
+			}
+		})
+	}
+}
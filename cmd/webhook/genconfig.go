@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Paths the webhook serves admission reviews on.
+const (
+	mutatePath         = "/mutate"
+	validatePath       = "/validate"
+	mutateWorkloadPath = "/mutate-workload"
+)
+
+// namespaceNameLabel is the label the API server sets on every namespace to
+// its name, which the namespace lists are turned into selectors on.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// genConfigOptions describes the webhook configurations genConfig prints.
+type genConfigOptions struct {
+	// name is the name of the configuration objects.
+	name string
+	// service, namespace and port address the webhook's Service.
+	service   string
+	namespace string
+	port      int32
+	// caBundle is the PEM bundle the API server verifies the webhook with.
+	caBundle      []byte
+	failurePolicy admregv1.FailurePolicyType
+	// allow and deny are the namespace lists, as for newNamespaceFilter.
+	allow, deny string
+	// workloads adds the webhook for Deployments and StatefulSets.
+	workloads bool
+	// validating adds the ValidatingWebhookConfiguration.
+	validating bool
+}
+
+// genConfig is the gen-config command. It parses args with the webhook's
+// own flags plus a few describing its Service, and writes the webhook
+// configurations matching them to w as YAML, for installs without the Helm
+// chart.
+func genConfig(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("gen-config", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+	name := fs.String("name", "gpu-scheduler-webhook", "Name of the generated webhook configurations")
+	service := fs.String("service-name", "gpu-scheduler-webhook", "Name of the Service in front of the webhook")
+	namespace := fs.String("service-namespace", "default", "Namespace of the Service in front of the webhook")
+	port := fs.Int("service-port", 0, "Port of the Service in front of the webhook; 0 uses the port of --addr")
+	caFile := fs.String("ca-file", "", "PEM file with the CA that signed the webhook's certificate; empty uses --tls-cert-file, for a self-signed certificate")
+	workloads := fs.Bool("workloads", false, "Also register the webhook for Deployments and StatefulSets")
+	validating := fs.Bool("validating", true, "Also print the ValidatingWebhookConfiguration")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	opts := genConfigOptions{
+		name:       *name,
+		service:    *service,
+		namespace:  *namespace,
+		workloads:  *workloads,
+		validating: *validating,
+	}
+	switch policy := admregv1.FailurePolicyType(*failurePolicy); policy {
+	case admregv1.Ignore, admregv1.Fail:
+		opts.failurePolicy = policy
+	default:
+		return fmt.Errorf("invalid --failure-policy %q: must be %s or %s", *failurePolicy, admregv1.Ignore, admregv1.Fail)
+	}
+	if *port == 0 {
+		_, p, err := net.SplitHostPort(*addr)
+		if err != nil {
+			return fmt.Errorf("invalid --addr %q: %w", *addr, err)
+		}
+		if *port, err = strconv.Atoi(p); err != nil {
+			return fmt.Errorf("invalid --addr %q: port is not a number", *addr)
+		}
+	}
+	if *port <= 0 || *port > 65535 {
+		return fmt.Errorf("invalid service port %d", *port)
+	}
+	opts.port = int32(*port)
+	fc, err := readFileConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("load configuration: %w", err)
+	}
+	opts.allow, opts.deny = namespaceLists(fc)
+	path := *caFile
+	if path == "" {
+		path = *tlsCert
+	}
+	if opts.caBundle, err = os.ReadFile(path); err != nil {
+		return fmt.Errorf("read CA: %w", err)
+	}
+	if block, _ := pem.Decode(opts.caBundle); block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("no PEM certificate in %s", path)
+	}
+
+	objs := []any{mutatingConfig(opts)}
+	if opts.validating {
+		objs = append(objs, validatingConfig(opts))
+	}
+	for i, obj := range objs {
+		raw, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutatingConfig returns the MutatingWebhookConfiguration for opts: pods,
+// their ephemeral containers and, with opts.workloads, Deployments and
+// StatefulSets.
+func mutatingConfig(opts genConfigOptions) *admregv1.MutatingWebhookConfiguration {
+	config := &admregv1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admregv1.SchemeGroupVersion.String(), Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.name},
+		Webhooks: []admregv1.MutatingWebhook{
+			webhookFor(opts, "pods.gpu-scheduler.svc", mutatePath,
+				namespacedRule("", []string{"pods"}, admregv1.Create),
+				namespacedRule("", []string{"pods/ephemeralcontainers"}, admregv1.Update)),
+		},
+	}
+	if opts.workloads {
+		config.Webhooks = append(config.Webhooks, webhookFor(opts, "workloads.gpu-scheduler.svc", mutateWorkloadPath,
+			namespacedRule("apps", []string{"deployments", "statefulsets"}, admregv1.Create, admregv1.Update)))
+	}
+	return config
+}
+
+// validatingConfig returns the ValidatingWebhookConfiguration for opts,
+// which checks pods as they are created and updated.
+func validatingConfig(opts genConfigOptions) *admregv1.ValidatingWebhookConfiguration {
+	m := webhookFor(opts, "validate.pods.gpu-scheduler.svc", validatePath, namespacedRule("", []string{"pods"}, admregv1.Create, admregv1.Update))
+	return &admregv1.ValidatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: admregv1.SchemeGroupVersion.String(), Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.name},
+		Webhooks: []admregv1.ValidatingWebhook{{
+			Name:                    m.Name,
+			ClientConfig:            m.ClientConfig,
+			Rules:                   m.Rules,
+			FailurePolicy:           m.FailurePolicy,
+			NamespaceSelector:       m.NamespaceSelector,
+			SideEffects:             m.SideEffects,
+			AdmissionReviewVersions: m.AdmissionReviewVersions,
+		}},
+	}
+}
+
+// webhookFor returns the webhook name, sending the requests rules match to
+// path on the webhook's Service. Validating webhooks are built from it too,
+// as they share these fields.
+func webhookFor(opts genConfigOptions, name, path string, rules ...admregv1.RuleWithOperations) admregv1.MutatingWebhook {
+	none := admregv1.SideEffectClassNone
+	return admregv1.MutatingWebhook{
+		Name: name,
+		ClientConfig: admregv1.WebhookClientConfig{
+			Service: &admregv1.ServiceReference{
+				Name:      opts.service,
+				Namespace: opts.namespace,
+				Path:      &path,
+				Port:      &opts.port,
+			},
+			CABundle: opts.caBundle,
+		},
+		Rules:                   rules,
+		FailurePolicy:           &opts.failurePolicy,
+		NamespaceSelector:       namespaceSelector(opts.allow, opts.deny),
+		SideEffects:             &none,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+	}
+}
+
+// namespacedRule matches the namespaced resources of the core or another
+// API group's v1 on the given operations.
+func namespacedRule(group string, resources []string, ops ...admregv1.OperationType) admregv1.RuleWithOperations {
+	scope := admregv1.NamespacedScope
+	return admregv1.RuleWithOperations{
+		Operations: ops,
+		Rule: admregv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{"v1"},
+			Resources:   resources,
+			Scope:       &scope,
+		},
+	}
+}
+
+// namespaceSelector turns the namespace lists into a selector on namespace
+// names that admits what newNamespaceFilter does, so the API server does not
+// call the webhook for pods it would leave alone. It is nil when both lists
+// are empty.
+func namespaceSelector(allow, deny string) *metav1.LabelSelector {
+	var exprs []metav1.LabelSelectorRequirement
+	if names := splitList(allow); len(names) > 0 {
+		exprs = append(exprs, metav1.LabelSelectorRequirement{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpIn, Values: slices.Sorted(slices.Values(names))})
+	}
+	if names := splitList(deny); len(names) > 0 {
+		exprs = append(exprs, metav1.LabelSelectorRequirement{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: slices.Sorted(slices.Values(names))})
+	}
+	if exprs == nil {
+		return nil
+	}
+	return &metav1.LabelSelector{MatchExpressions: exprs}
+}
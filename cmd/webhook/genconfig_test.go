@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestGenConfig(t *testing.T) {
+	defer func(a, allow, deny, cert, policy, config string) {
+		*addr, *nsAllowlist, *nsDenylist, *tlsCert, *failurePolicy, *configFile = a, allow, deny, cert, policy, config
+	}(*addr, *nsAllowlist, *nsDenylist, *tlsCert, *failurePolicy, *configFile)
+
+	dir := t.TempDir()
+	certFile, _ := writeTestCert(t, dir)
+	ca, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	writeConfig(t, configPath, "namespaceDenylist: [kube-system, gpu-system]\n")
+
+	podRules := []admregv1.RuleWithOperations{
+		namespacedRule("", []string{"pods"}, admregv1.Create),
+		namespacedRule("", []string{"pods/ephemeralcontainers"}, admregv1.Update),
+	}
+	workloadRules := []admregv1.RuleWithOperations{
+		namespacedRule("apps", []string{"deployments", "statefulsets"}, admregv1.Create, admregv1.Update),
+	}
+	tests := []struct {
+		name          string
+		args          []string
+		wantSelector  *metav1.LabelSelector
+		wantRules     [][]admregv1.RuleWithOperations
+		wantPort      int32
+		wantPolicy    admregv1.FailurePolicyType
+		wantValidator bool
+	}{
+		{
+			name:          "defaults",
+			args:          []string{"--tls-cert-file=" + certFile},
+			wantRules:     [][]admregv1.RuleWithOperations{podRules},
+			wantPort:      8443,
+			wantPolicy:    admregv1.Fail,
+			wantValidator: true,
+		},
+		{
+			name: "namespace lists become selectors",
+			args: []string{"--tls-cert-file=" + certFile, "--namespace-allowlist=team-b, team-a", "--namespace-denylist=team-b", "--addr=:9443", "--failure-policy=Ignore"},
+			wantSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpIn, Values: []string{"team-a", "team-b"}},
+				{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"team-b"}},
+			}},
+			wantRules:     [][]admregv1.RuleWithOperations{podRules},
+			wantPort:      9443,
+			wantPolicy:    admregv1.Ignore,
+			wantValidator: true,
+		},
+		{
+			name: "config file lists win over flags",
+			args: []string{"--ca-file=" + certFile, "--tls-cert-file=/missing", "--namespace-denylist=team-b", "--config-file=" + configPath, "--service-port=443", "--workloads", "--validating=false"},
+			wantSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"gpu-system", "kube-system"}},
+			}},
+			wantRules:  [][]admregv1.RuleWithOperations{podRules, workloadRules},
+			wantPort:   443,
+			wantPolicy: admregv1.Fail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*addr, *nsAllowlist, *nsDenylist, *failurePolicy, *configFile = ":8443", "", "", string(admregv1.Fail), ""
+
+			var out bytes.Buffer
+			if err := genConfig(tt.args, &out); err != nil {
+				t.Fatalf("genConfig: %v", err)
+			}
+			docs := strings.Split(out.String(), "\n---\n")
+			if want := map[bool]int{false: 1, true: 2}[tt.wantValidator]; len(docs) != want {
+				t.Fatalf("Expected %d documents, got %d:\n%s", want, len(docs), out.String())
+			}
+
+			var mutating admregv1.MutatingWebhookConfiguration
+			if err := yaml.UnmarshalStrict([]byte(docs[0]), &mutating); err != nil {
+				t.Fatalf("decode MutatingWebhookConfiguration: %v", err)
+			}
+			if mutating.Kind != "MutatingWebhookConfiguration" || mutating.APIVersion != "admissionregistration.k8s.io/v1" {
+				t.Errorf("Expected a v1 MutatingWebhookConfiguration, got %s %s", mutating.APIVersion, mutating.Kind)
+			}
+			if len(mutating.Webhooks) != len(tt.wantRules) {
+				t.Fatalf("Expected %d webhooks, got %d", len(tt.wantRules), len(mutating.Webhooks))
+			}
+			for i, wh := range mutating.Webhooks {
+				if !reflect.DeepEqual(wh.Rules, tt.wantRules[i]) {
+					t.Errorf("Expected webhook %s rules %+v, got %+v", wh.Name, tt.wantRules[i], wh.Rules)
+				}
+				if !reflect.DeepEqual(wh.NamespaceSelector, tt.wantSelector) {
+					t.Errorf("Expected webhook %s namespaceSelector %+v, got %+v", wh.Name, tt.wantSelector, wh.NamespaceSelector)
+				}
+				svc := wh.ClientConfig.Service
+				if svc == nil || svc.Port == nil || *svc.Port != tt.wantPort {
+					t.Errorf("Expected webhook %s to call port %d, got %+v", wh.Name, tt.wantPort, svc)
+				}
+				if !bytes.Equal(wh.ClientConfig.CABundle, ca) {
+					t.Errorf("Expected webhook %s to carry the certificate as caBundle", wh.Name)
+				}
+				if wh.FailurePolicy == nil || *wh.FailurePolicy != tt.wantPolicy {
+					t.Errorf("Expected webhook %s failurePolicy %s, got %v", wh.Name, tt.wantPolicy, wh.FailurePolicy)
+				}
+			}
+			if path := mutating.Webhooks[0].ClientConfig.Service.Path; path == nil || *path != mutatePath {
+				t.Errorf("Expected the pod webhook to call %s, got %v", mutatePath, path)
+			}
+
+			if !tt.wantValidator {
+				return
+			}
+			var validating admregv1.ValidatingWebhookConfiguration
+			if err := yaml.UnmarshalStrict([]byte(docs[1]), &validating); err != nil {
+				t.Fatalf("decode ValidatingWebhookConfiguration: %v", err)
+			}
+			if len(validating.Webhooks) != 1 {
+				t.Fatalf("Expected one validating webhook, got %d", len(validating.Webhooks))
+			}
+			wh := validating.Webhooks[0]
+			if want := []admregv1.RuleWithOperations{namespacedRule("", []string{"pods"}, admregv1.Create, admregv1.Update)}; !reflect.DeepEqual(wh.Rules, want) {
+				t.Errorf("Expected validating rules %+v, got %+v", want, wh.Rules)
+			}
+			if !reflect.DeepEqual(wh.NamespaceSelector, tt.wantSelector) {
+				t.Errorf("Expected validating namespaceSelector %+v, got %+v", tt.wantSelector, wh.NamespaceSelector)
+			}
+			if path := wh.ClientConfig.Service.Path; path == nil || *path != validatePath {
+				t.Errorf("Expected the validating webhook to call %s, got %v", validatePath, path)
+			}
+		})
+	}
+}
+
+func TestGenConfigRejectsNonCertificateCA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	writeConfig(t, path, "not a certificate")
+	err := genConfig([]string{"--ca-file=" + path}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "no PEM certificate") {
+		t.Errorf("Expected a missing certificate error, got %v", err)
+	}
+}
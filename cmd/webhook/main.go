@@ -1,15 +1,41 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	admv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	logsapi "k8s.io/component-base/logs/api/v1"
+	_ "k8s.io/component-base/logs/json/register"
+	"k8s.io/klog/v2"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
 	"github.com/restack/gpu-scheduler/internal/util"
 )
 
@@ -17,93 +43,1086 @@ var (
 	tlsCert = flag.String("tls-cert-file", "/certs/tls.crt", "Path to TLS certificate")
 	tlsKey  = flag.String("tls-private-key-file", "/certs/tls.key", "Path to TLS private key")
 	addr    = flag.String("addr", ":8443", "Webhook listen address")
+
+	metricsAddr    = flag.String("metrics-addr", ":8080", "Plaintext listen address for health and metrics endpoints")
+	enablePprof    = flag.Bool("enable-pprof", false, "Serve net/http/pprof profiles under /debug/pprof/ on --metrics-addr; unauthenticated, so keep it off outside debugging")
+	shutdownGrace  = flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for in-flight requests on SIGTERM")
+	certReloadRate = flag.Duration("cert-reload-interval", 10*time.Second, "How often to check the TLS files for changes")
+
+	failurePolicy   = flag.String("failure-policy", string(admregv1.Fail), "How internal errors are answered: Ignore admits the pod unpatched, Fail denies it")
+	injectInit      = flag.Bool("inject-init-containers", true, "Also inject device env vars into GPU-requesting init containers")
+	nsAllowlist     = flag.String("namespace-allowlist", "", "Comma-separated namespaces to mutate; empty means all")
+	nsDenylist      = flag.String("namespace-denylist", "", "Comma-separated namespaces never to mutate; wins over the allowlist")
+	gpuVendor       = flag.String("gpu-vendor", util.DefaultVendor, "GPU vendor whose resource and device env vars are used: "+strings.Join(util.VendorNames(), ", "))
+	gpuResourceName = flag.String("gpu-resource-name", "", "Container resource name that marks a container as requesting a GPU; defaults to the --gpu-vendor's")
+	verifyClaimRefs = flag.Bool("verify-claim-refs", true, "Reject pods whose claim annotation names a GpuClaim that does not exist")
+	verifyCapacity  = flag.Bool("verify-node-capacity", true, "Reject pods claiming more GPUs than the largest node has")
+	onConflict      = flag.String("on-conflict", string(conflictOverride), "What to do when a container already sets an injected env var: override, skip or error")
+	injectLimits    = flag.Bool("inject-gpu-limits", false, "Also set the GPU resource limit of a pod's sole patched GPU container to the claim's GPU count, or of containers with a gpu.scheduling/container-devices count to their own count, when they set no GPU limit or request")
+	maxGPUs         = flag.Int("max-gpus-per-pod", 0, "Reject pods claiming more GPUs than this; 0 sets no limit. MIG instances are not counted")
+	clampToMax      = flag.Bool("clamp-to-max-gpus", false, "Lower inline claims above --max-gpus-per-pod to the limit, with a warning, instead of rejecting the pod")
+	limitMismatch   = flag.String("limit-mismatch", string(mismatchWarn), "What to do when a container's GPU resource limit differs from the claim's GPU count: warn, deny or ignore")
+	readinessGate   = flag.Bool("readiness-gate", false, "Add a gpu.scheduling/Allocated readiness gate to claiming pods of --scheduler-name, which the scheduler satisfies once their allocation is written; it keeps them out of Service endpoints until then but does not delay their containers")
+	schedulerName   = flag.String("scheduler-name", "gpu-scheduler", "Scheduler name, as pods set it in spec.schedulerName, of the GPU scheduler; only its pods get the --readiness-gate")
+	mixedVendors    = flag.Bool("mixed-vendors", false, "Also inject the env vars of the other GPU vendors into containers requesting their resource, and every vendor's into opted-in containers of pods requesting no GPU resource, for clusters whose nodes carry the gpu.scheduling/vendor label")
+	kubeconfig      = flag.String("kubeconfig", "", "Path to the kubeconfig used to look up GpuClaims and nodes; empty uses the in-cluster config")
+	nsDefaultClaims = flag.Bool("namespace-default-claims", false, "Give pods annotated gpu.scheduling/use-default-claim=true, but without a claim, the claim their namespace's gpu.scheduling/default-claim annotation sets")
+	maxRequest      = flag.Int64("max-request-bytes", 8<<20, "Reject admission requests whose body is larger than this many bytes without decoding them; 0 sets no limit")
+	configFile      = flag.String("config-file", "", "YAML file overriding the namespace lists, injected env vars and conflict policy; reloaded when it changes or on a POST to /reload on --metrics-addr")
+
+	injectEnvVars = &stringList{}
 )
 
-func main() {
-	flag.Parse()
-	http.HandleFunc("/mutate", mutate)
-	if err := http.ListenAndServeTLS(*addr, *tlsCert, *tlsKey, nil); err != nil {
-		panic(err)
+// logConfig holds the logging flags, e.g. --logging-format=json and -v.
+var logConfig = logsapi.NewLoggingConfiguration()
+
+func init() {
+	flag.Var(injectEnvVars, "inject-env-var", "Environment variable to point at the allocated devices (repeatable); defaults to the --gpu-vendor's")
+	logsapi.AddGoFlags(logConfig, flag.CommandLine)
+}
+
+// patchOptions controls how buildPatch injects device visibility into containers.
+type patchOptions struct {
+	// envVars lists the variable names that reference the allocation annotation.
+	envVars []string
+	// gpuResource is the resource name that marks a container as a GPU consumer.
+	gpuResource corev1.ResourceName
+	// initContainers enables injection into init containers.
+	initContainers bool
+	// migResource, when set, also marks a container as a GPU consumer.
+	migResource corev1.ResourceName
+	// onConflict decides what happens to a variable the container already
+	// sets itself. Empty means conflictOverride.
+	onConflict conflictPolicy
+	// vendors are further vendors whose resource marks a container as a GPU
+	// consumer, which then gets that vendor's variables.
+	vendors []util.Vendor
+	// injectLimits has mutate resolve each pod's claim into gpuLimit.
+	injectLimits bool
+	// gpuLimit, when positive, is set as the gpuResource limit of patched
+	// containers that set no limit or request of it.
+	gpuLimit int
+	// readinessGate adds the util.ConditionAllocated readiness gate to pods
+	// of schedulerName, the only scheduler that sets the condition.
+	readinessGate bool
+	schedulerName string
+	// extraEnv holds the variables of the pod's util.AnnoExtraEnv annotation,
+	// which mutate reads.
+	extraEnv []corev1.EnvVar
+}
+
+// conflictPolicy is the handling of a user-set variable the webhook would
+// inject.
+type conflictPolicy string
+
+const (
+	// conflictOverride replaces the user's value.
+	conflictOverride conflictPolicy = "override"
+	// conflictSkip leaves the user's value in place.
+	conflictSkip conflictPolicy = "skip"
+	// conflictError denies the pod.
+	conflictError conflictPolicy = "error"
+)
+
+// mismatchPolicy is the handling of a container whose GPU resource limit
+// disagrees with the pod's claim.
+type mismatchPolicy string
+
+const (
+	// mismatchWarn admits the pod with an admission warning.
+	mismatchWarn mismatchPolicy = "warn"
+	// mismatchDeny denies the pod.
+	mismatchDeny mismatchPolicy = "deny"
+	// mismatchIgnore skips the check.
+	mismatchIgnore mismatchPolicy = "ignore"
+)
+
+// migEnvVar selects MIG instances by UUID for the NVIDIA container runtime.
+const migEnvVar = "NVIDIA_VISIBLE_DEVICES"
+
+// vendorPatchOptions returns the options for the vendor's GPUs. A non-empty
+// resource or envVars, as set on the command line, wins over the vendor's.
+func vendorPatchOptions(v util.Vendor, resource string, envVars []string) patchOptions {
+	o := patchOptions{gpuResource: v.Resource, envVars: v.EnvVars}
+	if resource != "" {
+		o.gpuResource = corev1.ResourceName(resource)
 	}
+	if len(envVars) > 0 {
+		o.envVars = envVars
+	}
+	return o
 }
 
-func mutate(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	var review admv1.AdmissionReview
-	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
-		writeResponse(w, admissionError(review, err))
-		return
+// forPod adapts the options to a pod asking for a MIG profile: containers
+// requesting the profile's resource are patched, and the runtime is pointed
+// at the allocated MIG UUIDs.
+func (o patchOptions) forPod(pod *corev1.Pod) patchOptions {
+	profile, err := util.ParseMIGProfile(pod.Annotations[util.AnnoMIGProfile])
+	if err != nil {
+		return o
 	}
-	if review.Request == nil {
-		writeResponse(w, admissionError(review, fmt.Errorf("empty request")))
-		return
+	o.migResource = util.MIGResource(profile)
+	if !slices.Contains(o.envVars, migEnvVar) {
+		o.envVars = append(slices.Clip(o.envVars), migEnvVar)
 	}
+	return o
+}
 
-	pod := &corev1.Pod{}
-	if err := json.Unmarshal(review.Request.Object.Raw, pod); err != nil {
-		writeResponse(w, admissionError(review, err))
+// withOtherVendors adds every known vendor but v to the options, so
+// containers requesting their resource are patched as well.
+func (o patchOptions) withOtherVendors(v util.Vendor) patchOptions {
+	for _, name := range util.VendorNames() {
+		if other, _ := util.LookupVendor(name); other.Name != v.Name {
+			o.vendors = append(o.vendors, other)
+		}
+	}
+	return o
+}
+
+// containerWantsGPU reports whether the container requests the GPU resource.
+func (o patchOptions) containerWantsGPU(c corev1.Container) bool {
+	for _, name := range []corev1.ResourceName{o.gpuResource, o.migResource} {
+		if name != "" && requestsResource(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// forContainer returns the options to patch the container with, and whether
+// it requests a GPU. A container requesting one of o.vendors' resources gets
+// that vendor's variables: pods are mutated before they are scheduled, but
+// only nodes of that vendor advertise the resource, so it is the vendor of
+// the node the pod will be bound to.
+func (o patchOptions) forContainer(c corev1.Container) (patchOptions, bool) {
+	if o.containerWantsGPU(c) {
+		return o, true
+	}
+	for _, v := range o.vendors {
+		if requestsResource(c, v.Resource) {
+			o.gpuResource, o.envVars = v.Resource, v.EnvVars
+			return o, true
+		}
+	}
+	return o, false
+}
+
+// forPodVendor returns the options for the pod's containers that request no
+// GPU resource themselves, such as ephemeral containers and those opted in
+// by annotation: those of its first GPU container, since they all run on
+// the same node. It reports false when no container requests a GPU, so the
+// vendor is not known yet.
+func (o patchOptions) forPodVendor(pod *corev1.Pod) (patchOptions, bool) {
+	for _, c := range pod.Spec.Containers {
+		if copts, ok := o.forContainer(c); ok {
+			return copts, true
+		}
+	}
+	return o, false
+}
+
+// withEveryVendorEnv adds the variables of o.vendors to o.envVars, for a
+// container that may land on a node of any vendor. A vendor ignores the
+// variables of the others.
+func (o patchOptions) withEveryVendorEnv() patchOptions {
+	for _, v := range o.vendors {
+		for _, name := range v.EnvVars {
+			if !slices.Contains(o.envVars, name) {
+				o.envVars = append(slices.Clip(o.envVars), name)
+			}
+		}
+	}
+	return o
+}
+
+// requestsResource reports whether the container limits or requests a
+// non-zero amount of name.
+func requestsResource(c corev1.Container, name corev1.ResourceName) bool {
+	if q, ok := c.Resources.Limits[name]; ok && !q.IsZero() {
+		return true
+	}
+	if q, ok := c.Resources.Requests[name]; ok && !q.IsZero() {
+		return true
+	}
+	return false
+}
+
+// namespaceFilter limits mutation to selected namespaces. The denylist takes
+// precedence over the allowlist; an empty allowlist admits every namespace.
+type namespaceFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newNamespaceFilter(allow, deny string) namespaceFilter {
+	f := namespaceFilter{allow: map[string]bool{}, deny: map[string]bool{}}
+	for _, ns := range splitList(allow) {
+		f.allow[ns] = true
+	}
+	for _, ns := range splitList(deny) {
+		f.deny[ns] = true
+	}
+	return f
+}
+
+func (f namespaceFilter) allowed(ns string) bool {
+	if f.deny[ns] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[ns]
+}
+
+var (
+	// errorPolicy decides whether admissionError allows or denies the request.
+	errorPolicy = admregv1.Fail
+	// claims looks up the GpuClaims pods reference. Nil skips the check.
+	claims crclient.Reader
+	// nodes reads node GPU capacity from a synced cache. Nil skips the check.
+	nodes corelisters.NodeLister
+	// vendor is the --gpu-vendor whose GPUs are checked and injected.
+	vendor = util.VendorNVIDIA
+	// maxGPUsPerPod caps the GPUs one pod may claim; 0 sets no cap.
+	maxGPUsPerPod int
+	// clampClaims has mutate lower inline claims above maxGPUsPerPod to it.
+	clampClaims bool
+	// mismatch decides how validate answers a GPU limit that disagrees
+	// with the claim.
+	mismatch = mismatchWarn
+	// maxRequestBytes caps the AdmissionReview bodies decodeReview reads; 0
+	// sets no cap.
+	maxRequestBytes int64
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen-config" {
+		if err := genConfig(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "gen-config: %v\n", err)
+			os.Exit(2)
+		}
 		return
 	}
+	flag.Parse()
+	if err := logsapi.ValidateAndApply(logConfig, nil); err != nil {
+		klog.Fatalf("invalid logging flags: %v", err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	switch policy := admregv1.FailurePolicyType(*failurePolicy); policy {
+	case admregv1.Ignore, admregv1.Fail:
+		errorPolicy = policy
+	default:
+		klog.Fatalf("invalid --failure-policy %q: must be %s or %s", *failurePolicy, admregv1.Ignore, admregv1.Fail)
+	}
+	v, err := util.LookupVendor(*gpuVendor)
+	if err != nil {
+		klog.Fatalf("invalid --gpu-vendor: %v", err)
+	}
+	vendor = v
+	maxGPUsPerPod = *maxGPUs
+	clampClaims = *clampToMax
+	maxRequestBytes = *maxRequest
+	switch policy := mismatchPolicy(*limitMismatch); policy {
+	case mismatchWarn, mismatchDeny, mismatchIgnore:
+		mismatch = policy
+	default:
+		klog.Fatalf("invalid --limit-mismatch %q: must be %s, %s or %s", *limitMismatch, mismatchWarn, mismatchDeny, mismatchIgnore)
+	}
+	config := &configReloader{path: *configFile, build: settingsFromFlags}
+	if err := config.reload(); err != nil {
+		klog.Fatalf("load configuration: %v", err)
+	}
+	if *configFile != "" {
+		if err := config.watch(ctx); err != nil {
+			klog.Fatalf("watch configuration: %v", err)
+		}
+	}
+	if *verifyClaimRefs || *verifyCapacity || *nsDefaultClaims {
+		cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		if err != nil {
+			klog.Fatalf("build kube config: %v", err)
+		}
+		if *verifyClaimRefs {
+			if claims, err = newClaimReader(cfg); err != nil {
+				klog.Fatalf("build GpuClaim client: %v", err)
+			}
+		}
+		if *verifyCapacity {
+			if nodes, err = newNodeLister(ctx, cfg); err != nil {
+				klog.Fatalf("watch nodes: %v", err)
+			}
+		}
+		if *nsDefaultClaims {
+			if namespaces, err = newNamespaceLister(ctx, cfg); err != nil {
+				klog.Fatalf("watch namespaces: %v", err)
+			}
+		}
+	}
+
+	ready := &readiness{}
+	certs := &certReloader{
+		certFile: *tlsCert,
+		keyFile:  *tlsKey,
+		onLoad:   func() { ready.certLoaded.Store(true) },
+	}
+	if err := certs.reload(); err != nil {
+		klog.ErrorS(err, "Initial webhook certificate load failed, will retry")
+	}
+	go certs.watch(ctx, *certReloadRate)
 
-	if pod.Annotations == nil || pod.Annotations[util.AnnoClaim] == "" {
-		review.Response = &admv1.AdmissionResponse{
-			UID:     review.Request.UID,
-			Allowed: true,
+	registerMetrics()
+	admin := metricsMux(ready, *enablePprof)
+	admin.HandleFunc("/reload", config.serveReload)
+	go func() {
+		if err := http.ListenAndServe(*metricsAddr, admin); err != nil {
+			klog.Fatalf("metrics server: %v", err)
 		}
-		writeResponse(w, review)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mutatePath, instrument("mutate", mutate))
+	mux.HandleFunc(validatePath, instrument("validate", validate))
+	mux.HandleFunc(mutateWorkloadPath, instrument("mutate-workload", mutateWorkload))
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		klog.Fatalf("listen on %s: %v", *addr, err)
+	}
+	ready.listening.Store(true)
+	srv := &http.Server{
+		Handler:   mux,
+		TLSConfig: &tls.Config{GetCertificate: certs.GetCertificate},
+	}
+	srv.RegisterOnShutdown(func() { ready.listening.Store(false) })
+	serve := func() error { return srv.ServeTLS(ln, "", "") }
+	if err := serveUntilDone(ctx, srv, serve, *shutdownGrace); err != nil {
+		klog.Fatalf("webhook server: %v", err)
+	}
+}
+
+// settingsFromFlags builds the settings from the command-line flags, with
+// the fields set in fc taking their place.
+func settingsFromFlags(fc fileConfig) (*webhookSettings, error) {
+	envVars := injectEnvVars.values
+	if fc.InjectEnvVars != nil {
+		envVars = fc.InjectEnvVars
+	}
+	policy := conflictPolicy(*onConflict)
+	if fc.OnConflict != "" {
+		policy = conflictPolicy(fc.OnConflict)
+	}
+	switch policy {
+	case conflictOverride, conflictSkip, conflictError:
+	default:
+		return nil, fmt.Errorf("invalid on-conflict policy %q: must be %s, %s or %s", policy, conflictOverride, conflictSkip, conflictError)
+	}
+	allow, deny := namespaceLists(fc)
+
+	opts := vendorPatchOptions(vendor, *gpuResourceName, envVars)
+	opts.initContainers = *injectInit
+	opts.onConflict = policy
+	opts.injectLimits = *injectLimits
+	opts.readinessGate = *readinessGate
+	opts.schedulerName = *schedulerName
+	if *mixedVendors {
+		opts = opts.withOtherVendors(vendor)
+	}
+	return &webhookSettings{patchOpts: opts, nsFilter: newNamespaceFilter(allow, deny)}, nil
+}
+
+// namespaceLists returns the namespace allow- and denylist, each taken from
+// the config file when it sets one and from the flags otherwise.
+func namespaceLists(fc fileConfig) (allow, deny string) {
+	allow, deny = *nsAllowlist, *nsDenylist
+	if fc.NamespaceAllowlist != nil {
+		allow = strings.Join(fc.NamespaceAllowlist, ",")
+	}
+	if fc.NamespaceDenylist != nil {
+		deny = strings.Join(fc.NamespaceDenylist, ",")
+	}
+	return allow, deny
+}
+
+func mutate(w http.ResponseWriter, r *http.Request) {
+	cfg := currentSettings()
+	review, pod, err := readReview(w, r)
+	logger := requestLogger(r.Context(), review, pod)
+	if err != nil {
+		fail(w, logger, review, err)
 		return
 	}
+
 	response := &admv1.AdmissionResponse{
 		UID:     review.Request.UID,
 		Allowed: true,
 	}
-	if len(pod.Spec.Containers) == 0 {
-		review.Response = response
-		writeResponse(w, review)
+	// notes describe the changes made to the pod's claim; the rest of
+	// mutate treats the pod as if it had set the changed claim itself.
+	var notes []string
+	if review.Request.SubResource == "" && cfg.nsFilter.allowed(review.Request.Namespace) {
+		claim, warning, err := defaultClaim(review.Request.Namespace, pod)
+		if err != nil {
+			fail(w, logger, review, err)
+			return
+		}
+		if warning != "" {
+			response.Warnings = append(response.Warnings, warning)
+		}
+		if claim != "" {
+			pod.Annotations[util.AnnoClaim] = claim
+			notes = append(notes, fmt.Sprintf("claim %q set from the default of namespace %s", claim, review.Request.Namespace))
+		}
+		if claim, note := clampClaim(pod); claim != "" {
+			pod.Annotations[util.AnnoClaim] = claim
+			notes = append(notes, note)
+		}
+	}
+	if !cfg.nsFilter.allowed(review.Request.Namespace) ||
+		pod.Annotations == nil || pod.Annotations[util.AnnoClaim] == "" ||
+		len(pod.Spec.Containers) == 0 {
+		// A claim added by a later update would otherwise be audited under
+		// the value the pod was created with.
+		if _, ok := pod.Annotations[util.AnnoRequestedBy]; ok && review.Request.SubResource == "" && cfg.nsFilter.allowed(review.Request.Namespace) {
+			patchBytes, err := json.Marshal([]map[string]interface{}{requestedByOp(review.Request)})
+			if err != nil {
+				fail(w, logger, review, err)
+				return
+			}
+			pt := admv1.PatchTypeJSONPatch
+			response.PatchType = &pt
+			response.Patch = patchBytes
+			countPatch(isDryRun(review))
+			respond(w, logger, review, response, "patched", "patchOps", 1)
+			return
+		}
+		respond(w, logger, review, response, "skipped")
 		return
 	}
 
-	patch := buildPatch(pod)
+	target := pod
+	if review.Request.SubResource == "ephemeralcontainers" {
+		// Only ephemeral containers may change through this subresource.
+		target = &corev1.Pod{
+			ObjectMeta: pod.ObjectMeta,
+			Spec:       corev1.PodSpec{EphemeralContainers: pod.Spec.EphemeralContainers},
+		}
+	}
+	opts := cfg.patchOpts
+	if opts.extraEnv, err = extraEnv(pod); err != nil {
+		fail(w, logger, review, err)
+		return
+	}
+	opts.extraEnv = withFractionLimit(opts.extraEnv, pod)
+	if opts.injectLimits {
+		if opts.gpuLimit, err = claimLimit(r.Context(), review.Request.Namespace, pod); err != nil {
+			fail(w, logger, review, err)
+			return
+		}
+	}
+	patch, err := buildPatch(target, opts)
+	if err != nil {
+		response.Allowed = false
+		response.Result = invalidPod(err.Error())
+		respond(w, logger, review, response, "rejected")
+		return
+	}
+	// A claim that does not parse is left for /validate to deny.
+	if _, err := util.ParseClaim(pod.Annotations[util.AnnoClaim]); err == nil && review.Request.SubResource == "" && !isDryRun(review) {
+		countClaim(review.Request, pod)
+	}
+	if len(notes) > 0 {
+		patch = append(patch, map[string]interface{}{
+			"op":    "add",
+			"path":  "/metadata/annotations/" + pointerEscaper.Replace(util.AnnoClaim),
+			"value": pod.Annotations[util.AnnoClaim],
+		}, map[string]interface{}{
+			"op":    "add",
+			"path":  "/metadata/annotations/" + pointerEscaper.Replace(util.AnnoWebhookNotes),
+			"value": strings.Join(notes, "; "),
+		})
+		response.Warnings = append(response.Warnings, notes...)
+	}
+	if review.Request.SubResource == "" {
+		// Replaces a value the user set; /validate denies changing it
+		// later.
+		if _, ok := pod.Annotations[util.AnnoRequestedBy]; ok || review.Request.UserInfo.Username != "" {
+			patch = append(patch, requestedByOp(review.Request))
+		}
+	}
+	if len(patch) == 0 {
+		respond(w, logger, review, response, "unchanged")
+		return
+	}
 	patchBytes, err := json.Marshal(patch)
 	if err != nil {
-		writeResponse(w, admissionError(review, err))
+		fail(w, logger, review, err)
 		return
 	}
 
 	pt := admv1.PatchTypeJSONPatch
 	response.PatchType = &pt
 	response.Patch = patchBytes
+	countPatch(isDryRun(review))
+	respond(w, logger, review, response, "patched", "patchOps", len(patch))
+}
+
+// requestedByOp sets util.AnnoRequestedBy to the user making req, or removes
+// it when req names no user.
+func requestedByOp(req *admv1.AdmissionRequest) map[string]interface{} {
+	path := "/metadata/annotations/" + pointerEscaper.Replace(util.AnnoRequestedBy)
+	if req.UserInfo.Username == "" {
+		return map[string]interface{}{"op": "remove", "path": path}
+	}
+	return map[string]interface{}{"op": "add", "path": path, "value": req.UserInfo.Username}
+}
+
+// validate rejects pods whose claim annotation cannot be parsed, names a
+// GpuClaim that does not exist, or asks for more GPUs than any node has or
+// than --max-gpus-per-pod allows, and MIG claims on a vendor without MIG.
+// Containers whose GPU limit differs from the claim are warned about or
+// denied per --limit-mismatch. Pod updates are only denied when they change
+// util.AnnoRequestedBy.
+func validate(w http.ResponseWriter, r *http.Request) {
+	review, pod, err := readReview(w, r)
+	logger := requestLogger(r.Context(), review, pod)
+	if err != nil {
+		fail(w, logger, review, err)
+		return
+	}
+
+	response := &admv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	if review.Request.Operation == admv1.Update {
+		// Updates are only checked for the annotation the webhook stamped
+		// on create; the claim was validated then.
+		old := &corev1.Pod{}
+		if err := json.Unmarshal(review.Request.OldObject.Raw, old); err != nil {
+			fail(w, logger, review, fmt.Errorf("decode old pod: %w", err))
+			return
+		}
+		decision := "accepted"
+		if old.Annotations[util.AnnoRequestedBy] != pod.Annotations[util.AnnoRequestedBy] {
+			response.Allowed = false
+			response.Result = invalidPod(fmt.Sprintf("%s annotation is set by the webhook when the pod is created and cannot be changed", util.AnnoRequestedBy))
+			decision = "rejected"
+		}
+		respond(w, logger, review, response, decision)
+		return
+	}
+	if value, ok := pod.Annotations[util.AnnoClaim]; ok {
+		claim, err := util.ParseClaim(value)
+		if err != nil {
+			response.Allowed = false
+			response.Result = invalidPod(fmt.Sprintf("invalid %s annotation: %v", util.AnnoClaim, err))
+		} else {
+			count := claim.Count
+			if claim.Name != "" && claims != nil {
+				gc := &apiv1.GpuClaim{}
+				err := claims.Get(r.Context(), types.NamespacedName{Namespace: review.Request.Namespace, Name: claim.Name}, gc)
+				switch {
+				case apierrors.IsNotFound(err):
+					response.Allowed = false
+					response.Result = invalidPod(fmt.Sprintf("%s annotation references GpuClaim %q, which does not exist in namespace %s",
+						util.AnnoClaim, claim.Name, review.Request.Namespace))
+				case err != nil:
+					fail(w, logger, review, fmt.Errorf("get GpuClaim %q: %w", claim.Name, err))
+					return
+				default:
+					count = gc.Spec.Devices.Count
+				}
+			}
+			_, mig := pod.Annotations[util.AnnoMIGProfile]
+			// With --mixed-vendors, nodes of another vendor may support MIG;
+			// the scheduler keeps the claim off those that do not.
+			if mig && !vendor.MIG && len(currentSettings().patchOpts.vendors) == 0 {
+				response.Allowed = false
+				response.Result = invalidPod(fmt.Sprintf("%s annotation is not supported on %s GPUs", util.AnnoMIGProfile, vendor.Name))
+			}
+			// A MIG claim counts instances, which a single GPU may hold several of.
+			if response.Allowed && !mig && maxGPUsPerPod > 0 && count > maxGPUsPerPod {
+				response.Allowed = false
+				response.Result = invalidPod(fmt.Sprintf("claim requests %d GPUs, above the limit of %d GPUs per pod", count, maxGPUsPerPod))
+			}
+			if response.Allowed && !mig {
+				msg, err := capacityExceeded(count)
+				if err != nil {
+					fail(w, logger, review, fmt.Errorf("check node capacity: %w", err))
+					return
+				}
+				if msg != "" {
+					response.Allowed = false
+					response.Result = invalidPod(msg)
+				}
+			}
+			devices, err := util.ContainerDevices(pod)
+			if response.Allowed && err != nil {
+				response.Allowed = false
+				response.Result = invalidPod(err.Error())
+			}
+			if response.Allowed && devices != nil {
+				if msg := devicesMismatch(devices, claim, count); msg != "" {
+					response.Allowed = false
+					response.Result = invalidPod(msg)
+				}
+			}
+			if response.Allowed && !mig && count > 0 && mismatch != mismatchIgnore {
+				if msg := limitMismatches(pod, currentSettings().patchOpts.gpuResource, count, devices); msg != "" {
+					if mismatch == mismatchDeny {
+						response.Allowed = false
+						response.Result = invalidPod(msg)
+					} else {
+						response.Warnings = append(response.Warnings, msg)
+					}
+				}
+			}
+		}
+	}
+	decision := "accepted"
+	if !response.Allowed {
+		decision = "rejected"
+	}
+	respond(w, logger, review, response, decision)
+}
+
+// devicesMismatch describes how the util.AnnoContainerDevices counts fail
+// to add up to the devices the claim is for, or returns "" when they do or
+// the claim's count is not known here.
+func devicesMismatch(devices map[string]int, claim util.Claim, count int) string {
+	if claim.Fraction > 0 {
+		count = 1
+	}
+	if count <= 0 {
+		return ""
+	}
+	total := 0
+	for _, n := range devices {
+		total += n
+	}
+	if total == count {
+		return ""
+	}
+	return fmt.Sprintf("%s annotation assigns %d devices, but the claim is for %d", util.AnnoContainerDevices, total, count)
+}
+
+// limitMismatches describes the containers that set a resource limit other
+// than the claim's count, or returns "" when there are none. A container
+// may also limit itself to the count devices gives it, and the containers
+// may split the count between them: limits that add up to it are each
+// their container's share. Containers without a limit are not checked.
+func limitMismatches(pod *corev1.Pod, resource corev1.ResourceName, count int, devices map[string]int) string {
+	if resource == "" {
+		return ""
+	}
+	// Init containers run one at a time, so only the others split the GPUs.
+	var total int64
+	for _, c := range pod.Spec.Containers {
+		if q, ok := c.Resources.Limits[resource]; ok {
+			total += q.Value()
+		}
+	}
+	var mismatched []string
+	check := func(c corev1.Container, split bool) {
+		q, ok := c.Resources.Limits[resource]
+		if !ok || split || q.Value() == int64(count) {
+			return
+		}
+		if n, ok := devices[c.Name]; !ok || q.Value() != int64(n) {
+			mismatched = append(mismatched, fmt.Sprintf("%s limits %s to %s", c.Name, resource, q.String()))
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		check(c, false)
+	}
+	for _, c := range pod.Spec.Containers {
+		check(c, total == int64(count))
+	}
+	if len(mismatched) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s annotation claims %d GPUs, but container %s", util.AnnoClaim, count, strings.Join(mismatched, ", "))
+}
+
+// invalidPod is the status of a pod denied for its own content.
+func invalidPod(msg string) *metav1.Status {
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonInvalid,
+		Code:    http.StatusUnprocessableEntity,
+		Message: msg,
+	}
+}
+
+// requestLogger tags the request's logger with a correlation ID, taken from
+// the AdmissionReview UID, and with the pod, so every line logged for one
+// request can be found together. Lines for a dry run say so, since nothing
+// is persisted.
+func requestLogger(ctx context.Context, review admv1.AdmissionReview, pod *corev1.Pod) klog.Logger {
+	logger := klog.FromContext(ctx)
+	if review.Request == nil {
+		return logger
+	}
+	name := review.Request.Name
+	if name == "" && pod != nil {
+		// Pods created from generateName have no name yet.
+		name = pod.Name
+		if name == "" {
+			name = pod.GenerateName
+		}
+	}
+	logger = klog.LoggerWithValues(logger,
+		"correlationID", string(review.Request.UID),
+		"pod", klog.KRef(review.Request.Namespace, name),
+		"operation", review.Request.Operation,
+	)
+	if isDryRun(review) {
+		logger = klog.LoggerWithValues(logger, "dryRun", true)
+	}
+	return logger
+}
+
+// isDryRun reports whether the request is a dry run, e.g. from kubectl apply
+// --dry-run=server, whose result the API server does not persist.
+func isDryRun(review admv1.AdmissionReview) bool {
+	return review.Request != nil && review.Request.DryRun != nil && *review.Request.DryRun
+}
+
+// respond logs the decision on the request and writes the response.
+func respond(w http.ResponseWriter, logger klog.Logger, review admv1.AdmissionReview, response *admv1.AdmissionResponse, decision string, kv ...interface{}) {
+	kv = append([]interface{}{"decision", decision, "allowed", response.Allowed}, kv...)
+	if response.Result != nil && response.Result.Message != "" {
+		kv = append(kv, "reason", response.Result.Message)
+	}
+	logger.Info("Admission decision", kv...)
+	countOutcome(response.Allowed)
 	review.Response = response
 	writeResponse(w, review)
 }
 
-const annotationFieldPath = "metadata.annotations['" + util.AnnoAllocated + "']"
+// fail logs an error processing the request and answers it per errorPolicy.
+func fail(w http.ResponseWriter, logger klog.Logger, review admv1.AdmissionReview, err error) {
+	logger.Error(err, "Failed to process admission request", "decision", "error", "allowed", errorPolicy == admregv1.Ignore)
+	writeResponse(w, admissionError(review, err, errorPolicy))
+}
+
+// newClaimReader builds a client that can read GpuClaims.
+func newClaimReader(cfg *rest.Config) (crclient.Reader, error) {
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return crclient.New(cfg, crclient.Options{Scheme: scheme})
+}
+
+// newNodeLister starts a node informer and waits for its cache to sync, so
+// the capacity check never judges a pod against a partial node list.
+func newNodeLister(ctx context.Context, cfg *rest.Config) (corelisters.NodeLister, error) {
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactory(cs, 0)
+	lister := factory.Core().V1().Nodes().Lister()
+	factory.Start(ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("%v cache did not sync", typ)
+		}
+	}
+	return lister, nil
+}
+
+// capacityExceeded explains why no node can hold a claim for count GPUs, or
+// returns "" when the largest node can. Nodes that advertise no GPUs say
+// nothing about capacity, so a cluster without any skips the check.
+func capacityExceeded(count int) (string, error) {
+	if nodes == nil {
+		return "", nil
+	}
+	list, err := nodes.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	var largest string
+	var most int
+	for _, node := range list {
+		// A node listing the devices it passes through has only those.
+		ids, _ := util.NodeDeviceIDs(node, util.NodeVendor(node, vendor).NodeCapacity(node))
+		if c := len(ids); c > most || (c == most && node.Name < largest) {
+			largest, most = node.Name, c
+		}
+	}
+	if most == 0 || count <= most {
+		return "", nil
+	}
+	return fmt.Sprintf("claim requests %d GPUs, but the largest node, %s, has %d", count, largest, most), nil
+}
+
+// readReview decodes the AdmissionReview and the pod it carries.
+func readReview(w http.ResponseWriter, r *http.Request) (admv1.AdmissionReview, *corev1.Pod, error) {
+	review, err := decodeReview(w, r)
+	if err != nil {
+		return review, nil, err
+	}
 
-func buildPatch(pod *corev1.Pod) []map[string]interface{} {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(review.Request.Object.Raw, pod); err != nil {
+		return review, nil, err
+	}
+	return review, pod, nil
+}
+
+// buildPatch points the configured env vars of every GPU container at the
+// container's own allocation annotation, which the scheduler writes before
+// binding the pod. Ephemeral containers cannot declare resources and are
+// added once the pod runs, so they are always patched, with the devices of
+// the whole pod. The other containers also get opts.extraEnv. It fails only
+// when opts.onConflict is conflictError and a patched container sets one of
+// the variables itself.
+//
+// The ops come in a fixed order, so the same pod always gets the same patch:
+// containers, then init containers, each in ascending index order, then the
+// readiness gate, then ephemeral containers. Within a container the env ops
+// follow opts.envVars, then opts.extraEnv, and the resource ops come last.
+func buildPatch(pod *corev1.Pod, opts patchOptions) ([]map[string]interface{}, error) {
+	opts = opts.forPod(pod)
 	var ops []map[string]interface{}
+	var err error
+	optIn := optedInContainers(pod)
+	devices, _ := util.ContainerDevices(pod)
+	if opts.gpuLimit > 0 && !hasGPUContainer(pod, opts, optIn) && len(pod.Spec.Containers) > 0 {
+		// The claim is the pod's only mention of GPUs; its first container
+		// is taken to be the one using them.
+		optIn[pod.Spec.Containers[0].Name] = true
+	}
+	// The claim's whole count only goes to a pod's sole GPU container;
+	// where there are several, only those with counts of their own in
+	// util.AnnoContainerDevices get a limit.
+	sole := gpuContainers(pod, opts, optIn) == 1
+	limit := func(c corev1.Container, copts patchOptions) patchOptions {
+		if n := devices[c.Name]; n > 0 && copts.gpuLimit > 0 {
+			// The container is only given its own share of the devices.
+			copts.gpuLimit = n
+		} else if !sole {
+			copts.gpuLimit = 0
+		}
+		return copts
+	}
+	// Containers requesting no GPU resource take the vendor of those that
+	// do. Without any, the vendor is only known when a limit of
+	// opts.gpuResource is injected, which keeps the pod to nodes of the
+	// default vendor; otherwise the pod may land on any vendor's node and
+	// gets every vendor's variables.
+	vendorOpts, known := opts.forPodVendor(pod)
+	if !known {
+		vendorOpts = opts.withEveryVendorEnv()
+		for _, c := range pod.Spec.Containers {
+			if optIn[c.Name] && addsLimit(c.Resources, limit(c, opts)) {
+				vendorOpts = opts
+				break
+			}
+		}
+	}
 	for i, c := range pod.Spec.Containers {
-		envPath := fmt.Sprintf("/spec/containers/%d/env", i)
-		value := map[string]interface{}{
-			"name": "CUDA_VISIBLE_DEVICES",
-			"valueFrom": map[string]interface{}{
-				"fieldRef": map[string]string{
-					"fieldPath": annotationFieldPath,
-				},
-			},
+		if copts, ok := opts.forContainer(c); ok || optIn[c.Name] {
+			if !ok {
+				copts = vendorOpts
+			}
+			copts = limit(c, copts)
+			src := envSource{fieldPath: allocatedFieldPath(c.Name)}
+			if ops, err = appendEnvOps(ops, fmt.Sprintf("/spec/containers/%d/env", i), c.Name, c.Env, copts, src); err != nil {
+				return nil, err
+			}
+			ops = appendExtraEnvOps(ops, fmt.Sprintf("/spec/containers/%d/env", i), c.Env, copts)
+			ops = appendLimitOps(ops, fmt.Sprintf("/spec/containers/%d/resources", i), c.Resources, copts)
 		}
-		switch idx := envIndex(c.Env, value["name"].(string)); {
-		case idx == -1 && len(c.Env) == 0:
-			ops = append(ops, map[string]interface{}{
-				"op":    "add",
-				"path":  envPath,
-				"value": []map[string]interface{}{value},
-			})
+	}
+	if opts.initContainers {
+		for i, c := range pod.Spec.InitContainers {
+			if copts, ok := opts.forContainer(c); ok || optIn[c.Name] {
+				if !ok {
+					copts = vendorOpts
+				}
+				src := envSource{fieldPath: allocatedFieldPath(c.Name)}
+				if ops, err = appendEnvOps(ops, fmt.Sprintf("/spec/initContainers/%d/env", i), c.Name, c.Env, copts, src); err != nil {
+					return nil, err
+				}
+				ops = appendExtraEnvOps(ops, fmt.Sprintf("/spec/initContainers/%d/env", i), c.Env, copts)
+			}
+		}
+	}
+	// Only the pod's create, not an ephemeral container update, has
+	// containers and can take a readiness gate. Pods of other schedulers
+	// would never get the condition and stay unready.
+	if opts.readinessGate && pod.Spec.SchedulerName == opts.schedulerName && len(pod.Spec.Containers) > 0 {
+		ops = appendReadinessGateOp(ops, pod)
+	}
+	if devices := podDevices(pod); devices != "" {
+		for i, c := range pod.Spec.EphemeralContainers {
+			src := envSource{value: devices}
+			if ops, err = appendEnvOps(ops, fmt.Sprintf("/spec/ephemeralContainers/%d/env", i), c.Name, c.Env, vendorOpts, src); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ops, nil
+}
+
+// appendReadinessGateOp adds the util.ConditionAllocated readiness gate,
+// unless the pod has it already, so the pod is not Ready, and stays out of
+// Service endpoints, until the scheduler has confirmed its allocation. The
+// gate does not hold back the pod's containers.
+func appendReadinessGateOp(ops []map[string]interface{}, pod *corev1.Pod) []map[string]interface{} {
+	if util.HasAllocatedGate(pod) {
+		return ops
+	}
+	gate := corev1.PodReadinessGate{ConditionType: util.ConditionAllocated}
+	if pod.Spec.ReadinessGates == nil {
+		return append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  "/spec/readinessGates",
+			"value": []corev1.PodReadinessGate{gate},
+		})
+	}
+	return append(ops, map[string]interface{}{
+		"op":    "add",
+		"path":  "/spec/readinessGates/-",
+		"value": gate,
+	})
+}
+
+// hasGPUContainer reports whether any of the pod's containers requests a GPU
+// or is listed in optIn.
+func hasGPUContainer(pod *corev1.Pod, opts patchOptions, optIn map[string]bool) bool {
+	return gpuContainers(pod, opts, optIn) > 0
+}
+
+// gpuContainers counts the pod's containers that request a GPU or are
+// listed in optIn.
+func gpuContainers(pod *corev1.Pod, opts patchOptions, optIn map[string]bool) int {
+	n := 0
+	for _, c := range pod.Spec.Containers {
+		if _, ok := opts.forContainer(c); ok || optIn[c.Name] {
+			n++
+		}
+	}
+	return n
+}
+
+// appendLimitOps sets the container's limit of opts.gpuResource to
+// opts.gpuLimit when the container sets neither a limit nor a request of
+// it; the API server defaults the request to the limit. A container that
+// sets either is left alone, since its own counts may divide the claim
+// between several containers.
+func appendLimitOps(ops []map[string]interface{}, resPath string, res corev1.ResourceRequirements, opts patchOptions) []map[string]interface{} {
+	if !addsLimit(res, opts) {
+		return ops
+	}
+	want := resource.NewQuantity(int64(opts.gpuLimit), resource.DecimalSI).String()
+	if res.Limits == nil {
+		return append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  resPath + "/limits",
+			"value": map[string]string{string(opts.gpuResource): want},
+		})
+	}
+	return append(ops, map[string]interface{}{
+		"op":    "add",
+		"path":  resPath + "/limits/" + pointerEscaper.Replace(string(opts.gpuResource)),
+		"value": want,
+	})
+}
+
+// addsLimit reports whether appendLimitOps sets a limit on a container with
+// resources res.
+func addsLimit(res corev1.ResourceRequirements, opts patchOptions) bool {
+	if opts.gpuLimit <= 0 || opts.gpuResource == "" {
+		return false
+	}
+	_, limit := res.Limits[opts.gpuResource]
+	_, request := res.Requests[opts.gpuResource]
+	return !limit && !request
+}
+
+// clampClaim returns the pod's claim lowered to maxGPUsPerPod and a note
+// saying so, or "" when clampClaims is off or the claim is within the cap.
+// Only inline counts are lowered: a GpuClaim above the cap cannot be changed
+// from here and is still rejected by validate. MIG instances do not count.
+func clampClaim(pod *corev1.Pod) (claim, note string) {
+	if !clampClaims || maxGPUsPerPod <= 0 {
+		return "", ""
+	}
+	if _, mig := pod.Annotations[util.AnnoMIGProfile]; mig {
+		return "", ""
+	}
+	c, err := util.ParseClaim(pod.Annotations[util.AnnoClaim])
+	if err != nil || c.Name != "" || c.Count <= maxGPUsPerPod {
+		return "", ""
+	}
+	// An inline count is always the head, before any qualifiers.
+	_, qualifiers, found := strings.Cut(pod.Annotations[util.AnnoClaim], ",")
+	claim = strconv.Itoa(maxGPUsPerPod)
+	if found {
+		claim += "," + qualifiers
+	}
+	return claim, fmt.Sprintf("claim lowered from %d to %d GPUs, the limit per pod", c.Count, maxGPUsPerPod)
+}
+
+// claimLimit returns the whole GPUs the pod's claim asks for, or 0 when it
+// asks for a share or MIG instances, does not parse, or names a GpuClaim
+// that cannot be looked up: without a claims reader, or when it does not
+// exist, which validate reports.
+func claimLimit(ctx context.Context, namespace string, pod *corev1.Pod) (int, error) {
+	if _, mig := pod.Annotations[util.AnnoMIGProfile]; mig {
+		return 0, nil
+	}
+	claim, err := util.ParseClaim(pod.Annotations[util.AnnoClaim])
+	if err != nil || claim.Name == "" {
+		return claim.Count, nil
+	}
+	if claims == nil {
+		return 0, nil
+	}
+	gc := &apiv1.GpuClaim{}
+	switch err := claims.Get(ctx, types.NamespacedName{Namespace: namespace, Name: claim.Name}, gc); {
+	case apierrors.IsNotFound(err):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("get GpuClaim %q: %w", claim.Name, err)
+	}
+	return max(gc.Spec.Devices.Count, 1), nil
+}
+
+// appendEnvOps adds each configured variable, resolving to src, to one
+// container's env. A variable the container already sets is handled per
+// opts.onConflict.
+func appendEnvOps(ops []map[string]interface{}, envPath, container string, env []corev1.EnvVar, opts patchOptions, src envSource) ([]map[string]interface{}, error) {
+	if len(env) == 0 {
+		values := make([]map[string]interface{}, 0, len(opts.envVars))
+		for _, name := range opts.envVars {
+			values = append(values, src.envVar(name))
+		}
+		return append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  envPath,
+			"value": values,
+		}), nil
+	}
+	for _, name := range opts.envVars {
+		value := src.envVar(name)
+		idx := envIndex(env, name)
+		switch {
 		case idx == -1:
 			ops = append(ops, map[string]interface{}{
 				"op":    "add",
 				"path":  envPath + "/-",
 				"value": value,
 			})
+		case opts.onConflict == conflictSkip && !src.injected(env[idx]):
+			// Keep the user's value.
+		case opts.onConflict == conflictError && !src.injected(env[idx]):
+			return nil, fmt.Errorf("container %q sets %s itself; the GPU scheduler assigns it from the %s annotation",
+				container, name, util.ContainerAllocatedKey(container))
 		default:
 			ops = append(ops, map[string]interface{}{
 				"op":    "replace",
@@ -112,9 +1131,149 @@ func buildPatch(pod *corev1.Pod) []map[string]interface{} {
 			})
 		}
 	}
+	return ops, nil
+}
+
+// appendExtraEnvOps adds opts.extraEnv to one container's env, which
+// appendEnvOps has already created if it was empty. A variable the container
+// sets itself keeps its value, and the device variables stay the webhook's.
+func appendExtraEnvOps(ops []map[string]interface{}, envPath string, env []corev1.EnvVar, opts patchOptions) []map[string]interface{} {
+	for _, e := range opts.extraEnv {
+		if envIndex(env, e.Name) != -1 || slices.Contains(opts.envVars, e.Name) {
+			continue
+		}
+		ops = append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  envPath + "/-",
+			"value": e,
+		})
+	}
 	return ops
 }
 
+// extraEnv parses the pod's util.AnnoExtraEnv annotation. Every entry needs
+// a name, used once.
+func extraEnv(pod *corev1.Pod) ([]corev1.EnvVar, error) {
+	v, ok := pod.Annotations[util.AnnoExtraEnv]
+	if !ok {
+		return nil, nil
+	}
+	var env []corev1.EnvVar
+	if err := json.Unmarshal([]byte(v), &env); err != nil {
+		return nil, fmt.Errorf("parse %s annotation: %w", util.AnnoExtraEnv, err)
+	}
+	for i, e := range env {
+		if e.Name == "" {
+			return nil, fmt.Errorf("parse %s annotation: entry %d has no name", util.AnnoExtraEnv, i)
+		}
+		if envIndex(env[:i], e.Name) != -1 {
+			return nil, fmt.Errorf("parse %s annotation: %s is set twice", util.AnnoExtraEnv, e.Name)
+		}
+	}
+	return env, nil
+}
+
+// fractionLimitEnvVar tells a container of a burstable fractional claim,
+// e.g. "0.3,lim=0.6", the share of its GPU it may burst to, for an MPS or
+// time-slicing setup to cap it at.
+const fractionLimitEnvVar = "GPU_FRACTION_LIMIT"
+
+// withFractionLimit adds fractionLimitEnvVar to env when the pod's claim
+// sets a limit and the util.AnnoExtraEnv annotation does not set the
+// variable itself.
+func withFractionLimit(env []corev1.EnvVar, pod *corev1.Pod) []corev1.EnvVar {
+	claim, err := util.ParseClaim(pod.Annotations[util.AnnoClaim])
+	if err != nil || claim.Limit == 0 || envIndex(env, fractionLimitEnvVar) != -1 {
+		return env
+	}
+	return append(env, corev1.EnvVar{Name: fractionLimitEnvVar, Value: strconv.FormatFloat(claim.Limit, 'f', -1, 64)})
+}
+
+// allocatedFieldPath is the fieldRef path of the container's allocation
+// annotation.
+func allocatedFieldPath(container string) string {
+	return "metadata.annotations['" + util.ContainerAllocatedKey(container) + "']"
+}
+
+// podDevices lists every device allocated to the pod, in container name
+// order, or returns "" when the pod has no allocation yet.
+func podDevices(pod *corev1.Pod) string {
+	alloc := util.AllocatedAnnotations(pod)
+	keys := make([]string, 0, len(alloc))
+	for k := range alloc {
+		if k != util.AnnoAllocated {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	var devices []string
+	for _, k := range keys {
+		for _, d := range splitList(alloc[k]) {
+			if !slices.Contains(devices, d) {
+				devices = append(devices, d)
+			}
+		}
+	}
+	return strings.Join(devices, ",")
+}
+
+// envSource is what an injected variable resolves to: a fieldRef to an
+// annotation, or a fixed value.
+type envSource struct {
+	fieldPath string
+	value     string
+}
+
+// envVar builds the env entry for the variable name.
+func (s envSource) envVar(name string) map[string]interface{} {
+	if s.fieldPath == "" {
+		return map[string]interface{}{"name": name, "value": s.value}
+	}
+	return map[string]interface{}{
+		"name": name,
+		"valueFrom": map[string]interface{}{
+			"fieldRef": map[string]string{
+				"fieldPath": s.fieldPath,
+			},
+		},
+	}
+}
+
+// injected reports whether e already resolves to s, as after an earlier
+// pass of the webhook, rather than to a value the user set.
+func (s envSource) injected(e corev1.EnvVar) bool {
+	if s.fieldPath == "" {
+		return e.ValueFrom == nil && e.Value == s.value
+	}
+	return e.ValueFrom != nil && e.ValueFrom.FieldRef != nil && e.ValueFrom.FieldRef.FieldPath == s.fieldPath
+}
+
+// optedInContainers returns the container names listed in the opt-in
+// annotation, and those util.AnnoContainerDevices gives devices of their own.
+func optedInContainers(pod *corev1.Pod) map[string]bool {
+	out := map[string]bool{}
+	for _, name := range splitList(pod.Annotations[util.AnnoInjectContainers]) {
+		out[name] = true
+	}
+	// A malformed annotation is left to validate.
+	devices, _ := util.ContainerDevices(pod)
+	for name := range devices {
+		out[name] = true
+	}
+	return out
+}
+
+// splitList parses a comma-separated list, dropping blanks.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func envIndex(vars []corev1.EnvVar, name string) int {
 	for i, env := range vars {
 		if env.Name == name {
@@ -124,16 +1283,49 @@ func envIndex(vars []corev1.EnvVar, name string) int {
 	return -1
 }
 
-func admissionError(review admv1.AdmissionReview, err error) admv1.AdmissionReview {
+// admissionError answers a request the webhook failed to process. With the
+// Ignore policy the pod is admitted unpatched; with Fail it is denied.
+// Either way the request counts as an error, not as allowed or denied.
+func admissionError(review admv1.AdmissionReview, err error, policy admregv1.FailurePolicyType) admv1.AdmissionReview {
+	requestsTotal.WithLabelValues(outcomeError).Inc()
 	review.Response = &admv1.AdmissionResponse{
+		Allowed: policy == admregv1.Ignore,
 		Result: &metav1.Status{
 			Message: err.Error(),
 		},
 	}
+	if review.Request != nil {
+		review.Response.UID = review.Request.UID
+	}
 	return review
 }
 
-func writeResponse(w http.ResponseWriter, review admv1.AdmissionReview) {
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(review)
+// stringList is a repeatable string flag. The first Set replaces the default.
+type stringList struct {
+	values []string
+	set    bool
+}
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.values, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	if v == "" {
+		return fmt.Errorf("value must not be empty")
+	}
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+	for _, existing := range l.values {
+		if existing == v {
+			return nil
+		}
+	}
+	l.values = append(l.values, v)
+	return nil
 }
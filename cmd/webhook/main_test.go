@@ -0,0 +1,1861 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	admv1 "k8s.io/api/admission/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func gpuLimits(n string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse(n)},
+	}
+}
+
+// useSettings swaps in s for the rest of the test.
+func useSettings(t *testing.T, s webhookSettings) {
+	t.Helper()
+	prev := settings.Load()
+	settings.Store(&s)
+	t.Cleanup(func() { settings.Store(prev) })
+}
+
+func mustBuildPatch(t *testing.T, pod *corev1.Pod, opts patchOptions) []map[string]interface{} {
+	t.Helper()
+	ops, err := buildPatch(pod, opts)
+	if err != nil {
+		t.Fatalf("buildPatch: %v", err)
+	}
+	return ops
+}
+
+func TestBuildPatchInjectsEachEnvVar(t *testing.T) {
+	opts := patchOptions{
+		envVars:     []string{"CUDA_VISIBLE_DEVICES", "NVIDIA_VISIBLE_DEVICES"},
+		gpuResource: "nvidia.com/gpu",
+	}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "empty", Resources: gpuLimits("1")},
+				{Name: "user-set", Resources: gpuLimits("1"), Env: []corev1.EnvVar{
+					{Name: "FOO", Value: "bar"},
+					{Name: "NVIDIA_VISIBLE_DEVICES", Value: "all"},
+				}},
+			},
+		},
+	}
+
+	ops := mustBuildPatch(t, pod, opts)
+	if len(ops) != 3 {
+		t.Fatalf("Expected 3 ops, got %d: %v", len(ops), ops)
+	}
+
+	// Container without env gets a single add with both variables.
+	if ops[0]["op"] != "add" || ops[0]["path"] != "/spec/containers/0/env" {
+		t.Errorf("Unexpected first op: %v", ops[0])
+	}
+	values := ops[0]["value"].([]map[string]interface{})
+	if len(values) != 2 || values[0]["name"] != "CUDA_VISIBLE_DEVICES" || values[1]["name"] != "NVIDIA_VISIBLE_DEVICES" {
+		t.Errorf("Unexpected env values: %v", values)
+	}
+
+	// Missing variable is appended, the user-set one is replaced in place.
+	if ops[1]["op"] != "add" || ops[1]["path"] != "/spec/containers/1/env/-" {
+		t.Errorf("Expected append of CUDA_VISIBLE_DEVICES, got %v", ops[1])
+	}
+	if ops[2]["op"] != "replace" || ops[2]["path"] != "/spec/containers/1/env/1" {
+		t.Errorf("Expected replace of NVIDIA_VISIBLE_DEVICES, got %v", ops[2])
+	}
+	want := "metadata.annotations['allocated.gpu.scheduling/user-set']"
+	for _, op := range ops[1:] {
+		value := op["value"].(map[string]interface{})
+		ref := value["valueFrom"].(map[string]interface{})["fieldRef"].(map[string]string)
+		if ref["fieldPath"] != want {
+			t.Errorf("Expected fieldRef to %s, got %s", want, ref["fieldPath"])
+		}
+	}
+}
+
+func TestBuildPatchOrder(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES", "NVIDIA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "empty", Resources: gpuLimits("1")},
+				{Name: "partial", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}}},
+				{Name: "set", Resources: gpuLimits("1"), Env: []corev1.EnvVar{
+					{Name: "FOO", Value: "bar"},
+					{Name: "NVIDIA_VISIBLE_DEVICES", Value: "all"},
+				}},
+			},
+		},
+	}
+	ref := func(c string) string {
+		return `"valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['allocated.gpu.scheduling/` + c + `']"}}`
+	}
+	want := []string{
+		`{"op":"add","path":"/spec/containers/0/env","value":[{"name":"CUDA_VISIBLE_DEVICES",` + ref("empty") + `},{"name":"NVIDIA_VISIBLE_DEVICES",` + ref("empty") + `}]}`,
+		`{"op":"add","path":"/spec/containers/1/env/-","value":{"name":"CUDA_VISIBLE_DEVICES",` + ref("partial") + `}}`,
+		`{"op":"add","path":"/spec/containers/1/env/-","value":{"name":"NVIDIA_VISIBLE_DEVICES",` + ref("partial") + `}}`,
+		`{"op":"add","path":"/spec/containers/2/env/-","value":{"name":"CUDA_VISIBLE_DEVICES",` + ref("set") + `}}`,
+		`{"op":"replace","path":"/spec/containers/2/env/1","value":{"name":"NVIDIA_VISIBLE_DEVICES",` + ref("set") + `}}`,
+	}
+
+	// Patch the same pod repeatedly, so an order that depended on map
+	// iteration would show.
+	for range 10 {
+		ops := mustBuildPatch(t, pod, opts)
+		got := make([]string, len(ops))
+		for i, op := range ops {
+			raw, err := json.Marshal(op)
+			if err != nil {
+				t.Fatalf("marshal op %d: %v", i, err)
+			}
+			got[i] = string(raw)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("Expected ops\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestBuildPatchPerContainerAllocation(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", initContainers: true}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "warm", Resources: gpuLimits("1")}},
+			Containers: []corev1.Container{
+				{Name: "worker-0", Resources: gpuLimits("1")},
+				{Name: "worker-1", Resources: gpuLimits("1")},
+			},
+		},
+	}
+
+	var got []string
+	for _, op := range mustBuildPatch(t, pod, opts) {
+		for _, v := range op["value"].([]map[string]interface{}) {
+			ref := v["valueFrom"].(map[string]interface{})["fieldRef"].(map[string]string)
+			got = append(got, op["path"].(string)+"="+ref["fieldPath"])
+		}
+	}
+	want := []string{
+		"/spec/containers/0/env=metadata.annotations['allocated.gpu.scheduling/worker-0']",
+		"/spec/containers/1/env=metadata.annotations['allocated.gpu.scheduling/worker-1']",
+		"/spec/initContainers/0/env=metadata.annotations['allocated.gpu.scheduling/warm']",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected fieldRefs\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// Each fieldRef names an annotation the scheduler writes for the pod.
+	alloc, err := util.SplitAllocation(pod, util.ResourceGPU, []int{2, 5})
+	if err != nil {
+		t.Fatalf("SplitAllocation: %v", err)
+	}
+	util.SetAllocated(pod, alloc)
+	for _, c := range pod.Spec.Containers {
+		if _, ok := pod.Annotations[util.ContainerAllocatedKey(c.Name)]; !ok {
+			t.Errorf("Expected an allocation annotation for %s, got %v", c.Name, pod.Annotations)
+		}
+	}
+	if a, b := pod.Annotations[util.ContainerAllocatedKey("worker-0")], pod.Annotations[util.ContainerAllocatedKey("worker-1")]; a != "2" || b != "5" {
+		t.Errorf("Expected the workers to get devices 2 and 5, got %q and %q", a, b)
+	}
+}
+
+func TestBuildPatchSkipsNonGPUContainers(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "log-agent"},
+				{Name: "trainer", Resources: gpuLimits("2")},
+				{Name: "proxy", Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				}},
+			},
+		},
+	}
+
+	ops := mustBuildPatch(t, pod, opts)
+	if len(ops) != 1 {
+		t.Fatalf("Expected 1 op, got %d: %v", len(ops), ops)
+	}
+	if ops[0]["path"] != "/spec/containers/1/env" {
+		t.Errorf("Expected only the second container to be patched, got %v", ops[0]["path"])
+	}
+
+	// The opt-in annotation pulls in a container without the GPU resource.
+	pod.Annotations = map[string]string{util.AnnoInjectContainers: "proxy"}
+	ops = mustBuildPatch(t, pod, opts)
+	if len(ops) != 2 || ops[1]["path"] != "/spec/containers/2/env" {
+		t.Errorf("Expected opted-in third container to be patched, got %v", ops)
+	}
+}
+
+func TestBuildPatchMIGProfile(t *testing.T) {
+	envVars := []string{"CUDA_VISIBLE_DEVICES"}
+	opts := patchOptions{envVars: envVars, gpuResource: "nvidia.com/gpu"}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoMIGProfile: "1g.5gb"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "sidecar"},
+				{Name: "infer", Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{"nvidia.com/mig-1g.5gb": resource.MustParse("1")},
+				}},
+			},
+		},
+	}
+
+	ops := mustBuildPatch(t, pod, opts)
+	if len(ops) != 1 || ops[0]["path"] != "/spec/containers/1/env" {
+		t.Fatalf("Expected only the MIG container to be patched, got %v", ops)
+	}
+	values := ops[0]["value"].([]map[string]interface{})
+	if len(values) != 2 || values[1]["name"] != "NVIDIA_VISIBLE_DEVICES" {
+		t.Errorf("Expected NVIDIA_VISIBLE_DEVICES to be injected for MIG, got %v", values)
+	}
+	if len(envVars) != 1 {
+		t.Errorf("Expected the shared env var list to stay untouched, got %v", envVars)
+	}
+
+	// A profile the container does not request leaves it alone.
+	pod.Annotations[util.AnnoMIGProfile] = "3g.20gb"
+	if ops := mustBuildPatch(t, pod, opts); len(ops) != 0 {
+		t.Errorf("Expected no patch for a non-matching profile, got %v", ops)
+	}
+}
+
+func TestBuildPatchInitAndEphemeralContainers(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			util.AnnoAllocated:                       `{"trainer":[3,1],"warm-cache":[3]}`,
+			util.ContainerAllocatedKey("trainer"):    "3,1",
+			util.ContainerAllocatedKey("warm-cache"): "3",
+		}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "warm-cache", Resources: gpuLimits("1")},
+				{Name: "fetch-data"},
+			},
+			Containers: []corev1.Container{
+				{Name: "trainer", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", Value: "0"}}},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Env: []corev1.EnvVar{{Name: "TERM", Value: "xterm"}}}},
+			},
+		},
+	}
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", initContainers: true}
+
+	paths := func(ops []map[string]interface{}) []string {
+		var out []string
+		for _, op := range ops {
+			out = append(out, op["op"].(string)+" "+op["path"].(string))
+		}
+		return out
+	}
+
+	got := paths(mustBuildPatch(t, pod, opts))
+	want := []string{
+		"replace /spec/containers/0/env/0",
+		"add /spec/initContainers/0/env",
+		"add /spec/ephemeralContainers/0/env/-",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected ops %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("op %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	// The debugger sees every device of the pod.
+	ops := mustBuildPatch(t, pod, opts)
+	if v := ops[2]["value"].(map[string]interface{})["value"]; v != "3,1" {
+		t.Errorf("Expected the ephemeral container to get the pod's devices, got %v", v)
+	}
+
+	// Opting out of init containers leaves them untouched.
+	opts.initContainers = false
+	for _, p := range paths(mustBuildPatch(t, pod, opts)) {
+		if p == "add /spec/initContainers/0/env" {
+			t.Errorf("Expected init containers to be skipped, got %v", p)
+		}
+	}
+
+	// Before the pod is bound there are no devices to give the debugger.
+	pod.Annotations = nil
+	for _, p := range paths(mustBuildPatch(t, pod, opts)) {
+		if strings.Contains(p, "ephemeralContainers") {
+			t.Errorf("Expected ephemeral containers of an unbound pod to be skipped, got %v", p)
+		}
+	}
+}
+
+func TestBuildPatchPassesDeviceUUIDs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			util.AnnoAllocated:                    `{"trainer":[0,3]}`,
+			util.ContainerAllocatedKey("trainer"): "GPU-aaa,GPU-ddd",
+		}},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"}},
+			},
+		},
+	}
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+	ops := mustBuildPatch(t, pod, opts)
+	if len(ops) != 1 {
+		t.Fatalf("Expected one op for the debugger, got %v", ops)
+	}
+	values := ops[0]["value"].([]map[string]interface{})
+	if v := values[0]["value"]; v != "GPU-aaa,GPU-ddd" {
+		t.Errorf("Expected the UUIDs passed through, got %v", v)
+	}
+}
+
+// FuzzBuildPatch feeds buildPatch arbitrary pods, which it must patch or
+// refuse without panicking, with ops addressing paths in the pod.
+func FuzzBuildPatch(f *testing.F) {
+	seed := func(pod *corev1.Pod) []byte {
+		raw, _ := json.Marshal(pod)
+		return raw
+	}
+	f.Add(seed(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer", Resources: gpuLimits("1")}}}}), uint8(2), false)
+	f.Add(seed(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoMIGProfile: "1g.5gb", util.AnnoInjectContainers: "sidecar"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "trainer", Env: []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", Value: "0"}}, Resources: gpuLimits("1")},
+				{Name: "sidecar"},
+			},
+			InitContainers:      []corev1.Container{{Name: "init", Resources: gpuLimits("1")}},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug"}}},
+		},
+	}), uint8(0), true)
+	f.Add([]byte(`{"spec":{"containers":[{"name":"","env":null}]}}`), uint8(255), false)
+
+	f.Fuzz(func(t *testing.T, raw []byte, limit uint8, conflictErr bool) {
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(raw, pod); err != nil {
+			return
+		}
+		opts := vendorPatchOptions(util.VendorNVIDIA, "", nil).withOtherVendors(util.VendorNVIDIA)
+		opts.initContainers = true
+		opts.readinessGate, opts.schedulerName = true, pod.Spec.SchedulerName
+		opts.gpuLimit = int(limit)
+		opts.extraEnv = []corev1.EnvVar{{Name: "NCCL_DEBUG", Value: "INFO"}}
+		if conflictErr {
+			opts.onConflict = conflictError
+		}
+		ops, err := buildPatch(pod, opts)
+		if err != nil {
+			return
+		}
+		for _, op := range ops {
+			if path, _ := op["path"].(string); !strings.HasPrefix(path, "/") {
+				t.Fatalf("Expected every op to address a path in the pod, got %v", op)
+			}
+		}
+		if _, err := json.Marshal(ops); err != nil {
+			t.Fatalf("marshal patch: %v", err)
+		}
+	})
+}
+
+func TestBuildPatchOnConflict(t *testing.T) {
+	injected := corev1.EnvVar{Name: "CUDA_VISIBLE_DEVICES", ValueFrom: &corev1.EnvVarSource{
+		FieldRef: &corev1.ObjectFieldSelector{FieldPath: allocatedFieldPath("reinvoked")},
+	}}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "user-set", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", Value: "1"}}},
+				{Name: "reinvoked", Resources: gpuLimits("1"), Env: []corev1.EnvVar{injected}},
+				{Name: "unset", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		policy  conflictPolicy
+		want    []string
+		wantErr bool
+	}{
+		{policy: "", want: []string{"replace /spec/containers/0/env/0", "replace /spec/containers/1/env/0", "add /spec/containers/2/env/-"}},
+		{policy: conflictOverride, want: []string{"replace /spec/containers/0/env/0", "replace /spec/containers/1/env/0", "add /spec/containers/2/env/-"}},
+		{policy: conflictSkip, want: []string{"replace /spec/containers/1/env/0", "add /spec/containers/2/env/-"}},
+		{policy: conflictError, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", onConflict: tt.policy}
+		ops, err := buildPatch(pod, opts)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), `"user-set"`) {
+				t.Errorf("%q: Expected an error naming the container, got %v", tt.policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.policy, err)
+			continue
+		}
+		var got []string
+		for _, op := range ops {
+			got = append(got, op["op"].(string)+" "+op["path"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: Expected ops %v, got %v", tt.policy, tt.want, got)
+		}
+	}
+
+	// The webhook's own reference from an earlier pass is not a conflict.
+	pod.Spec.Containers = pod.Spec.Containers[1:]
+	if _, err := buildPatch(pod, patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", onConflict: conflictError}); err != nil {
+		t.Errorf("Expected no conflict without a user-set value, got %v", err)
+	}
+}
+
+func TestMutateDeniesConflict(t *testing.T) {
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", onConflict: conflictError}})
+
+	raw, _ := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: map[string]string{util.AnnoClaim: "1"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "main", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", Value: "0"}}},
+		}},
+	})
+	body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+		UID: "review-uid", Namespace: "ml", Name: "trainer", Object: runtime.RawExtension{Raw: raw},
+	}})
+	rec := httptest.NewRecorder()
+	mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+
+	var out admv1.AdmissionReview
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Response.Allowed || out.Response.Patch != nil {
+		t.Errorf("Expected the pod to be denied unpatched, got allowed=%v patch=%s", out.Response.Allowed, out.Response.Patch)
+	}
+	if out.Response.Result == nil || !strings.Contains(out.Response.Result.Message, "CUDA_VISIBLE_DEVICES") {
+		t.Errorf("Expected the denial to name the variable, got %v", out.Response.Result)
+	}
+}
+
+func TestBuildPatchExtraEnv(t *testing.T) {
+	opts := patchOptions{
+		envVars:     []string{"CUDA_VISIBLE_DEVICES"},
+		gpuResource: "nvidia.com/gpu",
+		extraEnv: []corev1.EnvVar{
+			{Name: "NCCL_DEBUG", Value: "INFO"},
+			{Name: "CUDA_VISIBLE_DEVICES", Value: "all"},
+			{Name: "NCCL_IB_DISABLE", Value: "1"},
+		},
+	}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "empty", Resources: gpuLimits("1")},
+				{Name: "user-set", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "NCCL_DEBUG", Value: "WARN"}}},
+				{Name: "cpu-only"},
+			},
+		},
+	}
+	ops := mustBuildPatch(t, pod, opts)
+
+	var got []string
+	for _, op := range ops {
+		entry := op["op"].(string) + " " + op["path"].(string)
+		if e, ok := op["value"].(corev1.EnvVar); ok {
+			entry += " " + e.Name + "=" + e.Value
+		}
+		got = append(got, entry)
+	}
+	want := []string{
+		"add /spec/containers/0/env",
+		"add /spec/containers/0/env/- NCCL_DEBUG=INFO",
+		"add /spec/containers/0/env/- NCCL_IB_DISABLE=1",
+		"add /spec/containers/1/env/-",
+		"add /spec/containers/1/env/- NCCL_IB_DISABLE=1",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected ops %v, got %v", want, got)
+	}
+}
+
+func TestWithFractionLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		extra []corev1.EnvVar
+		want  []corev1.EnvVar
+	}{
+		{name: "burstable share", claim: "0.3,lim=0.6", want: []corev1.EnvVar{{Name: fractionLimitEnvVar, Value: "0.6"}}},
+		{name: "req form", claim: "req=0.25,lim=1", want: []corev1.EnvVar{{Name: fractionLimitEnvVar, Value: "1"}}},
+		{name: "no limit", claim: "0.3"},
+		{name: "whole GPUs", claim: "2"},
+		{name: "invalid claim", claim: "0.6,lim=0.3"},
+		{
+			name:  "set in extra-env",
+			claim: "0.3,lim=0.6",
+			extra: []corev1.EnvVar{{Name: fractionLimitEnvVar, Value: "0.5"}},
+			want:  []corev1.EnvVar{{Name: fractionLimitEnvVar, Value: "0.5"}},
+		},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoClaim: tt.claim}}}
+		if got := withFractionLimit(tt.extra, pod); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// The variable reaches the GPU containers like the extra-env ones.
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoClaim: "0.3,lim=0.6"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "server", Resources: gpuLimits("1")}, {Name: "sidecar"}}},
+	}
+	opts.extraEnv = withFractionLimit(nil, pod)
+	var added []string
+	for _, op := range mustBuildPatch(t, pod, opts) {
+		if e, ok := op["value"].(corev1.EnvVar); ok && e.Name == fractionLimitEnvVar {
+			added = append(added, op["path"].(string)+" "+e.Value)
+		}
+	}
+	if want := []string{"/spec/containers/0/env/- 0.6"}; !slices.Equal(added, want) {
+		t.Errorf("Expected %v, got %v", want, added)
+	}
+}
+
+func TestExtraEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "list", value: `[{"name":"NCCL_DEBUG","value":"INFO"},{"name":"POD","valueFrom":{"fieldRef":{"fieldPath":"metadata.name"}}}]`, want: []string{"NCCL_DEBUG", "POD"}},
+		{name: "not JSON", value: `NCCL_DEBUG=INFO`, wantErr: true},
+		{name: "not a list", value: `{"name":"NCCL_DEBUG"}`, wantErr: true},
+		{name: "no name", value: `[{"value":"INFO"}]`, wantErr: true},
+		{name: "duplicate", value: `[{"name":"A","value":"1"},{"name":"A","value":"2"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{}
+		if tt.value != "" {
+			pod.Annotations = map[string]string{util.AnnoExtraEnv: tt.value}
+		}
+		env, err := extraEnv(pod)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		var names []string
+		for _, e := range env {
+			names = append(names, e.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s: Expected %v, got %v", tt.name, tt.want, names)
+		}
+	}
+}
+
+func TestMutateMalformedExtraEnv(t *testing.T) {
+	defer func(p admregv1.FailurePolicyType) { errorPolicy = p }(errorPolicy)
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	raw, _ := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: map[string]string{
+			util.AnnoClaim:    "1",
+			util.AnnoExtraEnv: `[{"name":`,
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+	})
+	body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+		UID: "review-uid", Namespace: "ml", Name: "trainer", Object: runtime.RawExtension{Raw: raw},
+	}})
+	for _, tt := range []struct {
+		policy  admregv1.FailurePolicyType
+		allowed bool
+	}{
+		{admregv1.Ignore, true},
+		{admregv1.Fail, false},
+	} {
+		errorPolicy = tt.policy
+		rec := httptest.NewRecorder()
+		mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+
+		var out admv1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		resp := out.Response
+		if resp.Allowed != tt.allowed || resp.Patch != nil {
+			t.Errorf("%s: Expected allowed=%v without a patch, got allowed=%v patch=%s", tt.policy, tt.allowed, resp.Allowed, resp.Patch)
+		}
+		if resp.Result == nil || !strings.Contains(resp.Result.Message, util.AnnoExtraEnv) {
+			t.Errorf("%s: Expected the error to name the annotation, got %v", tt.policy, resp.Result)
+		}
+	}
+}
+
+func TestContainerWantsGPU(t *testing.T) {
+	opts := patchOptions{gpuResource: "nvidia.com/gpu"}
+	tests := []struct {
+		name string
+		c    corev1.Container
+		want bool
+	}{
+		{"no resources", corev1.Container{}, false},
+		{"limit", corev1.Container{Resources: gpuLimits("1")}, true},
+		{"zero limit", corev1.Container{Resources: gpuLimits("0")}, false},
+		{"request", corev1.Container{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")},
+		}}, true},
+	}
+	for _, tt := range tests {
+		if got := opts.containerWantsGPU(tt.c); got != tt.want {
+			t.Errorf("%s: containerWantsGPU = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStringListReplacesDefault(t *testing.T) {
+	l := &stringList{values: []string{"CUDA_VISIBLE_DEVICES"}}
+	for _, v := range []string{"NVIDIA_VISIBLE_DEVICES", "CUDA_VISIBLE_DEVICES", "NVIDIA_VISIBLE_DEVICES"} {
+		if err := l.Set(v); err != nil {
+			t.Fatalf("Set(%q) failed: %v", v, err)
+		}
+	}
+	if got := l.String(); got != "NVIDIA_VISIBLE_DEVICES,CUDA_VISIBLE_DEVICES" {
+		t.Errorf("Unexpected flag value %q", got)
+	}
+	if err := l.Set(""); err == nil {
+		t.Errorf("Expected error for empty value")
+	}
+}
+
+// review sends the pod through handler wrapped in an AdmissionReview.
+func review(t *testing.T, handler http.HandlerFunc, pod *corev1.Pod) *admv1.AdmissionResponse {
+	t.Helper()
+	return sendReview(t, handler, "Pod", pod.Namespace, pod)
+}
+
+// sendReview posts an AdmissionReview for obj, of the given kind, to handler
+// and returns the response.
+func sendReview(t *testing.T, handler http.HandlerFunc, kind, namespace string, obj interface{}) *admv1.AdmissionResponse {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("marshal %s: %v", kind, err)
+	}
+	body, err := json.Marshal(admv1.AdmissionReview{
+		Request: &admv1.AdmissionRequest{
+			UID:       "review-uid",
+			Kind:      metav1.GroupVersionKind{Kind: kind},
+			Namespace: namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal review: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	var out admv1.AdmissionReview
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Response == nil {
+		t.Fatalf("Expected a response")
+	}
+	return out.Response
+}
+
+func TestValidateClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   *string
+		allowed bool
+	}{
+		{name: "no claim", allowed: true},
+		{name: "inline count", claim: strPtr("2"), allowed: true},
+		{name: "claim reference", claim: strPtr("single-gpu"), allowed: true},
+		{name: "empty", claim: strPtr(""), allowed: false},
+		{name: "zero count", claim: strPtr("0"), allowed: false},
+		{name: "negative count", claim: strPtr("-3"), allowed: false},
+		{name: "typo", claim: strPtr("2 gpus"), allowed: false},
+		{name: "bad name", claim: strPtr("My_Claim"), allowed: false},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default"}}
+		if tt.claim != nil {
+			pod.Annotations = map[string]string{util.AnnoClaim: *tt.claim}
+		}
+
+		resp := review(t, validate, pod)
+		if resp.UID != "review-uid" {
+			t.Errorf("%s: expected UID to be echoed, got %q", tt.name, resp.UID)
+		}
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v", tt.name, resp.Allowed, tt.allowed)
+		}
+		if !tt.allowed && (resp.Result == nil || resp.Result.Message == "") {
+			t.Errorf("%s: expected a denial message, got %+v", tt.name, resp.Result)
+		}
+	}
+}
+
+func TestValidateClaimReference(t *testing.T) {
+	defer func(r crclient.Reader) { claims = r }(claims)
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme: %v", err)
+	}
+	existing := &apiv1.GpuClaim{ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "default"}}
+	found := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+	broken := crfake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(context.Context, crclient.WithWatch, crclient.ObjectKey, crclient.Object, ...crclient.GetOption) error {
+			return errors.New("apiserver unavailable")
+		},
+	}).Build()
+
+	tests := []struct {
+		name      string
+		reader    crclient.Reader
+		namespace string
+		claim     string
+		allowed   bool
+	}{
+		{name: "claim exists", reader: found, namespace: "default", claim: "training", allowed: true},
+		{name: "claim missing", reader: found, namespace: "default", claim: "inference", allowed: false},
+		{name: "claim in another namespace", reader: found, namespace: "team-b", claim: "training", allowed: false},
+		{name: "inline claims are not looked up", reader: broken, namespace: "default", claim: "2", allowed: true},
+		{name: "lookup fails under Fail", reader: broken, namespace: "default", claim: "training", allowed: false},
+		{name: "check disabled", reader: nil, namespace: "default", claim: "inference", allowed: true},
+	}
+	for _, tt := range tests {
+		claims = tt.reader
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "p",
+			Namespace:   tt.namespace,
+			Annotations: map[string]string{util.AnnoClaim: tt.claim},
+		}}
+		resp := review(t, validate, pod)
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v", tt.name, resp.Allowed, tt.allowed)
+		}
+		if !tt.allowed && (resp.Result == nil || resp.Result.Message == "") {
+			t.Errorf("%s: expected a denial message, got %+v", tt.name, resp.Result)
+		}
+	}
+}
+
+func TestValidateNodeCapacity(t *testing.T) {
+	defer func(r crclient.Reader, l corelisters.NodeLister) { claims, nodes = r, l }(claims, nodes)
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme: %v", err)
+	}
+	big := &apiv1.GpuClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+		Spec:       apiv1.GpuClaimSpec{Devices: apiv1.DeviceRequest{Count: 16}},
+	}
+	claims = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(big).Build()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, capacity := range map[string]string{"node-a": "4", "node-b": "8", "cpu-only": ""} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+		if capacity != "" {
+			node.Labels[util.LabelCapacity] = capacity
+		}
+		_ = indexer.Add(node)
+	}
+	nodes = corelisters.NewNodeLister(indexer)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		allowed     bool
+	}{
+		{name: "below the largest node", annotations: map[string]string{util.AnnoClaim: "4"}, allowed: true},
+		{name: "at the largest node", annotations: map[string]string{util.AnnoClaim: "8"}, allowed: true},
+		{name: "above the largest node", annotations: map[string]string{util.AnnoClaim: "16"}, allowed: false},
+		{name: "GpuClaim above the largest node", annotations: map[string]string{util.AnnoClaim: "big"}, allowed: false},
+		{name: "fraction", annotations: map[string]string{util.AnnoClaim: "0.5"}, allowed: true},
+		{name: "MIG instances", annotations: map[string]string{util.AnnoClaim: "16", util.AnnoMIGProfile: "1g.5gb"}, allowed: true},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: tt.annotations}}
+		resp := review(t, validate, pod)
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v (%v)", tt.name, resp.Allowed, tt.allowed, resp.Result)
+		}
+		if !tt.allowed && (resp.Result == nil || !strings.Contains(resp.Result.Message, "the largest node, node-b, has 8")) {
+			t.Errorf("%s: Expected the denial to name the largest capacity, got %+v", tt.name, resp.Result)
+		}
+	}
+
+	// Without any node advertising GPUs the check has nothing to go on.
+	nodes = corelisters.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{util.AnnoClaim: "16"}}}
+	if resp := review(t, validate, pod); !resp.Allowed {
+		t.Errorf("Expected a cluster without GPU nodes to skip the check, got %v", resp.Result)
+	}
+}
+
+func TestValidateMaxGPUsPerPod(t *testing.T) {
+	defer func(r crclient.Reader, n int) { claims, maxGPUsPerPod = r, n }(claims, maxGPUsPerPod)
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme: %v", err)
+	}
+	big := &apiv1.GpuClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+		Spec:       apiv1.GpuClaimSpec{Devices: apiv1.DeviceRequest{Count: 8}},
+	}
+	claims = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(big).Build()
+	maxGPUsPerPod = 4
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		allowed     bool
+	}{
+		{name: "at the cap", annotations: map[string]string{util.AnnoClaim: "4"}, allowed: true},
+		{name: "above the cap", annotations: map[string]string{util.AnnoClaim: "5"}, allowed: false},
+		{name: "GpuClaim above the cap", annotations: map[string]string{util.AnnoClaim: "big"}, allowed: false},
+		{name: "fraction", annotations: map[string]string{util.AnnoClaim: "0.5"}, allowed: true},
+		{name: "MIG instances", annotations: map[string]string{util.AnnoClaim: "7", util.AnnoMIGProfile: "1g.5gb"}, allowed: true},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: tt.annotations}}
+		resp := review(t, validate, pod)
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v (%v)", tt.name, resp.Allowed, tt.allowed, resp.Result)
+		}
+		if !tt.allowed && (resp.Result == nil || !strings.Contains(resp.Result.Message, "above the limit of 4 GPUs per pod")) {
+			t.Errorf("%s: Expected the denial to name the cap, got %+v", tt.name, resp.Result)
+		}
+	}
+
+	maxGPUsPerPod = 0
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{util.AnnoClaim: "64"}}}
+	if resp := review(t, validate, pod); !resp.Allowed {
+		t.Errorf("Expected no cap without --max-gpus-per-pod, got %v", resp.Result)
+	}
+}
+
+func TestValidateLimitMismatch(t *testing.T) {
+	defer func(p mismatchPolicy) { mismatch = p }(mismatch)
+	useSettings(t, webhookSettings{patchOpts: patchOptions{gpuResource: "nvidia.com/gpu"}})
+
+	withLimit := func(limit, sidecar string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{util.AnnoClaim: "2"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}, {Name: "sidecar"}}},
+		}
+		if limit != "" {
+			pod.Spec.Containers[0].Resources = gpuLimits(limit)
+		}
+		if sidecar != "" {
+			pod.Spec.Containers[1].Resources = gpuLimits(sidecar)
+		}
+		return pod
+	}
+	tests := []struct {
+		name         string
+		policy       mismatchPolicy
+		limit        string
+		sidecar      string
+		allowed      bool
+		wantWarnings int
+	}{
+		{name: "matching limit", policy: mismatchDeny, limit: "2", allowed: true},
+		{name: "no limit", policy: mismatchDeny, allowed: true},
+		{name: "mismatch warns", policy: mismatchWarn, limit: "4", allowed: true, wantWarnings: 1},
+		{name: "mismatch denied", policy: mismatchDeny, limit: "4", allowed: false},
+		{name: "mismatch ignored", policy: mismatchIgnore, limit: "4", allowed: true},
+		{name: "limits split the claim", policy: mismatchDeny, limit: "1", sidecar: "1", allowed: true},
+		{name: "limits over the claim", policy: mismatchDeny, limit: "4", sidecar: "1", allowed: false},
+	}
+	for _, tt := range tests {
+		mismatch = tt.policy
+		resp := review(t, validate, withLimit(tt.limit, tt.sidecar))
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v (%v)", tt.name, resp.Allowed, tt.allowed, resp.Result)
+		}
+		if len(resp.Warnings) != tt.wantWarnings {
+			t.Errorf("%s: Expected %d warnings, got %v", tt.name, tt.wantWarnings, resp.Warnings)
+		}
+		want := "claims 2 GPUs, but container main limits nvidia.com/gpu to 4"
+		if tt.wantWarnings > 0 && !strings.Contains(resp.Warnings[0], want) {
+			t.Errorf("%s: Expected warning %q, got %q", tt.name, want, resp.Warnings[0])
+		}
+		if !tt.allowed && (resp.Result == nil || !strings.Contains(resp.Result.Message, want)) {
+			t.Errorf("%s: Expected denial %q, got %+v", tt.name, want, resp.Result)
+		}
+	}
+}
+
+func TestContainerDevices(t *testing.T) {
+	defer func(p mismatchPolicy) { mismatch = p }(mismatch)
+	mismatch = mismatchDeny
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	pod := func(claim, devices string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{
+				util.AnnoClaim:            claim,
+				util.AnnoContainerDevices: devices,
+			}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "trainer", Resources: gpuLimits("2")},
+				{Name: "evaluator"},
+			}},
+		}
+	}
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		wantErr string
+	}{
+		{name: "two-container split", pod: pod("3", `{"trainer":2,"evaluator":1}`)},
+		{name: "fraction", pod: pod("0.5", `{"evaluator":1}`)},
+		{name: "counts do not add up", pod: pod("4", `{"trainer":2,"evaluator":1}`), wantErr: "assigns 3 devices, but the claim is for 4"},
+		{name: "unknown container", pod: pod("3", `{"trainer":2,"ghost":1}`), wantErr: `names "ghost", which is not a container`},
+		{name: "not JSON", pod: pod("3", `trainer=2`), wantErr: "invalid gpu.scheduling/container-devices annotation"},
+		{name: "limit differs from the container's count", pod: pod("3", `{"trainer":1,"evaluator":2}`), wantErr: "trainer limits nvidia.com/gpu to 2"},
+	}
+	for _, tt := range tests {
+		resp := review(t, validate, tt.pod)
+		if tt.wantErr == "" {
+			if !resp.Allowed {
+				t.Errorf("%s: Expected the pod to be admitted, got %v", tt.name, resp.Result)
+			}
+			continue
+		}
+		if resp.Allowed || resp.Result == nil || !strings.Contains(resp.Result.Message, tt.wantErr) {
+			t.Errorf("%s: Expected a denial containing %q, got allowed=%v %+v", tt.name, tt.wantErr, resp.Allowed, resp.Result)
+		}
+	}
+
+	// The evaluator requests no GPU, but is patched to see its own slice.
+	p := pod("3", `{"trainer":2,"evaluator":1}`)
+	ops := mustBuildPatch(t, p, currentSettings().patchOpts)
+	var paths []string
+	for _, op := range ops {
+		paths = append(paths, op["path"].(string))
+	}
+	if want := []string{"/spec/containers/0/env", "/spec/containers/1/env"}; !slices.Equal(paths, want) {
+		t.Errorf("Expected patches %v, got %v", want, paths)
+	}
+
+	// With --inject-gpu-limits each container's limit is its own count.
+	opts := currentSettings().patchOpts
+	opts.gpuLimit = 3
+	var limits []string
+	for _, op := range mustBuildPatch(t, p, opts) {
+		if path := op["path"].(string); strings.Contains(path, "/resources") {
+			limits = append(limits, fmt.Sprintf("%s=%v", path, op["value"]))
+		}
+	}
+	if want := []string{"/spec/containers/1/resources/limits=map[nvidia.com/gpu:1]"}; !slices.Equal(limits, want) {
+		t.Errorf("Expected limit patches %v, got %v", want, limits)
+	}
+}
+
+func TestVendorPatchOptions(t *testing.T) {
+	tests := []struct {
+		vendor   util.Vendor
+		resource string
+		envVars  []string
+		// other is a resource of another vendor, which must not be patched.
+		other corev1.ResourceName
+		want  []string
+	}{
+		{vendor: util.VendorNVIDIA, other: util.ResourceAMDGPU, want: []string{"CUDA_VISIBLE_DEVICES"}},
+		{vendor: util.VendorAMD, other: util.ResourceGPU, want: []string{"ROCR_VISIBLE_DEVICES", "HIP_VISIBLE_DEVICES"}},
+		{vendor: util.VendorAMD, resource: "amd.com/mi300x", envVars: []string{"HIP_VISIBLE_DEVICES"}, other: util.ResourceAMDGPU, want: []string{"HIP_VISIBLE_DEVICES"}},
+	}
+	for _, tt := range tests {
+		opts := vendorPatchOptions(tt.vendor, tt.resource, tt.envVars)
+		name := tt.vendor.Resource
+		if tt.resource != "" {
+			name = corev1.ResourceName(tt.resource)
+		}
+		limit := func(r corev1.ResourceName) corev1.ResourceRequirements {
+			return corev1.ResourceRequirements{Limits: corev1.ResourceList{r: resource.MustParse("1")}}
+		}
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "other", Resources: limit(tt.other)},
+			{Name: "worker", Resources: limit(name)},
+		}}}
+
+		ops := mustBuildPatch(t, pod, opts)
+		if len(ops) != 1 || ops[0]["path"] != "/spec/containers/1/env" {
+			t.Fatalf("%s: Expected only the worker to be patched, got %v", tt.vendor.Name, ops)
+		}
+		var got []string
+		for _, v := range ops[0]["value"].([]map[string]interface{}) {
+			got = append(got, v["name"].(string))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Expected %v injected, got %v", tt.vendor.Name, tt.want, got)
+		}
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+	allocated := corev1.PodReadinessGate{ConditionType: util.ConditionAllocated}
+	other := corev1.PodReadinessGate{ConditionType: "example.com/Ready"}
+
+	tests := []struct {
+		name       string
+		gates      []corev1.PodReadinessGate
+		containers []corev1.Container
+		scheduler  string
+		off        bool
+		wantPath   string
+		wantValue  interface{}
+	}{
+		{name: "no gates", wantPath: "/spec/readinessGates", wantValue: []corev1.PodReadinessGate{allocated}},
+		{name: "other gate", gates: []corev1.PodReadinessGate{other}, wantPath: "/spec/readinessGates/-", wantValue: allocated},
+		{name: "already gated", gates: []corev1.PodReadinessGate{other, allocated}},
+		{name: "option off", off: true},
+		{name: "ephemeral update", containers: []corev1.Container{}},
+		{name: "other scheduler", scheduler: "default-scheduler"},
+	}
+	for _, tt := range tests {
+		containers := []corev1.Container{{Name: "trainer", Resources: gpuLimits("1")}}
+		if tt.containers != nil {
+			containers = tt.containers
+		}
+		scheduler := "gpu-scheduler"
+		if tt.scheduler != "" {
+			scheduler = tt.scheduler
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoClaim: "1"}},
+			Spec:       corev1.PodSpec{SchedulerName: scheduler, Containers: containers, ReadinessGates: tt.gates},
+		}
+		o := opts
+		o.readinessGate, o.schedulerName = !tt.off, "gpu-scheduler"
+		var gateOps []map[string]interface{}
+		for _, op := range mustBuildPatch(t, pod, o) {
+			if strings.HasPrefix(op["path"].(string), "/spec/readinessGates") {
+				gateOps = append(gateOps, op)
+			}
+		}
+		if tt.wantPath == "" {
+			if len(gateOps) != 0 {
+				t.Errorf("%s: Expected no readiness gate op, got %v", tt.name, gateOps)
+			}
+			continue
+		}
+		if len(gateOps) != 1 || gateOps[0]["op"] != "add" || gateOps[0]["path"] != tt.wantPath ||
+			fmt.Sprint(gateOps[0]["value"]) != fmt.Sprint(tt.wantValue) {
+			t.Errorf("%s: Expected add %s %v, got %v", tt.name, tt.wantPath, tt.wantValue, gateOps)
+		}
+	}
+}
+
+func TestInjectGPULimits(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", gpuLimit: 2}
+	cpuLimits := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}}
+	lower := gpuLimits("1")
+	lower.Requests = corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}
+
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		// want maps each expected limit or request op's path to its value.
+		want map[string]interface{}
+	}{
+		{name: "no resources", want: map[string]interface{}{
+			"/spec/containers/0/resources/limits": map[string]string{"nvidia.com/gpu": "2"},
+		}},
+		{name: "other limits", resources: cpuLimits, want: map[string]interface{}{
+			"/spec/containers/0/resources/limits/nvidia.com~1gpu": "2",
+		}},
+		{name: "lower limit kept", resources: lower, want: map[string]interface{}{}},
+		{name: "request only kept", resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")},
+		}, want: map[string]interface{}{}},
+		{name: "equal limit", resources: gpuLimits("2"), want: map[string]interface{}{}},
+		{name: "higher limit kept", resources: gpuLimits("4"), want: map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoClaim: "2"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer", Resources: tt.resources}, {Name: "sidecar"}}},
+		}
+		got := map[string]interface{}{}
+		var envPatched bool
+		for _, op := range mustBuildPatch(t, pod, opts) {
+			path := op["path"].(string)
+			switch {
+			case strings.HasPrefix(path, "/spec/containers/1/"):
+				t.Errorf("%s: Expected the sidecar left alone, got %v", tt.name, op)
+			case strings.Contains(path, "/resources/"):
+				got[path] = op["value"]
+			default:
+				envPatched = true
+			}
+		}
+		if !envPatched {
+			t.Errorf("%s: Expected the env vars injected into trainer", tt.name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: Expected resource ops %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// Without a limit to set, a container that requests no GPU is not patched.
+	opts.gpuLimit = 0
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer"}}}}
+	if ops := mustBuildPatch(t, pod, opts); len(ops) != 0 {
+		t.Errorf("Expected no patch, got %v", ops)
+	}
+}
+
+func TestInjectGPULimitsSeveralContainers(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu", gpuLimit: 3}
+	optIn := map[string]string{util.AnnoClaim: "3", util.AnnoInjectContainers: "trainer,evaluator"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		containers  []corev1.Container
+		want        map[string]interface{}
+	}{
+		{
+			name:        "limits adding up to the claim",
+			annotations: map[string]string{util.AnnoClaim: "3"},
+			containers:  []corev1.Container{{Name: "trainer", Resources: gpuLimits("2")}, {Name: "evaluator", Resources: gpuLimits("1")}},
+			want:        map[string]interface{}{},
+		},
+		{
+			name:        "several without limits",
+			annotations: optIn,
+			containers:  []corev1.Container{{Name: "trainer"}, {Name: "evaluator"}},
+			want:        map[string]interface{}{},
+		},
+		{
+			name: "several with their own counts",
+			annotations: map[string]string{
+				util.AnnoClaim: "3", util.AnnoInjectContainers: "trainer,evaluator",
+				util.AnnoContainerDevices: `{"trainer":2,"evaluator":1}`,
+			},
+			containers: []corev1.Container{{Name: "trainer"}, {Name: "evaluator"}},
+			want: map[string]interface{}{
+				"/spec/containers/0/resources/limits": map[string]string{"nvidia.com/gpu": "2"},
+				"/spec/containers/1/resources/limits": map[string]string{"nvidia.com/gpu": "1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+			Spec:       corev1.PodSpec{Containers: tt.containers},
+		}
+		got := map[string]interface{}{}
+		for _, op := range mustBuildPatch(t, pod, opts) {
+			if path := op["path"].(string); strings.Contains(path, "/resources") {
+				got[path] = op["value"]
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: Expected resource ops %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestClaimLimit(t *testing.T) {
+	defer func(r crclient.Reader) { claims = r }(claims)
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("AddToScheme: %v", err)
+	}
+	claims = crfake.NewClientBuilder().WithScheme(scheme).WithObjects(&apiv1.GpuClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "default"},
+		Spec:       apiv1.GpuClaimSpec{Devices: apiv1.DeviceRequest{Count: 4}},
+	}).Build()
+
+	tests := []struct {
+		annotations map[string]string
+		want        int
+	}{
+		{annotations: map[string]string{util.AnnoClaim: "2,model=A100"}, want: 2},
+		{annotations: map[string]string{util.AnnoClaim: "training"}, want: 4},
+		{annotations: map[string]string{util.AnnoClaim: "missing"}, want: 0},
+		{annotations: map[string]string{util.AnnoClaim: "0.5"}, want: 0},
+		{annotations: map[string]string{util.AnnoClaim: "1", util.AnnoMIGProfile: "1g.5gb"}, want: 0},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+		got, err := claimLimit(context.Background(), "default", pod)
+		if err != nil || got != tt.want {
+			t.Errorf("%v: Expected limit %d, got %d, %v", tt.annotations, tt.want, got, err)
+		}
+	}
+}
+
+func TestMixedVendorPatch(t *testing.T) {
+	limit := func(r corev1.ResourceName) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Limits: corev1.ResourceList{r: resource.MustParse("1")}}
+	}
+	envNames := func(op map[string]interface{}) []string {
+		var names []string
+		for _, v := range op["value"].([]map[string]interface{}) {
+			names = append(names, v["name"].(string))
+		}
+		return names
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.ContainerAllocatedKey("rocm"): "0"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "cpu"},
+				{Name: "rocm", Resources: limit(util.ResourceAMDGPU)},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug"}}},
+		},
+	}
+
+	// Without --mixed-vendors only the --gpu-vendor's resource is patched.
+	single := vendorPatchOptions(util.VendorNVIDIA, "", nil)
+	ops := mustBuildPatch(t, pod, single)
+	if len(ops) != 1 || ops[0]["path"] != "/spec/ephemeralContainers/0/env" {
+		t.Fatalf("Expected only the ephemeral container patched, got %v", ops)
+	}
+
+	mixed := single.withOtherVendors(util.VendorNVIDIA)
+	ops = mustBuildPatch(t, pod, mixed)
+	if len(ops) != 2 {
+		t.Fatalf("Expected the AMD and ephemeral containers patched, got %v", ops)
+	}
+	want := util.VendorAMD.EnvVars
+	for i, path := range []string{"/spec/containers/1/env", "/spec/ephemeralContainers/0/env"} {
+		if ops[i]["path"] != path || !slices.Equal(envNames(ops[i]), want) {
+			t.Errorf("Expected %v injected at %s, got %v", want, path, ops[i])
+		}
+	}
+
+	// A container of the --gpu-vendor keeps its variables.
+	pod.Spec.Containers[0].Resources = limit(util.ResourceGPU)
+	ops = mustBuildPatch(t, pod, mixed)
+	if got := envNames(ops[0]); ops[0]["path"] != "/spec/containers/0/env" || !slices.Equal(got, util.VendorNVIDIA.EnvVars) {
+		t.Errorf("Expected %v injected into the NVIDIA container, got %v", util.VendorNVIDIA.EnvVars, ops[0])
+	}
+}
+
+func TestMixedVendorOptedIn(t *testing.T) {
+	envNames := func(op map[string]interface{}) []string {
+		var names []string
+		for _, v := range op["value"].([]map[string]interface{}) {
+			names = append(names, v["name"].(string))
+		}
+		return names
+	}
+	mixed := vendorPatchOptions(util.VendorNVIDIA, "", nil).withOtherVendors(util.VendorNVIDIA)
+	every := append(slices.Clone(util.VendorNVIDIA.EnvVars), util.VendorAMD.EnvVars...)
+	amd := corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceAMDGPU: resource.MustParse("1")}}
+
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		gpuLimit   int
+		want       []string
+	}{
+		{
+			name:       "beside an AMD container",
+			containers: []corev1.Container{{Name: "main"}, {Name: "rocm", Resources: amd}},
+			want:       util.VendorAMD.EnvVars,
+		},
+		{
+			name:       "vendor unknown",
+			containers: []corev1.Container{{Name: "main"}},
+			want:       every,
+		},
+		{
+			// The injected nvidia.com/gpu limit keeps the pod to NVIDIA nodes.
+			name:       "limit injected",
+			containers: []corev1.Container{{Name: "main"}},
+			gpuLimit:   1,
+			want:       util.VendorNVIDIA.EnvVars,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.AnnoInjectContainers: "main"}},
+				Spec:       corev1.PodSpec{Containers: tt.containers},
+			}
+			opts := mixed
+			opts.gpuLimit = tt.gpuLimit
+			ops := mustBuildPatch(t, pod, opts)
+			if len(ops) == 0 || ops[0]["path"] != "/spec/containers/0/env" {
+				t.Fatalf("Expected the opted-in container patched, got %v", ops)
+			}
+			if got := envNames(ops[0]); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v injected, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateMIGVendor(t *testing.T) {
+	defer func(v util.Vendor) { vendor = v }(vendor)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default", Annotations: map[string]string{
+		util.AnnoClaim:      "1",
+		util.AnnoMIGProfile: "1g.5gb",
+	}}}
+	if resp := review(t, validate, pod); !resp.Allowed {
+		t.Errorf("Expected a MIG claim on NVIDIA to be allowed, got %+v", resp.Result)
+	}
+	vendor = util.VendorAMD
+	if resp := review(t, validate, pod); resp.Allowed {
+		t.Errorf("Expected a MIG claim on AMD to be denied")
+	}
+}
+
+func TestMutateFailurePolicy(t *testing.T) {
+	defer func(p admregv1.FailurePolicyType) { errorPolicy = p }(errorPolicy)
+
+	body, _ := json.Marshal(admv1.AdmissionReview{
+		Request: &admv1.AdmissionRequest{
+			UID:    "review-uid",
+			Object: runtime.RawExtension{Raw: []byte(`{"spec":{"containers":"not-a-list"}}`)},
+		},
+	})
+	for _, tt := range []struct {
+		policy  admregv1.FailurePolicyType
+		allowed bool
+	}{
+		{admregv1.Ignore, true},
+		{admregv1.Fail, false},
+	} {
+		errorPolicy = tt.policy
+		rec := httptest.NewRecorder()
+		mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+
+		var out admv1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		resp := out.Response
+		if resp.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v", tt.policy, resp.Allowed, tt.allowed)
+		}
+		if resp.UID != "review-uid" {
+			t.Errorf("%s: expected UID to be echoed, got %q", tt.policy, resp.UID)
+		}
+		if resp.Patch != nil {
+			t.Errorf("%s: expected no patch on error, got %s", tt.policy, resp.Patch)
+		}
+		if resp.Result == nil || resp.Result.Message == "" {
+			t.Errorf("%s: expected the error to be reported", tt.policy)
+		}
+	}
+}
+
+func TestNamespaceFilter(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny string
+		want        map[string]bool
+	}{
+		{
+			name: "no lists",
+			want: map[string]bool{"default": true, "ml": true},
+		},
+		{
+			name:  "allow only",
+			allow: "ml, research",
+			want:  map[string]bool{"ml": true, "research": true, "default": false},
+		},
+		{
+			name: "deny only",
+			deny: "kube-system",
+			want: map[string]bool{"kube-system": false, "ml": true},
+		},
+		{
+			name:  "overlap",
+			allow: "ml,research",
+			deny:  "research",
+			want:  map[string]bool{"ml": true, "research": false, "default": false},
+		},
+	}
+	for _, tt := range tests {
+		f := newNamespaceFilter(tt.allow, tt.deny)
+		for ns, want := range tt.want {
+			if got := f.allowed(ns); got != want {
+				t.Errorf("%s: allowed(%q) = %v, want %v", tt.name, ns, got, want)
+			}
+		}
+	}
+}
+
+func TestMutateSkipsExcludedNamespace(t *testing.T) {
+	opts := patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "p",
+			Namespace:   "default",
+			Annotations: map[string]string{util.AnnoClaim: "1"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "c", Resources: gpuLimits("1")}}},
+	}
+
+	useSettings(t, webhookSettings{patchOpts: opts, nsFilter: newNamespaceFilter("", "")})
+	if resp := review(t, mutate, pod); resp.Patch == nil {
+		t.Errorf("Expected a patch without filters")
+	}
+	useSettings(t, webhookSettings{patchOpts: opts, nsFilter: newNamespaceFilter("ml", "")})
+	if resp := review(t, mutate, pod); !resp.Allowed || resp.Patch != nil {
+		t.Errorf("Expected pod outside the allowlist to be admitted unpatched, got %+v", resp)
+	}
+}
+
+func TestMutateLogsDecision(t *testing.T) {
+	useSettings(t, webhookSettings{
+		patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"},
+		nsFilter:  newNamespaceFilter("", ""),
+	})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "trainer",
+			Namespace:   "ml",
+			Annotations: map[string]string{util.AnnoClaim: "1"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "c", Resources: gpuLimits("1")}}},
+	}
+	raw, _ := json.Marshal(pod)
+	body, _ := json.Marshal(admv1.AdmissionReview{
+		Request: &admv1.AdmissionRequest{
+			UID:       "review-uid",
+			Namespace: "ml",
+			Operation: admv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	logger := ktesting.NewLogger(t, ktesting.NewConfig(ktesting.BufferLogs(true)))
+	ctx := klog.NewContext(context.Background(), logger)
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)).WithContext(ctx)
+	mutate(httptest.NewRecorder(), req)
+
+	entries := logger.GetSink().(ktesting.Underlier).GetBuffer().Data()
+	if len(entries) != 1 {
+		t.Fatalf("Expected one log line for the request, got %d: %v", len(entries), entries)
+	}
+	got := map[string]interface{}{}
+	for _, kvs := range [][]interface{}{entries[0].WithKVList, entries[0].ParameterKVList} {
+		for i := 0; i+1 < len(kvs); i += 2 {
+			got[kvs[i].(string)] = kvs[i+1]
+		}
+	}
+	want := map[string]interface{}{
+		"correlationID": "review-uid",
+		"pod":           klog.KRef("ml", "trainer"),
+		"operation":     admv1.Create,
+		"decision":      "patched",
+		"allowed":       true,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, got[k])
+		}
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestMutateDryRun(t *testing.T) {
+	registerMetrics()
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	raw, _ := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: map[string]string{util.AnnoClaim: "1"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+	})
+	send := func(dryRun bool) (*admv1.AdmissionResponse, map[string]interface{}) {
+		body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+			UID: "review-uid", Namespace: "ml", Name: "trainer", DryRun: &dryRun, Object: runtime.RawExtension{Raw: raw},
+		}})
+		logger := ktesting.NewLogger(t, ktesting.NewConfig(ktesting.BufferLogs(true)))
+		req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)).WithContext(klog.NewContext(context.Background(), logger))
+		rec := httptest.NewRecorder()
+		mutate(rec, req)
+		var out admv1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		kv := map[string]interface{}{}
+		for _, entry := range logger.GetSink().(ktesting.Underlier).GetBuffer().Data() {
+			for i := 0; i+1 < len(entry.WithKVList); i += 2 {
+				kv[entry.WithKVList[i].(string)] = entry.WithKVList[i+1]
+			}
+		}
+		return out.Response, kv
+	}
+	counts := func() (float64, float64) {
+		patched, err := testutil.GetCounterMetricValue(patchesTotal)
+		if err != nil {
+			t.Fatalf("read patches_total: %v", err)
+		}
+		dryRun, err := testutil.GetCounterMetricValue(dryRunPatchesTotal)
+		if err != nil {
+			t.Fatalf("read dry_run_patches_total: %v", err)
+		}
+		return patched, dryRun
+	}
+
+	patched, dryRun := counts()
+	resp, logged := send(true)
+	if resp.Patch == nil {
+		t.Fatalf("Expected a dry run to be patched")
+	}
+	if logged["dryRun"] != true {
+		t.Errorf("Expected the dry run's log lines to be tagged dryRun=true, got %v", logged)
+	}
+	if p, d := counts(); p != patched || d != dryRun+1 {
+		t.Errorf("Expected only the dry-run counter to grow, got patches %v -> %v, dry-run %v -> %v", patched, p, dryRun, d)
+	}
+
+	resp, logged = send(false)
+	if resp.Patch == nil {
+		t.Fatalf("Expected the pod to be patched")
+	}
+	if _, ok := logged["dryRun"]; ok {
+		t.Errorf("Expected no dryRun tag on a real request, got %v", logged)
+	}
+	if p, d := counts(); p != patched+1 || d != dryRun+1 {
+		t.Errorf("Expected only the patch counter to grow, got patches %v -> %v, dry-run %v -> %v", patched, p, dryRun+1, d)
+	}
+}
+
+func TestAdmissionMetrics(t *testing.T) {
+	registerMetrics()
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", instrument("mutate", mutate))
+	mux.HandleFunc("/validate", instrument("validate", validate))
+
+	review := func(annotations map[string]string) []byte {
+		raw, _ := json.Marshal(corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+		})
+		body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+			UID: "review-uid", Namespace: "ml", Name: "trainer", Object: runtime.RawExtension{Raw: raw},
+		}})
+		return body
+	}
+	outcomes := func() map[string]float64 {
+		counts := map[string]float64{}
+		for _, outcome := range []string{outcomeAllowed, outcomeDenied, outcomeError} {
+			v, err := testutil.GetCounterMetricValue(requestsTotal.WithLabelValues(outcome))
+			if err != nil {
+				t.Fatalf("read requests_total{outcome=%q}: %v", outcome, err)
+			}
+			counts[outcome] = v
+		}
+		return counts
+	}
+
+	before := outcomes()
+	for _, req := range []struct {
+		path string
+		body []byte
+	}{
+		{"/mutate", review(nil)},
+		{"/validate", review(map[string]string{util.AnnoClaim: "1"})},
+		{"/validate", review(map[string]string{util.AnnoClaim: "1,color=blue"})},
+		{"/mutate", []byte("{not json")},
+		{"/validate", []byte("{not json")},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, req.path, bytes.NewReader(req.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected status 200, got %d", req.path, rec.Code)
+		}
+	}
+	after := outcomes()
+	for outcome, want := range map[string]float64{outcomeAllowed: 2, outcomeDenied: 1, outcomeError: 2} {
+		if got := after[outcome] - before[outcome]; got != want {
+			t.Errorf("Expected %v more %s requests, got %v", want, outcome, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	metricsMux(&readiness{}, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scraped := rec.Body.String()
+	for _, series := range []string{
+		`gpu_webhook_request_duration_seconds_count{operation="mutate"}`,
+		`gpu_webhook_request_duration_seconds_count{operation="validate"}`,
+		`gpu_webhook_requests_total{outcome="allowed"}`,
+		`gpu_webhook_requests_total{outcome="denied"}`,
+		`gpu_webhook_requests_total{outcome="error"}`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(scraped, series) {
+			t.Errorf("Expected /metrics to export %s", series)
+		}
+	}
+}
+
+func TestMutateCountsClaimsByUser(t *testing.T) {
+	registerMetrics()
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	send := func(user string, owners []metav1.OwnerReference, serviceAccount string, dryRun bool, claim string) {
+		raw, _ := json.Marshal(corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "trainer", Namespace: "ml", OwnerReferences: owners,
+				Annotations: map[string]string{util.AnnoClaim: claim},
+			},
+			Spec: corev1.PodSpec{ServiceAccountName: serviceAccount, Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+		})
+		body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+			UID: "review-uid", Namespace: "ml", Name: "trainer", Operation: admv1.Create, DryRun: &dryRun,
+			UserInfo: authenticationv1.UserInfo{Username: user}, Object: runtime.RawExtension{Raw: raw},
+		}})
+		mutate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	}
+	count := func(user string) float64 {
+		v, err := testutil.GetCounterMetricValue(claimsByUserTotal.WithLabelValues(user))
+		if err != nil {
+			t.Fatalf("read claims_by_user_total{user=%q}: %v", user, err)
+		}
+		return v
+	}
+	controller := true
+	owned := func(kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: "uid-rs", Controller: &controller}}
+	}
+	const sa = "system:serviceaccount:kube-system:replicaset-controller"
+
+	alice, trainer, fallback := count("alice"), count("ServiceAccount/ml/trainer"), count("ServiceAccount/ml/default")
+	send("alice", nil, "trainer", false, "1")
+	send("alice", owned("ReplicaSet", "trainer-5d8f"), "trainer", false, "1")
+	// Each rollout brings a new ReplicaSet; its pods still count as the
+	// service account's.
+	send(sa, owned("ReplicaSet", "trainer-5d8f"), "trainer", false, "1")
+	send(sa, owned("ReplicaSet", "trainer-7b2c"), "trainer", false, "1")
+	send(sa, nil, "", false, "1")
+	send("alice", nil, "trainer", true, "1")
+	send("alice", nil, "trainer", false, "-1")
+	if got := count("alice") - alice; got != 2 {
+		t.Errorf("Expected 2 claims by alice, dry runs and invalid claims aside, got %v", got)
+	}
+	if got := count("ServiceAccount/ml/trainer") - trainer; got != 2 {
+		t.Errorf("Expected both ReplicaSets' pods counted under their service account, got %v", got)
+	}
+	if got := count("ServiceAccount/ml/default") - fallback; got != 1 {
+		t.Errorf("Expected a pod without a service account counted under default, got %v", got)
+	}
+}
+
+func TestMutateRecordsRequestingUser(t *testing.T) {
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+	raw, _ := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "trainer", Namespace: "ml",
+			// A value set by the user is replaced.
+			Annotations: map[string]string{util.AnnoClaim: "1", util.AnnoRequestedBy: "mallory"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+	})
+	body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+		UID: "review-uid", Namespace: "ml", Name: "trainer",
+		UserInfo: authenticationv1.UserInfo{Username: "alice"}, Object: runtime.RawExtension{Raw: raw},
+	}})
+	rec := httptest.NewRecorder()
+	mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	var out admv1.AdmissionReview
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	var patch []map[string]interface{}
+	if err := json.Unmarshal(out.Response.Patch, &patch); err != nil {
+		t.Fatalf("decode patch: %v", err)
+	}
+	want := map[string]interface{}{"op": "add", "path": "/metadata/annotations/gpu.scheduling~1requested-by", "value": "alice"}
+	if !slices.ContainsFunc(patch, func(op map[string]interface{}) bool { return maps.Equal(op, want) }) {
+		t.Errorf("Expected patch op %v, got %v", want, patch)
+	}
+}
+
+func TestMutateOverwritesRequestingUserWithoutClaim(t *testing.T) {
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+	path := "/metadata/annotations/gpu.scheduling~1requested-by"
+	tests := []struct {
+		name string
+		user string
+		want map[string]interface{}
+	}{
+		{name: "known user", user: "alice", want: map[string]interface{}{"op": "add", "path": path, "value": "alice"}},
+		{name: "no user", want: map[string]interface{}{"op": "remove", "path": path}},
+	}
+	for _, tt := range tests {
+		// A pod created without a claim may have one added by an update,
+		// which keeps the annotation.
+		raw, _ := json.Marshal(corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml",
+				Annotations: map[string]string{util.AnnoRequestedBy: "mallory"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+		})
+		body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+			UID: "review-uid", Namespace: "ml", Name: "trainer", Operation: admv1.Create,
+			UserInfo: authenticationv1.UserInfo{Username: tt.user}, Object: runtime.RawExtension{Raw: raw},
+		}})
+		rec := httptest.NewRecorder()
+		mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+		var out admv1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("%s: decode response: %v", tt.name, err)
+		}
+		var patch []map[string]interface{}
+		if err := json.Unmarshal(out.Response.Patch, &patch); err != nil {
+			t.Fatalf("%s: decode patch: %v", tt.name, err)
+		}
+		if len(patch) != 1 || !maps.Equal(patch[0], tt.want) {
+			t.Errorf("%s: Expected patch [%v], got %v", tt.name, tt.want, patch)
+		}
+	}
+}
+
+func TestValidateProtectsRequestingUser(t *testing.T) {
+	withUser := func(user string) []byte {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml",
+			Annotations: map[string]string{util.AnnoClaim: "1"}}}
+		if user != "" {
+			pod.Annotations[util.AnnoRequestedBy] = user
+		}
+		raw, _ := json.Marshal(pod)
+		return raw
+	}
+	tests := []struct {
+		name     string
+		old, new string
+		allowed  bool
+	}{
+		{name: "unchanged", old: "alice", new: "alice", allowed: true},
+		{name: "changed", old: "alice", new: "mallory"},
+		{name: "removed", old: "alice"},
+		{name: "added", new: "mallory"},
+		{name: "never set", allowed: true},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{
+			UID: "review-uid", Namespace: "ml", Name: "trainer", Operation: admv1.Update,
+			Object: runtime.RawExtension{Raw: withUser(tt.new)}, OldObject: runtime.RawExtension{Raw: withUser(tt.old)},
+		}})
+		rec := httptest.NewRecorder()
+		validate(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+		var out admv1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("%s: decode response: %v", tt.name, err)
+		}
+		if out.Response.Allowed != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v (%v)", tt.name, out.Response.Allowed, tt.allowed, out.Response.Result)
+		}
+	}
+}
+
+func TestMutateClampsClaim(t *testing.T) {
+	defer func(n int, clamp bool) { maxGPUsPerPod, clampClaims = n, clamp }(maxGPUsPerPod, clampClaims)
+	maxGPUsPerPod, clampClaims = 4, true
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		clamp       bool
+		wantClaim   string
+	}{
+		{name: "above the cap", annotations: map[string]string{util.AnnoClaim: "6,model=A100"}, clamp: true, wantClaim: "4,model=A100"},
+		{name: "at the cap", annotations: map[string]string{util.AnnoClaim: "4"}, clamp: true},
+		{name: "GpuClaim", annotations: map[string]string{util.AnnoClaim: "big"}, clamp: true},
+		{name: "MIG instances", annotations: map[string]string{util.AnnoClaim: "7", util.AnnoMIGProfile: "1g.5gb"}, clamp: true},
+		{name: "clamping off", annotations: map[string]string{util.AnnoClaim: "6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clampClaims = tt.clamp
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: tt.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Resources: gpuLimits("1")}}},
+			}
+			resp := review(t, mutate, pod)
+			var patch []map[string]interface{}
+			if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+				t.Fatalf("decode patch: %v", err)
+			}
+			annotations := map[string]interface{}{}
+			for _, op := range patch {
+				if path := op["path"].(string); strings.HasPrefix(path, "/metadata/annotations/") {
+					annotations[strings.TrimPrefix(path, "/metadata/annotations/")] = op["value"]
+				}
+			}
+			if tt.wantClaim == "" {
+				if len(annotations) > 0 || len(resp.Warnings) > 0 {
+					t.Errorf("Expected the claim to be left alone, got annotations %v and warnings %v", annotations, resp.Warnings)
+				}
+				return
+			}
+			note := "claim lowered from 6 to 4 GPUs, the limit per pod"
+			want := map[string]interface{}{"gpu.scheduling~1claim": tt.wantClaim, "gpu.scheduling~1webhook-notes": note}
+			if !maps.Equal(annotations, want) {
+				t.Errorf("Expected annotations %v, got %v", want, annotations)
+			}
+			if !slices.Equal(resp.Warnings, []string{note}) {
+				t.Errorf("Expected warning %q, got %v", note, resp.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	admv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const metricsSubsystem = "gpu_webhook"
+
+// Outcomes of an admission request, used as the outcome metric label.
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+	outcomeError   = "error"
+)
+
+// serviceAccountPrefix starts the username of a service account.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+var (
+	patchesTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "patches_total",
+			Help:           "Pods the mutating webhook patched, not counting dry-run requests.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	dryRunPatchesTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "dry_run_patches_total",
+			Help:           "Patches the mutating webhook returned for dry-run requests, e.g. kubectl apply --dry-run=server.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	requestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "request_duration_seconds",
+			Help:           "Time taken to answer an admission request, by operation.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"operation"})
+
+	requestsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "requests_total",
+			Help:           "Admission requests answered, by outcome: allowed, denied, or error when the webhook failed to process them.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"outcome"})
+
+	claimsByUserTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "claims_by_user_total",
+			Help:           "Pods with a GPU claim the mutating webhook admitted, by the user who created them, or the pod's own service account as ServiceAccount/namespace/name when a service account did.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"user"})
+
+	metricsOnce sync.Once
+)
+
+// registerMetrics registers the webhook metrics with the legacy registry
+// served on /metrics, which already carries the Go runtime and process
+// collectors. It is safe to call more than once.
+func registerMetrics() {
+	metricsOnce.Do(func() {
+		legacyregistry.MustRegister(patchesTotal, dryRunPatchesTotal, requestDuration, requestsTotal, claimsByUserTotal)
+	})
+}
+
+// instrument times the admission handler h under operation.
+func instrument(operation string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h(w, r)
+		requestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// countOutcome counts an answered request as allowed or denied.
+func countOutcome(allowed bool) {
+	outcome := outcomeDenied
+	if allowed {
+		outcome = outcomeAllowed
+	}
+	requestsTotal.WithLabelValues(outcome).Inc()
+}
+
+// countPatch counts a patched pod; a dry run changes nothing, so it goes to
+// its own counter.
+func countPatch(dryRun bool) {
+	if dryRun {
+		dryRunPatchesTotal.Inc()
+		return
+	}
+	patchesTotal.Inc()
+}
+
+// countClaim counts an admitted pod with a GPU claim under the identity that
+// created it.
+func countClaim(req *admv1.AdmissionRequest, pod *corev1.Pod) {
+	claimsByUserTotal.WithLabelValues(claimUser(req, pod)).Inc()
+}
+
+// claimUser names who asked for the pod's GPUs: the requesting user, or,
+// when that is a service account such as the ReplicaSet controller's, the
+// service account the pod runs as, e.g. ServiceAccount/ml/trainer. Unlike
+// the pod's controller, which a rollout or a CronJob run replaces, the
+// service account stays put, so the label takes as many values as there are
+// users and service accounts.
+func claimUser(req *admv1.AdmissionRequest, pod *corev1.Pod) string {
+	user := req.UserInfo.Username
+	if strings.HasPrefix(user, serviceAccountPrefix) {
+		sa := pod.Spec.ServiceAccountName
+		if sa == "" {
+			sa = "default"
+		}
+		return "ServiceAccount/" + req.Namespace + "/" + sa
+	}
+	if user == "" {
+		return "unknown"
+	}
+	return user
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	admv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// API servers before 1.16 only send admission.k8s.io/v1beta1 reviews. The
+// handlers work on v1 throughout: a v1beta1 review is converted on the way
+// in, and its response converted back, so the API server gets the version
+// it sent.
+var (
+	reviewV1      = admv1.SchemeGroupVersion.String()
+	reviewV1beta1 = admv1beta1.SchemeGroupVersion.String()
+)
+
+// decodeReview decodes the AdmissionReview, which must carry a request. The
+// review keeps the apiVersion it was sent with, v1 when it names none. A body
+// over maxRequestBytes is refused before any of it is decoded.
+func decodeReview(w http.ResponseWriter, r *http.Request) (admv1.AdmissionReview, error) {
+	defer r.Body.Close()
+	var review admv1.AdmissionReview
+	src := r.Body
+	if maxRequestBytes > 0 {
+		src = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	}
+	body, err := io.ReadAll(src)
+	if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		return review, fmt.Errorf("request body larger than %d bytes", tooLarge.Limit)
+	}
+	if err != nil {
+		return review, err
+	}
+	var meta metav1.TypeMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return review, err
+	}
+	if meta.APIVersion == reviewV1beta1 {
+		var old admv1beta1.AdmissionReview
+		if err := json.Unmarshal(body, &old); err != nil {
+			review.APIVersion = reviewV1beta1
+			return review, err
+		}
+		review = reviewFromV1beta1(old)
+	} else if err := json.Unmarshal(body, &review); err != nil {
+		return review, err
+	}
+	if review.Request == nil {
+		return review, fmt.Errorf("empty request")
+	}
+	return review, nil
+}
+
+// writeResponse answers in the apiVersion the review was sent with.
+func writeResponse(w http.ResponseWriter, review admv1.AdmissionReview) {
+	w.Header().Set("Content-Type", "application/json")
+	if review.APIVersion == reviewV1beta1 {
+		_ = json.NewEncoder(w).Encode(reviewToV1beta1(review))
+		return
+	}
+	review.APIVersion, review.Kind = reviewV1, "AdmissionReview"
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+// reviewFromV1beta1 converts a v1beta1 review to v1. The request fields are
+// the same in both versions.
+func reviewFromV1beta1(in admv1beta1.AdmissionReview) admv1.AdmissionReview {
+	out := admv1.AdmissionReview{TypeMeta: in.TypeMeta}
+	if req := in.Request; req != nil {
+		out.Request = &admv1.AdmissionRequest{
+			UID:                req.UID,
+			Kind:               req.Kind,
+			Resource:           req.Resource,
+			SubResource:        req.SubResource,
+			RequestKind:        req.RequestKind,
+			RequestResource:    req.RequestResource,
+			RequestSubResource: req.RequestSubResource,
+			Name:               req.Name,
+			Namespace:          req.Namespace,
+			Operation:          admv1.Operation(req.Operation),
+			UserInfo:           req.UserInfo,
+			Object:             req.Object,
+			OldObject:          req.OldObject,
+			DryRun:             req.DryRun,
+			Options:            req.Options,
+		}
+	}
+	return out
+}
+
+// reviewToV1beta1 converts a v1 review, with its response, to v1beta1.
+func reviewToV1beta1(in admv1.AdmissionReview) admv1beta1.AdmissionReview {
+	out := admv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: reviewV1beta1, Kind: "AdmissionReview"},
+	}
+	if resp := in.Response; resp != nil {
+		out.Response = &admv1beta1.AdmissionResponse{
+			UID:              resp.UID,
+			Allowed:          resp.Allowed,
+			Result:           resp.Result,
+			Patch:            resp.Patch,
+			AuditAnnotations: resp.AuditAnnotations,
+			Warnings:         resp.Warnings,
+		}
+		if resp.PatchType != nil {
+			pt := admv1beta1.PatchType(*resp.PatchType)
+			out.Response.PatchType = &pt
+		}
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admv1 "k8s.io/api/admission/v1"
+	admv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestReviewVersions(t *testing.T) {
+	useSettings(t, webhookSettings{patchOpts: patchOptions{envVars: []string{"CUDA_VISIBLE_DEVICES"}, gpuResource: "nvidia.com/gpu"}})
+
+	raw, _ := json.Marshal(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "default", Annotations: map[string]string{util.AnnoClaim: "1"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer", Resources: gpuLimits("1")}}},
+	})
+	request := map[string]interface{}{
+		"uid":       "review-uid",
+		"kind":      map[string]string{"version": "v1", "kind": "Pod"},
+		"namespace": "default",
+		"operation": "CREATE",
+		"object":    runtime.RawExtension{Raw: raw},
+	}
+
+	tests := []struct {
+		name       string
+		apiVersion string
+		want       string
+	}{
+		{name: "v1", apiVersion: "admission.k8s.io/v1", want: "admission.k8s.io/v1"},
+		{name: "v1beta1", apiVersion: "admission.k8s.io/v1beta1", want: "admission.k8s.io/v1beta1"},
+		{name: "no apiVersion", want: "admission.k8s.io/v1"},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]interface{}{
+			"apiVersion": tt.apiVersion,
+			"kind":       "AdmissionReview",
+			"request":    request,
+		})
+		rec := httptest.NewRecorder()
+		mutate(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+
+		var out admv1beta1.AdmissionReview
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("%s: decode response: %v", tt.name, err)
+		}
+		if out.APIVersion != tt.want || out.Kind != "AdmissionReview" {
+			t.Errorf("%s: Expected a %s AdmissionReview, got %s %s", tt.name, tt.want, out.APIVersion, out.Kind)
+		}
+		resp := out.Response
+		if resp == nil || !resp.Allowed || resp.UID != "review-uid" {
+			t.Fatalf("%s: Expected the request allowed with its UID, got %+v", tt.name, resp)
+		}
+		if resp.PatchType == nil || *resp.PatchType != admv1beta1.PatchTypeJSONPatch || len(resp.Patch) == 0 {
+			t.Errorf("%s: Expected a JSON patch, got %v %s", tt.name, resp.PatchType, resp.Patch)
+		}
+	}
+}
+
+func TestReviewV1beta1Conversion(t *testing.T) {
+	in := admv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request: &admv1beta1.AdmissionRequest{
+			UID:         "review-uid",
+			Namespace:   "default",
+			Name:        "trainer",
+			SubResource: "ephemeralcontainers",
+			Operation:   admv1beta1.Update,
+		},
+	}
+	review := reviewFromV1beta1(in)
+	req := review.Request
+	if req.UID != "review-uid" || req.Namespace != "default" || req.Name != "trainer" ||
+		req.SubResource != "ephemeralcontainers" || req.Operation != admv1.Update {
+		t.Errorf("Expected the request fields to carry over, got %+v", req)
+	}
+
+	pt := admv1.PatchTypeJSONPatch
+	review.Response = &admv1.AdmissionResponse{UID: req.UID, Allowed: true, Patch: []byte("[]"), PatchType: &pt, Warnings: []string{"w"}}
+	out := reviewToV1beta1(review)
+	resp := out.Response
+	if out.APIVersion != "admission.k8s.io/v1beta1" || resp.UID != "review-uid" || !resp.Allowed ||
+		string(resp.Patch) != "[]" || *resp.PatchType != admv1beta1.PatchTypeJSONPatch || len(resp.Warnings) != 1 {
+		t.Errorf("Expected the response fields to carry over, got %s %+v", out.APIVersion, resp)
+	}
+}
+
+func TestDecodeReviewLimitsBodySize(t *testing.T) {
+	defer func(n int64) { maxRequestBytes = n }(maxRequestBytes)
+	body, _ := json.Marshal(admv1.AdmissionReview{Request: &admv1.AdmissionRequest{UID: "review-uid"}})
+
+	tests := []struct {
+		name    string
+		limit   int64
+		body    []byte
+		wantErr string
+	}{
+		{name: "under the limit", limit: int64(len(body)), body: body},
+		{name: "no limit", body: append(body, bytes.Repeat([]byte(" "), 1<<20)...)},
+		{name: "over the limit", limit: int64(len(body)) - 1, body: body, wantErr: "larger than"},
+		{name: "padding counts", limit: 1 << 10, body: append(body, bytes.Repeat([]byte(" "), 1<<20)...), wantErr: "larger than 1024 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxRequestBytes = tt.limit
+			_, err := decodeReview(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected the review to decode, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// FuzzReview sends arbitrary bodies to the admission handlers, which must
+// answer every one with an AdmissionReview rather than panic.
+func FuzzReview(f *testing.F) {
+	defer func(n int64) { maxRequestBytes = n }(maxRequestBytes)
+	maxRequestBytes = 1 << 16
+	s := webhookSettings{patchOpts: vendorPatchOptions(util.VendorNVIDIA, "", nil).withOtherVendors(util.VendorNVIDIA)}
+	s.patchOpts.initContainers = true
+	s.patchOpts.injectLimits = true
+	s.patchOpts.readinessGate, s.patchOpts.schedulerName = true, "gpu-scheduler"
+	prev := settings.Load()
+	settings.Store(&s)
+	defer settings.Store(prev)
+
+	pod, _ := json.Marshal(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "default", Annotations: map[string]string{
+			util.AnnoClaim:    "2",
+			util.AnnoExtraEnv: `[{"name":"NCCL_DEBUG","value":"INFO"}]`,
+		}},
+		Spec: corev1.PodSpec{
+			Containers:          []corev1.Container{{Name: "trainer", Resources: gpuLimits("1"), Env: []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", Value: "0"}}}},
+			InitContainers:      []corev1.Container{{Name: "init", Resources: gpuLimits("1")}},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug"}}},
+		},
+	})
+	for _, apiVersion := range []string{reviewV1, reviewV1beta1} {
+		seed, _ := json.Marshal(map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       "AdmissionReview",
+			"request": map[string]interface{}{
+				"uid":       "review-uid",
+				"kind":      map[string]string{"version": "v1", "kind": "Pod"},
+				"namespace": "default",
+				"operation": "CREATE",
+				"object":    runtime.RawExtension{Raw: pod},
+			},
+		})
+		f.Add(seed)
+	}
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"request":{"object":null}}`))
+	f.Add([]byte(`{"request":{"subResource":"ephemeralcontainers","object":{"spec":{"ephemeralContainers":[{}]}}}}`))
+	f.Add([]byte(`[[[[[[[[[[`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		for name, handler := range map[string]http.HandlerFunc{"mutate": mutate, "validate": validate, "mutate-workload": mutateWorkload} {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+			var out admv1.AdmissionReview
+			if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+				t.Fatalf("%s: decode response: %v", name, err)
+			}
+			if out.Response == nil {
+				t.Fatalf("%s: Expected a response", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed key pair into dir.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gpu-scheduler-webhook"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestReadyzFlipsAfterCertLoad(t *testing.T) {
+	dir := t.TempDir()
+	ready := &readiness{}
+	ready.listening.Store(true)
+	certs := &certReloader{
+		certFile: filepath.Join(dir, "tls.crt"),
+		keyFile:  filepath.Join(dir, "tls.key"),
+		onLoad:   func() { ready.certLoaded.Store(true) },
+	}
+	mux := metricsMux(ready, false)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz 200, got %d", code)
+	}
+	if err := certs.reload(); err == nil {
+		t.Fatalf("Expected reload to fail before the cert exists")
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz 503 before cert load, got %d", code)
+	}
+
+	writeTestCert(t, dir)
+	if err := certs.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz 200 after cert load, got %d", code)
+	}
+	if cert, err := certs.GetCertificate(nil); err != nil || cert == nil {
+		t.Errorf("Expected a loaded certificate, got %v, %v", cert, err)
+	}
+}
+
+func TestMetricsMuxPprof(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		mux := metricsMux(&readiness{}, enabled)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+		want := http.StatusNotFound
+		if enabled {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Errorf("pprof enabled=%v: Expected /debug/pprof/ %d, got %d", enabled, want, rec.Code)
+		}
+	}
+}
+
+func TestServeUntilDoneDrainsInFlight(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveUntilDone(ctx, srv, func() error { return srv.Serve(ln) }, 5*time.Second)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/mutate")
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		resCh <- result{body: string(b), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-resCh
+	if res.err != nil || res.body != "done" {
+		t.Errorf("Expected in-flight request to complete, got %q, %v", res.body, res.err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// pointerEscaper escapes a map key for use in a JSON Patch path.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// mutateWorkload copies a claim annotation that was put on a Deployment or
+// StatefulSet itself into its pod template, where the pods it creates pick
+// it up. Pods are still patched by mutate; this only rescues the annotation.
+func mutateWorkload(w http.ResponseWriter, r *http.Request) {
+	review, err := decodeReview(w, r)
+	logger := workloadLogger(r.Context(), review)
+	if err != nil {
+		fail(w, logger, review, err)
+		return
+	}
+
+	response := &admv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	if !currentSettings().nsFilter.allowed(review.Request.Namespace) {
+		respond(w, logger, review, response, "skipped")
+		return
+	}
+	meta, template, err := workloadTemplate(review.Request.Kind.Kind, review.Request.Object.Raw)
+	if err != nil {
+		fail(w, logger, review, err)
+		return
+	}
+	patch := workloadPatch(meta, template)
+	if len(patch) == 0 {
+		respond(w, logger, review, response, "unchanged")
+		return
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		fail(w, logger, review, err)
+		return
+	}
+
+	pt := admv1.PatchTypeJSONPatch
+	response.PatchType = &pt
+	response.Patch = patchBytes
+	respond(w, logger, review, response, "patched", "patchOps", len(patch))
+}
+
+// workloadLogger is requestLogger for workload requests.
+func workloadLogger(ctx context.Context, review admv1.AdmissionReview) klog.Logger {
+	logger := klog.FromContext(ctx)
+	if review.Request == nil {
+		return logger
+	}
+	return klog.LoggerWithValues(logger,
+		"correlationID", string(review.Request.UID),
+		"kind", review.Request.Kind.Kind,
+		"workload", klog.KRef(review.Request.Namespace, review.Request.Name),
+		"operation", review.Request.Operation,
+	)
+}
+
+// workloadTemplate decodes a Deployment or StatefulSet into its own metadata
+// and its pod template.
+func workloadTemplate(kind string, raw []byte) (*metav1.ObjectMeta, *corev1.PodTemplateSpec, error) {
+	switch kind {
+	case "Deployment":
+		d := &appsv1.Deployment{}
+		if err := json.Unmarshal(raw, d); err != nil {
+			return nil, nil, err
+		}
+		return &d.ObjectMeta, &d.Spec.Template, nil
+	case "StatefulSet":
+		s := &appsv1.StatefulSet{}
+		if err := json.Unmarshal(raw, s); err != nil {
+			return nil, nil, err
+		}
+		return &s.ObjectMeta, &s.Spec.Template, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported workload kind %q", kind)
+}
+
+// workloadPatch adds the workload's claim annotation to its pod template. A
+// claim already on the template wins, so nothing is patched then.
+func workloadPatch(meta *metav1.ObjectMeta, template *corev1.PodTemplateSpec) []map[string]interface{} {
+	claim := meta.Annotations[util.AnnoClaim]
+	if claim == "" {
+		return nil
+	}
+	if _, ok := template.Annotations[util.AnnoClaim]; ok {
+		return nil
+	}
+	if template.Annotations == nil {
+		return []map[string]interface{}{{
+			"op":    "add",
+			"path":  "/spec/template/metadata/annotations",
+			"value": map[string]string{util.AnnoClaim: claim},
+		}}
+	}
+	return []map[string]interface{}{{
+		"op":    "add",
+		"path":  "/spec/template/metadata/annotations/" + pointerEscaper.Replace(util.AnnoClaim),
+		"value": claim,
+	}}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestMutateWorkloadCopiesClaim(t *testing.T) {
+	deployment := func(top, template map[string]string) *appsv1.Deployment {
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "ml", Annotations: top}}
+		d.Spec.Template.Annotations = template
+		return d
+	}
+	claim := map[string]string{util.AnnoClaim: "2"}
+
+	tests := []struct {
+		name string
+		obj  *appsv1.Deployment
+		want []map[string]interface{}
+	}{
+		{
+			name: "template without annotations",
+			obj:  deployment(claim, nil),
+			want: []map[string]interface{}{{
+				"op":    "add",
+				"path":  "/spec/template/metadata/annotations",
+				"value": map[string]interface{}{util.AnnoClaim: "2"},
+			}},
+		},
+		{
+			name: "template with other annotations",
+			obj:  deployment(claim, map[string]string{"team": "ml"}),
+			want: []map[string]interface{}{{
+				"op":    "add",
+				"path":  "/spec/template/metadata/annotations/gpu.scheduling~1claim",
+				"value": "2",
+			}},
+		},
+		{name: "template claim wins", obj: deployment(claim, map[string]string{util.AnnoClaim: "4"})},
+		{name: "no claim", obj: deployment(nil, nil)},
+	}
+	for _, tt := range tests {
+		resp := sendReview(t, mutateWorkload, "Deployment", "ml", tt.obj)
+		if !resp.Allowed {
+			t.Errorf("%s: Expected the Deployment to be allowed, got %v", tt.name, resp.Result)
+			continue
+		}
+		var got []map[string]interface{}
+		if resp.Patch != nil {
+			if err := json.Unmarshal(resp.Patch, &got); err != nil {
+				t.Fatalf("%s: decode patch: %v", tt.name, err)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Expected patch %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMutateWorkloadStatefulSet(t *testing.T) {
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+		Name:        "db",
+		Namespace:   "ml",
+		Annotations: map[string]string{util.AnnoClaim: "single-gpu"},
+	}}
+	resp := sendReview(t, mutateWorkload, "StatefulSet", "ml", sts)
+	if !resp.Allowed || resp.Patch == nil {
+		t.Fatalf("Expected the StatefulSet to be patched, got allowed=%v patch=%s", resp.Allowed, resp.Patch)
+	}
+}
@@ -0,0 +1,93 @@
+// Package audit keeps an append-only record of GPU allocations and releases
+// for compliance. Records go to a Sink; the one built in writes JSON lines to
+// a file or stdout, and others, e.g. shipping to a message bus, only need to
+// implement Sink.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Action is what happened to the devices of a Record.
+type Action string
+
+const (
+	// Allocate is a pod reserving devices.
+	Allocate Action = "allocate"
+	// Release is devices given back, by Unreserve, a failed bind, preemption
+	// or the lease collector.
+	Release Action = "release"
+)
+
+// Record is one allocation or release.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Action    Action    `json:"action"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	PodUID    string    `json:"podUID,omitempty"`
+	Node      string    `json:"node"`
+	Devices   []int     `json:"devices"`
+	// User is who created the pod, from the util.AnnoRequestedBy annotation
+	// the webhook sets. Empty when the pod lacks it.
+	User string `json:"user,omitempty"`
+	// Reason says why devices were released, e.g. "unreserved" or one of
+	// the lease collector's deletion reasons such as "finished".
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink receives records. Write is called concurrently and must not reorder
+// records it is handed one after the other.
+type Sink interface {
+	Write(r Record) error
+}
+
+// JSONSink writes each record as one line of JSON.
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewJSONSink returns a sink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// Open returns a sink appending to the file at path, created if missing, or
+// writing to stdout when path is "-".
+func Open(path string) (*JSONSink, error) {
+	if path == "-" {
+		return NewJSONSink(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	s := NewJSONSink(f)
+	s.c = f
+	return s, nil
+}
+
+// Write appends r. A zero Time is set to now.
+func (s *JSONSink) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(r)
+}
+
+// Close closes the file Open opened; it does nothing for other writers.
+func (s *JSONSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, action := range []Action{Allocate, Release} {
+		// Reopening keeps what earlier runs wrote.
+		s, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if err := s.Write(Record{Time: at, Action: action, Namespace: "ml", Pod: "trainer", Node: "node-a", Devices: []int{0, 1}}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	want := `{"time":"2026-01-02T03:04:05Z","action":"allocate","namespace":"ml","pod":"trainer","node":"node-a","devices":[0,1]}
+{"time":"2026-01-02T03:04:05Z","action":"release","namespace":"ml","pod":"trainer","node":"node-a","devices":[0,1]}
+`
+	if string(raw) != want {
+		t.Errorf("Expected audit log\n%s\ngot\n%s", want, raw)
+	}
+}
+
+func TestWriteStampsTime(t *testing.T) {
+	var b strings.Builder
+	before := time.Now().Add(-time.Second)
+	if err := NewJSONSink(&b).Write(Record{Action: Allocate}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var r Record
+	if err := json.Unmarshal([]byte(b.String()), &r); err != nil {
+		t.Fatalf("decode record: %v", err)
+	}
+	if r.Time.Before(before) {
+		t.Errorf("Expected the record to be stamped with the current time, got %v", r.Time)
+	}
+}
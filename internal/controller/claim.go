@@ -0,0 +1,200 @@
+// Package controller keeps GpuClaim status in step with the GPU leases held
+// by the pods that reference each claim.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// ClaimReconciler reports on each GpuClaim which node and devices the pod
+// referencing it holds. The scheduler plugin takes the leases; the reconciler
+// only reads them.
+type ClaimReconciler struct {
+	client.Client
+	// LabelPrefix names the scheduler instance whose leases are read; empty
+	// means lease.DefaultLabelPrefix.
+	LabelPrefix string
+}
+
+// Reconcile recomputes the status of one GpuClaim.
+func (r *ClaimReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	claim := &apiv1.GpuClaim{}
+	if err := r.Get(ctx, req.NamespacedName, claim); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(req.Namespace)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("list pods: %w", err)
+	}
+	var holders []claimHolder
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if claimName(pod) != claim.Name {
+			continue
+		}
+		leases := &coordv1.LeaseList{}
+		if err := r.List(ctx, leases, client.MatchingLabels(lease.HolderLabels(r.LabelPrefix, pod.Name))); err != nil {
+			return reconcile.Result{}, fmt.Errorf("list leases of pod %s: %w", pod.Name, err)
+		}
+		key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		held := slices.DeleteFunc(leases.Items, func(l coordv1.Lease) bool { return lease.PodOf(&l) != key })
+		if len(held) > 0 {
+			holders = append(holders, claimHolder{pod: pod, leases: held})
+		}
+	}
+
+	status := claimStatus(holders)
+	if equality.Semantic.DeepEqual(claim.Status, status) {
+		return reconcile.Result{}, nil
+	}
+	claim.Status = status
+	if err := r.Status().Update(ctx, claim); err != nil {
+		return reconcile.Result{}, fmt.Errorf("update GpuClaim status: %w", err)
+	}
+	klog.V(4).InfoS("updated GpuClaim status", "claim", klog.KObj(claim), "phase", status.Phase, "allocated", status.Allocated)
+	return reconcile.Result{}, nil
+}
+
+// claimHolder is a pod referencing the claim together with its leases.
+type claimHolder struct {
+	pod    *corev1.Pod
+	leases []coordv1.Lease
+}
+
+// claimStatus describes the first holder by pod name. A claim is meant to be
+// used by one pod at a time; further holders are only counted in the message.
+func claimStatus(holders []claimHolder) apiv1.GpuClaimStatus {
+	if len(holders) == 0 {
+		return apiv1.GpuClaimStatus{Phase: apiv1.ClaimPending}
+	}
+	sort.Slice(holders, func(i, j int) bool { return holders[i].pod.Name < holders[j].pod.Name })
+	h := holders[0]
+
+	node := lease.NodeOf(&h.leases[0])
+	ids := heldIDs(h.leases)
+	status := apiv1.GpuClaimStatus{
+		Phase:     apiv1.ClaimReserved,
+		NodeName:  node,
+		GPUIds:    ids,
+		Allocated: node + ":" + util.FormatAllocation(ids),
+		Message:   fmt.Sprintf("held by pod %s", h.pod.Name),
+	}
+	switch {
+	case h.pod.Status.Phase == corev1.PodFailed:
+		status.Phase = apiv1.ClaimFailed
+	case h.pod.Spec.NodeName != "":
+		status.Phase = apiv1.ClaimBound
+	}
+	if len(holders) > 1 {
+		status.Message += fmt.Sprintf("; %d more pods hold GPUs for this claim", len(holders)-1)
+	}
+	return status
+}
+
+// heldIDs returns the sorted device ids, or MIG instance ids, the leases hold.
+func heldIDs(leases []coordv1.Lease) []int {
+	held := lease.HeldDevices(lease.WithoutMIG(leases))
+	for id := range lease.MIGHeld(leases) {
+		held[id] = true
+	}
+	ids := make([]int, 0, len(held))
+	for id := range held {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// claimName returns the GpuClaim the pod's claim annotation names, or "" for
+// inline claims and pods without one.
+func claimName(pod *corev1.Pod) string {
+	c, err := util.ParseClaim(pod.Annotations[util.AnnoClaim])
+	if err != nil {
+		return ""
+	}
+	return c.Name
+}
+
+// podToClaim maps a pod event to the claim the pod references.
+func podToClaim(_ context.Context, obj client.Object) []reconcile.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	name := claimName(pod)
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: name}}}
+}
+
+// leaseToClaim maps a lease event to the claim its pod references.
+func (r *ClaimReconciler) leaseToClaim(ctx context.Context, obj client.Object) []reconcile.Request {
+	l, ok := obj.(*coordv1.Lease)
+	if !ok || lease.PodOf(l).Name == "" {
+		return nil
+	}
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, lease.PodOf(l), pod); err != nil {
+		return nil
+	}
+	return podToClaim(ctx, pod)
+}
+
+// SetupWithManager registers the reconciler to run on GpuClaim changes and on
+// changes to the pods and leases behind them.
+func (r *ClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("gpuclaim").
+		For(&apiv1.GpuClaim{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podToClaim)).
+		Watches(&coordv1.Lease{}, handler.EnqueueRequestsFromMapFunc(r.leaseToClaim)).
+		Complete(r)
+}
+
+// Run runs the GpuClaim controller until ctx is done. Its cache only holds
+// the leases of the instance with the given label prefix. Each call builds
+// the controller afresh, so a replica that regains leadership can run it
+// again.
+func Run(ctx context.Context, cfg *rest.Config, scheme *runtime.Scheme, labelPrefix string) error {
+	skipNameValidation := true
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:     scheme,
+		Metrics:    metricsserver.Options{BindAddress: "0"},
+		Controller: config.Controller{SkipNameValidation: &skipNameValidation},
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&coordv1.Lease{}: {Label: labels.SelectorFromSet(lease.ManagedLabels(labelPrefix))},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("build GpuClaim controller manager: %w", err)
+	}
+	if err := (&ClaimReconciler{Client: mgr.GetClient(), LabelPrefix: labelPrefix}).SetupWithManager(mgr); err != nil {
+		return fmt.Errorf("set up GpuClaim controller: %w", err)
+	}
+	return mgr.Start(ctx)
+}
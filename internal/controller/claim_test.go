@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func claimPod(name, claim, nodeName string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID("uid-" + name),
+			Annotations: map[string]string{util.AnnoClaim: claim},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+// newReconciler seeds a fake client with objs and with the leases the lease
+// package creates for each pod in held, keyed by pod name.
+func newReconciler(t *testing.T, held map[string][]int, objs ...client.Object) *ClaimReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiv1.AddToScheme(scheme))
+
+	// Build the leases through the lease package so they carry its labels.
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+	for pod, ids := range held {
+		for _, id := range ids {
+			if _, err := lease.TryAcquire(ctx, cs.CoordinationV1(), lease.DefaultLabelPrefix, "default", "node-a", "uid-"+pod, types.NamespacedName{Namespace: "default", Name: pod}, id); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+		}
+	}
+	leases, _ := lease.ListNode(ctx, cs.CoordinationV1(), lease.DefaultLabelPrefix, "node-a")
+	for i := range leases {
+		objs = append(objs, &leases[i])
+	}
+
+	c := crfake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&apiv1.GpuClaim{}).
+		Build()
+	return &ClaimReconciler{Client: c}
+}
+
+func TestReconcileClaimStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []client.Object
+		held map[string][]int
+		want apiv1.GpuClaimStatus
+	}{
+		{
+			name: "no pod references the claim",
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimPending},
+		},
+		{
+			name: "referencing pod holds no leases",
+			pods: []client.Object{claimPod("trainer", "training", "", corev1.PodPending)},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimPending},
+		},
+		{
+			name: "reserved before binding",
+			pods: []client.Object{claimPod("trainer", "training", "", corev1.PodPending)},
+			held: map[string][]int{"trainer": {2, 0}},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimReserved, NodeName: "node-a", GPUIds: []int{0, 2}, Allocated: "node-a:0,2", Message: "held by pod trainer"},
+		},
+		{
+			name: "bound",
+			pods: []client.Object{claimPod("trainer", "training", "node-a", corev1.PodRunning)},
+			held: map[string][]int{"trainer": {1}},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimBound, NodeName: "node-a", GPUIds: []int{1}, Allocated: "node-a:1", Message: "held by pod trainer"},
+		},
+		{
+			name: "failed pod",
+			pods: []client.Object{claimPod("trainer", "training", "node-a", corev1.PodFailed)},
+			held: map[string][]int{"trainer": {1}},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimFailed, NodeName: "node-a", GPUIds: []int{1}, Allocated: "node-a:1", Message: "held by pod trainer"},
+		},
+		{
+			name: "other claims and inline claims are ignored",
+			pods: []client.Object{
+				claimPod("other", "inference", "node-a", corev1.PodRunning),
+				claimPod("inline", "2", "node-a", corev1.PodRunning),
+			},
+			held: map[string][]int{"other": {0}, "inline": {1, 2}},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimPending},
+		},
+		{
+			name: "several holders",
+			pods: []client.Object{
+				claimPod("trainer-b", "training", "node-a", corev1.PodRunning),
+				claimPod("trainer-a", "training", "node-a", corev1.PodRunning),
+			},
+			held: map[string][]int{"trainer-a": {0}, "trainer-b": {1}},
+			want: apiv1.GpuClaimStatus{Phase: apiv1.ClaimBound, NodeName: "node-a", GPUIds: []int{0}, Allocated: "node-a:0",
+				Message: "held by pod trainer-a; 1 more pods hold GPUs for this claim"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			claim := &apiv1.GpuClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "default"},
+				Spec:       apiv1.GpuClaimSpec{Devices: apiv1.DeviceRequest{Count: 2}},
+			}
+			r := newReconciler(t, tt.held, append(tt.pods, claim)...)
+			key := types.NamespacedName{Namespace: "default", Name: "training"}
+
+			if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile: %v", err)
+			}
+			got := &apiv1.GpuClaim{}
+			if err := r.Get(ctx, key, got); err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			s := got.Status
+			if s.Phase != tt.want.Phase || s.NodeName != tt.want.NodeName || s.Allocated != tt.want.Allocated ||
+				!slices.Equal(s.GPUIds, tt.want.GPUIds) || s.Message != tt.want.Message {
+				t.Errorf("Expected status %+v, got %+v", tt.want, s)
+			}
+
+			// A second pass with nothing changed writes nothing.
+			if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile: %v", err)
+			}
+			again := &apiv1.GpuClaim{}
+			_ = r.Get(ctx, key, again)
+			if again.ResourceVersion != got.ResourceVersion {
+				t.Errorf("Expected an unchanged status not to be written, resourceVersion %s -> %s", got.ResourceVersion, again.ResourceVersion)
+			}
+		})
+	}
+}
+
+func TestReconcileMissingClaim(t *testing.T) {
+	r := newReconciler(t, nil)
+	_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "gone"}})
+	if err != nil {
+		t.Errorf("Expected a deleted claim to be ignored, got %v", err)
+	}
+}
+
+func TestLeaseToClaim(t *testing.T) {
+	r := newReconciler(t, map[string][]int{"trainer": {0}, "inline": {1}},
+		claimPod("trainer", "training", "", corev1.PodPending),
+		claimPod("inline", "1", "", corev1.PodPending),
+	)
+	ctx := context.Background()
+	leases := &coordv1.LeaseList{}
+	if err := r.List(ctx, leases); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []string
+	for i := range leases.Items {
+		for _, req := range r.leaseToClaim(ctx, &leases.Items[i]) {
+			got = append(got, req.Name)
+		}
+	}
+	if !slices.Equal(got, []string{"training"}) {
+		t.Errorf("Expected only the trainer's lease to map to claim training, got %v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// annoDevices lists the device ids a pod lease holds, e.g. "0,1,3".
+const annoDevices = "gpu.scheduling/devices"
+
+// PodLeaseName names the single lease holding all of a pod's whole GPUs on
+// node, into which Compact folds its per-device leases. Namespaces have no
+// dots, so the pod's namespace and name cannot run into each other.
+func PodLeaseName(prefix, node string, pod types.NamespacedName) string {
+	return leaseName(prefix, fmt.Sprintf("gpu-%s-pod-%s.%s", node, pod.Namespace, pod.Name))
+}
+
+// Compact folds the whole-GPU leases pod holds in ns on node into its pod
+// lease, which records their device ids, and deletes them. Only confirmed
+// leases are folded: Reserve still takes one lease per device, since a
+// lease's name is what makes the device exclusive, and until the pod is
+// bound those leases may be released one by one. The pod lease is written
+// before the device leases go, so the devices never look free in between.
+// Shares and MIG instances keep their own leases. It returns how many
+// device leases were folded.
+func Compact(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node string, pod types.NamespacedName) (int, error) {
+	leases, err := listHeld(ctx, cli, prefix, ns, node, pod)
+	if err != nil {
+		return 0, err
+	}
+	var podLease *coordv1.Lease
+	var devices []coordv1.Lease
+	for i := range leases {
+		l := &leases[i]
+		// The pod lease is told by its device list rather than its name,
+		// which was shorter before leases shared a namespace.
+		_, isPodLease := l.Annotations[annoDevices]
+		switch {
+		case isPodLease:
+			podLease = l
+		case compactable(l):
+			devices = append(devices, *l)
+		}
+	}
+	if len(devices) == 0 {
+		return 0, nil
+	}
+	// Only fold leases of one holder, in case the pod was recreated under
+	// the same name.
+	holder := devices[0].Spec.HolderIdentity
+	if podLease != nil {
+		holder = podLease.Spec.HolderIdentity
+	}
+	devices = slices.DeleteFunc(devices, func(l coordv1.Lease) bool {
+		return holder == nil || *l.Spec.HolderIdentity != *holder
+	})
+	if len(devices) == 0 || (len(devices) == 1 && podLease == nil) {
+		return 0, nil
+	}
+
+	ids := map[int]bool{}
+	if podLease != nil {
+		for _, id := range deviceIDs(*podLease) {
+			ids[id] = true
+		}
+	}
+	for _, l := range devices {
+		id, _ := deviceID(l)
+		ids[id] = true
+	}
+	held := make([]int, 0, len(ids))
+	for id := range ids {
+		held = append(held, id)
+	}
+	sort.Ints(held)
+
+	if podLease == nil {
+		_, err = cli.Leases(ns).Create(ctx, newPodLease(&devices[0], prefix, ns, node, pod, held), metav1.CreateOptions{})
+	} else {
+		patch, _ := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{annoDevices: util.FormatAllocation(held)},
+			},
+		})
+		_, err = cli.Leases(ns).Patch(ctx, podLease.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("write pod lease: %w", err)
+	}
+
+	var errs []error
+	for _, l := range devices {
+		err := cli.Leases(ns).Delete(ctx, l.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return len(devices), utilerrors.NewAggregate(errs)
+}
+
+// compactable reports whether l is a confirmed lease on one whole device.
+func compactable(l *coordv1.Lease) bool {
+	if _, ok := l.Labels[labelMIG]; ok {
+		return false
+	}
+	if _, ok := l.Annotations[annoFraction]; ok {
+		return false
+	}
+	if _, ok := l.Annotations[annoDevices]; ok {
+		return false
+	}
+	if l.Spec.HolderIdentity == nil || l.Spec.AcquireTime == nil {
+		return false
+	}
+	_, ok := deviceID(*l)
+	return ok
+}
+
+// newPodLease builds the pod lease for ids, taking its holder and timestamps
+// from one of the device leases it replaces.
+func newPodLease(from *coordv1.Lease, prefix, ns, node string, pod types.NamespacedName, ids []int) *coordv1.Lease {
+	l := &coordv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        PodLeaseName(prefix, node, pod),
+			Namespace:   ns,
+			Annotations: map[string]string{annoDevices: util.FormatAllocation(ids)},
+			Labels: map[string]string{
+				labelManaged:      managedValue(prefix),
+				labelOwnedBy:      ownerName,
+				labelPod:          pod.Name,
+				labelPodNamespace: pod.Namespace,
+			},
+		},
+		Spec: *from.Spec.DeepCopy(),
+	}
+	setNode(l, node)
+	if at, ok := from.Annotations[annoReservedAt]; ok {
+		l.Annotations[annoReservedAt] = at
+	}
+	if group, ok := from.Labels[labelAntiAffinity]; ok {
+		l.Labels[labelAntiAffinity] = group
+	}
+	return l
+}
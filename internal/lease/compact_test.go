@@ -0,0 +1,139 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// boundLeases takes and confirms leases on node-a for trainer's devices.
+func boundLeases(t *testing.T, ctx context.Context, client *fake.Clientset, ids ...int) {
+	t.Helper()
+	coord := client.CoordinationV1()
+	for _, id := range ids {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-trainer", podRef("default", "trainer"), id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if err := Confirm(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer"), "uid-trainer", time.Now()); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+}
+
+func leaseNames(t *testing.T, ctx context.Context, client *fake.Clientset) map[string]coordv1.Lease {
+	t.Helper()
+	list, err := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	out := map[string]coordv1.Lease{}
+	for _, l := range list.Items {
+		out[l.Name] = l
+	}
+	return out
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	boundLeases(t, ctx, client, 0, 1, 3)
+	// A share keeps its own lease, as does a reservation not yet bound.
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-trainer", podRef("default", "trainer"), "", 2, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-other", podRef("default", "other"), 4); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	n, err := Compact(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer"))
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 leases compacted, got %d, %v", n, err)
+	}
+	leases := leaseNames(t, ctx, client)
+	if len(leases) != 3 {
+		t.Errorf("Expected the pod lease, the share and the other reservation, got %v", leases)
+	}
+	pod, ok := leases[PodLeaseName(DefaultLabelPrefix, "node-a", podRef("default", "trainer"))]
+	if !ok {
+		t.Fatalf("Expected the pod lease, got %v", leases)
+	}
+	if pod.Annotations[annoDevices] != "0,1,3" || *pod.Spec.HolderIdentity != "uid-trainer" || pod.Spec.AcquireTime == nil {
+		t.Errorf("Expected devices 0,1,3 confirmed to uid-trainer, got %v %+v", pod.Annotations, pod.Spec)
+	}
+	if _, ok := pod.Labels[labelDevice]; ok {
+		t.Errorf("Expected no device label on the pod lease, got %v", pod.Labels)
+	}
+
+	// Running it again changes nothing; a device confirmed later is merged.
+	if n, err := Compact(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer")); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to compact, got %d, %v", n, err)
+	}
+	boundLeases(t, ctx, client, 5)
+	if n, err := Compact(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer")); err != nil || n != 1 {
+		t.Errorf("Expected the new lease compacted, got %d, %v", n, err)
+	}
+	pod = leaseNames(t, ctx, client)[PodLeaseName(DefaultLabelPrefix, "node-a", podRef("default", "trainer"))]
+	if pod.Annotations[annoDevices] != "0,1,3,5" {
+		t.Errorf("Expected devices 0,1,3,5, got %q", pod.Annotations[annoDevices])
+	}
+}
+
+func TestCompactSingleLease(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	boundLeases(t, ctx, client, 2)
+	if n, err := Compact(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer")); err != nil || n != 0 {
+		t.Errorf("Expected a lone device lease left as is, got %d, %v", n, err)
+	}
+	if _, ok := leaseNames(t, ctx, client)[LeaseName(DefaultLabelPrefix, "node-a", 2)]; !ok {
+		t.Errorf("Expected the device lease kept")
+	}
+}
+
+func TestCompactedLeaseAccounting(t *testing.T) {
+	pod := coordv1.Lease{ObjectMeta: metav1.ObjectMeta{
+		Name:        PodLeaseName(DefaultLabelPrefix, "node-a", podRef("default", "job-7")),
+		Labels:      map[string]string{labelNode: "node-a"},
+		Annotations: map[string]string{annoDevices: "0,2"},
+	}}
+	other := agedLease("default", "other", 2, "", time.Minute)
+	leases := []coordv1.Lease{pod, other}
+
+	if usage := DeviceUsage([]coordv1.Lease{pod}); len(usage) != 2 || usage[0] != 1 || usage[2] != 1 {
+		t.Errorf("Expected devices 0 and 2 in use, not 7, got %v", usage)
+	}
+	if got := GPUs([]coordv1.Lease{pod}); got != 2 {
+		t.Errorf("Expected 2 GPUs, got %v", got)
+	}
+	if got := DeviceMemory([]coordv1.Lease{pod}, 80<<30); got[0] != 80<<30 || got[2] != 80<<30 {
+		t.Errorf("Expected whole devices reserved, got %v", got)
+	}
+	if conflicts := Conflicts(leases); len(conflicts) != 1 || conflicts[0].Device != 2 {
+		t.Errorf("Expected a conflict on device 2, got %+v", conflicts)
+	}
+}
+
+func TestRunGCCompactedLease(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "trainer", Namespace: "default", UID: "uid-trainer"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	})
+	boundLeases(t, ctx, client, 0, 1)
+	if _, err := Compact(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer")); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods}).run(ctx, time.Now())
+	if leases := leaseNames(t, ctx, client); len(leases) != 0 {
+		t.Errorf("Expected the completed pod's lease collected, got %v", leases)
+	}
+}
@@ -0,0 +1,171 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// reasonDeviceConflict is the warning event reason for a lease reclaimed
+// because an older lease holds the same device.
+const reasonDeviceConflict = "DeviceConflict"
+
+// shareEpsilon absorbs float rounding when shares of a device are summed.
+const shareEpsilon = 1e-9
+
+// Conflict is a lease that double-books a device an older lease on the same
+// node already holds.
+type Conflict struct {
+	// Lease is the newer lease, which gives the device up.
+	Lease coordv1.Lease
+	// Survivor is the oldest lease on the device, which keeps it.
+	Survivor coordv1.Lease
+	Node     string
+	Device   int
+}
+
+// Conflicts finds device-index collisions among the given leases. An
+// exclusive lease cannot share its device, and the shares of a device cannot
+// add up to more than one. Leases are taken oldest first, by creation time and
+// then by namespace and name; each one the device can no longer hold is a
+// conflict. Leases in different namespaces may carry the same name, so each
+// scheduler's lease creation alone cannot rule this out. Leases of one holder
+// book a device once: a pod lease and a device lease Compact has not deleted
+// yet never conflict. MIG instance leases
+// are not device leases and never conflict, and neither do window
+// reservations: a pod still on the device when the window opens keeps it.
+func Conflicts(leases []coordv1.Lease) []Conflict {
+	type device struct {
+		node string
+		id   int
+	}
+	byDevice := map[device][]coordv1.Lease{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			continue
+		}
+		if _, ok := l.Annotations[annoReserveWindow]; ok {
+			continue
+		}
+		for _, id := range deviceIDs(l) {
+			d := device{node: NodeOf(&l), id: id}
+			byDevice[d] = append(byDevice[d], l)
+		}
+	}
+
+	var out []Conflict
+	for d, group := range byDevice {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return older(&group[i], &group[j]) })
+		used := deviceShare(group[0])
+		holders := map[string]bool{}
+		if h := group[0].Spec.HolderIdentity; h != nil {
+			holders[*h] = true
+		}
+		for _, l := range group[1:] {
+			if h := l.Spec.HolderIdentity; h != nil && holders[*h] {
+				continue
+			}
+			share := deviceShare(l)
+			if used < 1 && share < 1 && used+share <= 1+shareEpsilon {
+				used += share
+				if h := l.Spec.HolderIdentity; h != nil {
+					holders[*h] = true
+				}
+				continue
+			}
+			out = append(out, Conflict{Lease: l, Survivor: group[0], Node: d.node, Device: d.id})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Node != out[j].Node {
+			return out[i].Node < out[j].Node
+		}
+		if out[i].Device != out[j].Device {
+			return out[i].Device < out[j].Device
+		}
+		return older(&out[i].Lease, &out[j].Lease)
+	})
+	return out
+}
+
+// older orders leases by creation time, breaking ties by namespace and name.
+func older(a, b *coordv1.Lease) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// resolveConflicts reclaims every lease that double-books a device and returns
+// the rest for the per-lease checks.
+func (c *collector) resolveConflicts(ctx context.Context, leases []coordv1.Lease) []coordv1.Lease {
+	conflicts := Conflicts(leases)
+	if len(conflicts) == 0 {
+		return leases
+	}
+	reclaimed := map[types.NamespacedName]bool{}
+	for i := range conflicts {
+		c.reclaimConflict(ctx, &conflicts[i])
+		reclaimed[types.NamespacedName{Namespace: conflicts[i].Lease.Namespace, Name: conflicts[i].Lease.Name}] = true
+	}
+	rest := make([]coordv1.Lease, 0, len(leases)-len(reclaimed))
+	for _, l := range leases {
+		if !reclaimed[types.NamespacedName{Namespace: l.Namespace, Name: l.Name}] {
+			rest = append(rest, l)
+		}
+	}
+	return rest
+}
+
+// reclaimConflict warns about the conflict, deletes the newer lease and
+// evicts the pod holding it, so that pod is scheduled again instead of
+// sharing the device.
+func (c *collector) reclaimConflict(ctx context.Context, cf *Conflict) {
+	l := &cf.Lease
+	msg := fmt.Sprintf("GPU %d on node %s is also held by lease %s/%s of pod %s, created earlier; reclaiming lease %s",
+		cf.Device, cf.Node, cf.Survivor.Namespace, cf.Survivor.Name, PodOf(&cf.Survivor), l.Name)
+	klog.InfoS("GC: device conflict", "lease", klog.KObj(l), "survivor", klog.KObj(&cf.Survivor), "node", cf.Node, "device", cf.Device)
+
+	var regarding runtime.Object = l
+	key := PodOf(l)
+	pod, err := c.pods.Pods(key.Namespace).Get(key.Name)
+	if err != nil {
+		pod = nil
+	} else {
+		regarding = pod
+	}
+	if c.recorder != nil {
+		c.recorder.Event(regarding, corev1.EventTypeWarning, reasonDeviceConflict, msg)
+	}
+	if !c.deleteLease(ctx, l, regarding, reasonConflict, msg) || pod == nil {
+		return
+	}
+	// Only evict the pod the lease was taken for, and only while it runs.
+	if l.Spec.HolderIdentity == nil || string(pod.UID) != *l.Spec.HolderIdentity ||
+		pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	callCtx, cancel := CallContext(ctx, c.callTimeout)
+	defer cancel()
+	err = c.client.PolicyV1().Evictions(pod.Namespace).Evict(callCtx, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		c.logCallError(err, "GC: failed to evict pod of conflicting lease", "pod", klog.KObj(pod), "lease", klog.KObj(l))
+	}
+}
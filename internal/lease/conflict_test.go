@@ -0,0 +1,197 @@
+package lease
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+var conflictEpoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// agedLease is a device lease on node-a created age after conflictEpoch.
+func agedLease(ns, pod string, id int, fraction string, age time.Duration) coordv1.Lease {
+	name := LeaseName(DefaultLabelPrefix, "node-a", id)
+	if fraction != "" {
+		name = FractionLeaseName(DefaultLabelPrefix, "node-a", id, "uid-"+pod)
+	}
+	l := newLease(DefaultLabelPrefix, name, ns, "node-a", "uid-"+pod, podRef(ns, pod), id)
+	l.CreationTimestamp = metav1.NewTime(conflictEpoch.Add(age))
+	if fraction != "" {
+		l.Annotations = map[string]string{annoFraction: fraction}
+	}
+	return *l
+}
+
+func TestConflicts(t *testing.T) {
+	mig := agedLease("default", "mig", 0, "", 0)
+	mig.Name = MIGLeaseName(DefaultLabelPrefix, "node-a", 0)
+	mig.Labels[labelMIG] = "1g.5gb"
+	onNodeB := agedLease("team-b", "other", 0, "", time.Minute)
+	onNodeB.Labels[labelNode] = "node-b"
+	device := agedLease("default", "job", 0, "", 0)
+	compacted := *newPodLease(&device, DefaultLabelPrefix, "default", "node-a", podRef("default", "job"), []int{0, 1})
+	compacted.CreationTimestamp = metav1.NewTime(conflictEpoch.Add(time.Minute))
+
+	tests := []struct {
+		name   string
+		leases []coordv1.Lease
+		want   []string // reclaimed namespace/pod, survivor first
+	}{
+		{
+			name: "exclusive leases in two namespaces",
+			leases: []coordv1.Lease{
+				agedLease("team-b", "newer", 0, "", time.Minute),
+				agedLease("default", "older", 0, "", 0),
+			},
+			want: []string{"team-b/newer<default/older"},
+		},
+		{
+			name: "share after an exclusive lease",
+			leases: []coordv1.Lease{
+				agedLease("default", "whole", 1, "", 0),
+				agedLease("default", "share", 1, "0.5", time.Second),
+			},
+			want: []string{"default/share<default/whole"},
+		},
+		{
+			name: "exclusive lease after a share",
+			leases: []coordv1.Lease{
+				agedLease("default", "share", 1, "0.5", 0),
+				agedLease("default", "whole", 1, "", time.Second),
+			},
+			want: []string{"default/whole<default/share"},
+		},
+		{
+			name: "shares within the device",
+			leases: []coordv1.Lease{
+				agedLease("default", "a", 2, "0.5", 0),
+				agedLease("default", "b", 2, "0.25", time.Second),
+				agedLease("default", "c", 2, "0.25", 2*time.Second),
+			},
+		},
+		{
+			name: "shares past the device",
+			leases: []coordv1.Lease{
+				agedLease("default", "c", 2, "0.5", 2*time.Second),
+				agedLease("default", "a", 2, "0.5", 0),
+				agedLease("default", "b", 2, "0.75", time.Second),
+			},
+			want: []string{"default/b<default/a"},
+		},
+		{
+			name: "same index on another node or as a MIG instance",
+			leases: []coordv1.Lease{
+				agedLease("default", "older", 0, "", 0),
+				onNodeB,
+				mig,
+			},
+		},
+		{
+			name: "pod lease beside a device lease of its holder",
+			leases: []coordv1.Lease{
+				agedLease("default", "job", 0, "", 0),
+				compacted,
+			},
+		},
+		{
+			name: "pod lease after another holder's device lease",
+			leases: []coordv1.Lease{
+				agedLease("default", "older", 0, "", 0),
+				agedLease("default", "job", 1, "", 0),
+				compacted,
+			},
+			want: []string{"default/job<default/older"},
+		},
+		{
+			name: "same age falls back to namespace",
+			leases: []coordv1.Lease{
+				agedLease("team-b", "b", 3, "", 0),
+				agedLease("team-a", "a", 3, "", 0),
+			},
+			want: []string{"team-b/b<team-a/a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range Conflicts(tt.leases) {
+				got = append(got, c.Lease.Namespace+"/"+PodOf(&c.Lease).Name+"<"+c.Survivor.Namespace+"/"+PodOf(&c.Survivor).Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected conflicts %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunGCReclaimsDeviceConflict(t *testing.T) {
+	ctx := context.Background()
+	running := func(ns, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, UID: types.UID("uid-" + name)},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	older, newer := agedLease("default", "older", 0, "", 0), agedLease("team-b", "newer", 0, "", time.Minute)
+	client := fake.NewSimpleClientset(running("default", "older"), running("team-b", "newer"), &older, &newer)
+	pods, _ := podCache(t, client)
+	recorder := record.NewFakeRecorder(10)
+	client.ClearActions()
+
+	(&collector{client: client, pods: pods, recorder: recorder}).run(ctx, conflictEpoch.Add(time.Hour))
+
+	if _, err := client.CoordinationV1().Leases("default").Get(ctx, older.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the older lease to survive, got %v", err)
+	}
+	if _, err := client.CoordinationV1().Leases("team-b").Get(ctx, newer.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the newer lease to be reclaimed")
+	}
+
+	var evicted []string
+	for _, a := range client.Actions() {
+		if c, ok := a.(k8stesting.CreateAction); ok && a.GetSubresource() == "eviction" {
+			evicted = append(evicted, a.GetNamespace()+"/"+c.GetObject().(metav1.Object).GetName())
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != "team-b/newer" {
+		t.Errorf("Expected only team-b/newer to be evicted, got %v", evicted)
+	}
+
+	close(recorder.Events)
+	var warned bool
+	for e := range recorder.Events {
+		if strings.HasPrefix(e, corev1.EventTypeWarning+" "+reasonDeviceConflict+" ") && strings.Contains(e, "default/"+older.Name) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("Expected a %s warning naming the surviving lease", reasonDeviceConflict)
+	}
+}
+
+func TestRunGCDeviceConflictDryRun(t *testing.T) {
+	ctx := context.Background()
+	older, newer := agedLease("default", "older", 0, "", 0), agedLease("team-b", "newer", 0, "", time.Minute)
+	client := fake.NewSimpleClientset(&older, &newer)
+	pods, _ := podCache(t, client)
+	// Keep the pods' leases out of the missing-pod path.
+	c := &collector{client: client, pods: pods, grace: time.Hour, dryRun: true}
+	client.ClearActions()
+
+	c.run(ctx, conflictEpoch.Add(time.Hour))
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "delete" || a.GetSubresource() == "eviction" {
+			t.Errorf("Expected a dry run to leave the conflict in place, got %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+}
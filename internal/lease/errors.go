@@ -0,0 +1,30 @@
+package lease
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDeviceConflict is wrapped by the errors the acquire functions return
+	// when another holder already leases the device. The API server's
+	// AlreadyExists error stays in the chain.
+	ErrDeviceConflict = errors.New("GPU lease held by another pod")
+	// ErrInsufficientGPUs marks an allocation that could not lease as many
+	// devices as the pod asked for.
+	ErrInsufficientGPUs = errors.New("not enough GPUs available")
+)
+
+// shortageError reports an allocation short of devices. It keeps the
+// caller's message and matches ErrInsufficientGPUs.
+type shortageError struct{ msg string }
+
+func (e *shortageError) Error() string { return e.msg }
+
+func (e *shortageError) Is(target error) bool { return target == ErrInsufficientGPUs }
+
+// Shortagef returns an error, matching ErrInsufficientGPUs, that describes
+// which devices a node ran out of.
+func Shortagef(format string, args ...any) error {
+	return &shortageError{msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,104 @@
+package lease
+
+import (
+	"context"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// Fragmentation returns the longest run of consecutive free device ids on a
+// node with capacity GPUs, over the node's free device count. A device
+// shared in part is not free. It is 1 when the free devices form a single
+// block, and when none are free, since there is nothing left to fragment; a
+// node whose four free devices are all apart scores 0.25.
+func Fragmentation(leases []coordv1.Lease, capacity int) float64 {
+	devices := make([]int, capacity)
+	for i := range devices {
+		devices[i] = i
+	}
+	return blockRatio(freeBlocks(DeviceUsage(leases), devices))
+}
+
+func blockRatio(largest, free int) float64 {
+	if free == 0 {
+		return 1
+	}
+	return float64(largest) / float64(free)
+}
+
+// freeBlocks returns the longest run of consecutive free ids among devices,
+// which are sorted, and how many are free in all. A gap in the ids breaks a
+// run like a used device does.
+func freeBlocks(usage map[int]float64, devices []int) (largest, free int) {
+	run := 0
+	for i, id := range devices {
+		if usage[id] > 0 {
+			run = 0
+			continue
+		}
+		if i > 0 && id != devices[i-1]+1 {
+			run = 0
+		}
+		free++
+		run++
+		largest = max(largest, run)
+	}
+	return largest, free
+}
+
+// SetDevices has the inventory export gpu_node_fragmentation_ratio for each
+// node whose leases change, looking up the ids of the node's GPUs with
+// devices, which reports false for a node that is gone. It must be called
+// before the informer starts.
+func (inv *Inventory) SetDevices(devices func(node string) ([]int, bool)) {
+	inv.devices = devices
+}
+
+// ObserveWindows updates the fragmentation gauge of the nodes holding
+// reservations every interval until ctx is done, since their windows open
+// and close without any lease changing.
+func (inv *Inventory) ObserveWindows(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(context.Context) {
+		for _, node := range inv.reservedNodes() {
+			inv.observe(node)
+		}
+	}, interval)
+}
+
+// reservedNodes returns the nodes holding a reservation lease.
+func (inv *Inventory) reservedNodes() []string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var nodes []string
+	for node, leases := range inv.nodes {
+		for _, l := range leases {
+			if _, ok := reserveWindow(l); ok {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	return nodes
+}
+
+// observe updates the node's fragmentation gauge from the leases holding its
+// devices now, leaving out reservations outside their window.
+func (inv *Inventory) observe(node string) {
+	if inv.devices == nil || node == "" {
+		return
+	}
+	devices, ok := inv.devices(node)
+	if !ok {
+		fragmentationRatio.DeleteLabelValues(node)
+		return
+	}
+	largest, free := freeBlocks(DeviceUsage(Active(inv.Node(node), time.Now())), devices)
+	ratio := blockRatio(largest, free)
+	fragmentationRatio.WithLabelValues(node).Set(ratio)
+	if ratio < 1 {
+		klog.V(3).InfoS("GPU allocation fragmented", "node", node, "ratio", ratio, "free", free, "largestBlock", largest)
+	}
+}
@@ -0,0 +1,111 @@
+package lease
+
+import (
+	"testing"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestFragmentation(t *testing.T) {
+	exclusive := func(ids ...int) []coordv1.Lease {
+		var leases []coordv1.Lease
+		for _, id := range ids {
+			leases = append(leases, *newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "node-a", id), "default", "node-a", "holder", podRef("default", "pod"), id))
+		}
+		return leases
+	}
+	share := *newLease(DefaultLabelPrefix, FractionLeaseName(DefaultLabelPrefix, "node-a", 5, "holder"), "default", "node-a", "holder", podRef("default", "pod"), 5)
+	share.Annotations[annoFraction] = "0.5"
+	pod := *newPodLease(&exclusive(0)[0], DefaultLabelPrefix, "default", "node-a", podRef("default", "pod"), []int{0, 1})
+
+	tests := []struct {
+		name     string
+		leases   []coordv1.Lease
+		capacity int
+		want     float64
+	}{
+		{name: "idle", capacity: 8, want: 1},
+		{name: "full", leases: exclusive(0, 1, 2, 3), capacity: 4, want: 1},
+		{name: "packed", leases: exclusive(0, 1, 2, 3), capacity: 8, want: 1},
+		// Free 1, 3, 5, 7: no two adjacent.
+		{name: "alternating", leases: exclusive(0, 2, 4, 6), capacity: 8, want: 0.25},
+		// Free 0, 2-3, 5-7: the largest block is three of six.
+		{name: "scattered", leases: exclusive(1, 4), capacity: 8, want: 0.5},
+		// A half-used device splits 0-4 from 6-7.
+		{name: "share", leases: []coordv1.Lease{share}, capacity: 8, want: 5.0 / 7},
+		{name: "pod lease", leases: []coordv1.Lease{pod}, capacity: 4, want: 1},
+	}
+	for _, tt := range tests {
+		if got := Fragmentation(tt.leases, tt.capacity); got != tt.want {
+			t.Errorf("%s: Expected ratio %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestInventoryFragmentationGauge(t *testing.T) {
+	RegisterMetrics()
+	inv := &Inventory{nodes: map[string]map[types.NamespacedName]*coordv1.Lease{}}
+	inv.SetDevices(func(node string) ([]int, bool) { return []int{0, 1, 2, 3}, node == "frag-a" })
+	gauge := func() float64 {
+		t.Helper()
+		v, err := testutil.GetGaugeMetricValue(fragmentationRatio.WithLabelValues("frag-a"))
+		if err != nil {
+			t.Fatalf("read gauge: %v", err)
+		}
+		return v
+	}
+
+	// Devices 0 and 2 leave 1 and 3 free, apart.
+	for _, id := range []int{0, 2} {
+		inv.add(newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "frag-a", id), "default", "frag-a", "holder", podRef("default", "pod"), id))
+	}
+	if got := gauge(); got != 0.5 {
+		t.Errorf("Expected ratio 0.5, got %v", got)
+	}
+	inv.forget("default", LeaseName(DefaultLabelPrefix, "frag-a", 2))
+	if got := gauge(); got != 1 {
+		t.Errorf("Expected ratio 1 once 1-3 are free, got %v", got)
+	}
+}
+
+func TestInventoryFragmentationFollowsDeviceIDs(t *testing.T) {
+	RegisterMetrics()
+	inv := &Inventory{nodes: map[string]map[types.NamespacedName]*coordv1.Lease{}}
+	// Only 0, 1, 4 and 5 are passed through; 2 and 3 are never free.
+	inv.SetDevices(func(node string) ([]int, bool) { return []int{0, 1, 4, 5}, true })
+
+	inv.add(newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "frag-b", 0), "default", "frag-b", "holder", podRef("default", "pod"), 0))
+	v, err := testutil.GetGaugeMetricValue(fragmentationRatio.WithLabelValues("frag-b"))
+	if err != nil {
+		t.Fatalf("read gauge: %v", err)
+	}
+	// Free 1, 4 and 5: the largest block is two of three.
+	if v != 2.0/3 {
+		t.Errorf("Expected ratio 2/3 across the gap in device ids, got %v", v)
+	}
+}
+
+func TestInventoryFragmentationFollowsWindows(t *testing.T) {
+	RegisterMetrics()
+	inv := &Inventory{nodes: map[string]map[types.NamespacedName]*coordv1.Lease{}}
+	inv.SetDevices(func(node string) ([]int, bool) { return []int{0, 1, 2, 3}, true })
+
+	// Device 0 is taken; a reservation of device 2 whose window has passed
+	// leaves it free, so 1-3 form one block.
+	inv.add(newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "frag-c", 0), "default", "frag-c", "holder", podRef("default", "pod"), 0))
+	reservation := newLease(DefaultLabelPrefix, ReservationLeaseName(DefaultLabelPrefix, "frag-c", 2, "past"), "default", "frag-c", "past", podRef("default", ""), 2)
+	reservation.Annotations[annoReserveWindow] = "2000-01-01T00:00:00Z/2001-01-01T00:00:00Z"
+	inv.add(reservation)
+	v, err := testutil.GetGaugeMetricValue(fragmentationRatio.WithLabelValues("frag-c"))
+	if err != nil {
+		t.Fatalf("read gauge: %v", err)
+	}
+	if v != 1 {
+		t.Errorf("Expected ratio 1 with the reservation outside its window, got %v", v)
+	}
+	if got := inv.reservedNodes(); len(got) != 1 || got[0] != "frag-c" {
+		t.Errorf("Expected frag-c to be observed as windows change, got %v", got)
+	}
+}
@@ -2,86 +2,851 @@ package lease
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	"golang.org/x/time/rate"
+	coordv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+
+	"github.com/restack/gpu-scheduler/internal/audit"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+const (
+	// DefaultGCInterval is how often StartGC looks for orphaned leases.
+	DefaultGCInterval = 30 * time.Second
+	// DefaultGCJitter is the fraction by which StartGC varies each wait
+	// around the interval.
+	DefaultGCJitter = 0.1
+	// DefaultGCGrace is how long a lease's pod must stay missing before
+	// StartGC deletes the lease.
+	DefaultGCGrace = 2 * time.Minute
+	// DefaultGCUnknownGrace is how long a lease's pod may stay in phase
+	// Unknown before StartGC deletes the lease.
+	DefaultGCUnknownGrace = 5 * time.Minute
+	// DefaultGCWorkers is how many leases the collector handles at once.
+	DefaultGCWorkers = 4
+	// DefaultDeleteQPS and DefaultDeleteBurst pace lease deletions.
+	DefaultDeleteQPS   = 10
+	DefaultDeleteBurst = 20
+	// DefaultGCBindTimeout is how long a lease may be held for a pod that
+	// is not bound to a node yet.
+	DefaultGCBindTimeout = 10 * time.Minute
 )
 
 const (
-	gcInterval   = 30 * time.Second
-	labelManaged = "gpu.scheduling/managed"
-	labelPod     = "gpu.scheduling/pod"
+	ownerName    = "gpu-scheduler"
+	annoFraction = "gpu.scheduling/fraction"
+	// annoFractionLimit records the share a burstable fractional lease's
+	// holder may use beyond annoFraction, for an enforcer such as MPS or
+	// time-slicing to cap it at. Packing only counts annoFraction.
+	annoFractionLimit = "gpu.scheduling/fraction-limit"
+	// annoMemory records the GPU memory, in bytes, a fractional lease reserves.
+	annoMemory = "gpu.scheduling/memory"
+	// annoMissingSince records when GC first failed to find the lease's pod.
+	annoMissingSince = "gpu.scheduling/missing-since"
+	// annoUnknownSince records when GC first saw the lease's pod in phase
+	// Unknown, which usually means its node stopped reporting.
+	annoUnknownSince = "gpu.scheduling/unknown-since"
+	// annoReservedAt records when the scheduler reserved the device, so GC
+	// can reclaim reservations whose pod is never bound.
+	annoReservedAt = "gpu.scheduling/reserved-at"
+	// annoReclaimRequested marks a lease GC will delete on its next pass,
+	// with soft reclaim on. A controller removing it keeps the lease, as
+	// does setting it to "false".
+	annoReclaimRequested = "gpu.scheduling/reclaim-requested"
+	// annoReclaimRequestedFor records the UID of the pod whose name the
+	// lease's holder lost when GC marked it, so a mark removed for that
+	// mismatch is not put back.
+	annoReclaimRequestedFor = "gpu.scheduling/reclaim-requested-for"
 )
 
-// StartGC runs a background loop to clean up orphaned leases.
+// GCOptions tunes the lease garbage collector.
+type GCOptions struct {
+	// Interval between collections. Non-positive values use DefaultGCInterval.
+	Interval time.Duration
+	// Jitter varies each wait between collections by up to this fraction of
+	// Interval either way, e.g. 0.1 for ±10%, so replicas and controllers
+	// started together do not all hit the API server at the same moment.
+	// Zero waits exactly Interval; negative values, or values of 1 or more,
+	// use DefaultGCJitter.
+	Jitter float64
+	// Grace is how long a pod must be continuously missing before its lease
+	// is deleted, so a transient NotFound does not drop a live reservation.
+	// Zero deletes on the first miss; negative values use DefaultGCGrace.
+	Grace time.Duration
+	// UnknownGrace is how long a pod may stay in phase Unknown before its
+	// lease is deleted. Pending pods always keep their leases. Zero deletes on
+	// the first sighting; negative values use DefaultGCUnknownGrace.
+	UnknownGrace time.Duration
+	// Pods serves pod lookups from an informer cache, which must include
+	// finished pods for their leases to go at once. When nil, the collector
+	// starts its own pod informer on the client.
+	Pods corelisters.PodLister
+	// PodsSynced reports whether Pods is filled; collection waits for it.
+	PodsSynced cache.InformerSynced
+	// Nodes serves node lookups for the stale-renewal check. When nil, the
+	// collector starts its own node informer on the client.
+	Nodes corelisters.NodeLister
+	// NodesSynced reports whether Nodes is filled; collection waits for it.
+	NodesSynced cache.InformerSynced
+	// Recorder, when set, records an event for every deleted lease.
+	Recorder record.EventRecorder
+	// Audit, when set, gets a release record for every deleted lease.
+	Audit audit.Sink
+	// DeleteQPS and DeleteBurst cap the lease delete rate across passes, so a
+	// large batch finishing at once does not flood the API server.
+	// Non-positive values use DefaultDeleteQPS and DefaultDeleteBurst.
+	DeleteQPS   float64
+	DeleteBurst int
+	// Workers is how many leases are handled concurrently. Non-positive
+	// values use DefaultGCWorkers.
+	Workers int
+	// LeaderElection, when set, runs the collector only on the replica that
+	// holds the election lease, so several schedulers do not race to delete
+	// the same leases.
+	LeaderElection *LeaderElection
+	// WhileLeading, when set, runs next to the collector, with a context
+	// that ends with the replica's leadership, or with ctx when there is no
+	// LeaderElection. It is called again on every term a replica leads, and
+	// a term only ends once it has returned.
+	WhileLeading func(context.Context)
+	// DryRun logs the leases the collector would delete, and counts them in
+	// the would-delete metric, without deleting them. Grace period
+	// annotations are still written, but SoftReclaim marks no lease.
+	DryRun bool
+	// StaleRenewals is how many lease durations may pass after a lease's
+	// RenewTime before the lease is deleted, provided its pod is gone or not
+	// Running, or its node is NotReady or unknown. A missed renewal alone
+	// never deletes a lease. Leases never renewed are not checked. Zero
+	// disables the check; negative values use DefaultStaleRenewals.
+	StaleRenewals int
+	// BindTimeout is how long after Reserve a lease is kept while its pod
+	// is Pending and not bound to a node, e.g. because binding keeps failing
+	// or the pod waits in Permit forever. Zero disables the check; negative
+	// values use DefaultGCBindTimeout.
+	BindTimeout time.Duration
+	// ClearStaleAllocations has a lease reclaimed for a UID mismatch also
+	// remove the allocation annotations from the pod now bearing the name,
+	// which still carry the old instance's devices. A pod holding leases of
+	// its own keeps them, since they are its own allocation.
+	ClearStaleAllocations bool
+	// SoftReclaim makes reclaiming a lease for a UID mismatch two-phase: a
+	// pass first marks the lease gpu.scheduling/reclaim-requested=true, and
+	// a later pass deletes it only if the mark is still there and the
+	// mismatch unresolved, so a controller has a pass's time to step in by
+	// removing the mark.
+	SoftReclaim bool
+	// APICallTimeout bounds each API call, so a pass is not held up by one
+	// slow namespace: the call gives up and the next lease is handled. Zero
+	// leaves calls bounded only by the collector's context; negative values
+	// use DefaultAPICallTimeout.
+	APICallTimeout time.Duration
+	// Namespaces, when set, are the only namespaces whose leases are
+	// listed and collected, for schedulers that may not list leases
+	// cluster-wide. Leases in LeaseNamespace are listed as well and
+	// collected when their pod is in one of Namespaces. Empty collects in
+	// every namespace.
+	Namespaces []string
+	// LeaseNamespace is where leases are created for pods in every
+	// namespace; empty means DefaultNamespace.
+	LeaseNamespace string
+	// LabelPrefix names the scheduler instance whose leases are collected;
+	// empty means DefaultLabelPrefix.
+	LabelPrefix string
+}
+
+// reasonLeaseGC is the event reason for leases deleted by the collector.
+const reasonLeaseGC = "LeaseGarbageCollected"
+
+// reasonReclaimRequested is the event reason for leases marked for a soft
+// reclaim.
+const reasonReclaimRequested = "LeaseReclaimRequested"
+
+// collector deletes leases whose pods no longer need them.
+type collector struct {
+	client clientset.Interface
+	pods   corelisters.PodLister
+	// nodes tells whether a stale lease's node is still Ready; nil treats
+	// every node as Ready.
+	nodes    corelisters.NodeLister
+	recorder record.EventRecorder
+	audit    audit.Sink
+	grace    time.Duration
+	// unknownGrace is how long a pod may sit in phase Unknown.
+	unknownGrace time.Duration
+	// limiter paces deletes across all workers; nil means unlimited.
+	limiter *rate.Limiter
+	// workers is the number of leases handled concurrently; at least one.
+	workers int
+	// dryRun logs deletions instead of making them.
+	dryRun bool
+	// staleRenewals is the number of missed lease durations after which a
+	// renewed lease is reclaimed; zero disables the check.
+	staleRenewals int
+	// bindTimeout is how long an unbound pod may hold a reservation; zero
+	// disables the check.
+	bindTimeout time.Duration
+	// clearAllocations removes a stale allocation from a pod whose lease is
+	// reclaimed for a UID mismatch.
+	clearAllocations bool
+	// softReclaim marks a lease with a UID mismatch before deleting it on a
+	// later pass.
+	softReclaim bool
+	// callTimeout bounds each API call; zero leaves calls unbounded.
+	callTimeout time.Duration
+	// namespaces limits collection to these namespaces, and to the leases
+	// in leaseNamespace of pods in them; empty means all.
+	namespaces     []string
+	leaseNamespace string
+	// prefix is the label prefix of the instance whose leases are collected.
+	prefix string
+	// holders are the UIDs holding a lease in the current pass. run sets it
+	// before the workers start.
+	holders map[string]bool
+}
+
+// StartGC runs a background loop to clean up orphaned leases every
+// DefaultGCInterval.
 func StartGC(ctx context.Context, client clientset.Interface) {
-	go func() {
-		ticker := time.NewTicker(gcInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				runGC(ctx, client)
-			}
+	StartGCWithInterval(ctx, client, DefaultGCInterval)
+}
+
+// StartGCWithInterval is StartGC with a custom period. A non-positive interval
+// falls back to DefaultGCInterval.
+func StartGCWithInterval(ctx context.Context, client clientset.Interface, interval time.Duration) {
+	StartGCWithOptions(ctx, client, GCOptions{Interval: interval, Grace: DefaultGCGrace, UnknownGrace: DefaultGCUnknownGrace})
+}
+
+// StartGCWithOptions is StartGC with every setting exposed. It only fails when
+// opts.LabelPrefix or opts.LeaderElection is invalid.
+func StartGCWithOptions(ctx context.Context, client clientset.Interface, opts GCOptions) error {
+	if err := ValidateLabelPrefix(opts.LabelPrefix); err != nil {
+		return err
+	}
+	interval, grace := opts.Interval, opts.Grace
+	if interval <= 0 {
+		klog.InfoS("GC: invalid interval, using default", "interval", interval, "default", DefaultGCInterval)
+		interval = DefaultGCInterval
+	}
+	jitter := opts.Jitter
+	if jitter < 0 || jitter >= 1 {
+		klog.InfoS("GC: invalid jitter, using default", "jitter", jitter, "default", DefaultGCJitter)
+		jitter = DefaultGCJitter
+	}
+	if grace < 0 {
+		klog.InfoS("GC: invalid grace period, using default", "grace", grace, "default", DefaultGCGrace)
+		grace = DefaultGCGrace
+	}
+	unknownGrace := opts.UnknownGrace
+	if unknownGrace < 0 {
+		klog.InfoS("GC: invalid unknown-phase grace period, using default", "grace", unknownGrace, "default", DefaultGCUnknownGrace)
+		unknownGrace = DefaultGCUnknownGrace
+	}
+	pods, synced := opts.Pods, opts.PodsSynced
+	nodes, nodesSynced := opts.Nodes, opts.NodesSynced
+	if pods == nil || nodes == nil {
+		factory := informers.NewSharedInformerFactory(client, 0)
+		if pods == nil {
+			podInformer := factory.Core().V1().Pods()
+			pods, synced = podInformer.Lister(), podInformer.Informer().HasSynced
+		}
+		if nodes == nil {
+			nodeInformer := factory.Core().V1().Nodes()
+			nodes, nodesSynced = nodeInformer.Lister(), nodeInformer.Informer().HasSynced
+		}
+		factory.Start(ctx.Done())
+	}
+	qps, burst := opts.DeleteQPS, opts.DeleteBurst
+	if qps <= 0 {
+		qps = DefaultDeleteQPS
+	}
+	if burst <= 0 {
+		burst = DefaultDeleteBurst
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultGCWorkers
+	}
+	staleRenewals := opts.StaleRenewals
+	if staleRenewals < 0 {
+		staleRenewals = DefaultStaleRenewals
+	}
+	bindTimeout := opts.BindTimeout
+	if bindTimeout < 0 {
+		bindTimeout = DefaultGCBindTimeout
+	}
+	callTimeout := opts.APICallTimeout
+	if callTimeout < 0 {
+		callTimeout = DefaultAPICallTimeout
+	}
+	leaseNamespace := opts.LeaseNamespace
+	if leaseNamespace == "" {
+		leaseNamespace = DefaultNamespace
+	}
+	c := &collector{
+		client:        client,
+		pods:          pods,
+		nodes:         nodes,
+		recorder:      opts.Recorder,
+		audit:         opts.Audit,
+		grace:         grace,
+		unknownGrace:  unknownGrace,
+		limiter:       rate.NewLimiter(rate.Limit(qps), burst),
+		workers:       workers,
+		dryRun:        opts.DryRun,
+		staleRenewals: staleRenewals,
+		bindTimeout:   bindTimeout,
+
+		clearAllocations: opts.ClearStaleAllocations,
+		softReclaim:      opts.SoftReclaim,
+		callTimeout:      callTimeout,
+		namespaces:       opts.Namespaces,
+		leaseNamespace:   leaseNamespace,
+		prefix:           opts.LabelPrefix,
+	}
+	RegisterMetrics()
+	loop := func(ctx context.Context) {
+		// An unsynced cache reports every pod as missing.
+		if synced != nil && !cache.WaitForCacheSync(ctx.Done(), synced) {
+			return
+		}
+		if nodesSynced != nil && !cache.WaitForCacheSync(ctx.Done(), nodesSynced) {
+			return
 		}
-	}()
+		c.loop(ctx, interval, jitter)
+	}
+	if lead := opts.WhileLeading; lead != nil {
+		collect := loop
+		loop = func(ctx context.Context) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				lead(ctx)
+			}()
+			collect(ctx)
+			<-done
+		}
+	}
+	if opts.LeaderElection == nil {
+		go loop(ctx)
+		return nil
+	}
+	return runElected(ctx, client, *opts.LeaderElection, loop)
 }
 
-func runGC(ctx context.Context, client clientset.Interface) {
-	// List all leases managed by us
-	leases, err := client.CoordinationV1().Leases("").List(ctx, metav1.ListOptions{
-		LabelSelector: labelManaged + "=true",
-	})
-	if err != nil {
-		klog.ErrorS(err, "GC: failed to list leases")
+// loop calls run every interval, varied by jitter, until ctx is done. The
+// wait is counted from the end of a pass, and the first pass waits as well,
+// so a new leader does not collect the moment it takes over.
+func (c *collector) loop(ctx context.Context, interval time.Duration, jitter float64) {
+	base, factor := jitterBand(interval, jitter)
+	select {
+	case <-ctx.Done():
 		return
+	case <-time.After(jittered(base, factor)):
 	}
+	wait.JitterUntilWithContext(ctx, func(ctx context.Context) { c.run(ctx, time.Now()) }, base, factor, true)
+}
 
-	for _, lease := range leases.Items {
-		podName := lease.Labels[labelPod]
-		if podName == "" {
-			continue
+// jitterBand returns the base period and jitter factor for wait.Jitter that
+// spread waits evenly over interval ± jitter·interval, as wait.Jitter only
+// adds to the period.
+func jitterBand(interval time.Duration, jitter float64) (time.Duration, float64) {
+	if jitter <= 0 {
+		return interval, 0
+	}
+	return time.Duration(float64(interval) * (1 - jitter)), 2 * jitter / (1 - jitter)
+}
+
+// jittered draws one wait from the band the way wait.JitterUntil does;
+// wait.Jitter alone treats a zero factor as 1.
+func jittered(base time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return base
+	}
+	return wait.Jitter(base, factor)
+}
+
+// run makes one pass over the managed leases, spreading them over the
+// workers, and returns once every lease has been handled. Pods are read from
+// the cache; only lease writes go to the API server. now is injected so tests
+// can step through the grace period.
+func (c *collector) run(ctx context.Context, now time.Time) {
+	start := time.Now()
+	defer func() { gcDuration.Observe(time.Since(start).Seconds()) }()
+
+	leases, ok := c.listLeases(ctx)
+	if !ok {
+		return
+	}
+	leasesTotal.Set(float64(len(leases)))
+	items := c.resolveConflicts(ctx, leases)
+	c.holders = map[string]bool{}
+	for _, l := range items {
+		if l.Spec.HolderIdentity != nil {
+			c.holders[*l.Spec.HolderIdentity] = true
 		}
+	}
 
-		// Check if pod exists and is active
-		pod, err := client.CoreV1().Pods(lease.Namespace).Get(ctx, podName, metav1.GetOptions{})
-		if err != nil {
-			if errors.IsNotFound(err) {
-				// Pod is gone, delete lease
-				klog.InfoS("GC: deleting lease for missing pod", "lease", lease.Name, "pod", podName)
-				deleteLease(ctx, client, lease.Namespace, lease.Name)
-			} else {
-				klog.ErrorS(err, "GC: failed to get pod", "pod", podName)
+	work := make(chan *coordv1.Lease)
+	var wg sync.WaitGroup
+	for w := 0; w < max(c.workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lease := range work {
+				c.collect(ctx, lease, now)
 			}
+		}()
+	}
+	for i := range items {
+		work <- &items[i]
+	}
+	close(work)
+	wg.Wait()
+}
+
+// listLeases lists the leases created by us, in every namespace or only in
+// c.namespaces, as ownedByUs tells them apart. With c.namespaces it also
+// lists c.leaseNamespace, where the leases of pods in every namespace live,
+// and keeps those of pods in c.namespaces. A namespace that cannot be
+// listed is logged and skipped so the others are still collected; ok is
+// false when no list succeeded.
+func (c *collector) listLeases(ctx context.Context) (leases []coordv1.Lease, ok bool) {
+	namespaces := c.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	} else if c.leaseNamespace != "" && !slices.Contains(namespaces, c.leaseNamespace) {
+		namespaces = append(slices.Clip(namespaces), c.leaseNamespace)
+	}
+	for _, ns := range namespaces {
+		callCtx, cancel := CallContext(ctx, c.callTimeout)
+		list, err := c.client.CoordinationV1().Leases(ns).List(callCtx, metav1.ListOptions{
+			LabelSelector: managedSelector(c.prefix),
+		})
+		cancel()
+		if err != nil {
+			c.logCallError(err, "GC: failed to list leases", "namespace", ns)
 			continue
 		}
+		for _, l := range list.Items {
+			if !ownedByUs(&l) {
+				continue
+			}
+			if len(c.namespaces) > 0 && !slices.Contains(c.namespaces, ns) && !slices.Contains(c.namespaces, PodOf(&l).Namespace) {
+				continue
+			}
+			leases = append(leases, l)
+		}
+		ok = true
+	}
+	return leases, ok
+}
 
-		// Check if pod is completed or failed
-		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
-			klog.InfoS("GC: deleting lease for completed/failed pod", "lease", lease.Name, "pod", podName, "phase", pod.Status.Phase)
-			deleteLease(ctx, client, lease.Namespace, lease.Name)
-			continue
+// ownedByUs reports whether the scheduler created lease. Its leases carry
+// labelOwnedBy, except those made by releases before the label existed,
+// which must look exactly like a device lease of theirs. A managed lease
+// that does not, or is owned by someone else, belongs to other tooling.
+func ownedByUs(lease *coordv1.Lease) bool {
+	if owner, ok := lease.Labels[labelOwnedBy]; ok {
+		return owner == ownerName
+	}
+	return legacyLease(lease)
+}
+
+// legacyLease reports whether lease, which has no labelOwnedBy, is a device
+// lease as releases before the label created them: held by a pod, labeled
+// with it, its node and device, and named after the node and device as
+// LeaseName, FractionLeaseName or MIGLeaseName would name it.
+func legacyLease(lease *coordv1.Lease) bool {
+	node, pod := lease.Labels[labelNode], lease.Labels[labelPod]
+	holder := lease.Spec.HolderIdentity
+	if node == "" || pod == "" || holder == nil || *holder == "" {
+		return false
+	}
+	id, err := strconv.Atoi(lease.Labels[labelDevice])
+	if err != nil {
+		return false
+	}
+	if _, ok := lease.Labels[labelMIG]; ok {
+		return lease.Name == MIGLeaseName(DefaultLabelPrefix, node, id)
+	}
+	return lease.Name == LeaseName(DefaultLabelPrefix, node, id) || lease.Name == FractionLeaseName(DefaultLabelPrefix, node, id, *holder)
+}
+
+// collect decides whether one lease is still needed and deletes it if not.
+// Several workers call it concurrently.
+func (c *collector) collect(ctx context.Context, lease *coordv1.Lease, now time.Time) {
+	// A reservation has no pod. It is kept until its window is over for
+	// good, which only a one-off window ever is.
+	if w, ok := reserveWindow(lease); ok {
+		if w.Expired(now) {
+			klog.InfoS("GC: deleting reservation whose window ended", "lease", lease.Name, "window", lease.Annotations[annoReserveWindow])
+			c.deleteLease(ctx, lease, lease, reasonWindowEnded, fmt.Sprintf("Deleted GPU reservation %s: its window %s has ended",
+				lease.Name, lease.Annotations[annoReserveWindow]))
 		}
+		return
+	}
 
-		// Check if pod UID matches holder identity
-		if lease.Spec.HolderIdentity != nil && string(pod.UID) != *lease.Spec.HolderIdentity {
-			klog.InfoS("GC: deleting lease for UID mismatch", "lease", lease.Name, "pod", podName, "podUID", pod.UID, "holder", *lease.Spec.HolderIdentity)
-			deleteLease(ctx, client, lease.Namespace, lease.Name)
+	key := PodOf(lease)
+	podName := key.Name
+	if podName == "" {
+		return
+	}
+
+	// A lease whose node agent stopped renewing it is only a hint: the agent
+	// may just have restarted. It is reclaimed without waiting for the other
+	// checks' grace periods once its pod is gone or not running, or its node
+	// is down, since a dead kubelet never updates its pods' status.
+	deadline, ok := renewDeadline(lease, c.staleRenewals)
+	stale := ok && !now.Before(deadline)
+
+	// Check if pod exists and is active
+	pod, err := c.pods.Pods(key.Namespace).Get(podName)
+	if err != nil {
+		if errors.IsNotFound(err) && stale {
+			c.deleteStale(ctx, lease, lease, "pod missing")
+			return
+		}
+		if errors.IsNotFound(err) {
+			missingSince, ok := parseSince(lease.Annotations[annoMissingSince])
+			if !ok {
+				missingSince = now
+			}
+			if now.Sub(missingSince) >= c.grace {
+				// Pod has been gone for the whole grace period, delete lease
+				klog.InfoS("GC: deleting lease for missing pod", "lease", lease.Name, "pod", podName, "missingSince", missingSince)
+				c.deleteLease(ctx, lease, lease, reasonMissing, fmt.Sprintf("Deleted GPU lease %s: pod %s missing since %s",
+					lease.Name, podName, missingSince.UTC().Format(time.RFC3339)))
+			} else if !ok {
+				klog.V(2).InfoS("GC: pod missing, waiting for grace period", "lease", lease.Name, "pod", podName, "grace", c.grace)
+				c.setSince(ctx, lease.Namespace, lease.Name, annoMissingSince, &missingSince)
+			}
+		} else {
+			klog.ErrorS(err, "GC: failed to get pod", "pod", podName)
 		}
+		return
 	}
+
+	// The pod is back (or never left); forget an earlier miss.
+	if _, ok := lease.Annotations[annoMissingSince]; ok {
+		c.setSince(ctx, lease.Namespace, lease.Name, annoMissingSince, nil)
+	}
+
+	// Check if pod is completed or failed
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		klog.InfoS("GC: deleting lease for completed/failed pod", "lease", lease.Name, "pod", podName, "phase", pod.Status.Phase)
+		c.deleteLease(ctx, lease, pod, reasonFinished, fmt.Sprintf("Deleted GPU lease %s: pod is %s", lease.Name, pod.Status.Phase))
+		return
+	}
+
+	if stale {
+		if pod.Status.Phase != corev1.PodRunning {
+			c.deleteStale(ctx, lease, pod, "pod "+string(pod.Status.Phase))
+			return
+		}
+		if !c.nodeReady(pod.Spec.NodeName) {
+			c.deleteStale(ctx, lease, pod, "node "+pod.Spec.NodeName+" not ready")
+			return
+		}
+	}
+
+	// Check if pod UID matches holder identity
+	if lease.Spec.HolderIdentity != nil && string(pod.UID) != *lease.Spec.HolderIdentity {
+		if c.softReclaim && !c.reclaimDue(ctx, lease, pod) {
+			return
+		}
+		klog.InfoS("GC: deleting lease for UID mismatch", "lease", lease.Name, "pod", podName, "podUID", pod.UID, "holder", *lease.Spec.HolderIdentity)
+		deleted := c.deleteLease(ctx, lease, lease, reasonUIDMismatch, fmt.Sprintf("Deleted GPU lease %s: held by pod UID %s, but pod %s now has UID %s",
+			lease.Name, *lease.Spec.HolderIdentity, podName, pod.UID))
+		if deleted && c.clearAllocations && !c.holders[string(pod.UID)] {
+			c.clearAllocation(ctx, pod)
+		}
+		return
+	}
+	// The mismatch was resolved, e.g. by a controller handing the lease to
+	// the new pod; a reclaim request no longer applies.
+	_, requested := lease.Annotations[annoReclaimRequested]
+	_, requestedFor := lease.Annotations[annoReclaimRequestedFor]
+	if requested || requestedFor {
+		c.setAnnotations(ctx, lease.Namespace, lease.Name, map[string]*string{annoReclaimRequested: nil, annoReclaimRequestedFor: nil})
+	}
+
+	// A pod in phase Unknown has lost contact with its node and may never
+	// come back; reclaim its lease once it has been unknown long enough.
+	// Pending pods are kept: they are waiting to use the lease.
+	if pod.Status.Phase == corev1.PodUnknown {
+		unknownSince, ok := parseSince(lease.Annotations[annoUnknownSince])
+		if !ok {
+			unknownSince = now
+		}
+		if now.Sub(unknownSince) >= c.unknownGrace {
+			klog.InfoS("GC: deleting lease for pod in unknown phase", "lease", lease.Name, "pod", podName, "unknownSince", unknownSince)
+			c.deleteLease(ctx, lease, pod, reasonUnknown, fmt.Sprintf("Deleted GPU lease %s: pod phase Unknown since %s",
+				lease.Name, unknownSince.UTC().Format(time.RFC3339)))
+		} else if !ok {
+			klog.V(2).InfoS("GC: pod phase unknown, waiting for grace period", "lease", lease.Name, "pod", podName, "grace", c.unknownGrace)
+			c.setSince(ctx, lease.Namespace, lease.Name, annoUnknownSince, &unknownSince)
+		}
+		return
+	}
+	if _, ok := lease.Annotations[annoUnknownSince]; ok {
+		c.setSince(ctx, lease.Namespace, lease.Name, annoUnknownSince, nil)
+	}
+
+	// A Pending pod normally keeps its lease, but one that was never bound
+	// long after Reserve is not going to use it. A bound pod, however slow
+	// to start, may already have been handed the device.
+	if c.bindTimeout > 0 && pod.Status.Phase == corev1.PodPending && pod.Spec.NodeName == "" && lease.Spec.AcquireTime == nil {
+		if reservedAt, ok := parseSince(lease.Annotations[annoReservedAt]); ok && now.Sub(reservedAt) >= c.bindTimeout {
+			klog.InfoS("GC: deleting lease for pod never bound", "lease", lease.Name, "pod", podName, "reservedAt", reservedAt)
+			c.deleteLease(ctx, lease, pod, reasonBindTimeout, fmt.Sprintf("Deleted GPU lease %s: reserved at %s, pod still not bound",
+				lease.Name, reservedAt.UTC().Format(time.RFC3339)))
+		}
+	}
+}
+
+// deleteStale deletes a lease that is no longer renewed, now that why
+// confirms its pod is not using the device.
+func (c *collector) deleteStale(ctx context.Context, lease *coordv1.Lease, regarding runtime.Object, why string) {
+	klog.InfoS("GC: deleting lease that is no longer renewed", "lease", lease.Name, "pod", PodOf(lease).Name, "renewTime", lease.Spec.RenewTime.Time, "why", why)
+	c.deleteLease(ctx, lease, regarding, reasonStale, fmt.Sprintf("Deleted GPU lease %s: not renewed since %s, %s",
+		lease.Name, lease.Spec.RenewTime.UTC().Format(time.RFC3339), why))
+}
+
+// nodeReady reports whether name is a known node whose Ready condition is
+// True. Without a node lister every node counts as Ready.
+func (c *collector) nodeReady(name string) bool {
+	if c.nodes == nil {
+		return true
+	}
+	node, err := c.nodes.Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.ErrorS(err, "GC: failed to get node", "node", name)
+			return true
+		}
+		return false
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// deleteLease deletes the lease and records why on regarding, which is the
+// pod when it still exists and the lease otherwise. In dry-run mode it only
+// logs and counts the deletion. It reports whether the lease was deleted.
+func (c *collector) deleteLease(ctx context.Context, lease *coordv1.Lease, regarding runtime.Object, reason, message string) bool {
+	if c.dryRun {
+		klog.InfoS("GC: dry run, not deleting lease", "lease", klog.KObj(lease), "reason", reason, "message", message)
+		wouldDeleteTotal.WithLabelValues(reason).Inc()
+		return false
+	}
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			klog.V(2).InfoS("GC: gave up waiting to delete lease", "lease", lease.Name, "err", err)
+			return false
+		}
+	}
+	// The timeout starts after the limiter wait: only the call is bounded.
+	callCtx, cancel := CallContext(ctx, c.callTimeout)
+	defer cancel()
+	if err := c.client.CoordinationV1().Leases(lease.Namespace).Delete(callCtx, lease.Name, metav1.DeleteOptions{}); err != nil {
+		if !errors.IsNotFound(err) {
+			c.logCallError(err, "GC: failed to delete lease", "lease", lease.Name)
+		}
+		return false
+	}
+	deletedTotal.WithLabelValues(reason).Inc()
+	if c.recorder != nil {
+		c.recorder.Event(regarding, corev1.EventTypeNormal, reasonLeaseGC, message)
+	}
+	c.recordRelease(lease, regarding, reason)
+	return true
+}
+
+// recordRelease writes the deleted lease's devices to the audit sink.
+// Reservations have no pod and are not recorded.
+func (c *collector) recordRelease(lease *coordv1.Lease, regarding runtime.Object, reason string) {
+	if c.audit == nil || lease.Labels[labelPod] == "" {
+		return
+	}
+	r := audit.Record{
+		Action:    audit.Release,
+		Namespace: PodOf(lease).Namespace,
+		Pod:       lease.Labels[labelPod],
+		Node:      NodeOf(lease),
+		Devices:   deviceIDs(*lease),
+		Reason:    reason,
+	}
+	if lease.Spec.HolderIdentity != nil {
+		r.PodUID = *lease.Spec.HolderIdentity
+	}
+	// The user is only known while the pod still exists.
+	if pod, ok := regarding.(*corev1.Pod); ok {
+		r.User = pod.Annotations[util.AnnoRequestedBy]
+	}
+	if err := c.audit.Write(r); err != nil {
+		klog.ErrorS(err, "GC: failed to write audit record", "lease", klog.KObj(lease))
+	}
+}
+
+// clearAllocation removes the pod's allocation annotations, if it has any.
+// The holders of the pass may be out of date by now, so the API server is
+// asked again whether the pod holds a lease, and the patch is made to apply
+// only to the pod as read: an allocation written since, or a pod recreated
+// under the name, keeps its annotations until a later pass.
+func (c *collector) clearAllocation(ctx context.Context, pod *corev1.Pod) {
+	stale := util.AllocatedAnnotations(pod)
+	if len(stale) == 0 {
+		return
+	}
+	if held, err := c.holdsLease(ctx, pod); err != nil || held {
+		if err != nil {
+			c.logCallError(err, "GC: failed to list leases of pod", "pod", klog.KObj(pod))
+		}
+		return
+	}
+	annotations := make(map[string]interface{}, len(stale))
+	for k := range stale {
+		annotations[k] = nil
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             pod.UID,
+			"resourceVersion": pod.ResourceVersion,
+			"annotations":     annotations,
+		},
+	})
+	callCtx, cancel := CallContext(ctx, c.callTimeout)
+	defer cancel()
+	if _, err := c.client.CoreV1().Pods(pod.Namespace).Patch(callCtx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		if errors.IsConflict(err) || errors.IsNotFound(err) {
+			klog.V(2).InfoS("GC: pod changed, keeping its allocation for now", "pod", klog.KObj(pod), "uid", pod.UID)
+			return
+		}
+		c.logCallError(err, "GC: failed to clear stale allocation", "pod", klog.KObj(pod))
+		return
+	}
+	klog.InfoS("GC: cleared stale allocation", "pod", klog.KObj(pod), "uid", pod.UID)
 }
 
-func deleteLease(ctx context.Context, client clientset.Interface, ns, name string) {
-	if err := client.CoordinationV1().Leases(ns).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+// holdsLease reports whether pod holds one of the instance's leases, as the
+// API server has them rather than the pass.
+func (c *collector) holdsLease(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	callCtx, cancel := CallContext(ctx, c.callTimeout)
+	defer cancel()
+	list, err := c.client.CoordinationV1().Leases("").List(callCtx, metav1.ListOptions{
+		LabelSelector: managedSelector(c.prefix, labelPod+"="+pod.Name),
+	})
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(list.Items, func(l coordv1.Lease) bool {
+		return l.Spec.HolderIdentity != nil && *l.Spec.HolderIdentity == string(pod.UID)
+	}), nil
+}
+
+func parseSince(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil
+}
+
+// reclaimDue reports whether a lease with a UID mismatch is to be deleted
+// now, with soft reclaim on. A lease not yet marked for pod's UID is marked
+// and kept for this pass. One whose mark a controller removed, or set to
+// anything but "true", is kept, until pod's name passes to yet another UID.
+// A dry run marks nothing and reports the lease due, so the deletion the
+// marking leads to is logged and counted like any other.
+func (c *collector) reclaimDue(ctx context.Context, lease *coordv1.Lease, pod *corev1.Pod) bool {
+	value, ok := lease.Annotations[annoReclaimRequested]
+	marked := lease.Annotations[annoReclaimRequestedFor] == string(pod.UID)
+	switch {
+	case value == "true" && marked:
+		return true
+	case marked:
+		if !ok {
+			value = "removed"
+		}
+		klog.V(2).InfoS("GC: reclaim held off", "lease", lease.Name, "pod", pod.Name, annoReclaimRequested, value)
+		return false
+	}
+	if c.dryRun {
+		klog.InfoS("GC: dry run, not requesting reclaim of lease for UID mismatch", "lease", lease.Name, "pod", pod.Name, "podUID", pod.UID, "holder", *lease.Spec.HolderIdentity)
+		return true
+	}
+	klog.InfoS("GC: requesting reclaim of lease for UID mismatch", "lease", lease.Name, "pod", pod.Name, "podUID", pod.UID, "holder", *lease.Spec.HolderIdentity)
+	mark, uid := "true", string(pod.UID)
+	c.setAnnotations(ctx, lease.Namespace, lease.Name, map[string]*string{annoReclaimRequested: &mark, annoReclaimRequestedFor: &uid})
+	if c.recorder != nil {
+		c.recorder.Eventf(lease, corev1.EventTypeNormal, reasonReclaimRequested,
+			"GPU lease %s is held by pod UID %s, but pod %s now has UID %s; it is deleted on the next pass unless %s is removed",
+			lease.Name, *lease.Spec.HolderIdentity, pod.Name, pod.UID, annoReclaimRequested)
+	}
+	return false
+}
+
+// setSince stamps the time of a first miss or sighting in the annotation anno,
+// or clears it when since is nil.
+func (c *collector) setSince(ctx context.Context, ns, name, anno string, since *time.Time) {
+	var value *string
+	if since != nil {
+		s := since.UTC().Format(time.RFC3339)
+		value = &s
+	}
+	c.setAnnotation(ctx, ns, name, anno, value)
+}
+
+// setAnnotation sets the annotation anno of lease ns/name to value, or
+// removes it when value is nil.
+func (c *collector) setAnnotation(ctx context.Context, ns, name, anno string, value *string) {
+	c.setAnnotations(ctx, ns, name, map[string]*string{anno: value})
+}
+
+// setAnnotations is setAnnotation for several annotations in one patch.
+func (c *collector) setAnnotations(ctx context.Context, ns, name string, values map[string]*string) {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": values,
+		},
+	})
+	callCtx, cancel := CallContext(ctx, c.callTimeout)
+	defer cancel()
+	if _, err := c.client.CoordinationV1().Leases(ns).Patch(callCtx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
 		if !errors.IsNotFound(err) {
-			klog.ErrorS(err, "GC: failed to delete lease", "lease", name)
+			c.logCallError(err, "GC: failed to update lease", "lease", name)
 		}
 	}
 }
+
+// logCallError logs a failed API call. A call that ran out of time is logged
+// as a timeout, so a slow API server stands apart from one refusing writes.
+func (c *collector) logCallError(err error, msg string, keysAndValues ...interface{}) {
+	if TimedOut(err) {
+		klog.ErrorS(err, "GC: API call timed out", append(keysAndValues, "call", msg, "timeout", c.callTimeout)...)
+		return
+	}
+	klog.ErrorS(err, msg, keysAndValues...)
+}
@@ -1,15 +1,52 @@
 package lease
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/time/rate"
 	coordv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/restack/gpu-scheduler/internal/audit"
+	"github.com/restack/gpu-scheduler/internal/util"
 )
 
+// podCache returns a lister over an informer cache seeded with the client's
+// current pods. Tests add or remove pods through the returned indexer.
+func podCache(t *testing.T, client *fake.Clientset) (corelisters.PodLister, cache.Indexer) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	pods, err := client.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list pods: %v", err)
+	}
+	for i := range pods.Items {
+		if err := indexer.Add(&pods.Items[i]); err != nil {
+			t.Fatalf("seed cache: %v", err)
+		}
+	}
+	return corelisters.NewPodLister(indexer), indexer
+}
+
 func TestRunGC(t *testing.T) {
 	ctx := context.Background()
 	client := fake.NewSimpleClientset()
@@ -21,6 +58,7 @@ func TestRunGC(t *testing.T) {
 			Namespace: "default",
 			Labels: map[string]string{
 				labelManaged: "true",
+				labelOwnedBy: ownerName,
 				labelPod:     "missing-pod",
 			},
 		},
@@ -45,6 +83,7 @@ func TestRunGC(t *testing.T) {
 			Namespace: "default",
 			Labels: map[string]string{
 				labelManaged: "true",
+				labelOwnedBy: ownerName,
 				labelPod:     "running-pod",
 			},
 		},
@@ -72,6 +111,7 @@ func TestRunGC(t *testing.T) {
 			Namespace: "default",
 			Labels: map[string]string{
 				labelManaged: "true",
+				labelOwnedBy: ownerName,
 				labelPod:     "completed-pod",
 			},
 		},
@@ -81,16 +121,1039 @@ func TestRunGC(t *testing.T) {
 	}
 	_, _ = client.CoordinationV1().Leases("default").Create(ctx, leaseCompletedPod, metav1.CreateOptions{})
 
-	// Run GC
-	runGC(ctx, client)
+	// 4. A lease some other tool labelled as managed, for a missing pod (should keep)
+	leaseForeign := &coordv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "lease-foreign",
+			Namespace: "default",
+			Labels: map[string]string{
+				labelManaged: "true",
+				labelPod:     "missing-pod",
+			},
+		},
+	}
+	_, _ = client.CoordinationV1().Leases("default").Create(ctx, leaseForeign, metav1.CreateOptions{})
+
+	// Run GC without a grace period, reading pods only from the cache
+	pods, _ := podCache(t, client)
+	client.ClearActions()
+	(&collector{client: client, pods: pods, grace: 0}).run(ctx, time.Now())
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "pods" {
+			t.Errorf("Expected no pod API calls, got %s", action.GetVerb())
+		}
+	}
 
 	// Verify results
 	leases, _ := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
-	if len(leases.Items) != 1 {
-		t.Errorf("Expected 1 lease, got %d", len(leases.Items))
+	var remaining []string
+	for _, l := range leases.Items {
+		remaining = append(remaining, l.Name)
+	}
+	if want := []string{"lease-foreign", "lease-running-pod"}; !slices.Equal(remaining, want) {
+		t.Errorf("Expected %v to remain, got %v", want, remaining)
+	}
+}
+
+func TestRunGCUnlabeledLeases(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	holder := "uid-gone"
+	for _, l := range []*coordv1.Lease{
+		// Made by a release before the owned-by label, for a deleted pod
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: LeaseName(DefaultLabelPrefix, "node-a", 0), Namespace: "default",
+				Labels: map[string]string{labelManaged: "true", labelPod: "gone", labelNode: "node-a", labelDevice: "0"}},
+			Spec: coordv1.LeaseSpec{HolderIdentity: &holder},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: FractionLeaseName(DefaultLabelPrefix, "node-a", 1, holder), Namespace: "default",
+				Labels: map[string]string{labelManaged: "true", labelPod: "gone", labelNode: "node-a", labelDevice: "1"}},
+			Spec: coordv1.LeaseSpec{HolderIdentity: &holder},
+		},
+		// Other tooling's, unlabeled but held and naming a pod
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "lease-foreign", Namespace: "default",
+				Labels: map[string]string{labelManaged: "true", labelPod: "gone", labelNode: "node-a", labelDevice: "2"}},
+			Spec: coordv1.LeaseSpec{HolderIdentity: &holder},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "lease-owner-ref", Namespace: "default",
+				Labels:          map[string]string{labelManaged: "true", labelPod: "gone"},
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "gone", UID: types.UID(holder)}}},
+		},
+		// Owned by other tooling, which marks it as such
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "lease-other-owner", Namespace: "default",
+				Labels: map[string]string{labelManaged: "true", labelOwnedBy: "other-tool", labelPod: "gone"}},
+			Spec: coordv1.LeaseSpec{HolderIdentity: &holder},
+		},
+	} {
+		if _, err := client.CoordinationV1().Leases("default").Create(ctx, l, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("create lease: %v", err)
+		}
+	}
+
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods, grace: 0}).run(ctx, time.Now())
+
+	leases, _ := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+	var remaining []string
+	for _, l := range leases.Items {
+		remaining = append(remaining, l.Name)
+	}
+	if want := []string{"lease-foreign", "lease-other-owner", "lease-owner-ref"}; !slices.Equal(remaining, want) {
+		t.Errorf("Expected %v to remain, got %v", want, remaining)
+	}
+}
+
+func TestStartGCWithIntervalHonorsInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-gone", podRef("default", "gone"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	// With the 30s default the orphan would survive the whole test.
+	StartGCWithOptions(ctx, client, GCOptions{Interval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		leases, _ := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+		if len(leases.Items) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected the orphaned lease to be collected within the injected interval")
+}
+
+func TestStartGCCollectsFinishedPodsAtOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "default", UID: "uid-done"},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	})
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-done", podRef("default", "done"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	// The collector's own pod informer sees the finished pod, so its lease
+	// goes without waiting out the grace period for missing pods.
+	StartGCWithOptions(ctx, client, GCOptions{Interval: 10 * time.Millisecond, Grace: time.Hour})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		leases, _ := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+		if len(leases.Items) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Expected the finished pod's lease to be collected at once")
+}
+
+func TestRunGCGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-flaky", podRef("default", "flaky"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	name := LeaseName(DefaultLabelPrefix, "node-a", 0)
+	grace := 2 * time.Minute
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	missingSince := func() (string, bool) {
+		l, err := coord.Leases("default").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected lease to exist: %v", err)
+		}
+		v, ok := l.Annotations[annoMissingSince]
+		return v, ok
+	}
+
+	// First miss records the timestamp and keeps the lease.
+	pods, indexer := podCache(t, client)
+	c := &collector{client: client, pods: pods, grace: grace}
+	c.run(ctx, start)
+	if v, ok := missingSince(); !ok || v != start.Format(time.RFC3339) {
+		t.Fatalf("Expected missing-since %s after first miss, got %q", start.Format(time.RFC3339), v)
+	}
+
+	// Still missing but within grace: keep the lease and the first timestamp.
+	c.run(ctx, start.Add(time.Minute))
+	if v, _ := missingSince(); v != start.Format(time.RFC3339) {
+		t.Errorf("Expected the first-miss timestamp to be kept, got %q", v)
+	}
+
+	// The pod reappears: the annotation is cleared.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "flaky", Namespace: "default", UID: "uid-flaky"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	_ = indexer.Add(pod)
+	c.run(ctx, start.Add(90*time.Second))
+	if v, ok := missingSince(); ok {
+		t.Errorf("Expected missing-since to be cleared once the pod is back, got %q", v)
+	}
+
+	// Missing again: the grace period restarts from the new miss.
+	_ = indexer.Delete(pod)
+	second := start.Add(3 * time.Minute)
+	c.run(ctx, second)
+	c.run(ctx, second.Add(grace-time.Second))
+	if _, ok := missingSince(); !ok {
+		t.Fatalf("Expected lease to survive within the new grace period")
+	}
+
+	// Past grace: the lease is deleted.
+	c.run(ctx, second.Add(grace))
+	if _, err := coord.Leases("default").Get(ctx, name, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected lease to be deleted after the grace period")
+	}
+}
+
+func TestRunGCUnknownPhase(t *testing.T) {
+	grace := 5 * time.Minute
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		phase     corev1.PodPhase
+		elapsed   time.Duration
+		wantLease bool
+	}{
+		{name: "node lost within grace", phase: corev1.PodUnknown, elapsed: grace - time.Second, wantLease: true},
+		{name: "node lost past grace", phase: corev1.PodUnknown, elapsed: grace, wantLease: false},
+		{name: "pending is always kept", phase: corev1.PodPending, elapsed: time.Hour, wantLease: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", UID: "uid-worker"},
+				Status:     corev1.PodStatus{Phase: tt.phase},
+			})
+			coord := client.CoordinationV1()
+			if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-worker", podRef("default", "worker"), 0); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+			pods, _ := podCache(t, client)
+			c := &collector{client: client, pods: pods, grace: 0, unknownGrace: grace}
+
+			c.run(ctx, start)
+			c.run(ctx, start.Add(tt.elapsed))
+			_, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if got := err == nil; got != tt.wantLease {
+				t.Errorf("Expected lease kept=%v, got %v", tt.wantLease, got)
+			}
+		})
+	}
+}
+
+func TestRunGCBindTimeout(t *testing.T) {
+	timeout := 10 * time.Minute
+	tests := []struct {
+		name      string
+		nodeName  string
+		confirmed bool
+		elapsed   time.Duration
+		wantLease bool
+	}{
+		{name: "expired reservation", elapsed: timeout, wantLease: false},
+		{name: "slow bind within timeout", elapsed: timeout - time.Minute, wantLease: true},
+		{name: "bound pod still starting", nodeName: "node-a", elapsed: time.Hour, wantLease: true},
+		{name: "confirmed lease", confirmed: true, elapsed: time.Hour, wantLease: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", UID: "uid-worker"},
+				Spec:       corev1.PodSpec{NodeName: tt.nodeName},
+				Status:     corev1.PodStatus{Phase: corev1.PodPending},
+			})
+			coord := client.CoordinationV1()
+			if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-worker", podRef("default", "worker"), 0); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+			l, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get lease: %v", err)
+			}
+			reservedAt, ok := parseSince(l.Annotations[annoReservedAt])
+			if !ok {
+				t.Fatalf("Expected a reserved-at annotation, got %v", l.Annotations)
+			}
+			if tt.confirmed {
+				if err := Confirm(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "worker"), "uid-worker", reservedAt); err != nil {
+					t.Fatalf("Confirm: %v", err)
+				}
+			}
+			pods, _ := podCache(t, client)
+			c := &collector{client: client, pods: pods, bindTimeout: timeout}
+
+			c.run(ctx, reservedAt.Add(tt.elapsed))
+			_, err = coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if got := err == nil; got != tt.wantLease {
+				t.Errorf("Expected lease kept=%v, got %v", tt.wantLease, got)
+			}
+		})
+	}
+}
+
+func TestRunGCClearStaleAllocations(t *testing.T) {
+	allocated := map[string]string{
+		"gpu.scheduling/allocated":         `{"trainer":[0]}`,
+		"allocated.gpu.scheduling/trainer": "0",
+		"team":                             "ml",
+	}
+	pod := func(name, uid string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid), Annotations: allocated},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	tests := []struct {
+		name string
+		// holder holds the pod's lease; a lease of its own is added when
+		// ownLease is set.
+		holder    string
+		ownLease  bool
+		phase     corev1.PodPhase
+		clear     bool
+		wantPatch bool
+	}{
+		{name: "recreated pod", holder: "uid-old", phase: corev1.PodPending, clear: true, wantPatch: true},
+		{name: "option off", holder: "uid-old", phase: corev1.PodPending},
+		{name: "recreated pod with its own lease", holder: "uid-old", ownLease: true, phase: corev1.PodRunning, clear: true},
+		{name: "finished pod", holder: "uid-new", phase: corev1.PodSucceeded, clear: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fake.NewSimpleClientset(pod("trainer", "uid-new", tt.phase))
+			coord := client.CoordinationV1()
+			if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", tt.holder, podRef("default", "trainer"), 0); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+			if tt.ownLease {
+				if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-b", "uid-new", podRef("default", "trainer"), 0); err != nil {
+					t.Fatalf("TryAcquire: %v", err)
+				}
+			}
+			pods, _ := podCache(t, client)
+			client.ClearActions()
+
+			(&collector{client: client, pods: pods, clearAllocations: tt.clear}).run(ctx, time.Now())
+			if _, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{}); err == nil {
+				t.Fatalf("Expected the lease to be collected")
+			}
+			var patched bool
+			for _, a := range client.Actions() {
+				patched = patched || (a.GetVerb() == "patch" && a.GetResource().Resource == "pods")
+			}
+			if patched != tt.wantPatch {
+				t.Fatalf("Expected pod patched=%v, got %v", tt.wantPatch, patched)
+			}
+			got, _ := client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+			want := allocated
+			if tt.wantPatch {
+				want = map[string]string{"team": "ml"}
+			}
+			if fmt.Sprint(got.Annotations) != fmt.Sprint(want) {
+				t.Errorf("Expected annotations %v, got %v", want, got.Annotations)
+			}
+		})
+	}
+}
+
+func TestClearAllocationRechecks(t *testing.T) {
+	ctx := context.Background()
+	trainer := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "trainer", Namespace: "default", UID: "uid-new", ResourceVersion: "7",
+		Annotations: map[string]string{"gpu.scheduling/allocated": `{"trainer":[0]}`},
+	}}
+	patches := func(client *fake.Clientset) []string {
+		var out []string
+		for _, a := range client.Actions() {
+			if p, ok := a.(k8stesting.PatchAction); ok && a.GetResource().Resource == "pods" {
+				out = append(out, string(p.GetPatch()))
+			}
+		}
+		return out
+	}
+
+	client := fake.NewSimpleClientset(trainer)
+	(&collector{client: client}).clearAllocation(ctx, trainer)
+	got := patches(client)
+	if len(got) != 1 || !strings.Contains(got[0], `"uid":"uid-new"`) || !strings.Contains(got[0], `"resourceVersion":"7"`) {
+		t.Errorf("Expected one patch conditional on the pod's UID and resourceVersion, got %v", got)
+	}
+
+	// The pod was given a lease after the pass took its holders.
+	client = fake.NewSimpleClientset(trainer)
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-b", "uid-new", podRef("default", "trainer"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	(&collector{client: client}).clearAllocation(ctx, trainer)
+	if got := patches(client); len(got) != 0 {
+		t.Errorf("Expected the allocation of a pod holding a lease kept, got patches %v", got)
+	}
+}
+
+func TestRunGCSoftReclaim(t *testing.T) {
+	tests := []struct {
+		name string
+		// intervene changes the lease between the two passes, as a
+		// controller would.
+		intervene  func(l *coordv1.Lease)
+		wantKept   bool
+		wantMarker string
+	}{
+		{name: "deleted on the next pass", intervene: func(*coordv1.Lease) {}},
+		{
+			name:      "clearing the flag keeps the lease",
+			intervene: func(l *coordv1.Lease) { delete(l.Annotations, annoReclaimRequested) },
+			wantKept:  true,
+		},
+		{
+			name:       "setting the flag to false keeps the lease",
+			intervene:  func(l *coordv1.Lease) { l.Annotations[annoReclaimRequested] = "false" },
+			wantKept:   true,
+			wantMarker: "false",
+		},
+		{
+			name: "handing the lease to the new pod resolves the request",
+			intervene: func(l *coordv1.Lease) {
+				holder := "uid-new"
+				l.Spec.HolderIdentity = &holder
+			},
+			wantKept: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fake.NewSimpleClientset(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "uid-new"},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			})
+			leases := client.CoordinationV1().Leases("default")
+			if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-old", podRef("default", "recreated"), 0); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+			pods, _ := podCache(t, client)
+			recorder := record.NewFakeRecorder(10)
+			c := &collector{client: client, pods: pods, recorder: recorder, softReclaim: true}
+
+			c.run(ctx, time.Now())
+			l, err := leases.Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected the first pass to keep the lease: %v", err)
+			}
+			if got := l.Annotations[annoReclaimRequested]; got != "true" {
+				t.Fatalf("Expected the first pass to mark the lease %s=true, got %q", annoReclaimRequested, got)
+			}
+			select {
+			case e := <-recorder.Events:
+				if !strings.HasPrefix(e, "Normal "+reasonReclaimRequested+" ") || !strings.Contains(e, "now has UID uid-new") {
+					t.Errorf("Expected a %s event, got %q", reasonReclaimRequested, e)
+				}
+			default:
+				t.Errorf("Expected a %s event", reasonReclaimRequested)
+			}
+
+			tt.intervene(l)
+			if _, err := leases.Update(ctx, l, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("update lease: %v", err)
+			}
+			// A pass later the controller's decision still stands.
+			for pass := 2; pass <= 3; pass++ {
+				c.run(ctx, time.Now())
+				l, err = leases.Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+				if !tt.wantKept {
+					if err == nil {
+						t.Errorf("Expected pass %d to delete the lease", pass)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Expected pass %d to keep the lease: %v", pass, err)
+				}
+				if got := l.Annotations[annoReclaimRequested]; got != tt.wantMarker {
+					t.Errorf("Expected %s=%q after pass %d, got %q", annoReclaimRequested, tt.wantMarker, pass, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRunGCSoftReclaimDryRun(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "uid-new"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-old", podRef("default", "recreated"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	RegisterMetrics()
+	before, _ := testutil.GetCounterMetricValue(wouldDeleteTotal.WithLabelValues(reasonUIDMismatch))
+
+	pods, _ := podCache(t, client)
+	recorder := record.NewFakeRecorder(10)
+	client.ClearActions()
+	(&collector{client: client, pods: pods, recorder: recorder, softReclaim: true, dryRun: true}).run(ctx, time.Now())
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "leases" && action.GetVerb() != "list" && action.GetVerb() != "get" && action.GetVerb() != "watch" {
+			t.Errorf("Expected no lease writes in dry-run mode, got %s", action.GetVerb())
+		}
+	}
+	select {
+	case e := <-recorder.Events:
+		t.Errorf("Expected no event in dry-run mode, got %q", e)
+	default:
+	}
+	after, err := testutil.GetCounterMetricValue(wouldDeleteTotal.WithLabelValues(reasonUIDMismatch))
+	if err != nil {
+		t.Fatalf("read counter: %v", err)
+	}
+	if after-before != 1 {
+		t.Errorf("Expected one would-delete for %s, got %v", reasonUIDMismatch, after-before)
+	}
+}
+
+func TestRunGCStaleRenewal(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const period = 10 * time.Second
+	tests := []struct {
+		name      string
+		renew     bool
+		elapsed   time.Duration
+		renewals  int
+		phase     corev1.PodPhase
+		nodeReady corev1.ConditionStatus
+		noPod     bool
+		wantLease bool
+	}{
+		{name: "fresh renewal, node down", renew: true, elapsed: 6*period - time.Second, renewals: 6, nodeReady: corev1.ConditionUnknown, wantLease: true},
+		{name: "stale renewal, node down", renew: true, elapsed: 6 * period, renewals: 6, nodeReady: corev1.ConditionUnknown, wantLease: false},
+		{name: "stale renewal, node not ready", renew: true, elapsed: 6 * period, renewals: 6, nodeReady: corev1.ConditionFalse, wantLease: false},
+		{name: "stale renewal, node gone", renew: true, elapsed: 6 * period, renewals: 6, wantLease: false},
+		{name: "stale renewal, node ready", renew: true, elapsed: time.Hour, renewals: 6, nodeReady: corev1.ConditionTrue, wantLease: true},
+		{name: "stale renewal, pod not running", renew: true, elapsed: 6 * period, renewals: 6, phase: corev1.PodPending, nodeReady: corev1.ConditionTrue, wantLease: false},
+		{name: "stale renewal, pod gone", renew: true, elapsed: 6 * period, renewals: 6, noPod: true, nodeReady: corev1.ConditionTrue, wantLease: false},
+		{name: "never renewed", elapsed: time.Hour, renewals: 6, nodeReady: corev1.ConditionUnknown, wantLease: true},
+		{name: "check disabled", renew: true, elapsed: time.Hour, renewals: 0, nodeReady: corev1.ConditionUnknown, wantLease: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			// By default the pod still reports Running, as it does after its
+			// kubelet dies.
+			phase := tt.phase
+			if phase == "" {
+				phase = corev1.PodRunning
+			}
+			client := fake.NewSimpleClientset()
+			if !tt.noPod {
+				client = fake.NewSimpleClientset(&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", UID: "uid-worker"},
+					Spec:       corev1.PodSpec{NodeName: "node-a"},
+					Status:     corev1.PodStatus{Phase: phase},
+				})
+			}
+			nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if tt.nodeReady != "" {
+				if err := nodeIndexer.Add(&corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+					Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: tt.nodeReady}}},
+				}); err != nil {
+					t.Fatalf("seed node cache: %v", err)
+				}
+			}
+			coord := client.CoordinationV1()
+			if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-worker", podRef("default", "worker"), 0); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+			if tt.renew {
+				if err := Renew(ctx, coord, DefaultLabelPrefix, "node-a", period, start); err != nil {
+					t.Fatalf("Renew: %v", err)
+				}
+			}
+			pods, _ := podCache(t, client)
+			// A long grace keeps the missing-pod check out of the way.
+			c := &collector{client: client, pods: pods, nodes: corelisters.NewNodeLister(nodeIndexer), grace: time.Hour, staleRenewals: tt.renewals}
+
+			c.run(ctx, start.Add(tt.elapsed))
+			_, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if got := err == nil; got != tt.wantLease {
+				t.Errorf("Expected lease kept=%v, got %v", tt.wantLease, got)
+			}
+		})
+	}
+}
+
+func TestRunGCUnknownPhaseRecovers(t *testing.T) {
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default", UID: "uid-worker"},
+		Status:     corev1.PodStatus{Phase: corev1.PodUnknown},
+	}
+	client := fake.NewSimpleClientset(pod)
+	coord := client.CoordinationV1()
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-worker", podRef("default", "worker"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	pods, indexer := podCache(t, client)
+	c := &collector{client: client, pods: pods, unknownGrace: time.Minute}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	unknownSince := func() (string, bool) {
+		l, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected lease to exist: %v", err)
+		}
+		v, ok := l.Annotations[annoUnknownSince]
+		return v, ok
+	}
+
+	c.run(ctx, start)
+	if v, ok := unknownSince(); !ok || v != start.Format(time.RFC3339) {
+		t.Fatalf("Expected unknown-since %s, got %q", start.Format(time.RFC3339), v)
+	}
+
+	// The node reports again before the grace period ends.
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	_ = indexer.Update(running)
+	c.run(ctx, start.Add(30*time.Second))
+	if v, ok := unknownSince(); ok {
+		t.Errorf("Expected unknown-since to be cleared once the pod is running, got %q", v)
+	}
+}
+
+func TestRunGCRecordsEvents(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "default", UID: "uid-done"},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "uid-new"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	coord := client.CoordinationV1()
+	for i, l := range []struct{ holder, pod string }{
+		{"uid-gone", "gone"},
+		{"uid-done", "done"},
+		{"uid-old", "recreated"},
+	} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", l.holder, podRef("default", l.pod), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	pods, _ := podCache(t, client)
+	recorder := record.NewFakeRecorder(10)
+	(&collector{client: client, pods: pods, recorder: recorder}).run(ctx, time.Now())
+	close(recorder.Events)
+
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, e)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected one event per deleted lease, got %d: %v", len(events), events)
+	}
+	wants := []string{"missing since", "pod is Failed", "now has UID uid-new"}
+	for _, want := range wants {
+		found := false
+		for _, e := range events {
+			if strings.HasPrefix(e, "Normal "+reasonLeaseGC+" ") && strings.Contains(e, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %s event mentioning %q, got %v", reasonLeaseGC, want, events)
+		}
+	}
+}
+
+func TestRunGCAudit(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "done", Namespace: "default", UID: "uid-done",
+			Annotations: map[string]string{util.AnnoRequestedBy: "alice"},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	})
+	coord := client.CoordinationV1()
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-done", podRef("default", "done"), 3); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-gone", podRef("default", "gone"), 5); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	pods, _ := podCache(t, client)
+	var buf bytes.Buffer
+	(&collector{client: client, pods: pods, audit: audit.NewJSONSink(&buf)}).run(ctx, time.Now())
+
+	var got []audit.Record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r audit.Record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decode audit record: %v", err)
+		}
+		got = append(got, r)
+	}
+	slices.SortFunc(got, func(a, b audit.Record) int { return strings.Compare(a.Pod, b.Pod) })
+	want := []audit.Record{
+		{Action: audit.Release, Namespace: "default", Pod: "done", PodUID: "uid-done", Node: "node-a", Devices: []int{3}, User: "alice", Reason: reasonFinished},
+		{Action: audit.Release, Namespace: "default", Pod: "gone", PodUID: "uid-gone", Node: "node-a", Devices: []int{5}, Reason: reasonMissing},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d audit records, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i].Time.IsZero() {
+			t.Errorf("Expected record %d to be timestamped", i)
+		}
+		got[i].Time = time.Time{}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("Expected record %+v, got %+v", want[i], got[i])
+		}
+	}
+}
+
+func TestRunGCDryRun(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "default", UID: "uid-done"},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "uid-new"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	coord := client.CoordinationV1()
+	for i, l := range []struct{ holder, pod string }{
+		{"uid-gone", "gone"},
+		{"uid-done", "done"},
+		{"uid-old", "recreated"},
+	} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", l.holder, podRef("default", l.pod), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	RegisterMetrics()
+	reasons := []string{reasonMissing, reasonFinished, reasonUIDMismatch}
+	before := map[string]float64{}
+	for _, r := range reasons {
+		before[r], _ = testutil.GetCounterMetricValue(wouldDeleteTotal.WithLabelValues(r))
+	}
+
+	pods, _ := podCache(t, client)
+	client.ClearActions()
+	(&collector{client: client, pods: pods, dryRun: true}).run(ctx, time.Now())
+
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("Expected no deletes in dry-run mode, got %s", action.GetResource().Resource)
+		}
+	}
+	leases, _ := coord.Leases("default").List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 3 {
+		t.Errorf("Expected all 3 leases to remain, got %d", len(leases.Items))
+	}
+	for _, r := range reasons {
+		after, err := testutil.GetCounterMetricValue(wouldDeleteTotal.WithLabelValues(r))
+		if err != nil {
+			t.Fatalf("read counter: %v", err)
+		}
+		if after-before[r] != 1 {
+			t.Errorf("Expected one would-delete for %s, got %v", r, after-before[r])
+		}
+	}
+}
+
+// gcTicks reads how many passes the duration histogram has observed.
+func gcTicks(t *testing.T) uint64 {
+	t.Helper()
+	vec, err := testutil.GetHistogramVecFromGatherer(legacyregistry.DefaultGatherer, "gpu_lease_gc_duration_seconds", nil)
+	if err != nil {
+		t.Fatalf("read histogram: %v", err)
+	}
+	return vec.GetAggregatedSampleCount()
+}
+
+func TestRunGCMetrics(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "default", UID: "uid-done"},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "recreated", Namespace: "default", UID: "uid-new"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default", UID: "uid-running"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	coord := client.CoordinationV1()
+	for i, l := range []struct{ holder, pod string }{
+		{"uid-gone", "gone"},
+		{"uid-done", "done"},
+		{"uid-old", "recreated"},
+		{"uid-running", "running"},
+	} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", l.holder, podRef("default", l.pod), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	RegisterMetrics()
+	reasons := []string{reasonMissing, reasonFinished, reasonUIDMismatch, reasonUnknown}
+	before := map[string]float64{}
+	for _, r := range reasons {
+		before[r], _ = testutil.GetCounterMetricValue(deletedTotal.WithLabelValues(r))
+	}
+	ticks := gcTicks(t)
+
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods}).run(ctx, time.Now())
+
+	want := map[string]float64{reasonMissing: 1, reasonFinished: 1, reasonUIDMismatch: 1, reasonUnknown: 0}
+	for _, r := range reasons {
+		after, err := testutil.GetCounterMetricValue(deletedTotal.WithLabelValues(r))
+		if err != nil {
+			t.Fatalf("read counter: %v", err)
+		}
+		if got := after - before[r]; got != want[r] {
+			t.Errorf("Expected %v deletions for %s, got %v", want[r], r, got)
+		}
+	}
+	if n, _ := testutil.GetGaugeMetricValue(leasesTotal); n != 4 {
+		t.Errorf("Expected the gauge to count 4 leases, got %v", n)
+	}
+	if n := gcTicks(t); n != ticks+1 {
+		t.Errorf("Expected one observed tick, got %d", n-ticks)
+	}
+}
+
+func TestRunGCPacesDeletes(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	const orphans = 6
+	for i := 0; i < orphans; i++ {
+		if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-gone", podRef("default", "gone"), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	pods, _ := podCache(t, client)
+
+	// 50 deletes/s with a burst of one: the last of six waits ~100ms.
+	const qps = 50
+	c := &collector{client: client, pods: pods, limiter: rate.NewLimiter(qps, 1)}
+	start := time.Now()
+	c.run(ctx, start)
+	elapsed := time.Since(start)
+
+	leases, _ := client.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 0 {
+		t.Fatalf("Expected all orphans to be deleted, got %d left", len(leases.Items))
+	}
+	if minimum := time.Duration(orphans-1) * time.Second / qps; elapsed < minimum*9/10 {
+		t.Errorf("Expected %d deletes to take at least %v at %d QPS, took %v", orphans, minimum, qps, elapsed)
+	}
+}
+
+func TestRunGCWorkersAcrossNamespaces(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+
+	// In each namespace, even-numbered leases belong to running pods and
+	// odd-numbered ones to pods that are gone. Each namespace's pods run on
+	// their own node, so no two leases claim the same device.
+	const namespaces, perNamespace = 8, 10
+	for n := 0; n < namespaces; n++ {
+		ns, node := fmt.Sprintf("team-%d", n), fmt.Sprintf("node-%d", n)
+		for i := 0; i < perNamespace; i++ {
+			pod := fmt.Sprintf("worker-%d", i)
+			uid := types.UID(ns + "-" + pod)
+			if i%2 == 0 {
+				_, _ = client.CoreV1().Pods(ns).Create(ctx, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: pod, Namespace: ns, UID: uid},
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+				}, metav1.CreateOptions{})
+			}
+			if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, ns, node, string(uid), podRef(ns, pod), i); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+		}
+	}
+
+	pods, _ := podCache(t, client)
+	recorder := record.NewFakeRecorder(namespaces * perNamespace)
+	c := &collector{client: client, pods: pods, recorder: recorder, workers: 4}
+	c.run(ctx, time.Now())
+
+	leases, _ := coord.Leases("").List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != namespaces*perNamespace/2 {
+		t.Errorf("Expected %d leases to remain, got %d", namespaces*perNamespace/2, len(leases.Items))
 	}
+	for _, l := range leases.Items {
+		if id, _ := deviceID(l); id%2 != 0 {
+			t.Errorf("Expected orphaned lease %s/%s to be deleted", l.Namespace, l.Name)
+		}
+	}
+	if n := len(recorder.Events); n != namespaces*perNamespace/2 {
+		t.Errorf("Expected one event per deletion, got %d", n)
+	}
+}
+
+func TestRunGCLabelPrefix(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	const staging = "staging.gpu.scheduling"
+	// Each instance leases device 0 of the same node for itself.
+	for _, prefix := range []string{DefaultLabelPrefix, staging} {
+		if _, err := TryAcquire(ctx, coord, prefix, "kube-system", "node-a", "uid-"+prefix, podRef("default", "gone-"+prefix), 0); err != nil {
+			t.Fatalf("TryAcquire under %s: %v", prefix, err)
+		}
+	}
+	if name := LeaseName(staging, "node-a", 0); name != "staging.gpu.scheduling.gpu-node-a-0" {
+		t.Errorf("Expected the instance prefix in the lease name, got %s", name)
+	}
+	for _, prefix := range []string{DefaultLabelPrefix, staging} {
+		held, err := ListNode(ctx, coord, prefix, "node-a")
+		if err != nil {
+			t.Fatalf("ListNode: %v", err)
+		}
+		if len(held) != 1 || held[0].Name != LeaseName(prefix, "node-a", 0) {
+			t.Errorf("Expected ListNode under %s to see only its own lease, got %v", prefix, held)
+		}
+	}
+
+	// Both pods are gone, but only the staging lease is the collector's to
+	// delete.
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods, prefix: staging}).run(ctx, time.Now())
+	if _, err := coord.Leases("kube-system").Get(ctx, LeaseName(staging, "node-a", 0), metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the staging lease to be collected")
+	}
+	if _, err := coord.Leases("kube-system").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the default instance's lease to be left alone: %v", err)
+	}
+
+	if err := ValidateLabelPrefix("not a prefix"); err == nil {
+		t.Errorf("Expected an invalid prefix to be rejected")
+	}
+}
+
+func TestRunGCNamespaces(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	namespaces := []string{"team-a", "team-b", "team-c", "other"}
+	for i, ns := range namespaces {
+		// Each pod is gone; distinct devices keep the leases from conflicting.
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, ns, "node-a", "uid-"+ns, podRef(ns, "gone"), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	// Like RBAC would, refuse cluster-wide lease lists and lists in team-c.
+	client.PrependReactor("list", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if ns := action.GetNamespace(); ns == metav1.NamespaceAll || ns == "team-c" {
+			return true, nil, apierrors.NewForbidden(coordv1.Resource("leases"), "", fmt.Errorf("namespace %q", ns))
+		}
+		return false, nil, nil
+	})
+
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods, namespaces: []string{"team-a", "team-b", "team-c"}}).run(ctx, time.Now())
+
+	// team-c cannot be listed, but team-a and team-b are collected anyway;
+	// other is not configured at all.
+	want := map[string]bool{"team-a": false, "team-b": false, "team-c": true, "other": true}
+	for i, ns := range namespaces {
+		_, err := coord.Leases(ns).Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", i), metav1.GetOptions{})
+		if kept := err == nil; kept != want[ns] {
+			t.Errorf("%s: Expected lease kept=%v, got %v (%v)", ns, want[ns], kept, err)
+		}
+	}
+}
+
+func TestRunGCNamespacesInLeaseNamespace(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	// Every lease lives in kube-system, as the scheduler makes them; the
+	// pods are gone.
+	podNamespaces := []string{"team-a", "team-b", "other"}
+	for i, ns := range podNamespaces {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "kube-system", "node-a", "uid-"+ns, podRef(ns, "gone"), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	// Only the configured namespaces and the lease namespace may be listed.
+	client.PrependReactor("list", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if ns := action.GetNamespace(); !slices.Contains([]string{"team-a", "team-b", "kube-system"}, ns) {
+			return true, nil, apierrors.NewForbidden(coordv1.Resource("leases"), "", fmt.Errorf("namespace %q", ns))
+		}
+		return false, nil, nil
+	})
+
+	pods, _ := podCache(t, client)
+	(&collector{client: client, pods: pods, namespaces: []string{"team-a", "team-b"}, leaseNamespace: "kube-system"}).run(ctx, time.Now())
+
+	// The leases of pods in team-a and team-b are collected; other's lease
+	// is not this scheduler's to collect.
+	want := map[string]bool{"team-a": false, "team-b": false, "other": true}
+	for i, ns := range podNamespaces {
+		_, err := coord.Leases("kube-system").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", i), metav1.GetOptions{})
+		if kept := err == nil; kept != want[ns] {
+			t.Errorf("%s: Expected lease kept=%v, got %v (%v)", ns, want[ns], kept, err)
+		}
+	}
+}
 
-	if leases.Items[0].Name != "lease-running-pod" {
-		t.Errorf("Expected lease-running-pod to remain, got %s", leases.Items[0].Name)
+func TestJitterBand(t *testing.T) {
+	const interval = 30 * time.Second
+	for _, jitter := range []float64{0, 0.1, 0.5} {
+		lo := time.Duration(float64(interval) * (1 - jitter))
+		hi := time.Duration(float64(interval) * (1 + jitter))
+		seen := map[time.Duration]bool{}
+		for range 200 {
+			d := jittered(jitterBand(interval, jitter))
+			if d < lo || d > hi {
+				t.Errorf("Expected waits with jitter %v within [%v, %v], got %v", jitter, lo, hi, d)
+			}
+			seen[d] = true
+		}
+		if jitter == 0 && len(seen) != 1 {
+			t.Errorf("Expected every wait to be %v without jitter, got %d distinct waits", interval, len(seen))
+		}
+		if jitter > 0 && len(seen) < 2 {
+			t.Errorf("Expected successive waits with jitter %v to vary, got only %v", jitter, seen)
+		}
 	}
 }
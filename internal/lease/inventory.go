@@ -0,0 +1,308 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	coordinformers "k8s.io/client-go/informers/coordination/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Inventory holds the managed leases indexed by node, so the scheduler can
+// read a node's GPU usage without listing leases on every Filter and Score
+// call. A lease informer keeps it current. Writes made through Track are
+// applied at once, so leases acquired in Reserve count before the informer
+// reports them.
+type Inventory struct {
+	mu     sync.RWMutex
+	nodes  map[string]map[types.NamespacedName]*coordv1.Lease
+	synced cache.InformerSynced
+	// devices looks up the ids of a node's GPUs for the fragmentation gauge;
+	// nil leaves the gauge alone.
+	devices func(node string) ([]int, bool)
+	// adopted is set once Adopt has loaded the leases. pending holds the
+	// adopted leases neither the informer nor Track has reported since.
+	adopted bool
+	pending map[types.NamespacedName]bool
+	// freed holds, per node, when each device last had a lease removed.
+	// It only covers what this process has seen.
+	freed map[string]map[int]time.Time
+}
+
+// NewInformer returns an informer over the leases of the instance with the
+// given label prefix in namespace, or in all namespaces when it is
+// metav1.NamespaceAll. The caller runs it.
+func NewInformer(client clientset.Interface, resync time.Duration, prefix, namespace string) cache.SharedIndexInformer {
+	return coordinformers.NewFilteredLeaseInformer(client, namespace, resync, cache.Indexers{}, func(opts *metav1.ListOptions) {
+		opts.LabelSelector = managedSelector(prefix)
+	})
+}
+
+// NewInventory builds an inventory fed by informers, which should come from
+// NewInformer, one per namespace when the leases are watched namespace by
+// namespace.
+func NewInventory(informers ...cache.SharedIndexInformer) (*Inventory, error) {
+	inv := &Inventory{nodes: map[string]map[types.NamespacedName]*coordv1.Lease{}}
+	var synced []cache.InformerSynced
+	for _, informer := range informers {
+		reg, err := informer.AddEventHandler(inv.handler())
+		if err != nil {
+			return nil, fmt.Errorf("add lease event handler: %w", err)
+		}
+		synced = append(synced, reg.HasSynced)
+	}
+	inv.synced = func() bool {
+		for _, s := range synced {
+			if !s() {
+				return false
+			}
+		}
+		return len(synced) > 0
+	}
+	return inv, nil
+}
+
+// handler applies the informer's lease events to inv.
+func (inv *Inventory) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if l, ok := obj.(*coordv1.Lease); ok {
+				inv.add(l)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok1 := oldObj.(*coordv1.Lease)
+			l, ok2 := newObj.(*coordv1.Lease)
+			if !ok1 || !ok2 {
+				return
+			}
+			if NodeOf(old) != NodeOf(l) {
+				inv.remove(old)
+			}
+			inv.add(l)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if l, ok := obj.(*coordv1.Lease); ok {
+				inv.remove(l)
+			}
+		},
+	}
+}
+
+// HasSynced reports whether the inventory has seen every lease the informers
+// listed at start.
+func (inv *Inventory) HasSynced() bool {
+	return inv.synced != nil && inv.synced()
+}
+
+// Ready reports whether the inventory holds every lease, through Adopt or
+// the informer, so the scheduler can place GPU pods by it.
+func (inv *Inventory) Ready() bool {
+	inv.mu.RLock()
+	adopted := inv.adopted
+	inv.mu.RUnlock()
+	return adopted || inv.HasSynced()
+}
+
+// Adopt lists the leases of the instance with the given label prefix from
+// the API server and records them, so that after a restart the leases of
+// running pods count before the informer has synced. Leases the informer or
+// Track already reported are kept as they are. Once the informer has synced, the adopted leases it did not
+// report were deleted in between and are dropped; ctx bounds that wait. It
+// returns how many leases were adopted.
+func (inv *Inventory) Adopt(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix string) (int, error) {
+	list, err := cli.Leases(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: managedSelector(prefix)})
+	if err != nil {
+		return 0, fmt.Errorf("list leases: %w", err)
+	}
+	adopted := 0
+	inv.mu.Lock()
+	inv.pending = map[types.NamespacedName]bool{}
+	for i := range list.Items {
+		l := &list.Items[i]
+		key := types.NamespacedName{Namespace: l.Namespace, Name: l.Name}
+		if _, known := inv.nodes[NodeOf(l)][key]; known {
+			continue
+		}
+		if inv.recordLocked(l) {
+			inv.pending[key] = true
+			adopted++
+		}
+	}
+	inv.adopted = true
+	inv.mu.Unlock()
+
+	if inv.synced != nil {
+		go func() {
+			if cache.WaitForCacheSync(ctx.Done(), inv.synced) {
+				inv.prune()
+			}
+		}()
+	}
+	return adopted, nil
+}
+
+// prune drops the adopted leases the informer never reported.
+func (inv *Inventory) prune() {
+	inv.mu.Lock()
+	gone := inv.pending
+	inv.pending = nil
+	inv.mu.Unlock()
+	for key := range gone {
+		inv.forget(key.Namespace, key.Name)
+	}
+}
+
+// Node returns the managed leases held on node, like ListNode.
+func (inv *Inventory) Node(node string) []coordv1.Lease {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	held := make([]coordv1.Lease, 0, len(inv.nodes[node]))
+	for _, l := range inv.nodes[node] {
+		held = append(held, *l)
+	}
+	return held
+}
+
+// add records l under its node. A lease is keyed by namespace and name, so
+// the informer reporting a lease already recorded by Track replaces it
+// rather than counting it twice.
+func (inv *Inventory) add(l *coordv1.Lease) {
+	inv.mu.Lock()
+	recorded := inv.recordLocked(l)
+	delete(inv.pending, types.NamespacedName{Namespace: l.Namespace, Name: l.Name})
+	inv.mu.Unlock()
+	if recorded {
+		inv.observe(NodeOf(l))
+	}
+}
+
+// recordLocked records l under its node and reports whether it has one.
+func (inv *Inventory) recordLocked(l *coordv1.Lease) bool {
+	node := NodeOf(l)
+	if node == "" {
+		return false
+	}
+	held := inv.nodes[node]
+	if held == nil {
+		held = map[types.NamespacedName]*coordv1.Lease{}
+		inv.nodes[node] = held
+	}
+	held[types.NamespacedName{Namespace: l.Namespace, Name: l.Name}] = l
+	return true
+}
+
+// remove drops l from its node. A late delete event for an earlier lease of
+// the same name leaves a newer one with a different UID in place.
+func (inv *Inventory) remove(l *coordv1.Lease) {
+	node := NodeOf(l)
+	inv.mu.Lock()
+	removed := inv.forgetLocked(node, types.NamespacedName{Namespace: l.Namespace, Name: l.Name}, l.UID)
+	inv.mu.Unlock()
+	if removed {
+		inv.observe(node)
+	}
+}
+
+// forget drops the lease ns/name from whichever node holds it.
+func (inv *Inventory) forget(ns, name string) {
+	var changed []string
+	inv.mu.Lock()
+	key := types.NamespacedName{Namespace: ns, Name: name}
+	for node := range inv.nodes {
+		if inv.forgetLocked(node, key, "") {
+			changed = append(changed, node)
+		}
+	}
+	inv.mu.Unlock()
+	for _, node := range changed {
+		inv.observe(node)
+	}
+}
+
+// forgetLocked drops key from node unless the recorded lease has a UID other
+// than uid, and reports whether it did.
+func (inv *Inventory) forgetLocked(node string, key types.NamespacedName, uid types.UID) bool {
+	held := inv.nodes[node]
+	cur, ok := held[key]
+	if !ok {
+		return false
+	}
+	if uid != "" && cur.UID != "" && cur.UID != uid {
+		return false
+	}
+	delete(held, key)
+	if len(held) == 0 {
+		delete(inv.nodes, node)
+	}
+	if ids := deviceIDs(*cur); len(ids) > 0 {
+		if inv.freed == nil {
+			inv.freed = map[string]map[int]time.Time{}
+		}
+		if inv.freed[node] == nil {
+			inv.freed[node] = map[int]time.Time{}
+		}
+		now := time.Now()
+		for _, id := range ids {
+			inv.freed[node][id] = now
+		}
+	}
+	return true
+}
+
+// FreedAt returns when each device of node last had a lease removed, for
+// the devices that did since the inventory started.
+func (inv *Inventory) FreedAt(node string) map[int]time.Time {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return maps.Clone(inv.freed[node])
+}
+
+// Track wraps cli so the leases it creates and deletes are applied to the
+// inventory as soon as the API server accepts the write.
+func (inv *Inventory) Track(cli coordclient.CoordinationV1Interface) coordclient.CoordinationV1Interface {
+	return &trackedClient{CoordinationV1Interface: cli, inv: inv}
+}
+
+type trackedClient struct {
+	coordclient.CoordinationV1Interface
+	inv *Inventory
+}
+
+func (c *trackedClient) Leases(ns string) coordclient.LeaseInterface {
+	return &trackedLeases{LeaseInterface: c.CoordinationV1Interface.Leases(ns), inv: c.inv, ns: ns}
+}
+
+type trackedLeases struct {
+	coordclient.LeaseInterface
+	inv *Inventory
+	ns  string
+}
+
+func (t *trackedLeases) Create(ctx context.Context, l *coordv1.Lease, opts metav1.CreateOptions) (*coordv1.Lease, error) {
+	out, err := t.LeaseInterface.Create(ctx, l, opts)
+	if err == nil && len(opts.DryRun) == 0 && out.Labels[labelManaged] != "" {
+		t.inv.add(out.DeepCopy())
+	}
+	return out, err
+}
+
+func (t *trackedLeases) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	err := t.LeaseInterface.Delete(ctx, name, opts)
+	if len(opts.DryRun) == 0 && (err == nil || apierrors.IsNotFound(err)) {
+		t.inv.forget(t.ns, name)
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func startInventory(t *testing.T, ctx context.Context, client *fake.Clientset) *Inventory {
+	t.Helper()
+	informer := NewInformer(client, 0, DefaultLabelPrefix, metav1.NamespaceAll)
+	inv, err := NewInventory(informer)
+	if err != nil {
+		t.Fatalf("NewInventory: %v", err)
+	}
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), inv.HasSynced) {
+		t.Fatalf("inventory did not sync")
+	}
+	return inv
+}
+
+func waitForLeases(t *testing.T, inv *Inventory, node string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(inv.Node(node)) == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d leases on %s, got %d", want, node, len(inv.Node(node)))
+}
+
+func TestInventoryFollowsInformer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-early", podRef("default", "early"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	inv := startInventory(t, ctx, client)
+	if got := len(inv.Node("node-a")); got != 1 {
+		t.Errorf("Expected the lease listed at start, got %d leases", got)
+	}
+
+	// Writes that bypass Track arrive through the informer.
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "team-b", "node-a", "uid-late", podRef("team-b", "late"), 1); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if err := AcquireFraction(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-b", "uid-share", podRef("default", "share"), "", 0, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	waitForLeases(t, inv, "node-a", 2)
+	waitForLeases(t, inv, "node-b", 1)
+
+	if err := Release(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", 0); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	waitForLeases(t, inv, "node-a", 1)
+	if got := DeviceUsage(inv.Node("node-a")); got[1] != 1 || len(got) != 1 {
+		t.Errorf("Expected only device 1 in use, got %v", got)
+	}
+}
+
+func TestInventoryTrackAppliesWritesAtOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	inv := startInventory(t, ctx, client)
+	cli := inv.Track(client.CoordinationV1())
+
+	if _, err := TryAcquire(ctx, cli, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 3); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if got := HeldDevices(inv.Node("node-a")); !got[3] {
+		t.Errorf("Expected device 3 to be held right after acquiring, got %v", got)
+	}
+	if err := Release(ctx, cli, DefaultLabelPrefix, "default", "node-a", 3); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	// The informer may still deliver the create; it must settle on none.
+	waitForLeases(t, inv, "node-a", 0)
+}
+
+func TestInventoryFreedAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	inv := startInventory(t, ctx, client)
+	cli := inv.Track(client.CoordinationV1())
+
+	for _, id := range []int{0, 1} {
+		if _, err := TryAcquire(ctx, cli, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if freed := inv.FreedAt("node-a"); len(freed) != 0 {
+		t.Errorf("Expected no device freed yet, got %v", freed)
+	}
+	before := time.Now()
+	if err := Release(ctx, cli, DefaultLabelPrefix, "default", "node-a", 1); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	freed := inv.FreedAt("node-a")
+	if _, ok := freed[0]; ok || len(freed) != 1 || freed[1].Before(before) {
+		t.Errorf("Expected only device 1 freed after %v, got %v", before, freed)
+	}
+}
+
+func TestInventoryConcurrentTrackAndInformer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	inv := startInventory(t, ctx, client)
+	cli := inv.Track(client.CoordinationV1())
+
+	const devices = 16
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if held := inv.Node("node-a"); len(held) > devices {
+				t.Errorf("Expected at most %d leases, got %d", devices, len(held))
+				return
+			}
+		}
+	}()
+	for id := 0; id < devices; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			pod := fmt.Sprintf("pod-%d", id)
+			if _, err := TryAcquire(ctx, cli, DefaultLabelPrefix, "default", "node-a", "uid-"+pod, podRef("default", pod), id); err != nil {
+				t.Errorf("TryAcquire %d: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if got := len(inv.Node("node-a")); got != devices {
+		t.Errorf("Expected all %d tracked leases before the informer catches up, got %d", devices, got)
+	}
+	waitForLeases(t, inv, "node-a", devices)
+
+	for id := 0; id < devices; id += 2 {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := Release(ctx, cli, DefaultLabelPrefix, "default", "node-a", id); err != nil {
+				t.Errorf("Release %d: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+	waitForLeases(t, inv, "node-a", devices/2)
+	close(stop)
+	readers.Wait()
+
+	for id, held := range HeldDevices(inv.Node("node-a")) {
+		if held && id%2 == 0 {
+			t.Errorf("Expected released device %d to be free", id)
+		}
+	}
+}
+
+func TestInventoryAdopt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	for i, pod := range []string{"a", "b", "c"} {
+		if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-"+pod, podRef("default", pod), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	informer := NewInformer(client, 0, DefaultLabelPrefix, metav1.NamespaceAll)
+	inv, err := NewInventory(informer)
+	if err != nil {
+		t.Fatalf("NewInventory: %v", err)
+	}
+	if inv.Ready() {
+		t.Fatalf("Expected the inventory not to be ready before adoption")
+	}
+
+	n, err := inv.Adopt(ctx, client.CoordinationV1(), DefaultLabelPrefix)
+	if err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if n != 3 || !inv.Ready() {
+		t.Errorf("Expected 3 leases adopted and the inventory ready, got %d, ready=%v", n, inv.Ready())
+	}
+	if got := HeldDevices(inv.Node("node-a")); len(got) != 3 {
+		t.Errorf("Expected devices 0-2 held before the informer runs, got %v", got)
+	}
+
+	// A lease deleted before the informer lists is dropped once it syncs.
+	if err := Release(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", 1); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	go informer.Run(ctx.Done())
+	waitForLeases(t, inv, "node-a", 2)
+	if got := HeldDevices(inv.Node("node-a")); got[1] {
+		t.Errorf("Expected device 1 to be free, got %v", got)
+	}
+}
+
+func TestInventoryAdoptAfterSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	if _, err := TryAcquire(ctx, client.CoordinationV1(), DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	inv := startInventory(t, ctx, client)
+	n, err := inv.Adopt(ctx, client.CoordinationV1(), DefaultLabelPrefix)
+	if err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if n != 0 || len(inv.Node("node-a")) != 1 {
+		t.Errorf("Expected the informer's lease kept and none adopted, got %d adopted, %d leases", n, len(inv.Node("node-a")))
+	}
+}
+
+func TestInventoryIgnoresStaleDelete(t *testing.T) {
+	inv := &Inventory{nodes: map[string]map[types.NamespacedName]*coordv1.Lease{}}
+	lease := func(uid string) *coordv1.Lease {
+		l := newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "node-a", 0), "default", "node-a", "holder", podRef("default", "pod"), 0)
+		l.UID = types.UID(uid)
+		return l
+	}
+	inv.add(lease("new"))
+	inv.remove(lease("old"))
+	if got := len(inv.Node("node-a")); got != 1 {
+		t.Errorf("Expected a delete for an earlier lease to keep the current one, got %d leases", got)
+	}
+	inv.remove(lease("new"))
+	if got := len(inv.Node("node-a")); got != 0 {
+		t.Errorf("Expected the lease to be removed, got %d leases", got)
+	}
+}
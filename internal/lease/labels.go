@@ -0,0 +1,105 @@
+package lease
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultLabelPrefix is the label prefix of the default scheduler instance.
+// Its leases keep the names and labels they had before prefixes existed.
+const DefaultLabelPrefix = "gpu.scheduling"
+
+const (
+	// labelManaged marks a lease as the scheduler's. Its value is the
+	// instance's managedValue, so instances sharing a cluster neither count
+	// nor collect each other's leases.
+	labelManaged = "gpu.scheduling/managed"
+	labelPod     = "gpu.scheduling/pod"
+	// labelPodNamespace is the namespace of the pod named by labelPod. Leases
+	// from before it was added live in their pod's namespace instead.
+	labelPodNamespace = "gpu.scheduling/pod-namespace"
+	labelNode         = "gpu.scheduling/node"
+	labelDevice       = "gpu.scheduling/device"
+	labelMIG          = "gpu.scheduling/mig-profile"
+	// labelAntiAffinity carries the holder's device anti-affinity group.
+	labelAntiAffinity = "gpu.scheduling/device-anti-affinity"
+	// labelOwnedBy marks leases the scheduler created itself. The collector
+	// only touches leases carrying it, or held by a pod from before it was
+	// added, so leases other tooling labels as managed are left alone.
+	labelOwnedBy = "gpu.scheduling/owned-by"
+)
+
+// annoNode holds the name of the node a lease was taken for when it is too
+// long for labelNode, which then carries nodeLabelValue.
+const annoNode = "gpu.scheduling/node"
+
+// nodeLabelValue is the labelNode value of the leases on node: its name, or,
+// past the 63 characters a label value may have, the start of the name and a
+// hash of the whole. Node names are DNS subdomains, so the name itself is a
+// valid value whenever it is short enough.
+func nodeLabelValue(node string) string {
+	if len(node) <= validation.LabelValueMaxLength {
+		return node
+	}
+	sum := sha256.Sum256([]byte(node))
+	hash := hex.EncodeToString(sum[:8])
+	return node[:validation.LabelValueMaxLength-len(hash)-1] + "-" + hash
+}
+
+// setNode labels l with node, and annotates it with the name when the label
+// cannot hold it.
+func setNode(l *coordv1.Lease, node string) {
+	value := nodeLabelValue(node)
+	l.Labels[labelNode] = value
+	if value != node {
+		l.Annotations[annoNode] = node
+	}
+}
+
+// ValidateLabelPrefix checks that prefix can name a scheduler instance: it
+// goes in front of the instance's lease names and is the value of their
+// managed label. An empty prefix means DefaultLabelPrefix.
+func ValidateLabelPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	errs := validation.IsDNS1123Subdomain(prefix)
+	errs = append(errs, validation.IsValidLabelValue(prefix)...)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid label prefix %q: %s", prefix, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func defaultPrefix(prefix string) bool {
+	return prefix == "" || prefix == DefaultLabelPrefix
+}
+
+// managedValue is the labelManaged value of the leases of the instance with
+// the given prefix.
+func managedValue(prefix string) string {
+	if defaultPrefix(prefix) {
+		return "true"
+	}
+	return prefix
+}
+
+// leaseName puts prefix in front of name, a lease name of the default
+// instance.
+func leaseName(prefix, name string) string {
+	if defaultPrefix(prefix) {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// managedSelector selects the leases of the instance with the given prefix,
+// narrowed by the selector terms in more.
+func managedSelector(prefix string, more ...string) string {
+	return strings.Join(append([]string{labelManaged + "=" + managedValue(prefix)}, more...), ",")
+}
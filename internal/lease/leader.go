@@ -0,0 +1,126 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultElectionLeaseName and DefaultElectionLeaseNamespace locate the
+	// lease the GC replicas elect a leader with.
+	DefaultElectionLeaseName      = "gpu-scheduler-gc"
+	DefaultElectionLeaseNamespace = "kube-system"
+	// DefaultLeaseDuration, DefaultRenewDeadline and DefaultRetryPeriod match
+	// the kube-scheduler's own leader election.
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElection configures the election that gates the GC loop.
+type LeaderElection struct {
+	// LeaseName and LeaseNamespace locate the election lease. Empty values
+	// use DefaultElectionLeaseName and DefaultElectionLeaseNamespace.
+	LeaseName      string
+	LeaseNamespace string
+	// Identity names this replica in the lease. Empty uses the hostname with
+	// a random suffix.
+	Identity string
+	// LeaseDuration, RenewDeadline and RetryPeriod have the meaning of the
+	// client-go leaderelection settings. Non-positive values use the defaults.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// config fills in defaults and builds the client-go election config; run is
+// called with a context that is cancelled when leadership is lost.
+func (le LeaderElection) config(client clientset.Interface, run func(context.Context)) (leaderelection.LeaderElectionConfig, error) {
+	if le.LeaseName == "" {
+		le.LeaseName = DefaultElectionLeaseName
+	}
+	if le.LeaseNamespace == "" {
+		le.LeaseNamespace = DefaultElectionLeaseNamespace
+	}
+	if le.Identity == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return leaderelection.LeaderElectionConfig{}, fmt.Errorf("leader election identity: %w", err)
+		}
+		le.Identity = host + "_" + string(uuid.NewUUID())
+	}
+	if le.LeaseDuration <= 0 {
+		le.LeaseDuration = DefaultLeaseDuration
+	}
+	if le.RenewDeadline <= 0 {
+		le.RenewDeadline = DefaultRenewDeadline
+	}
+	if le.RetryPeriod <= 0 {
+		le.RetryPeriod = DefaultRetryPeriod
+	}
+
+	return leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: le.LeaseName, Namespace: le.LeaseNamespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: le.Identity},
+		},
+		LeaseDuration: le.LeaseDuration,
+		RenewDeadline: le.RenewDeadline,
+		RetryPeriod:   le.RetryPeriod,
+		// Hand the lease over at once on shutdown instead of after it expires.
+		ReleaseOnCancel: true,
+		Name:            le.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.InfoS("GC: became leader, starting collection", "identity", le.Identity)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("GC: stopped leading, pausing collection", "identity", le.Identity)
+			},
+		},
+	}, nil
+}
+
+// runElected campaigns for the election lease in the background and runs run
+// while this replica leads. After losing the lease it campaigns again, so a
+// replica that was demoted can take over later; it stops when ctx is done.
+func runElected(ctx context.Context, client clientset.Interface, le LeaderElection, run func(context.Context)) error {
+	// The elector does not wait for run to return after leadership is lost;
+	// keep a quick re-election from overlapping a pass still in flight.
+	var mu sync.Mutex
+	cfg, err := le.config(client, func(ctx context.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+		run(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	// Validate once up front; each campaign needs a fresh elector.
+	if _, err := leaderelection.NewLeaderElector(cfg); err != nil {
+		return fmt.Errorf("leader election: %w", err)
+	}
+	go func() {
+		for ctx.Err() == nil {
+			elector, err := leaderelection.NewLeaderElector(cfg)
+			if err != nil {
+				klog.ErrorS(err, "GC: failed to create leader elector")
+				return
+			}
+			elector.Run(ctx)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,152 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// startCandidate starts a GC replica that records its deletions on its own
+// recorder, so the test can tell which replica collected a lease. lead, if
+// set, runs while the replica leads.
+func startCandidate(t *testing.T, ctx context.Context, client *fake.Clientset, identity string, lead func(context.Context)) *record.FakeRecorder {
+	t.Helper()
+	recorder := record.NewFakeRecorder(100)
+	err := StartGCWithOptions(ctx, client, GCOptions{
+		Interval:     10 * time.Millisecond,
+		Recorder:     recorder,
+		WhileLeading: lead,
+		LeaderElection: &LeaderElection{
+			Identity:      identity,
+			LeaseDuration: 600 * time.Millisecond,
+			RenewDeadline: 400 * time.Millisecond,
+			RetryPeriod:   100 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("start %s: %v", identity, err)
+	}
+	return recorder
+}
+
+// collectOrphan creates a lease for a missing pod and waits for a replica to
+// delete it.
+func collectOrphan(t *testing.T, client *fake.Clientset, name string) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := client.CoordinationV1().Leases("default").Create(ctx, &coordv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{labelManaged: "true", labelOwnedBy: ownerName, labelPod: name},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := client.CoordinationV1().Leases("default").Get(ctx, name, metav1.GetOptions{}); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected lease %s to be collected", name)
+}
+
+func electionHolder(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+	l, err := client.CoordinationV1().Leases(DefaultElectionLeaseNamespace).Get(context.Background(), DefaultElectionLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get election lease: %v", err)
+	}
+	if l.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *l.Spec.HolderIdentity
+}
+
+func TestLeaderElectionGatesGC(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	recA := startCandidate(t, ctxA, client, "replica-a", nil)
+	collectOrphan(t, client, "orphan-0")
+	if holder := electionHolder(t, client); holder != "replica-a" {
+		t.Fatalf("Expected replica-a to lead, got %q", holder)
+	}
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	recB := startCandidate(t, ctxB, client, "replica-b", nil)
+	for i := 1; i <= 3; i++ {
+		collectOrphan(t, client, fmt.Sprintf("orphan-%d", i))
+	}
+	if len(recB.Events) != 0 {
+		t.Errorf("Expected the follower not to collect, got %d events", len(recB.Events))
+	}
+	if len(recA.Events) != 4 {
+		t.Errorf("Expected the leader to collect every lease, got %d events", len(recA.Events))
+	}
+
+	// Stopping the leader releases the election lease; the follower takes over.
+	cancelA()
+	collectOrphan(t, client, "orphan-4")
+	if holder := electionHolder(t, client); holder != "replica-b" {
+		t.Errorf("Expected replica-b to lead after replica-a stopped, got %q", holder)
+	}
+	if len(recB.Events) != 1 {
+		t.Errorf("Expected the new leader to collect, got %d events", len(recB.Events))
+	}
+	if len(recA.Events) != 4 {
+		t.Errorf("Expected the old leader to stop collecting, got %d events", len(recA.Events))
+	}
+}
+
+func TestWhileLeadingFollowsLeadership(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var leading sync.Map
+	lead := func(identity string) func(context.Context) {
+		return func(ctx context.Context) {
+			leading.Store(identity, true)
+			<-ctx.Done()
+			leading.Delete(identity)
+		}
+	}
+	waitLeading := func(identity string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, ok := leading.Load(identity); ok == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected %s leading=%v", identity, want)
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	startCandidate(t, ctxA, client, "replica-a", lead("replica-a"))
+	waitLeading("replica-a", true)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	startCandidate(t, ctxB, client, "replica-b", lead("replica-b"))
+	collectOrphan(t, client, "orphan-0")
+	if _, ok := leading.Load("replica-b"); ok {
+		t.Fatalf("Expected the follower not to run its hook")
+	}
+
+	cancelA()
+	waitLeading("replica-a", false)
+	waitLeading("replica-b", true)
+}
@@ -2,48 +2,606 @@ package lease
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	coordv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/restack/gpu-scheduler/internal/util"
 )
 
-// LeaseName deterministically maps a node and GPU id to the lease resource identifier.
-func LeaseName(node string, id int) string {
-	return fmt.Sprintf("gpu-%s-%d", node, id)
+// DefaultNamespace is where the scheduler keeps its leases unless told
+// otherwise. Every lease lives in one namespace, whatever its pod's, so that
+// a device's lease name is unique cluster-wide and creating the lease is
+// what makes the device exclusive.
+const DefaultNamespace = "kube-system"
+
+// LeaseName deterministically maps a node and GPU id to the lease resource
+// identifier of the scheduler instance with the given label prefix.
+func LeaseName(prefix, node string, id int) string {
+	return leaseName(prefix, fmt.Sprintf("gpu-%s-%d", node, id))
+}
+
+// FractionLeaseName names one holder's share of a time-sliced GPU. Unlike
+// LeaseName it is unique per holder, so several shares of a device coexist.
+func FractionLeaseName(prefix, node string, id int, holder string) string {
+	return leaseName(prefix, fmt.Sprintf("gpu-%s-%d-%s", node, id, holder))
 }
 
-// TryAcquire attempts to create a lease per GPU id. Success indicates this pod owns the GPU.
+// TryAcquire attempts to create a lease per GPU id in namespace ns, the
+// scheduler's lease namespace, for pod, labelled for the instance with the
+// given label prefix. Success indicates this pod owns the GPU.
 func TryAcquire(
 	ctx context.Context,
 	cli coordclient.CoordinationV1Interface,
-	ns, node, holder, podName string,
+	prefix, ns, node, holder string,
+	pod types.NamespacedName,
+	id int,
+) (bool, error) {
+	lease := newLease(prefix, LeaseName(prefix, node, id), ns, node, holder, pod, id)
+	if err := create(ctx, cli, lease); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AcquireFraction records that holder uses fraction of GPU id and, when
+// memory is positive, reserves that many bytes of its memory. A non-empty
+// antiAffinity tags the lease with the holder's anti-affinity group. Callers
+// consult DeviceUsage, DeviceMemory and AntiAffine first. Since another
+// scheduling cycle may have picked the same device meanwhile, the device's
+// leases are listed again once the share's is created, and it is deleted
+// again with an error matching ErrInsufficientGPUs if together they take
+// more than the whole device.
+func AcquireFraction(
+	ctx context.Context,
+	cli coordclient.CoordinationV1Interface,
+	prefix, ns, node, holder string,
+	pod types.NamespacedName,
+	antiAffinity string,
+	id int,
+	fraction float64,
+	memory int64,
+) error {
+	return AcquireFractionWithLimit(ctx, cli, prefix, ns, node, holder, pod, antiAffinity, id, fraction, 0, memory)
+}
+
+// AcquireFractionWithLimit is AcquireFraction for a share that may burst up
+// to limit of the device. The limit is only recorded, for the runtime to
+// enforce; the device's usage counts fraction. A non-positive limit records
+// none.
+func AcquireFractionWithLimit(
+	ctx context.Context,
+	cli coordclient.CoordinationV1Interface,
+	prefix, ns, node, holder string,
+	pod types.NamespacedName,
+	antiAffinity string,
 	id int,
+	fraction, limit float64,
+	memory int64,
+) error {
+	lease := newLease(prefix, FractionLeaseName(prefix, node, id, holder), ns, node, holder, pod, id)
+	setAntiAffinity(lease, antiAffinity)
+	lease.Annotations[annoFraction] = strconv.FormatFloat(fraction, 'f', -1, 64)
+	if limit > 0 {
+		lease.Annotations[annoFractionLimit] = strconv.FormatFloat(limit, 'f', -1, 64)
+	}
+	if memory > 0 {
+		lease.Annotations[annoMemory] = strconv.FormatInt(memory, 10)
+	}
+	if err := create(ctx, cli, lease); err != nil {
+		return err
+	}
+	held, err := ListNode(ctx, cli, prefix, node)
+	if err == nil && overfilled(held, id) {
+		// When two shares race, both may see the other and back out; each
+		// is tried again in a later cycle.
+		err = Shortagef("GPU %d on node %s was shared out concurrently", id, node)
+	}
+	if err != nil {
+		_ = ReleaseFraction(ctx, cli, prefix, ns, node, holder, id)
+		return err
+	}
+	return nil
+}
+
+// overfilled reports whether the pod leases in held take more than the
+// whole of device id. Reservations are left out: a share is only placed on
+// a reserved device by a pod the reservation admits.
+func overfilled(held []coordv1.Lease, id int) bool {
+	held = slices.DeleteFunc(held, func(l coordv1.Lease) bool {
+		_, ok := reserveWindow(&l)
+		return ok
+	})
+	return DeviceUsage(held)[id] > 1+shareEpsilon
+}
+
+// annoMIGDevice records the GPU a MIG instance lease's instance lives on, so
+// the GPU counts as used and is not also leased whole or in part.
+const annoMIGDevice = "gpu.scheduling/mig-device"
+
+// MIGLeaseName names the lease for a MIG instance. Instance ids are unique per
+// node, independent of the parent device.
+func MIGLeaseName(prefix, node string, id int) string {
+	return leaseName(prefix, fmt.Sprintf("gpu-%s-mig-%d", node, id))
+}
+
+// TryAcquireMIG attempts to create the lease for MIG instance id of profile,
+// which lives on GPU device, tagged like AcquireFraction with a non-empty
+// antiAffinity group.
+func TryAcquireMIG(
+	ctx context.Context,
+	cli coordclient.CoordinationV1Interface,
+	prefix, ns, node, holder string,
+	pod types.NamespacedName,
+	profile, antiAffinity string,
+	id, device int,
 ) (bool, error) {
-	name := LeaseName(node, id)
-	lease := &coordv1.Lease{
+	lease := newLease(prefix, MIGLeaseName(prefix, node, id), ns, node, holder, pod, id)
+	lease.Labels[labelMIG] = profile
+	lease.Annotations[annoMIGDevice] = strconv.Itoa(device)
+	setAntiAffinity(lease, antiAffinity)
+	if err := create(ctx, cli, lease); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// create creates the lease, retrying with backoff while the API server
+// reports a conflict, as it may when another scheduling cycle touches the
+// same device. A lease that already exists is re-read and counts as created
+// when its holder is the new lease's, e.g. because an earlier attempt went
+// through but its response was lost; otherwise the AlreadyExists error is
+// returned, wrapped in ErrDeviceConflict. One deleted before it could be
+// read is created again.
+func create(ctx context.Context, cli coordclient.CoordinationV1Interface, lease *coordv1.Lease) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, err := cli.Leases(lease.Namespace).Create(ctx, lease, metav1.CreateOptions{})
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		existing, getErr := cli.Leases(lease.Namespace).Get(ctx, lease.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(getErr):
+			return apierrors.NewConflict(coordv1.Resource("leases"), lease.Name, getErr)
+		case getErr != nil:
+			return err
+		case existing.Spec.HolderIdentity != nil && *existing.Spec.HolderIdentity == *lease.Spec.HolderIdentity:
+			return nil
+		}
+		return fmt.Errorf("%w: %w", ErrDeviceConflict, err)
+	})
+}
+
+func newLease(prefix, name, ns, node, holder string, pod types.NamespacedName, id int) *coordv1.Lease {
+	l := &coordv1.Lease{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: ns,
+			Name:        name,
+			Namespace:   ns,
+			Annotations: map[string]string{annoReservedAt: time.Now().UTC().Format(time.RFC3339)},
 			Labels: map[string]string{
-				"gpu.scheduling/managed": "true",
-				"gpu.scheduling/pod":     podName,
+				labelManaged:      managedValue(prefix),
+				labelOwnedBy:      ownerName,
+				labelPod:          pod.Name,
+				labelPodNamespace: pod.Namespace,
+				labelDevice:       strconv.Itoa(id),
 			},
 		},
 		Spec: coordv1.LeaseSpec{
 			HolderIdentity: strPtr(holder),
 		},
 	}
-	if _, err := cli.Leases(ns).Create(ctx, lease, metav1.CreateOptions{}); err != nil {
-		return false, err
+	setNode(l, node)
+	return l
+}
+
+func setAntiAffinity(lease *coordv1.Lease, group string) {
+	if group != "" {
+		lease.Labels[labelAntiAffinity] = group
 	}
-	return true, nil
 }
 
 // Release drops the lease so other pods may use the GPU.
-func Release(ctx context.Context, cli coordclient.CoordinationV1Interface, ns, node string, id int) error {
-	return cli.Leases(ns).Delete(ctx, LeaseName(node, id), metav1.DeleteOptions{})
+func Release(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node string, id int) error {
+	return cli.Leases(ns).Delete(ctx, LeaseName(prefix, node, id), metav1.DeleteOptions{})
+}
+
+// ReleaseHeld drops the lease on GPU id only while holder holds it, so a pod
+// whose reservation another pod took over leaves the new holder's lease be.
+func ReleaseHeld(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node, holder string, id int) error {
+	l, err := cli.Leases(ns).Get(ctx, LeaseName(prefix, node, id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if l.Spec.HolderIdentity == nil || *l.Spec.HolderIdentity != holder {
+		return nil
+	}
+	// Take keeps the lease's UID, so only its version tells it was taken
+	// since it was read.
+	opts := deleteExactly(l)
+	if opts.Preconditions != nil {
+		opts.Preconditions.ResourceVersion = &l.ResourceVersion
+	}
+	return cli.Leases(ns).Delete(ctx, l.Name, opts)
+}
+
+// deleteExactly returns options deleting l only, not a lease recreated under
+// its name since it was read.
+func deleteExactly(l *coordv1.Lease) metav1.DeleteOptions {
+	if l.UID == "" {
+		return metav1.DeleteOptions{}
+	}
+	uid := l.UID
+	return metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}}
+}
+
+// Reservations returns the exclusive lease on each device that Reserve took
+// and Confirm has not confirmed yet, because its pod is not bound.
+func Reservations(leases []coordv1.Lease) map[int]coordv1.Lease {
+	out := map[int]coordv1.Lease{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			continue
+		}
+		if _, ok := l.Annotations[annoFraction]; ok {
+			continue
+		}
+		if _, ok := l.Annotations[annoDevices]; ok {
+			continue
+		}
+		if _, ok := l.Annotations[annoReserveWindow]; ok {
+			continue
+		}
+		if l.Spec.AcquireTime != nil || l.Spec.HolderIdentity == nil {
+			continue
+		}
+		if id, ok := deviceID(l); ok {
+			out[id] = l
+		}
+	}
+	return out
+}
+
+// Take hands the exclusive lease l over to pod, as holder, in one update
+// that fails with a conflict if l changed since it was read, so the device
+// is never free in between. It returns the lease as updated, for GiveBack.
+func Take(ctx context.Context, cli coordclient.CoordinationV1Interface, l *coordv1.Lease, holder string, pod types.NamespacedName) (*coordv1.Lease, error) {
+	id, _ := deviceID(*l)
+	next := newLease("", l.Name, l.Namespace, NodeOf(l), holder, pod, id)
+	next.Labels[labelManaged] = l.Labels[labelManaged]
+	next.UID, next.ResourceVersion = l.UID, l.ResourceVersion
+	return cli.Leases(l.Namespace).Update(ctx, next, metav1.UpdateOptions{})
+}
+
+// GiveBack returns a lease Take handed over, now taken, to prev, the lease
+// as it was before. It fails with a conflict if taken changed meanwhile.
+func GiveBack(ctx context.Context, cli coordclient.CoordinationV1Interface, prev, taken *coordv1.Lease) error {
+	back := prev.DeepCopy()
+	back.ResourceVersion = taken.ResourceVersion
+	_, err := cli.Leases(back.Namespace).Update(ctx, back, metav1.UpdateOptions{})
+	return err
+}
+
+// ReleaseFraction drops holder's share of GPU id.
+func ReleaseFraction(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node, holder string, id int) error {
+	return cli.Leases(ns).Delete(ctx, FractionLeaseName(prefix, node, id, holder), metav1.DeleteOptions{})
+}
+
+// ReleaseMIG drops the lease for MIG instance id.
+func ReleaseMIG(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node string, id int) error {
+	return cli.Leases(ns).Delete(ctx, MIGLeaseName(prefix, node, id), metav1.DeleteOptions{})
+}
+
+// Confirm records that pod, holding leases in ns on node, was bound: each
+// of its leases gets uid as holder and now as AcquireTime, telling bound
+// reservations apart from ones still in flight. Leases deleted meanwhile are
+// skipped.
+func Confirm(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node string, pod types.NamespacedName, uid types.UID, now time.Time) error {
+	leases, err := listHeld(ctx, cli, prefix, ns, node, pod)
+	if err != nil {
+		return err
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"holderIdentity": string(uid),
+			"acquireTime":    metav1.NewMicroTime(now),
+		},
+	})
+	var errs []error
+	for _, l := range leases {
+		_, err := cli.Leases(ns).Patch(ctx, l.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// listHeld returns the instance's leases in ns that pod holds on node.
+func listHeld(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, node string, pod types.NamespacedName) ([]coordv1.Lease, error) {
+	list, err := cli.Leases(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: managedSelector(prefix, labelPod+"="+pod.Name, labelNode+"="+nodeLabelValue(node)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(list.Items, func(l coordv1.Lease) bool { return PodOf(&l) != pod || NodeOf(&l) != node }), nil
+}
+
+// ListNode returns the leases the instance with the given label prefix
+// holds on node across all namespaces.
+func ListNode(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, node string) ([]coordv1.Lease, error) {
+	list, err := cli.Leases("").List(ctx, metav1.ListOptions{
+		LabelSelector: managedSelector(prefix, labelNode+"="+nodeLabelValue(node)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(list.Items, func(l coordv1.Lease) bool { return NodeOf(&l) != node }), nil
+}
+
+// ManagedLabels selects every lease of the instance with the given label
+// prefix.
+func ManagedLabels(prefix string) map[string]string {
+	return map[string]string{labelManaged: managedValue(prefix)}
+}
+
+// HolderLabels selects the instance's leases held by pods of the given name,
+// in any namespace; PodOf tells which pod each is held by.
+func HolderLabels(prefix, podName string) map[string]string {
+	return map[string]string{labelManaged: managedValue(prefix), labelPod: podName}
+}
+
+// PodOf returns the pod a lease was taken for; its name is empty for a
+// reservation. A lease without labelPodNamespace predates it and lives in
+// its pod's namespace.
+func PodOf(l *coordv1.Lease) types.NamespacedName {
+	ns, ok := l.Labels[labelPodNamespace]
+	if !ok {
+		ns = l.Namespace
+	}
+	return types.NamespacedName{Namespace: ns, Name: l.Labels[labelPod]}
+}
+
+// NodeOf returns the node a lease was taken for.
+func NodeOf(l *coordv1.Lease) string {
+	if node, ok := l.Annotations[annoNode]; ok {
+		return node
+	}
+	return l.Labels[labelNode]
+}
+
+// ListNamespace returns the instance's leases in ns held by pods in
+// namespace podNamespace, including those taken before labelPodNamespace,
+// which live in podNamespace itself.
+func ListNamespace(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, podNamespace string) ([]coordv1.Lease, error) {
+	list, err := cli.Leases(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: managedSelector(prefix, labelPodNamespace+"="+podNamespace),
+	})
+	if err != nil {
+		return nil, err
+	}
+	leases := list.Items
+	if podNamespace != ns {
+		list, err := cli.Leases(podNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: managedSelector(prefix, "!"+labelPodNamespace),
+		})
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, list.Items...)
+	}
+	return leases, nil
+}
+
+// GPUs totals the GPUs the given leases hold: an exclusive lease or a MIG
+// instance counts as one, a pod lease as its devices, a share as its
+// fraction.
+func GPUs(leases []coordv1.Lease) float64 {
+	var total float64
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			total++
+			continue
+		}
+		if _, ok := l.Annotations[annoDevices]; ok {
+			total += float64(len(deviceIDs(l)))
+			continue
+		}
+		total += deviceShare(l)
+	}
+	return total
+}
+
+// HeldDevices returns the device ids claimed by the given leases, whether
+// exclusively, in part, or through MIG instances.
+func HeldDevices(leases []coordv1.Lease) map[int]bool {
+	held := map[int]bool{}
+	for id := range DeviceUsage(leases) {
+		held[id] = true
+	}
+	return held
+}
+
+// DeviceUsage sums the share of each device claimed by the given leases. An
+// exclusive lease counts as the whole device, and so do the MIG instances
+// leased on a device, however many; MIG instance leases from before
+// annoMIGDevice name no device and are skipped.
+func DeviceUsage(leases []coordv1.Lease) map[int]float64 {
+	usage := map[int]float64{}
+	migDevices := map[int]bool{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			if id, err := strconv.Atoi(l.Annotations[annoMIGDevice]); err == nil {
+				migDevices[id] = true
+			}
+			continue
+		}
+		for _, id := range deviceIDs(l) {
+			usage[id] += deviceShare(l)
+		}
+	}
+	for id := range migDevices {
+		usage[id]++
+	}
+	return usage
+}
+
+// WithoutMIG returns the given leases other than MIG instance leases.
+func WithoutMIG(leases []coordv1.Lease) []coordv1.Lease {
+	return slices.DeleteFunc(slices.Clone(leases), func(l coordv1.Lease) bool {
+		_, ok := l.Labels[labelMIG]
+		return ok
+	})
+}
+
+// Holders groups the device leases by the pod holding them. MIG instance
+// leases are left out.
+func Holders(leases []coordv1.Lease) map[types.NamespacedName][]coordv1.Lease {
+	holders := map[types.NamespacedName][]coordv1.Lease{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			continue
+		}
+		key := PodOf(&l)
+		if key.Name == "" {
+			continue
+		}
+		holders[key] = append(holders[key], l)
+	}
+	return holders
+}
+
+// DeviceMemory sums the memory reserved on each device by the given leases,
+// for devices of perDevice bytes. An exclusive lease reserves the whole
+// device; a share without a recorded reservation is charged its fraction of
+// perDevice. MIG instance leases are skipped.
+func DeviceMemory(leases []coordv1.Lease, perDevice int64) map[int]int64 {
+	reserved := map[int]int64{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; ok {
+			continue
+		}
+		bytes := perDevice
+		if v, ok := l.Annotations[annoFraction]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < 1 {
+				bytes = int64(f * float64(perDevice))
+			}
+			if m, err := strconv.ParseInt(l.Annotations[annoMemory], 10, 64); err == nil && m > 0 {
+				bytes = m
+			}
+		}
+		for _, id := range deviceIDs(l) {
+			reserved[id] += bytes
+		}
+	}
+	return reserved
+}
+
+// AntiAffine returns the leases held by pods in device anti-affinity group.
+// An empty group has no members.
+func AntiAffine(leases []coordv1.Lease, group string) []coordv1.Lease {
+	if group == "" {
+		return nil
+	}
+	var out []coordv1.Lease
+	for _, l := range leases {
+		if l.Labels[labelAntiAffinity] == group {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// MIGInUse returns the MIG instance ids of profile held by the given leases.
+func MIGInUse(leases []coordv1.Lease, profile string) map[int]bool {
+	inUse := map[int]bool{}
+	for _, l := range leases {
+		if l.Labels[labelMIG] != profile {
+			continue
+		}
+		if id, ok := deviceID(l); ok {
+			inUse[id] = true
+		}
+	}
+	return inUse
+}
+
+// MIGHeld returns the MIG instance ids of any profile held by the given
+// leases.
+func MIGHeld(leases []coordv1.Lease) map[int]bool {
+	held := map[int]bool{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; !ok {
+			continue
+		}
+		if id, ok := deviceID(l); ok {
+			held[id] = true
+		}
+	}
+	return held
+}
+
+// UnplacedMIG returns the MIG instance ids held by the given leases from
+// before annoMIGDevice, which name no GPU; HeldDevices cannot count them.
+func UnplacedMIG(leases []coordv1.Lease) map[int]bool {
+	unplaced := map[int]bool{}
+	for _, l := range leases {
+		if _, ok := l.Labels[labelMIG]; !ok {
+			continue
+		}
+		if _, ok := l.Annotations[annoMIGDevice]; ok {
+			continue
+		}
+		if id, ok := deviceID(l); ok {
+			unplaced[id] = true
+		}
+	}
+	return unplaced
+}
+
+// deviceIDs returns the devices a lease holds: those listed on a pod lease,
+// otherwise its one device, if it names one.
+func deviceIDs(l coordv1.Lease) []int {
+	if v, ok := l.Annotations[annoDevices]; ok {
+		ids, _ := util.ParseAllocation(v)
+		return ids
+	}
+	if id, ok := deviceID(l); ok {
+		return []int{id}
+	}
+	return nil
+}
+
+func deviceID(l coordv1.Lease) (int, bool) {
+	v, ok := l.Labels[labelDevice]
+	if !ok {
+		// Leases created before the device label only encode it in the name.
+		v = l.Name[strings.LastIndex(l.Name, "-")+1:]
+	}
+	id, err := strconv.Atoi(v)
+	return id, err == nil
+}
+
+// deviceShare is the part of its device a lease claims: its fraction for a
+// share, otherwise the whole device.
+func deviceShare(l coordv1.Lease) float64 {
+	if v, ok := l.Annotations[annoFraction]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < 1 {
+			return f
+		}
+	}
+	return 1
 }
 
 func strPtr(s string) *string { return &s }
@@ -0,0 +1,365 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// podRef names the pod holding a lease in tests.
+func podRef(ns, name string) types.NamespacedName {
+	return types.NamespacedName{Namespace: ns, Name: name}
+}
+
+func TestListNode(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+
+	for _, l := range []struct {
+		ns, node string
+		id       int
+	}{{"default", "node-a", 0}, {"team", "node-a", 1}, {"default", "node-b", 0}} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, l.ns, l.node, "uid", podRef(l.ns, "pod"), l.id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	leases, err := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+	if err != nil {
+		t.Fatalf("ListNode: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Errorf("Expected 2 leases on node-a across namespaces, got %d", len(leases))
+	}
+}
+
+func TestListNodeLongName(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+
+	// Both names run past a label value's 63 characters and only differ
+	// after them.
+	nodes := []string{
+		strings.Repeat("gpu-worker.", 6) + "zone-a.example.internal",
+		strings.Repeat("gpu-worker.", 6) + "zone-b.example.internal",
+	}
+	for _, node := range nodes {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", node, "uid", podRef("default", "pod"), 0); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	for _, node := range nodes {
+		leases, err := ListNode(ctx, coord, DefaultLabelPrefix, node)
+		if err != nil {
+			t.Fatalf("ListNode: %v", err)
+		}
+		if len(leases) != 1 || NodeOf(&leases[0]) != node {
+			t.Fatalf("Expected the one lease on %s, got %v", node, leases)
+		}
+		if errs := validation.IsValidLabelValue(leases[0].Labels[labelNode]); len(errs) > 0 {
+			t.Errorf("Expected a valid node label, got %v", errs)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	for _, l := range []struct {
+		ns, node, pod string
+		id            int
+	}{{"default", "node-a", "trainer", 0}, {"default", "node-a", "trainer", 1}, {"default", "node-a", "other", 2}, {"team", "node-a", "trainer", 3}} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, l.ns, l.node, "uid-reserved", podRef(l.ns, l.pod), l.id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := Confirm(ctx, coord, DefaultLabelPrefix, "default", "node-a", podRef("default", "trainer"), "uid-bound", now); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	leases, _ := coord.Leases("").List(ctx, metav1.ListOptions{})
+	for _, l := range leases.Items {
+		confirmed := l.Spec.AcquireTime != nil
+		if want := l.Namespace == "default" && PodOf(&l).Name == "trainer"; confirmed != want {
+			t.Errorf("%s/%s: Expected confirmed=%v, got %v", l.Namespace, l.Name, want, confirmed)
+			continue
+		}
+		if !confirmed {
+			if *l.Spec.HolderIdentity != "uid-reserved" {
+				t.Errorf("%s/%s: Expected the holder untouched, got %s", l.Namespace, l.Name, *l.Spec.HolderIdentity)
+			}
+			continue
+		}
+		if !l.Spec.AcquireTime.Time.Equal(now) || *l.Spec.HolderIdentity != "uid-bound" {
+			t.Errorf("%s: Expected holder uid-bound acquired at %v, got %s at %v", l.Name, now, *l.Spec.HolderIdentity, l.Spec.AcquireTime.Time)
+		}
+	}
+}
+
+func TestHeldDevices(t *testing.T) {
+	leases := []coordv1.Lease{
+		{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-a-0", Labels: map[string]string{labelDevice: "0"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-a-3"}},
+	}
+	held := HeldDevices(leases)
+	if len(held) != 2 || !held[0] || !held[3] {
+		t.Errorf("Expected devices 0 and 3, got %v", held)
+	}
+}
+
+func TestDeviceUsage(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	for _, holder := range []string{"uid-b", "uid-c"} {
+		if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", holder, podRef("default", holder), "", 1, 0.25, 0); err != nil {
+			t.Fatalf("AcquireFraction: %v", err)
+		}
+	}
+	leases, err := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+	if err != nil {
+		t.Fatalf("ListNode: %v", err)
+	}
+	usage := DeviceUsage(leases)
+	if usage[0] != 1 || usage[1] != 0.5 || len(usage) != 2 {
+		t.Errorf("Expected device 0 fully and device 1 half used, got %v", usage)
+	}
+
+	if err := ReleaseFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", 1); err != nil {
+		t.Fatalf("ReleaseFraction: %v", err)
+	}
+	leases, _ = ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+	if usage := DeviceUsage(leases); usage[1] != 0.25 {
+		t.Errorf("Expected a quarter of device 1 used after release, got %v", usage[1])
+	}
+}
+
+func TestAcquireFractionBacksOutOfAFullDevice(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	// Both pods saw device 0 empty; the second share to land overfills it.
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), "", 0, 0.6, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "", 0, 0.6, 0)
+	if !errors.Is(err, ErrInsufficientGPUs) {
+		t.Fatalf("Expected the second share to back out, got %v", err)
+	}
+	leases, _ := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+	if usage := DeviceUsage(leases); usage[0] != 0.6 {
+		t.Errorf("Expected only the first share on device 0, got %v", usage[0])
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-c", podRef("default", "c"), "", 0, 0.4, 0); err != nil {
+		t.Errorf("Expected a share that fits to be kept, got %v", err)
+	}
+}
+
+func TestMIGLeasesHoldTheirDevice(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	// Two instances on device 1 take it once.
+	for _, id := range []int{0, 1} {
+		if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "1g.5gb", "", id, 1); err != nil {
+			t.Fatalf("TryAcquireMIG: %v", err)
+		}
+	}
+	leases, _ := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+
+	if usage := DeviceUsage(leases); len(usage) != 2 || usage[0] != 1 || usage[1] != 1 {
+		t.Errorf("Expected devices 0 and 1 in use, got %v", usage)
+	}
+	if usage := DeviceUsage(WithoutMIG(leases)); len(usage) != 1 || usage[0] != 1 {
+		t.Errorf("Expected only device 0 leased outside MIG, got %v", usage)
+	}
+	if inUse := MIGInUse(leases, "1g.5gb"); len(inUse) != 2 || !inUse[0] || !inUse[1] {
+		t.Errorf("Expected MIG instances 0 and 1 in use, got %v", inUse)
+	}
+	if inUse := MIGInUse(leases, "3g.20gb"); len(inUse) != 0 {
+		t.Errorf("Expected no 3g.20gb instances in use, got %v", inUse)
+	}
+}
+
+func TestHolders(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	for _, id := range []int{0, 1} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "batch", "node-a", "uid-a", podRef("batch", "a"), id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "", 2, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-c", podRef("default", "c"), "1g.5gb", "", 0, 3); err != nil {
+		t.Fatalf("TryAcquireMIG: %v", err)
+	}
+	leases, _ := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+
+	holders := Holders(leases)
+	if len(holders) != 2 {
+		t.Fatalf("Expected 2 holders without the MIG lease, got %v", holders)
+	}
+	if n := len(holders[types.NamespacedName{Namespace: "batch", Name: "a"}]); n != 2 {
+		t.Errorf("Expected batch/a to hold 2 leases, got %d", n)
+	}
+	if n := len(holders[types.NamespacedName{Namespace: "default", Name: "b"}]); n != 1 {
+		t.Errorf("Expected default/b to hold 1 lease, got %d", n)
+	}
+}
+
+func TestDeviceMemory(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+	const perDevice = 80 << 30
+
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "", 1, 0.25, 40<<30); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-c", podRef("default", "c"), "", 1, 0.25, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	leases, _ := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+
+	reserved := DeviceMemory(leases, perDevice)
+	if reserved[0] != perDevice {
+		t.Errorf("Expected an exclusive lease to reserve the whole device, got %d", reserved[0])
+	}
+	if want := int64(40<<30 + 20<<30); reserved[1] != want {
+		t.Errorf("Expected %d bytes reserved on device 1, got %d", want, reserved[1])
+	}
+}
+
+func TestAntiAffine(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), "web", 0, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "api", 1, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-c", podRef("default", "c"), "1g.5gb", "web", 4, 2); err != nil {
+		t.Fatalf("TryAcquireMIG: %v", err)
+	}
+	leases, _ := ListNode(ctx, coord, DefaultLabelPrefix, "node-a")
+
+	web := AntiAffine(leases, "web")
+	if len(web) != 2 {
+		t.Fatalf("Expected 2 leases in group web, got %d", len(web))
+	}
+	if held := HeldDevices(web); len(held) != 2 || !held[0] || !held[2] {
+		t.Errorf("Expected group web to hold devices 0 and 2, got %v", held)
+	}
+	if held := MIGHeld(web); len(held) != 1 || !held[4] {
+		t.Errorf("Expected group web to hold MIG instance 4, got %v", held)
+	}
+	if got := AntiAffine(leases, ""); len(got) != 0 {
+		t.Errorf("Expected the empty group to have no members, got %d", len(got))
+	}
+}
+
+func TestGPUs(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "team-a", "node-a", "uid-a", podRef("team-a", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "team-a", "node-b", "uid-b", podRef("team-a", "b"), "", 1, 0.25, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "team-a", "node-a", "uid-c", podRef("team-a", "c"), "1g.5gb", "", 3, 0); err != nil {
+		t.Fatalf("TryAcquireMIG: %v", err)
+	}
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "team-b", "node-a", "uid-d", podRef("team-b", "d"), 1); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	held, err := ListNamespace(ctx, coord, DefaultLabelPrefix, "team-a", "team-a")
+	if err != nil {
+		t.Fatalf("ListNamespace: %v", err)
+	}
+	if got := GPUs(held); got != 2.25 {
+		t.Errorf("Expected team-a to hold 2.25 GPUs, got %g", got)
+	}
+}
+
+func TestTryAcquireRetriesConflict(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	var creates int
+	client.PrependReactor("create", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if creates++; creates == 1 {
+			return true, nil, apierrors.NewConflict(coordv1.Resource("leases"), "gpu-node-a-0", errors.New("storage busy"))
+		}
+		return false, nil, nil
+	})
+
+	ok, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0)
+	if !ok || err != nil {
+		t.Fatalf("Expected the lease acquired after a conflict, got %v, %v", ok, err)
+	}
+	if creates != 2 {
+		t.Errorf("Expected 2 create attempts, got %d", creates)
+	}
+	if _, err := coord.Leases("default").Get(ctx, LeaseName(DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the lease to exist: %v", err)
+	}
+}
+
+func TestTryAcquireAlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	coord := fake.NewSimpleClientset().CoordinationV1()
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	// The holder's own lease, e.g. from an attempt whose response was lost.
+	if ok, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); !ok || err != nil {
+		t.Errorf("Expected the holder's existing lease to count as acquired, got %v, %v", ok, err)
+	}
+	ok, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), 0)
+	if ok || !apierrors.IsAlreadyExists(err) {
+		t.Errorf("Expected another holder's lease to stay busy, got %v, %v", ok, err)
+	}
+	if wrapped := fmt.Errorf("reserve node-a: %w", err); !errors.Is(wrapped, ErrDeviceConflict) || !apierrors.IsAlreadyExists(wrapped) {
+		t.Errorf("Expected the wrapped error to match ErrDeviceConflict and AlreadyExists, got %v", wrapped)
+	}
+	if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), "1g.5gb", "", 3, 0); err != nil {
+		t.Fatalf("TryAcquireMIG: %v", err)
+	}
+	if _, err := TryAcquireMIG(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-b", podRef("default", "b"), "1g.5gb", "", 3, 0); !errors.Is(err, ErrDeviceConflict) {
+		t.Errorf("Expected a held MIG instance to report ErrDeviceConflict, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package lease
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const metricsSubsystem = "gpu_lease_gc"
+
+// Reasons the collector deletes a lease, used as the reason metric label.
+const (
+	reasonMissing     = "missing"
+	reasonFinished    = "finished"
+	reasonUIDMismatch = "uid_mismatch"
+	reasonUnknown     = "unknown_phase"
+	reasonStale       = "stale_renewal"
+	reasonConflict    = "device_conflict"
+	reasonBindTimeout = "bind_timeout"
+	reasonWindowEnded = "window_ended"
+)
+
+var (
+	gcDuration = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "duration_seconds",
+			Help:           "Time taken by one lease GC pass.",
+			Buckets:        metrics.ExponentialBuckets(0.01, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	deletedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "deleted_total",
+			Help:           "Leases deleted by the GC, by reason.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"reason"})
+
+	leasesTotal = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "gpu_leases_total",
+			Help:           "Scheduler-owned GPU leases seen by the last GC pass.",
+			StabilityLevel: metrics.ALPHA,
+		})
+
+	wouldDeleteTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "would_delete_total",
+			Help:           "Leases a dry-run GC would have deleted, by reason.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"reason"})
+
+	fragmentationRatio = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "gpu_node_fragmentation_ratio",
+			Help:           "Largest block of consecutive free GPUs on the node over its free GPUs; 1 when they are contiguous or none are free.",
+			StabilityLevel: metrics.ALPHA,
+		}, []string{"node"})
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the lease GC and inventory metrics with the legacy registry the
+// scheduler serves on /metrics. It is safe to call more than once.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(gcDuration, deletedTotal, leasesTotal, wouldDeleteTotal, fragmentationRatio)
+	})
+}
@@ -0,0 +1,83 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultRenewInterval is how often StartRenewer renews a node's leases.
+	DefaultRenewInterval = 10 * time.Second
+	// DefaultStaleRenewals is how many lease durations may pass without a
+	// renewal before the collector reclaims the lease.
+	DefaultStaleRenewals = 6
+)
+
+// Renew stamps now as the RenewTime of every lease the instance with the
+// given label prefix holds on node and sets period as their
+// LeaseDurationSeconds, so the collector can tell when renewals stop. Leases
+// deleted meanwhile are skipped.
+func Renew(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, node string, period time.Duration, now time.Time) error {
+	leases, err := ListNode(ctx, cli, prefix, node)
+	if err != nil {
+		return err
+	}
+	seconds := int32(max(math.Ceil(period.Seconds()), 1))
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"renewTime":            metav1.NewMicroTime(now),
+			"leaseDurationSeconds": seconds,
+		},
+	})
+	var errs []error
+	for _, l := range leases {
+		_, err := cli.Leases(l.Namespace).Patch(ctx, l.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// StartRenewer renews node's leases every interval until ctx is done. It is
+// run by the node agent: when the node dies, renewals stop and the collector
+// reclaims the node's GPUs without waiting for pod status to change.
+func StartRenewer(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, node string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRenewInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := Renew(ctx, cli, prefix, node, interval, time.Now()); err != nil {
+				klog.ErrorS(err, "failed to renew GPU leases", "node", node)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// renewDeadline returns when the lease counts as abandoned: multiple lease
+// durations after its last renewal. Leases never renewed have no deadline.
+func renewDeadline(l *coordv1.Lease, multiple int) (time.Time, bool) {
+	if multiple <= 0 || l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+		return time.Time{}, false
+	}
+	d := time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second * time.Duration(multiple)
+	return l.Spec.RenewTime.Add(d), true
+}
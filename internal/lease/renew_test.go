@@ -0,0 +1,48 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRenewStampsNodeLeases(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-a", "uid-a", podRef("default", "a"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if err := AcquireFraction(ctx, coord, DefaultLabelPrefix, "team-b", "node-a", "uid-b", podRef("team-b", "b"), "", 1, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+	if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, "default", "node-b", "uid-c", podRef("default", "c"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := Renew(ctx, coord, DefaultLabelPrefix, "node-a", 1500*time.Millisecond, now); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+
+	leases, _ := coord.Leases("").List(ctx, metav1.ListOptions{})
+	for _, l := range leases.Items {
+		renewed := l.Spec.RenewTime != nil
+		if want := NodeOf(&l) == "node-a"; renewed != want {
+			t.Errorf("%s: Expected renewed=%v, got %v", l.Name, want, renewed)
+			continue
+		}
+		if !renewed {
+			continue
+		}
+		if !l.Spec.RenewTime.Time.Equal(now) {
+			t.Errorf("%s: Expected RenewTime %v, got %v", l.Name, now, l.Spec.RenewTime.Time)
+		}
+		if d := l.Spec.LeaseDurationSeconds; d == nil || *d != 2 {
+			t.Errorf("%s: Expected the period rounded up to 2s, got %v", l.Name, d)
+		}
+	}
+}
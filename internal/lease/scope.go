@@ -0,0 +1,50 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+// InNamespaces wraps cli so that a lease list across every namespace lists
+// only namespaces instead, one list per namespace, for schedulers that may
+// not list leases cluster-wide. Calls naming a namespace pass through. An
+// empty namespaces returns cli unchanged.
+func InNamespaces(cli coordclient.CoordinationV1Interface, namespaces []string) coordclient.CoordinationV1Interface {
+	if len(namespaces) == 0 {
+		return cli
+	}
+	return &scopedClient{CoordinationV1Interface: cli, namespaces: namespaces}
+}
+
+type scopedClient struct {
+	coordclient.CoordinationV1Interface
+	namespaces []string
+}
+
+func (c *scopedClient) Leases(ns string) coordclient.LeaseInterface {
+	if ns != metav1.NamespaceAll {
+		return c.CoordinationV1Interface.Leases(ns)
+	}
+	return &scopedLeases{LeaseInterface: c.CoordinationV1Interface.Leases(ns), client: c}
+}
+
+type scopedLeases struct {
+	coordclient.LeaseInterface
+	client *scopedClient
+}
+
+func (s *scopedLeases) List(ctx context.Context, opts metav1.ListOptions) (*coordv1.LeaseList, error) {
+	all := &coordv1.LeaseList{}
+	for _, ns := range s.client.namespaces {
+		list, err := s.client.CoordinationV1Interface.Leases(ns).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", ns, err)
+		}
+		all.Items = append(all.Items, list.Items...)
+	}
+	return all, nil
+}
@@ -0,0 +1,34 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInNamespacesListsOnlyThoseNamespaces(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// A cluster-wide list hangs, so the test fails if one is made.
+	client := &hangingClient{Clientset: fake.NewSimpleClientset(), hanging: map[string]bool{"": true}}
+	for i, ns := range []string{"kube-system", "team", "other"} {
+		if _, err := TryAcquire(ctx, client.Clientset.CoordinationV1(), DefaultLabelPrefix, ns, "node-a", "uid", podRef(ns, "pod"), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+
+	leases, err := ListNode(ctx, InNamespaces(client.CoordinationV1(), []string{"kube-system", "team"}), DefaultLabelPrefix, "node-a")
+	if err != nil {
+		t.Fatalf("ListNode: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Fatalf("Expected the 2 leases in the scoped namespaces, got %d", len(leases))
+	}
+	for _, l := range leases {
+		if l.Namespace == "other" {
+			t.Errorf("Expected no lease from namespace other, got %s", l.Name)
+		}
+	}
+}
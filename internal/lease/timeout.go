@@ -0,0 +1,90 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+// DefaultAPICallTimeout bounds each API server call the collector and the
+// plugin make.
+const DefaultAPICallTimeout = 10 * time.Second
+
+// CallContext derives the context for one API call, which gives up after
+// timeout. A non-positive timeout leaves the call bounded by ctx alone.
+func CallContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// TimedOut reports whether err is an API call running out of time, as
+// opposed to the API server rejecting it.
+func TimedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// WithCallTimeout wraps cli so each lease call it makes gives up after
+// timeout. A non-positive timeout returns cli unchanged.
+func WithCallTimeout(cli coordclient.CoordinationV1Interface, timeout time.Duration) coordclient.CoordinationV1Interface {
+	if timeout <= 0 {
+		return cli
+	}
+	return &timedClient{CoordinationV1Interface: cli, timeout: timeout}
+}
+
+type timedClient struct {
+	coordclient.CoordinationV1Interface
+	timeout time.Duration
+}
+
+func (c *timedClient) Leases(ns string) coordclient.LeaseInterface {
+	return &timedLeases{LeaseInterface: c.CoordinationV1Interface.Leases(ns), timeout: c.timeout}
+}
+
+type timedLeases struct {
+	coordclient.LeaseInterface
+	timeout time.Duration
+}
+
+func (t *timedLeases) Create(ctx context.Context, l *coordv1.Lease, opts metav1.CreateOptions) (*coordv1.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.Create(ctx, l, opts)
+}
+
+func (t *timedLeases) Update(ctx context.Context, l *coordv1.Lease, opts metav1.UpdateOptions) (*coordv1.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.Update(ctx, l, opts)
+}
+
+func (t *timedLeases) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.Delete(ctx, name, opts)
+}
+
+func (t *timedLeases) Get(ctx context.Context, name string, opts metav1.GetOptions) (*coordv1.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.Get(ctx, name, opts)
+}
+
+func (t *timedLeases) List(ctx context.Context, opts metav1.ListOptions) (*coordv1.LeaseList, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.List(ctx, opts)
+}
+
+func (t *timedLeases) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*coordv1.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.LeaseInterface.Patch(ctx, name, pt, data, opts, subresources...)
+}
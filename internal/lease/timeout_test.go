@@ -0,0 +1,130 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+// hangingClient is a fake clientset whose lease calls in the hanging
+// namespaces block until their context is done, like an API server that
+// stopped answering. The namespace "" stands for cluster-wide lists.
+type hangingClient struct {
+	*fake.Clientset
+	hanging map[string]bool
+}
+
+func (c *hangingClient) CoordinationV1() coordclient.CoordinationV1Interface {
+	return &hangingCoord{CoordinationV1Interface: c.Clientset.CoordinationV1(), hanging: c.hanging}
+}
+
+type hangingCoord struct {
+	coordclient.CoordinationV1Interface
+	hanging map[string]bool
+}
+
+func (c *hangingCoord) Leases(ns string) coordclient.LeaseInterface {
+	leases := c.CoordinationV1Interface.Leases(ns)
+	if !c.hanging[ns] {
+		return leases
+	}
+	return &hangingLeases{LeaseInterface: leases}
+}
+
+type hangingLeases struct {
+	coordclient.LeaseInterface
+}
+
+func (l *hangingLeases) List(ctx context.Context, opts metav1.ListOptions) (*coordv1.LeaseList, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (l *hangingLeases) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// runWithin runs one collection pass and fails the test if it does not
+// return within limit.
+func runWithin(t *testing.T, c *collector, limit time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(context.Background(), time.Now())
+	}()
+	select {
+	case <-done:
+	case <-time.After(limit):
+		t.Fatalf("Expected the pass to return within %v", limit)
+	}
+}
+
+func TestRunGCListTimeout(t *testing.T) {
+	client := &hangingClient{Clientset: fake.NewSimpleClientset(), hanging: map[string]bool{"": true}}
+	pods, _ := podCache(t, client.Clientset)
+	c := &collector{client: client, pods: pods, callTimeout: 50 * time.Millisecond}
+	runWithin(t, c, 5*time.Second)
+}
+
+func TestRunGCSlowNamespace(t *testing.T) {
+	ctx := context.Background()
+	client := &hangingClient{Clientset: fake.NewSimpleClientset(), hanging: map[string]bool{"slow": true}}
+	coord := client.Clientset.CoordinationV1()
+	for _, ns := range []string{"slow", "fast"} {
+		if _, err := TryAcquire(ctx, coord, DefaultLabelPrefix, ns, "node-"+ns, "uid-gone", podRef(ns, "gone"), 0); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	pods, _ := podCache(t, client.Clientset)
+	c := &collector{client: client, pods: pods, workers: 1, callTimeout: 50 * time.Millisecond}
+	runWithin(t, c, 5*time.Second)
+
+	if _, err := coord.Leases("fast").Get(ctx, LeaseName(DefaultLabelPrefix, "node-fast", 0), metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the orphan in the fast namespace to be deleted")
+	}
+	if _, err := coord.Leases("slow").Get(ctx, LeaseName(DefaultLabelPrefix, "node-slow", 0), metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the timed-out delete to leave the slow namespace's lease, got %v", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	ctx, cancel := CallContext(context.Background(), time.Second)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("Expected a deadline for a positive timeout")
+	}
+	ctx, cancel = CallContext(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("Expected no deadline for a zero timeout")
+	}
+	if !TimedOut(context.DeadlineExceeded) || TimedOut(context.Canceled) {
+		t.Errorf("Expected only a deadline to count as a timeout")
+	}
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	client := &hangingClient{Clientset: fake.NewSimpleClientset(), hanging: map[string]bool{"default": true}}
+	cli := WithCallTimeout(client.CoordinationV1(), 50*time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ListNamespace(context.Background(), cli, DefaultLabelPrefix, "default", "default")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !TimedOut(err) {
+			t.Errorf("Expected the list to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the list to return once its call timed out")
+	}
+}
@@ -0,0 +1,165 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/types"
+	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+// annoReserveWindow turns a lease into a reservation of its device for a
+// time window, e.g. "02:00-06:00", instead of a pod's hold on it. Such a
+// lease has no pod; its holder identity names the reservation.
+const annoReserveWindow = "gpu.scheduling/reserve-window"
+
+// ReservationLeaseName names the lease reserving GPU id on node for the
+// named reservation. It differs from LeaseName, so the pods using the
+// reservation can lease the device next to it.
+func ReservationLeaseName(prefix, node string, id int, name string) string {
+	return leaseName(prefix, fmt.Sprintf("gpu-%s-%d-reservation-%s", node, id, name))
+}
+
+// ReserveWindow reserves GPU id on node for the named reservation during
+// window, which must parse with ParseWindow, with a lease in ns. Pods in
+// namespace podNamespace whose reservation annotation names it may use the
+// device meanwhile; others may not.
+func ReserveWindow(ctx context.Context, cli coordclient.CoordinationV1Interface, prefix, ns, podNamespace, node, name, window string, id int) error {
+	if _, err := ParseWindow(window); err != nil {
+		return err
+	}
+	l := newLease(prefix, ReservationLeaseName(prefix, node, id, name), ns, node, name, types.NamespacedName{Namespace: podNamespace}, id)
+	delete(l.Labels, labelPod)
+	l.Annotations[annoReserveWindow] = window
+	return create(ctx, cli, l)
+}
+
+// Window is when a reservation holds its device: every day between two
+// times of day in UTC, or once between two instants.
+type Window struct {
+	// daily windows run from start to end, offsets from midnight UTC. An end
+	// before the start wraps past midnight.
+	daily      bool
+	start, end time.Duration
+	// one-off windows run from from to to.
+	from, to time.Time
+}
+
+// ParseWindow parses a window: "HH:MM-HH:MM" for a daily window in UTC, or
+// two RFC 3339 times separated by "/" for a one-off window.
+func ParseWindow(s string) (Window, error) {
+	if from, to, ok := strings.Cut(s, "/"); ok {
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(from))
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid window start: %w", err)
+		}
+		end, err := time.Parse(time.RFC3339, strings.TrimSpace(to))
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid window end: %w", err)
+		}
+		if !end.After(start) {
+			return Window{}, fmt.Errorf("window %q ends before it starts", s)
+		}
+		return Window{from: start, to: end}, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM or <start>/<end>", s)
+	}
+	start, err := timeOfDay(strings.TrimSpace(from))
+	if err != nil {
+		return Window{}, err
+	}
+	end, err := timeOfDay(strings.TrimSpace(to))
+	if err != nil {
+		return Window{}, err
+	}
+	if start == end {
+		return Window{}, fmt.Errorf("window %q is empty", s)
+	}
+	return Window{daily: true, start: start, end: end}, nil
+}
+
+func timeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active reports whether the window holds its device at now.
+func (w Window) Active(now time.Time) bool {
+	if !w.daily {
+		return !now.Before(w.from) && now.Before(w.to)
+	}
+	now = now.UTC()
+	t := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	if w.start < w.end {
+		return t >= w.start && t < w.end
+	}
+	return t >= w.start || t < w.end
+}
+
+// Expired reports whether a one-off window is over at now. Daily windows
+// never are.
+func (w Window) Expired(now time.Time) bool {
+	return !w.daily && !now.Before(w.to)
+}
+
+// reserveWindow returns the window of a reservation lease; ok is false for
+// other leases. A window that does not parse holds its device all the time,
+// so a typo never frees it, and never expires.
+func reserveWindow(l *coordv1.Lease) (w Window, ok bool) {
+	v, ok := l.Annotations[annoReserveWindow]
+	if !ok {
+		return Window{}, false
+	}
+	w, err := ParseWindow(v)
+	if err != nil {
+		return Window{daily: true, start: 0, end: 24 * time.Hour}, true
+	}
+	return w, true
+}
+
+// WindowReservations groups the reservation leases among leases by the
+// namespace and name of their reservation.
+func WindowReservations(leases []coordv1.Lease) map[types.NamespacedName][]coordv1.Lease {
+	reservations := map[types.NamespacedName][]coordv1.Lease{}
+	for _, l := range leases {
+		if _, ok := reserveWindow(&l); !ok || l.Spec.HolderIdentity == nil {
+			continue
+		}
+		key := types.NamespacedName{Namespace: PodOf(&l).Namespace, Name: *l.Spec.HolderIdentity}
+		reservations[key] = append(reservations[key], l)
+	}
+	return reservations
+}
+
+// Active drops the reservation leases whose window is not active at now,
+// leaving the leases that hold their devices then.
+func Active(leases []coordv1.Lease, now time.Time) []coordv1.Lease {
+	return Occupying(leases, "", "", now)
+}
+
+// Occupying drops the leases that leave their device free, at now, for a pod
+// in namespace ns using the named reservation: reservations outside their
+// window, and the reservation itself, which is made for the pod's namespace.
+func Occupying(leases []coordv1.Lease, ns, reservation string, now time.Time) []coordv1.Lease {
+	var out []coordv1.Lease
+	for _, l := range leases {
+		if w, ok := reserveWindow(&l); ok {
+			if !w.Active(now) {
+				continue
+			}
+			if reservation != "" && PodOf(&l).Namespace == ns && l.Spec.HolderIdentity != nil && *l.Spec.HolderIdentity == reservation {
+				continue
+			}
+		}
+		out = append(out, l)
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// reservation builds a window reservation of GPU id on node-a, as
+// ReserveWindow creates it.
+func reservation(name, ns, holder, window string, id int) *coordv1.Lease {
+	l := newLease(DefaultLabelPrefix, name, ns, "node-a", holder, podRef(ns, ""), id)
+	delete(l.Labels, labelPod)
+	l.Annotations[annoReserveWindow] = window
+	return l
+}
+
+func TestParseWindow(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		window  string
+		at      time.Duration
+		active  bool
+		wantErr bool
+	}{
+		{window: "02:00-06:00", at: 2 * time.Hour, active: true},
+		{window: "02:00-06:00", at: 5*time.Hour + 59*time.Minute, active: true},
+		{window: "02:00-06:00", at: 6 * time.Hour},
+		{window: "02:00-06:00", at: time.Hour},
+		{window: "22:00-02:00", at: 23 * time.Hour, active: true},
+		{window: "22:00-02:00", at: time.Hour, active: true},
+		{window: "22:00-02:00", at: 12 * time.Hour},
+		{window: "2024-03-01T02:00:00Z/2024-03-01T06:00:00Z", at: 3 * time.Hour, active: true},
+		{window: "2024-03-01T02:00:00Z/2024-03-01T06:00:00Z", at: 30 * time.Hour},
+		{window: "02:00", wantErr: true},
+		{window: "2:00-25:00", wantErr: true},
+		{window: "02:00-02:00", wantErr: true},
+		{window: "2024-03-01T06:00:00Z/2024-03-01T02:00:00Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.window)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error", tt.window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.window, err)
+			continue
+		}
+		if got := w.Active(day.Add(tt.at)); got != tt.active {
+			t.Errorf("%s at %v: Expected active=%v, got %v", tt.window, tt.at, tt.active, got)
+		}
+	}
+}
+
+func TestOccupying(t *testing.T) {
+	night := time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC)
+	noon := night.Add(9 * time.Hour)
+	leases := []coordv1.Lease{
+		*reservation("nightly", "research", "batch", "02:00-06:00", 0),
+		*reservation("broken", "research", "other", "every night", 1),
+		*newLease(DefaultLabelPrefix, LeaseName(DefaultLabelPrefix, "node-a", 2), "default", "node-a", "uid-a", podRef("default", "a"), 2),
+	}
+
+	tests := []struct {
+		name        string
+		ns          string
+		reservation string
+		now         time.Time
+		want        map[int]bool
+	}{
+		{name: "in window", now: night, want: map[int]bool{0: true, 1: true, 2: true}},
+		{name: "out of window", now: noon, want: map[int]bool{1: true, 2: true}},
+		{name: "reservation's own pod", ns: "research", reservation: "batch", now: night, want: map[int]bool{1: true, 2: true}},
+		{name: "same name in another namespace", ns: "default", reservation: "batch", now: night, want: map[int]bool{0: true, 1: true, 2: true}},
+	}
+	for _, tt := range tests {
+		got := HeldDevices(Occupying(leases, tt.ns, tt.reservation, tt.now))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Expected devices %v held, got %v", tt.name, tt.want, got)
+			continue
+		}
+		for id := range tt.want {
+			if !got[id] {
+				t.Errorf("%s: Expected devices %v held, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestRunGCReservations(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	coord := client.CoordinationV1()
+	for _, l := range []*coordv1.Lease{
+		reservation("nightly", "research", "batch", "02:00-06:00", 0),
+		reservation("launch", "research", "demo", "2024-03-01T02:00:00Z/2024-03-01T06:00:00Z", 1),
+		reservation("ended", "research", "demo", "2024-02-28T02:00:00Z/2024-02-28T06:00:00Z", 2),
+	} {
+		if _, err := coord.Leases(l.Namespace).Create(ctx, l, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("create lease: %v", err)
+		}
+	}
+	pods, _ := podCache(t, client)
+	c := &collector{client: client, pods: pods, grace: time.Nanosecond, bindTimeout: time.Nanosecond}
+
+	c.run(ctx, time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC))
+	for name, want := range map[string]bool{"nightly": true, "launch": true, "ended": false} {
+		_, err := coord.Leases("research").Get(ctx, name, metav1.GetOptions{})
+		if got := err == nil; got != want {
+			t.Errorf("%s: Expected lease kept=%v, got %v", name, want, got)
+		}
+	}
+}
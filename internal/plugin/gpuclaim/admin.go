@@ -0,0 +1,124 @@
+package gpuclaim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// serveAdmin serves mux on addr until ctx is done.
+func serveAdmin(ctx context.Context, addr string, mux *http.ServeMux) {
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.ErrorS(err, "Admin server stopped", "addr", addr)
+	}
+}
+
+// inventoryNode is one node's devices in the GET /inventory response.
+type inventoryNode struct {
+	Node string `json:"node"`
+	// Devices are the ids of the GPUs the node may hand out.
+	Devices []int `json:"devices"`
+	// Free are the devices no lease claims any part of.
+	Free []int `json:"free"`
+	// Used is the share of each claimed device in use, 1 for a whole one.
+	Used    map[int]float64   `json:"used,omitempty"`
+	Holders []inventoryHolder `json:"holders,omitempty"`
+	// Reservations hold devices for the pods naming them; only those
+	// within their window are listed.
+	Reservations []inventoryReservation `json:"reservations,omitempty"`
+}
+
+// inventoryHolder is a pod holding devices on a node.
+type inventoryHolder struct {
+	// Pod is namespace/name.
+	Pod     string `json:"pod"`
+	Devices []int  `json:"devices"`
+}
+
+// inventoryReservation is a reservation holding devices on a node.
+type inventoryReservation struct {
+	// Reservation is the namespace of the pods it is for and its name.
+	Reservation string `json:"reservation"`
+	Devices     []int  `json:"devices"`
+}
+
+// inventoryHandler answers GET /inventory with the devices of every GPU
+// node as Filter and Reserve see them. With the lease inventory that
+// includes the leases Reserve just took, before the informer reports them.
+type inventoryHandler struct {
+	p     *Plugin
+	nodes corelisters.NodeLister
+}
+
+func (h *inventoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	out, err := h.inventory(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// inventory lists the nodes with GPUs or leases, by name.
+func (h *inventoryHandler) inventory(ctx context.Context) ([]inventoryNode, error) {
+	list, err := h.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	slices.SortFunc(list, func(a, b *corev1.Node) int { return strings.Compare(a.Name, b.Name) })
+	out := []inventoryNode{}
+	for _, node := range list {
+		held, devices, err := h.p.nodeLeases(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) == 0 && len(held) == 0 {
+			continue
+		}
+		used := lease.DeviceUsage(held)
+		n := inventoryNode{
+			Node:    node.Name,
+			Devices: devices,
+			Free:    slices.DeleteFunc(slices.Clone(devices), func(id int) bool { return used[id] > 0 }),
+		}
+		if len(used) > 0 {
+			n.Used = used
+		}
+		for key, leases := range lease.Holders(held) {
+			ids := slices.Sorted(maps.Keys(lease.HeldDevices(leases)))
+			n.Holders = append(n.Holders, inventoryHolder{Pod: key.String(), Devices: ids})
+		}
+		slices.SortFunc(n.Holders, func(a, b inventoryHolder) int { return strings.Compare(a.Pod, b.Pod) })
+		for key, leases := range lease.WindowReservations(held) {
+			ids := slices.Sorted(maps.Keys(lease.HeldDevices(leases)))
+			n.Reservations = append(n.Reservations, inventoryReservation{Reservation: key.String(), Devices: ids})
+		}
+		slices.SortFunc(n.Reservations, func(a, b inventoryReservation) int { return strings.Compare(a.Reservation, b.Reservation) })
+		out = append(out, n)
+	}
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package gpuclaim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func TestInventoryEndpointReflectsReserve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+	cs := p.client.(*fake.Clientset)
+	// The informer never runs, so only Reserve's own writes reach the
+	// inventory.
+	inventory, err := lease.NewInventory(lease.NewInformer(cs, 0, lease.DefaultLabelPrefix, metav1.NamespaceAll))
+	if err != nil {
+		t.Fatalf("NewInventory: %v", err)
+	}
+	// A reservation holds device 3 now; one whose window has passed leaves
+	// device 2 free.
+	for _, r := range []struct {
+		name, window string
+		id           int
+	}{{"nightly", "2000-01-01T00:00:00Z/2100-01-01T00:00:00Z", 3}, {"past", "2000-01-01T00:00:00Z/2001-01-01T00:00:00Z", 2}} {
+		if err := lease.ReserveWindow(ctx, cs.CoordinationV1(), lease.DefaultLabelPrefix, lease.DefaultNamespace, "team", "node-a", r.name, r.window, r.id); err != nil {
+			t.Fatalf("ReserveWindow: %v", err)
+		}
+	}
+	adoptLeases(ctx, inventory, cs.CoordinationV1(), lease.DefaultLabelPrefix)
+	p.inventory = inventory
+	p.coord = inventory.Track(cs.CoordinationV1())
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	// Nodes without GPUs are left out.
+	for _, node := range []*corev1.Node{gpuNode("node-a", "4"), {ObjectMeta: metav1.ObjectMeta{Name: "cpu-only"}}} {
+		_ = indexer.Add(node)
+	}
+	h := &inventoryHandler{p: p, nodes: corelisters.NewNodeLister(indexer)}
+
+	if status := p.Reserve(ctx, cycleStateFor(2), testPod("trainer"), "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	cs.ClearActions()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inventory", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got []inventoryNode
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode inventory: %v", err)
+	}
+	want := []inventoryNode{{
+		Node:    "node-a",
+		Devices: []int{0, 1, 2, 3},
+		Free:    []int{2},
+		Used:    map[int]float64{0: 1, 1: 1, 3: 1},
+		Holders: []inventoryHolder{{Pod: "default/trainer", Devices: []int{0, 1}}},
+
+		Reservations: []inventoryReservation{{Reservation: "team/nightly", Devices: []int{3}}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected inventory %+v, got %+v", want, got)
+	}
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "leases" {
+			t.Errorf("Expected the endpoint to read the inventory, got a lease list")
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/inventory", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func antiAffinePod(name, claim, group string) *corev1.Pod {
+	pod := testPod(name)
+	pod.Annotations = map[string]string{util.AnnoClaim: claim}
+	if group != "" {
+		pod.Labels = map[string]string{util.LabelDeviceAntiAffinity: group}
+	}
+	return pod
+}
+
+func TestDeviceAntiAffinitySpreadsShares(t *testing.T) {
+	ctx := context.Background()
+	node := gpuNode("node-a", "2")
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+
+	// Binpack would stack both quarter shares on one device.
+	schedule := func(pod *corev1.Pod) (*stateData, *framework.Status) {
+		t.Helper()
+		state := framework.NewCycleState()
+		if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+			t.Fatalf("PreFilter %s: %v", pod.Name, status.Message())
+		}
+		if status := p.Filter(ctx, state, pod, nodeInfo(node)); !status.IsSuccess() {
+			return nil, status
+		}
+		if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+			return nil, status
+		}
+		data, _ := readState(state)
+		return data, nil
+	}
+
+	devices := map[int]string{}
+	for _, name := range []string{"web-0", "web-1"} {
+		data, status := schedule(antiAffinePod(name, "0.25", "web"))
+		if !status.IsSuccess() {
+			t.Fatalf("%s: %v", name, status.Message())
+		}
+		id := data.chosenIDs[0]
+		if other, ok := devices[id]; ok {
+			t.Errorf("Expected %s on its own device, got device %d shared with %s", name, id, other)
+		}
+		devices[id] = name
+	}
+
+	if _, status := schedule(antiAffinePod("web-2", "0.25", "web")); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected a third member to be rejected once every device holds one, got %v", status.Code())
+	}
+	if _, status := schedule(antiAffinePod("api-0", "0.25", "api")); !status.IsSuccess() {
+		t.Errorf("Expected another group to share a device, got %v", status.Message())
+	}
+	if _, status := schedule(antiAffinePod("batch", "0.25", "")); !status.IsSuccess() {
+		t.Errorf("Expected a pod without the label to share a device, got %v", status.Message())
+	}
+}
+
+func TestDeviceAntiAffinityMIG(t *testing.T) {
+	ctx := context.Background()
+	gns := gpuNodeStatus("node-a", 0, 1)
+	gns.Status.MIGInstances = []apiv1.MIGInstance{
+		{ID: 0, Device: 0, Profile: "1g.5gb", UUID: "MIG-aaaa"},
+		{ID: 1, Device: 0, Profile: "1g.5gb", UUID: "MIG-bbbb"},
+		{ID: 2, Device: 1, Profile: "1g.5gb", UUID: "MIG-cccc"},
+	}
+	p := newTestPlugin(gns)
+
+	reserve := func(name string) (*stateData, *framework.Status) {
+		pod := migPod(name, "1g.5gb")
+		pod.Labels = map[string]string{util.LabelDeviceAntiAffinity: "web"}
+		state := framework.NewCycleState()
+		if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+			t.Fatalf("PreFilter %s: %v", name, status.Message())
+		}
+		status := p.Reserve(ctx, state, pod, "node-a")
+		data, _ := readState(state)
+		return data, status
+	}
+
+	if data, status := reserve("web-0"); !status.IsSuccess() || data.chosenIDs[0] != 0 {
+		t.Fatalf("Expected web-0 on instance 0, got %v: %v", data.chosenIDs, status.Message())
+	}
+	if data, status := reserve("web-1"); !status.IsSuccess() || data.chosenIDs[0] != 2 {
+		t.Errorf("Expected web-1 on the other GPU's instance 2, got %v: %v", data.chosenIDs, status.Message())
+	}
+	if _, status := reserve("web-2"); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected web-2 to be rejected, got %v", status.Code())
+	}
+}
+
+func TestDeviceAntiAffinityShareAvoidsMIG(t *testing.T) {
+	ctx := context.Background()
+	node := gpuNode("node-a", "2")
+	gns := gpuNodeStatus("node-a", 0, 1)
+	gns.Status.MIGInstances = []apiv1.MIGInstance{
+		{ID: 0, Device: 0, Profile: "1g.5gb", UUID: "MIG-aaaa"},
+	}
+
+	tests := []struct {
+		name string
+		// legacy drops the GPU from the peer's lease, as leases from before
+		// MIG leases named it lack it.
+		legacy bool
+	}{
+		{name: "lease names its GPU"},
+		{name: "legacy lease", legacy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(gns)
+			peer := migPod("web-mig", "1g.5gb")
+			if ok, err := lease.TryAcquireMIG(ctx, p.coord, p.labelPrefix, p.leaseNamespace, "node-a", string(peer.UID), podKey(peer), "1g.5gb", "web", 0, 0); err != nil || !ok {
+				t.Fatalf("TryAcquireMIG: ok=%v err=%v", ok, err)
+			}
+			if tt.legacy {
+				leases, err := p.coord.Leases(p.leaseNamespace).List(ctx, metav1.ListOptions{})
+				if err != nil || len(leases.Items) != 1 {
+					t.Fatalf("Expected the MIG lease, got %v: %v", leases, err)
+				}
+				l := leases.Items[0]
+				delete(l.Annotations, "gpu.scheduling/mig-device")
+				if _, err := p.coord.Leases(p.leaseNamespace).Update(ctx, &l, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+			}
+
+			pod := antiAffinePod("web-0", "0.25", "web")
+			state := framework.NewCycleState()
+			if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter: %v", status.Message())
+			}
+			if status := p.Filter(ctx, state, pod, nodeInfo(gpuNode("node-a", "1"))); status.Code() != framework.Unschedulable {
+				t.Errorf("Expected a one-GPU node to be rejected, got %v", status.Code())
+			}
+			if status := p.Filter(ctx, state, pod, nodeInfo(node)); !status.IsSuccess() {
+				t.Fatalf("Filter: %v", status.Message())
+			}
+			if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+				t.Fatalf("Reserve: %v", status.Message())
+			}
+			if data, _ := readState(state); data.chosenIDs[0] != 1 {
+				t.Errorf("Expected the share off the GPU of the peer's MIG instance, got device %d", data.chosenIDs[0])
+			}
+		})
+	}
+}
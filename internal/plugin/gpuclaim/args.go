@@ -0,0 +1,68 @@
+package gpuclaim
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+)
+
+const (
+	// StrategyBinpack prefers nodes left with the fewest free GPUs.
+	StrategyBinpack = "binpack"
+	// StrategySpread prefers nodes left with the most free GPUs.
+	StrategySpread = "spread"
+
+	defaultGangTimeoutSeconds = 60
+)
+
+// Args is read from the plugin's pluginConfig entry in the scheduler profile.
+type Args struct {
+	// PackingStrategy is binpack (default) or spread.
+	PackingStrategy string `json:"packingStrategy,omitempty"`
+	// GangTimeoutSeconds bounds how long reserved gang members wait in Permit
+	// for the rest of their gang. Defaults to 60.
+	GangTimeoutSeconds int64 `json:"gangTimeoutSeconds,omitempty"`
+	// QuotaConfigMap names, as namespace/name, the ConfigMap holding
+	// per-namespace GPU quotas. Empty disables quota enforcement.
+	QuotaConfigMap string `json:"quotaConfigMap,omitempty"`
+	// HealthWeight is the percentage, 0-100, of a node's score taken from
+	// its GPU health annotation rather than from packing. 0 (default)
+	// ignores health.
+	HealthWeight int64 `json:"healthWeight,omitempty"`
+}
+
+func (a Args) gangTimeout() time.Duration {
+	return time.Duration(a.GangTimeoutSeconds) * time.Second
+}
+
+func decodeArgs(obj runtime.Object) (Args, error) {
+	args := Args{}
+	if err := frameworkruntime.DecodeInto(obj, &args); err != nil {
+		return args, fmt.Errorf("decode %s args: %w", Name, err)
+	}
+	switch args.PackingStrategy {
+	case "":
+		args.PackingStrategy = StrategyBinpack
+	case StrategyBinpack, StrategySpread:
+	default:
+		return args, fmt.Errorf("invalid packingStrategy %q: must be %s or %s", args.PackingStrategy, StrategyBinpack, StrategySpread)
+	}
+	switch {
+	case args.GangTimeoutSeconds == 0:
+		args.GangTimeoutSeconds = defaultGangTimeoutSeconds
+	case args.GangTimeoutSeconds < 0:
+		return args, fmt.Errorf("invalid gangTimeoutSeconds %d: must be positive", args.GangTimeoutSeconds)
+	}
+	if args.HealthWeight < 0 || args.HealthWeight > 100 {
+		return args, fmt.Errorf("invalid healthWeight %d: must be between 0 and 100", args.HealthWeight)
+	}
+	if args.QuotaConfigMap != "" {
+		if ns, name, err := cache.SplitMetaNamespaceKey(args.QuotaConfigMap); err != nil || ns == "" || name == "" {
+			return args, fmt.Errorf("invalid quotaConfigMap %q: must be namespace/name", args.QuotaConfigMap)
+		}
+	}
+	return args, nil
+}
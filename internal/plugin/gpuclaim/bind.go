@@ -0,0 +1,98 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// Bind binds a pod holding GPU leases and settles the leases with the
+// outcome, so a reservation does not outlive a failed binding until the
+// collector finds it: on success the leases are confirmed to the bound pod,
+// and a pod's whole GPUs folded into one pod lease, on failure they are
+// released right away, unless reading the pod back shows it bound after all
+// or cannot tell. Pods without a claim are left to the next bind
+// plugin.
+func (p *Plugin) Bind(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	ctx, span := p.startSpan(ctx, "Bind", pod, nodeName)
+	status := p.bind(ctx, cycleState, pod, nodeName)
+	endSpan(span, cycleState, status)
+	return status
+}
+
+func (p *Plugin) bind(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	data, err := readState(cycleState)
+	if errors.Is(err, framework.ErrNotFound) {
+		return framework.NewStatus(framework.Skip)
+	}
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: nodeName},
+	}
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	if err := p.client.CoreV1().Pods(pod.Namespace).Bind(callCtx, binding, metav1.CreateOptions{}); err != nil {
+		// A timeout or lost response says nothing about whether the binding
+		// was made, so the pod is read back before its leases go.
+		node, known := p.boundNode(ctx, pod)
+		switch {
+		case known && node == nodeName:
+			klog.InfoS("pod bound despite bind error", "pod", klog.KObj(pod), "node", nodeName, "err", err)
+		case known:
+			p.releaseLeases(ctx, pod, nodeName, data, "bind_failed")
+			return framework.AsStatus(fmt.Errorf("bind pod %s/%s to node %s: %w", pod.Namespace, pod.Name, nodeName, err))
+		default:
+			// The pod may be running on the devices; the collector releases
+			// them once it is gone or never bound, and Unreserve must not.
+			klog.InfoS("binding outcome unknown, leaving GPU leases to the collector", "pod", klog.KObj(pod), "node", nodeName, "err", err)
+			data.chosenIDs, data.chosenUUIDs, data.annotated, data.draClaims = nil, nil, nil, nil
+			return framework.AsStatus(fmt.Errorf("bind pod %s/%s to node %s: %w", pod.Namespace, pod.Name, nodeName, err))
+		}
+	}
+
+	// The pod is bound either way; leases left unconfirmed are still held
+	// by the pod's UID and collected with it.
+	if err := lease.Confirm(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, podKey(pod), pod.UID, time.Now()); err != nil {
+		klog.ErrorS(err, "failed to confirm GPU leases", "pod", klog.KObj(pod), "node", nodeName)
+		return nil
+	}
+	if data.migProfile == "" && data.fraction == 0 {
+		if _, err := lease.Compact(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, podKey(pod)); err != nil {
+			klog.ErrorS(err, "failed to compact GPU leases", "pod", klog.KObj(pod), "node", nodeName)
+		}
+	}
+	return nil
+}
+
+// boundNode reads the pod back from the API server and returns the node it
+// is bound to, "" when it is not bound or is gone or replaced. known is
+// false when the pod cannot be read.
+func (p *Plugin) boundNode(ctx context.Context, pod *corev1.Pod) (node string, known bool) {
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	live, err := p.client.CoreV1().Pods(pod.Namespace).Get(callCtx, pod.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", true
+	}
+	if err != nil {
+		klog.ErrorS(err, "failed to read pod back after bind error", "pod", klog.KObj(pod))
+		return "", false
+	}
+	if live.UID != pod.UID {
+		return "", true
+	}
+	return live.Spec.NodeName, true
+}
@@ -0,0 +1,161 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func TestBindConfirmsLeases(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	p := newTestPlugin(pod, gpuNodeStatus("node-a", 0, 1, 2, 3))
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.Bind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Bind: %v", status.Message())
+	}
+
+	var bound bool
+	for _, action := range p.client.(*fake.Clientset).Actions() {
+		if action.Matches("create", "pods") && action.GetSubresource() == "binding" {
+			binding := action.(k8stesting.CreateAction).GetObject().(*corev1.Binding)
+			bound = binding.Target.Name == "node-a" && binding.UID == pod.UID
+		}
+	}
+	if !bound {
+		t.Errorf("Expected the pod to be bound to node-a")
+	}
+	leases, err := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	if len(leases.Items) != 1 || leases.Items[0].Name != lease.PodLeaseName(lease.DefaultLabelPrefix, "node-a", podRef("default", "trainer")) {
+		t.Fatalf("Expected the 2 reserved leases folded into the pod lease, got %d", len(leases.Items))
+	}
+	l := leases.Items[0]
+	if l.Spec.AcquireTime == nil || *l.Spec.HolderIdentity != string(pod.UID) {
+		t.Errorf("Expected the pod lease confirmed to the pod, got %+v", l.Spec)
+	}
+	if usage := lease.DeviceUsage(leases.Items); len(usage) != 2 || usage[0] != 1 || usage[1] != 1 {
+		t.Errorf("Expected the pod lease to hold devices 0 and 1, got %v", usage)
+	}
+}
+
+func TestBindFailureReleasesLeases(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	p := newTestPlugin(pod, gpuNodeStatus("node-a", 0, 1, 2, 3))
+	p.client.(*fake.Clientset).PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("apiserver unavailable")
+	})
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.Bind(ctx, state, pod, "node-a"); status.Code() != framework.Error {
+		t.Fatalf("Expected Bind to fail, got %v", status.Code())
+	}
+	leases, err := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	if len(leases.Items) != 0 {
+		t.Errorf("Expected the failed binding to release its leases, got %d", len(leases.Items))
+	}
+	if data, _ := readState(state); len(data.chosenIDs) != 0 {
+		t.Errorf("Expected the released devices forgotten, got %v", data.chosenIDs)
+	}
+	// Unreserve, which the framework runs next, has nothing left to do.
+	p.Unreserve(ctx, state, pod, "node-a")
+}
+
+func TestBindErrorAfterBinding(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	p := newTestPlugin(pod, gpuNodeStatus("node-a", 0, 1, 2, 3))
+	client := p.client.(*fake.Clientset)
+	// The binding is made, but its response is lost.
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		bound := pod.DeepCopy()
+		bound.Spec.NodeName = "node-a"
+		if err := client.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), bound, bound.Namespace); err != nil {
+			t.Fatalf("bind pod: %v", err)
+		}
+		return true, nil, errors.New("context deadline exceeded")
+	})
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.Bind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Expected Bind to succeed for a bound pod, got %v", status.Message())
+	}
+	leases, err := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	if len(leases.Items) != 1 || leases.Items[0].Spec.AcquireTime == nil {
+		t.Errorf("Expected the bound pod's leases kept and confirmed, got %d leases", len(leases.Items))
+	}
+}
+
+func TestBindErrorOutcomeUnknown(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	p := newTestPlugin(pod, gpuNodeStatus("node-a", 0, 1, 2, 3))
+	client := p.client.(*fake.Clientset)
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("context deadline exceeded")
+	})
+	client.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.Bind(ctx, state, pod, "node-a"); status.Code() != framework.Error {
+		t.Fatalf("Expected Bind to fail, got %v", status.Code())
+	}
+	p.Unreserve(ctx, state, pod, "node-a")
+	leases, err := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	if len(leases.Items) != 2 {
+		t.Errorf("Expected the leases left to the collector, got %d", len(leases.Items))
+	}
+}
+
+func TestBindSkipsPodsWithoutClaim(t *testing.T) {
+	p := newTestPlugin()
+	status := p.Bind(context.Background(), framework.NewCycleState(), testPod("web"), "node-a")
+	if status.Code() != framework.Skip {
+		t.Errorf("Expected Skip for a pod without a claim, got %v", status.Code())
+	}
+}
@@ -0,0 +1,230 @@
+package gpuclaim
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// reasonFragmented is the event reason for a claim that only fails because
+// the free GPUs are spread over too many nodes.
+const reasonFragmented = "GPUFragmentation"
+
+// fragmentation is a claim for need whole GPUs that the free GPUs would
+// cover together, though no single node has enough of them.
+type fragmentation struct {
+	need int
+	// free maps each node with a free GPU to how many it has.
+	free map[string]int
+}
+
+func (f *fragmentation) total() int {
+	n := 0
+	for _, free := range f.free {
+		n += free
+	}
+	return n
+}
+
+func (f *fragmentation) most() int {
+	n := 0
+	for _, free := range f.free {
+		n = max(n, free)
+	}
+	return n
+}
+
+// message says why the claim does not fit and what would help.
+func (f *fragmentation) message() string {
+	return fmt.Sprintf("%d GPUs are free across %d nodes, but at most %d on one node and the claim needs %d; moving smaller GPU pods together would make room",
+		f.total(), len(f.free), f.most(), f.need)
+}
+
+// detectFragmentation reports whether a claim for need whole GPUs fails on
+// nodes only because their free GPUs are scattered. It is nil when some node
+// has need free GPUs, or all of them together do not.
+func (p *Plugin) detectFragmentation(ctx context.Context, pod *corev1.Pod, nodes []*corev1.Node, need int) *fragmentation {
+	f := &fragmentation{need: need, free: map[string]int{}}
+	for _, node := range nodes {
+		held, devices, err := p.nodeLeases(ctx, node)
+		if err != nil {
+			klog.V(4).InfoS("skipping node in fragmentation check", "pod", klog.KObj(pod), "node", node.Name, "err", err)
+			continue
+		}
+		if free := freeDevices(lease.DeviceUsage(occupying(pod, held)), devices); free > 0 {
+			f.free[node.Name] = free
+		}
+	}
+	if f.total() < need || f.most() >= need {
+		return nil
+	}
+	return f
+}
+
+// recordFragmentation tells the pod's owner, through an event, that the
+// cluster's free GPUs are fragmented.
+func (p *Plugin) recordFragmentation(pod *corev1.Pod, msg string) {
+	if recorder := p.handle.EventRecorder(); recorder != nil {
+		recorder.Eventf(pod, nil, corev1.EventTypeWarning, reasonFragmented, "Scheduling", msg)
+	}
+}
+
+// postFilterFragmented ends a PostFilter that found nothing to preempt. If
+// the claim only fails because the free GPUs are scattered, it says so in an
+// event and the status, and with defragRebalance moves pods to make room.
+func (p *Plugin) postFilterFragmented(ctx context.Context, pod *corev1.Pod, data *stateData, nodes []*corev1.Node, budgets *pdbBudgets) (*framework.PostFilterResult, *framework.Status) {
+	f := p.detectFragmentation(ctx, pod, nodes, data.reqCount)
+	if f == nil {
+		return nil, framework.NewStatus(framework.Unschedulable, data.withReasons("no node can free enough GPUs by preempting lower-priority pods"))
+	}
+	msg := f.message()
+	p.recordFragmentation(pod, msg)
+	if p.defragRebalance {
+		if plan := p.planRebalance(ctx, pod, nodes, f, budgets); plan != nil {
+			if err := p.preempt(ctx, pod, plan); err != nil {
+				return nil, framework.AsStatus(err)
+			}
+			return framework.NewPostFilterResultWithNominatedNode(plan.node), framework.NewStatus(framework.Success)
+		}
+	}
+	return nil, framework.NewStatus(framework.Unschedulable, data.withReasons(msg))
+}
+
+// planRebalance picks pods to move off one node so that it has f.need free
+// GPUs, each of which fits in the free GPUs of another node and so is not
+// displaced for good. Only pods of at most the pending pod's priority, that
+// hold whole GPUs, and whose disruption budgets allow it are moved. The node
+// with the most free GPUs is tried first. It returns nil when no node can be
+// cleared this way.
+func (p *Plugin) planRebalance(ctx context.Context, pod *corev1.Pod, nodes []*corev1.Node, f *fragmentation, budgets *pdbBudgets) *preemption {
+	targets := slices.Clone(nodes)
+	slices.SortFunc(targets, func(a, b *corev1.Node) int {
+		if c := cmp.Compare(f.free[b.Name], f.free[a.Name]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	for _, node := range targets {
+		plan, err := p.rebalanceNode(ctx, pod, node, f, budgets)
+		if err != nil {
+			klog.V(4).InfoS("skipping rebalance candidate", "pod", klog.KObj(pod), "node", node.Name, "err", err)
+			continue
+		}
+		if plan != nil {
+			return plan
+		}
+	}
+	return nil
+}
+
+// rebalanceNode picks the pods to move off node for planRebalance.
+func (p *Plugin) rebalanceNode(ctx context.Context, pod *corev1.Pod, node *corev1.Node, f *fragmentation, budgets *pdbBudgets) (*preemption, error) {
+	held, devices, err := p.nodeLeases(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("list leases: %w", err)
+	}
+	held = occupying(pod, held)
+	enough := func(moved []victim) bool {
+		return freeDevices(lease.DeviceUsage(remainingLeases(held, moved)), devices) >= f.need
+	}
+
+	var candidates []victim
+	for key, leases := range lease.Holders(held) {
+		if !wholeDevices(leases) {
+			continue
+		}
+		holder, err := p.getPod(ctx, key)
+		if apierrors.IsNotFound(err) {
+			// Left for the lease GC.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get pod %s: %w", key, err)
+		}
+		// Moving a peer of equal priority would let the two move each
+		// other in turn.
+		if podPriority(holder) >= podPriority(pod) {
+			continue
+		}
+		candidates = append(candidates, victim{pod: holder, leases: leases})
+	}
+	// Move the least important and the smallest pods first.
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := podPriority(candidates[i].pod), podPriority(candidates[j].pod)
+		if pi != pj {
+			return pi < pj
+		}
+		ni, nj := len(lease.DeviceUsage(candidates[i].leases)), len(lease.DeviceUsage(candidates[j].leases))
+		if ni != nj {
+			return ni < nj
+		}
+		return candidates[i].pod.Name < candidates[j].pod.Name
+	})
+
+	// Room left elsewhere for the moved pods.
+	room := map[string]int{}
+	for name, free := range f.free {
+		if name != node.Name {
+			room[name] = free
+		}
+	}
+	var moved []victim
+	for _, v := range candidates {
+		if enough(moved) {
+			break
+		}
+		dest := bestFit(room, len(lease.DeviceUsage(v.leases)))
+		if dest == "" {
+			continue
+		}
+		allowed, err := budgets.allows(ctx, v.pod, moved)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+		room[dest] -= len(lease.DeviceUsage(v.leases))
+		moved = append(moved, v)
+	}
+	if len(moved) == 0 || !enough(moved) {
+		return nil, nil
+	}
+	return &preemption{node: node.Name, victims: moved}, nil
+}
+
+// wholeDevices reports whether leases only hold whole GPUs, which a moved
+// pod finds again on any node with as many free.
+func wholeDevices(leases []coordv1.Lease) bool {
+	for _, share := range lease.DeviceUsage(leases) {
+		if share < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// bestFit returns the node in room with the fewest free GPUs that still
+// holds n, or "" when none does.
+func bestFit(room map[string]int, n int) string {
+	best := ""
+	for name, free := range room {
+		if free < n {
+			continue
+		}
+		if best == "" || free < room[best] || (free == room[best] && name < best) {
+			best = name
+		}
+	}
+	return best
+}
@@ -0,0 +1,142 @@
+package gpuclaim
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/events"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func TestPostFilterFragmentation(t *testing.T) {
+	tests := []struct {
+		name        string
+		claim       int
+		fillPrio    int32
+		rebalance   bool
+		wantMessage string
+		wantNode    string
+		wantEvicted []string
+	}{
+		{
+			name:        "reports scattered GPUs",
+			claim:       2,
+			fillPrio:    10,
+			wantMessage: "3 GPUs are free across 3 nodes, but at most 1 on one node and the claim needs 2",
+		},
+		{
+			name:        "not enough free GPUs in total",
+			claim:       4,
+			fillPrio:    10,
+			wantMessage: "no node can free enough GPUs",
+		},
+		{
+			name:        "rebalance moves a pod off the first node",
+			claim:       2,
+			fillPrio:    0,
+			rebalance:   true,
+			wantNode:    "node-a",
+			wantEvicted: []string{"fill-a"},
+		},
+		{
+			name:        "rebalance leaves pods of equal priority",
+			claim:       2,
+			fillPrio:    10,
+			rebalance:   true,
+			wantMessage: "3 GPUs are free across 3 nodes",
+		},
+		{
+			name:        "rebalance leaves higher priority pods",
+			claim:       2,
+			fillPrio:    100,
+			rebalance:   true,
+			wantMessage: "3 GPUs are free across 3 nodes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			// Three 2-GPU nodes with one GPU taken on each.
+			var objs []runtime.Object
+			var infos []*framework.NodeInfo
+			statuses := framework.NewDefaultNodeToStatus()
+			for _, name := range []string{"node-a", "node-b", "node-c"} {
+				fill := priorityPod("fill-"+strings.TrimPrefix(name, "node-"), tt.fillPrio)
+				objs = append(objs, fill)
+				infos = append(infos, nodeInfo(gpuNode(name, "2")))
+				statuses.Set(name, framework.NewStatus(framework.Unschedulable, "insufficient free GPUs"))
+			}
+			p := newTestPlugin(objs...)
+			p.defragRebalance = tt.rebalance
+			recorder := events.NewFakeRecorder(10)
+			p.handle.(*fakeHandle).nodes = infos
+			p.handle.(*fakeHandle).recorder = recorder
+			for i, obj := range objs {
+				fill := obj.(*corev1.Pod)
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, fill.Namespace, infos[i].Node().Name, string(fill.UID), podKey(fill), 0); err != nil {
+					t.Fatalf("TryAcquire: %v", err)
+				}
+			}
+			cs := p.client.(*fake.Clientset)
+			cs.ClearActions()
+
+			result, status := p.PostFilter(ctx, cycleStateFor(tt.claim), priorityPod("trainer", 10), statuses)
+
+			var evicted []string
+			for _, action := range cs.Actions() {
+				if action.GetSubresource() == "eviction" {
+					evicted = append(evicted, action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+				}
+			}
+			if !slices.Equal(evicted, tt.wantEvicted) {
+				t.Errorf("Expected evictions %v, got %v", tt.wantEvicted, evicted)
+			}
+			if tt.wantNode != "" {
+				if !status.IsSuccess() {
+					t.Fatalf("PostFilter: %v", status.Message())
+				}
+				if result == nil || result.NominatedNodeName != tt.wantNode {
+					t.Errorf("Expected nomination to %s, got %+v", tt.wantNode, result)
+				}
+				return
+			}
+			if status.Code() != framework.Unschedulable {
+				t.Fatalf("Expected Unschedulable, got %v: %s", status.Code(), status.Message())
+			}
+			if !strings.Contains(status.Message(), tt.wantMessage) {
+				t.Errorf("Expected message containing %q, got %q", tt.wantMessage, status.Message())
+			}
+			fragmented := strings.Contains(tt.wantMessage, "are free across")
+			select {
+			case event := <-recorder.Events:
+				if !fragmented {
+					t.Errorf("Expected no event, got %q", event)
+				} else if !strings.Contains(event, reasonFragmented) || !strings.Contains(event, tt.wantMessage) {
+					t.Errorf("Expected a %s event containing %q, got %q", reasonFragmented, tt.wantMessage, event)
+				}
+			default:
+				if fragmented {
+					t.Errorf("Expected a %s event", reasonFragmented)
+				}
+			}
+		})
+	}
+}
+
+func TestBestFit(t *testing.T) {
+	room := map[string]int{"node-a": 3, "node-b": 1, "node-c": 2}
+	for n, want := range map[int]string{1: "node-b", 2: "node-c", 3: "node-a", 4: ""} {
+		if got := bestFit(room, n); got != want {
+			t.Errorf("Expected bestFit(%d) = %q, got %q", n, want, got)
+		}
+	}
+}
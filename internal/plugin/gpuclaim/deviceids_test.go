@@ -0,0 +1,56 @@
+package gpuclaim
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestNodeDeviceIDs(t *testing.T) {
+	ctx := context.Background()
+	node := gpuNode("node-a", "3")
+	node.Annotations = map[string]string{util.AnnoDeviceIDs: "1,2,5"}
+	// The agent reports every device on the host; only 1, 2 and 5 are
+	// handed to this scheduler.
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3, 4, 5))
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+
+	if status := p.Filter(ctx, cycleStateFor(3), testPod("trainer"), nodeInfo(node)); !status.IsSuccess() {
+		t.Errorf("Expected 3 GPUs to fit, got %v (%s)", status.Code(), status.Message())
+	}
+	if status := p.Filter(ctx, cycleStateFor(4), testPod("trainer"), nodeInfo(node)); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected 4 GPUs not to fit, got %v", status.Code())
+	}
+
+	state := cycleStateFor(3)
+	if status := p.Reserve(ctx, state, testPod("trainer"), "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if data, _ := readState(state); !slices.Equal(data.chosenIDs, []int{1, 2, 5}) {
+		t.Errorf("Expected devices [1 2 5], got %v", data.chosenIDs)
+	}
+	p.Unreserve(ctx, state, testPod("trainer"), "node-a")
+
+	// A lease on a listed device takes it out of the node's free set.
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), 2); err != nil {
+		t.Fatalf("seed lease: %v", err)
+	}
+	if status := p.Filter(ctx, cycleStateFor(3), testPod("trainer"), nodeInfo(node)); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected 3 GPUs not to fit with device 2 leased, got %v", status.Code())
+	}
+	if status := p.Filter(ctx, cycleStateFor(2), testPod("trainer"), nodeInfo(node)); !status.IsSuccess() {
+		t.Errorf("Expected 2 GPUs to fit, got %v (%s)", status.Code(), status.Message())
+	}
+	state = cycleStateFor(2)
+	if status := p.Reserve(ctx, state, testPod("trainer"), "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if data, _ := readState(state); !slices.Equal(data.chosenIDs, []int{1, 5}) {
+		t.Errorf("Expected devices [1 5], got %v", data.chosenIDs)
+	}
+}
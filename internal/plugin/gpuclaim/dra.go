@@ -0,0 +1,205 @@
+package gpuclaim
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1beta1 "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+const (
+	// DefaultDRADeviceClass is the DeviceClass whose ResourceClaims the
+	// plugin allocates when DRA support is on.
+	DefaultDRADeviceClass = "gpu.scheduling"
+	// DefaultDRADriver is the driver named in the allocation results the
+	// plugin publishes.
+	DefaultDRADriver = "gpu.scheduling"
+
+	draDevicePrefix = "gpu-"
+)
+
+// draClaim is a ResourceClaim of the pod with requests for the plugin's
+// device class.
+type draClaim struct {
+	name     string
+	requests []draRequest
+}
+
+// draRequest is one request of a draClaim and the GPUs it asks for.
+type draRequest struct {
+	name  string
+	count int
+}
+
+// draCount sums the GPUs requested across claims.
+func draCount(claims []draClaim) int {
+	var n int
+	for _, c := range claims {
+		for _, r := range c.requests {
+			n += r.count
+		}
+	}
+	return n
+}
+
+// draDeviceName names device id in allocation results.
+func draDeviceName(id int) string {
+	return draDevicePrefix + strconv.Itoa(id)
+}
+
+// resourceClaims reads the pod's ResourceClaims that request devices of the
+// plugin's device class. Claims for other classes are left to the
+// DynamicResources plugin. A claim that mixes both is rejected, since
+// publishing the GPUs would mark its other requests allocated too.
+func (p *Plugin) resourceClaims(ctx context.Context, pod *corev1.Pod) ([]draClaim, *framework.Status) {
+	var claims []draClaim
+	for _, ref := range pod.Spec.ResourceClaims {
+		name, ok := resourceClaimName(pod, ref)
+		if !ok {
+			msg := fmt.Sprintf("waiting for the ResourceClaim of pod claim %q to be created", ref.Name)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+		claim, err := p.getResourceClaim(ctx, pod.Namespace, name)
+		if err != nil {
+			msg := fmt.Sprintf("failed to get ResourceClaim %q: %v", name, err)
+			return nil, framework.NewStatus(framework.Unschedulable, msg)
+		}
+		c := draClaim{name: name}
+		other := ""
+		for _, req := range claim.Spec.Devices.Requests {
+			if req.DeviceClassName != p.draClass {
+				other = req.Name
+				continue
+			}
+			if req.AllocationMode == resourcev1beta1.DeviceAllocationModeAll {
+				msg := fmt.Sprintf("ResourceClaim %q request %q: allocationMode All is not supported", name, req.Name)
+				return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+			}
+			count := int(req.Count)
+			if count <= 0 {
+				count = defaultGPUCount
+			}
+			c.requests = append(c.requests, draRequest{name: req.Name, count: count})
+		}
+		if len(c.requests) == 0 {
+			continue
+		}
+		if other != "" {
+			msg := fmt.Sprintf("ResourceClaim %q request %q is not for DeviceClass %s; put the GPU requests in a claim of their own", name, other, p.draClass)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+		if claim.Status.Allocation != nil {
+			msg := fmt.Sprintf("ResourceClaim %q is already allocated; claims cannot be shared between pods", name)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+// resourceClaimName resolves a pod's claim reference to the ResourceClaim
+// name, which for a template is only known once the claim is generated.
+func resourceClaimName(pod *corev1.Pod, ref corev1.PodResourceClaim) (string, bool) {
+	if ref.ResourceClaimName != nil {
+		return *ref.ResourceClaimName, true
+	}
+	for _, s := range pod.Status.ResourceClaimStatuses {
+		if s.Name == ref.Name && s.ResourceClaimName != nil {
+			return *s.ResourceClaimName, true
+		}
+	}
+	return "", false
+}
+
+// publishAllocation writes the reserved GPUs into the status of the pod's
+// ResourceClaims, handing them out to the claims' requests in order, and
+// reserves each claim for the pod.
+func (p *Plugin) publishAllocation(ctx context.Context, pod *corev1.Pod, nodeName string, data *stateData) error {
+	ids := data.chosenIDs
+	for _, c := range data.draClaims {
+		claim, err := p.getResourceClaim(ctx, pod.Namespace, c.name)
+		if err != nil {
+			return fmt.Errorf("get ResourceClaim %q: %w", c.name, err)
+		}
+		var results []resourcev1beta1.DeviceRequestAllocationResult
+		for _, req := range c.requests {
+			if len(ids) < req.count {
+				return fmt.Errorf("ResourceClaim %q request %q: %d GPUs reserved, %d requested", c.name, req.name, len(ids), req.count)
+			}
+			for _, id := range ids[:req.count] {
+				results = append(results, resourcev1beta1.DeviceRequestAllocationResult{
+					Request: req.name,
+					Driver:  p.draDriver,
+					Pool:    nodeName,
+					Device:  draDeviceName(id),
+				})
+			}
+			ids = ids[req.count:]
+		}
+		claim.Status.Allocation = &resourcev1beta1.AllocationResult{
+			Devices:      resourcev1beta1.DeviceAllocationResult{Results: results},
+			NodeSelector: nodeNameSelector(nodeName),
+		}
+		claim.Status.ReservedFor = []resourcev1beta1.ResourceClaimConsumerReference{{
+			Resource: "pods",
+			Name:     pod.Name,
+			UID:      pod.UID,
+		}}
+		if err := p.updateResourceClaimStatus(ctx, pod.Namespace, claim); err != nil {
+			return fmt.Errorf("update ResourceClaim %q status: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// releaseClaims clears the allocation publishAllocation wrote for the pod,
+// leaving claims reserved for anyone else alone.
+func (p *Plugin) releaseClaims(ctx context.Context, pod *corev1.Pod, data *stateData) {
+	for _, c := range data.draClaims {
+		claim, err := p.getResourceClaim(ctx, pod.Namespace, c.name)
+		if err != nil {
+			continue
+		}
+		if len(claim.Status.ReservedFor) != 1 || claim.Status.ReservedFor[0].UID != pod.UID {
+			continue
+		}
+		claim.Status.Allocation = nil
+		claim.Status.ReservedFor = nil
+		if err := p.updateResourceClaimStatus(ctx, pod.Namespace, claim); err != nil {
+			klog.ErrorS(err, "failed to release ResourceClaim", "pod", klog.KObj(pod), "claim", c.name)
+		}
+	}
+}
+
+// getResourceClaim reads the ResourceClaim ns/name.
+func (p *Plugin) getResourceClaim(ctx context.Context, ns, name string) (*resourcev1beta1.ResourceClaim, error) {
+	ctx, cancel := p.callContext(ctx)
+	defer cancel()
+	return p.client.ResourceV1beta1().ResourceClaims(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+// updateResourceClaimStatus writes the status of claim, in namespace ns.
+func (p *Plugin) updateResourceClaimStatus(ctx context.Context, ns string, claim *resourcev1beta1.ResourceClaim) error {
+	ctx, cancel := p.callContext(ctx)
+	defer cancel()
+	_, err := p.client.ResourceV1beta1().ResourceClaims(ns).UpdateStatus(ctx, claim, metav1.UpdateOptions{})
+	return err
+}
+
+// nodeNameSelector selects exactly the named node.
+func nodeNameSelector(nodeName string) *corev1.NodeSelector {
+	return &corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchFields: []corev1.NodeSelectorRequirement{{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{nodeName},
+			}},
+		}},
+	}
+}
@@ -0,0 +1,173 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1beta1 "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func resourceClaim(name, class string, count int64) *resourcev1beta1.ResourceClaim {
+	return &resourcev1beta1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: resourcev1beta1.ResourceClaimSpec{Devices: resourcev1beta1.DeviceClaim{
+			Requests: []resourcev1beta1.DeviceRequest{{
+				Name:            "gpu",
+				DeviceClassName: class,
+				AllocationMode:  resourcev1beta1.DeviceAllocationModeExactCount,
+				Count:           count,
+			}},
+		}},
+	}
+}
+
+func draPod(name string, claims ...string) *corev1.Pod {
+	pod := testPod(name)
+	for _, c := range claims {
+		pod.Spec.ResourceClaims = append(pod.Spec.ResourceClaims, corev1.PodResourceClaim{Name: c, ResourceClaimName: strPtr(c)})
+	}
+	return pod
+}
+
+func newDRATestPlugin(objs ...runtime.Object) *Plugin {
+	p := newTestPlugin(objs...)
+	p.draClass, p.draDriver = DefaultDRADeviceClass, DefaultDRADriver
+	return p
+}
+
+func TestResourceClaimAllocation(t *testing.T) {
+	ctx := context.Background()
+	pod := draPod("trainer", "gpus", "nics")
+	p := newDRATestPlugin(
+		pod,
+		gpuNodeStatus("node-a", 0, 1, 2, 3),
+		resourceClaim("gpus", DefaultDRADeviceClass, 2),
+		resourceClaim("nics", "nic.example.com", 1),
+	)
+
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if data, _ := readState(state); data.reqCount != 2 {
+		t.Errorf("Expected the claim's 2 GPUs to be requested, got %d", data.reqCount)
+	}
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+
+	claims := p.client.ResourceV1beta1().ResourceClaims("default")
+	got, err := claims.Get(ctx, "gpus", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get claim: %v", err)
+	}
+	alloc := got.Status.Allocation
+	if alloc == nil {
+		t.Fatalf("Expected an allocation on the claim")
+	}
+	want := []string{"gpu-0", "gpu-1"}
+	if len(alloc.Devices.Results) != len(want) {
+		t.Fatalf("Expected %d results, got %+v", len(want), alloc.Devices.Results)
+	}
+	for i, r := range alloc.Devices.Results {
+		if r.Request != "gpu" || r.Driver != DefaultDRADriver || r.Pool != "node-a" || r.Device != want[i] {
+			t.Errorf("result %d: Expected gpu/%s/node-a/%s, got %+v", i, DefaultDRADriver, want[i], r)
+		}
+	}
+	if alloc.NodeSelector == nil || alloc.NodeSelector.NodeSelectorTerms[0].MatchFields[0].Values[0] != "node-a" {
+		t.Errorf("Expected the allocation to select node-a, got %+v", alloc.NodeSelector)
+	}
+	if len(got.Status.ReservedFor) != 1 || got.Status.ReservedFor[0].UID != pod.UID {
+		t.Errorf("Expected the claim reserved for the pod, got %+v", got.Status.ReservedFor)
+	}
+	if other, _ := claims.Get(ctx, "nics", metav1.GetOptions{}); other.Status.Allocation != nil {
+		t.Errorf("Expected the claim of another device class untouched")
+	}
+
+	// The annotations the webhook reads are written as for annotated pods.
+	annotated, err := p.client.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get pod: %v", err)
+	}
+	if annotated.Annotations[util.AnnoAllocated] == "" {
+		t.Errorf("Expected the allocation annotation on the pod")
+	}
+
+	p.Unreserve(ctx, state, pod, "node-a")
+	if got, _ := claims.Get(ctx, "gpus", metav1.GetOptions{}); got.Status.Allocation != nil || len(got.Status.ReservedFor) != 0 {
+		t.Errorf("Expected Unreserve to clear the allocation, got %+v", got.Status)
+	}
+}
+
+func TestPreFilterResourceClaims(t *testing.T) {
+	allocated := resourceClaim("allocated", DefaultDRADeviceClass, 1)
+	allocated.Status.Allocation = &resourcev1beta1.AllocationResult{}
+	all := resourceClaim("all", DefaultDRADeviceClass, 0)
+	all.Spec.Devices.Requests[0].AllocationMode = resourcev1beta1.DeviceAllocationModeAll
+	mixed := resourceClaim("mixed", DefaultDRADeviceClass, 1)
+	mixed.Spec.Devices.Requests = append(mixed.Spec.Devices.Requests, resourcev1beta1.DeviceRequest{
+		Name: "nic", DeviceClassName: "nic.example.com", AllocationMode: resourcev1beta1.DeviceAllocationModeExactCount, Count: 1,
+	})
+
+	template := testPod("template")
+	template.Spec.ResourceClaims = []corev1.PodResourceClaim{{Name: "gpu", ResourceClaimTemplateName: strPtr("gpu-template")}}
+	generated := template.DeepCopy()
+	generated.Status.ResourceClaimStatuses = []corev1.PodResourceClaimStatus{{Name: "gpu", ResourceClaimName: strPtr("generated")}}
+	annotated := draPod("annotated", "generated")
+	annotated.Annotations = map[string]string{util.AnnoClaim: "3"}
+
+	tests := []struct {
+		name  string
+		pod   *corev1.Pod
+		dra   bool
+		want  framework.Code
+		count int
+	}{
+		{name: "DRA off", pod: draPod("off", "generated"), want: framework.Skip},
+		{name: "claim", pod: draPod("claim", "generated"), dra: true, want: framework.Success, count: 2},
+		{name: "default count", pod: draPod("one", "unset"), dra: true, want: framework.Success, count: 1},
+		{name: "other device class", pod: draPod("other", "nics"), dra: true, want: framework.Skip},
+		{name: "other class beside", pod: draPod("beside", "generated", "nics"), dra: true, want: framework.Success, count: 2},
+		{name: "mixed classes in a claim", pod: draPod("mixed", "mixed"), dra: true, want: framework.UnschedulableAndUnresolvable},
+		{name: "template not generated", pod: template, dra: true, want: framework.UnschedulableAndUnresolvable},
+		{name: "generated from template", pod: generated, dra: true, want: framework.Success, count: 2},
+		{name: "already allocated", pod: draPod("shared", "allocated"), dra: true, want: framework.UnschedulableAndUnresolvable},
+		{name: "allocation mode All", pod: draPod("all", "all"), dra: true, want: framework.UnschedulableAndUnresolvable},
+		{name: "missing claim", pod: draPod("missing", "missing"), dra: true, want: framework.Unschedulable},
+		{name: "annotation wins", pod: annotated, dra: true, want: framework.Success, count: 3},
+	}
+	for _, tt := range tests {
+		p := newTestPlugin(
+			resourceClaim("generated", DefaultDRADeviceClass, 2),
+			resourceClaim("unset", DefaultDRADeviceClass, 0),
+			resourceClaim("nics", "nic.example.com", 1),
+			allocated, all, mixed,
+		)
+		if tt.dra {
+			p.draClass, p.draDriver = DefaultDRADeviceClass, DefaultDRADriver
+		}
+		state := framework.NewCycleState()
+		_, status := p.PreFilter(context.Background(), state, tt.pod)
+		if got := status.Code(); got != tt.want {
+			t.Errorf("%s: Expected code %v, got %v (%s)", tt.name, tt.want, got, status.Message())
+			continue
+		}
+		if tt.want != framework.Success {
+			continue
+		}
+		if data, err := readState(state); err != nil || data.reqCount != tt.count {
+			t.Errorf("%s: Expected %d GPUs requested, got %+v, %v", tt.name, tt.count, data, err)
+		}
+	}
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,144 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// fractionEpsilon absorbs float rounding when shares such as 0.1 add up to a
+// whole device.
+const fractionEpsilon = 1e-9
+
+// fits reports whether fraction more of a device with used share fits.
+func fits(used, fraction float64) bool {
+	return used+fraction <= 1+fractionEpsilon
+}
+
+// memoryNeed checks a claim's memory request against what co-tenants have
+// reserved on each device. The zero value fits every device.
+type memoryNeed struct {
+	request   int64
+	perDevice int64
+	reserved  map[int]int64
+}
+
+func newMemoryNeed(held []coordv1.Lease, perDevice, request int64) memoryNeed {
+	if request <= 0 {
+		return memoryNeed{}
+	}
+	return memoryNeed{request: request, perDevice: perDevice, reserved: lease.DeviceMemory(held, perDevice)}
+}
+
+// fits reports whether device id has the requested memory unreserved.
+func (m memoryNeed) fits(id int) bool {
+	return m.request <= 0 || m.perDevice-m.reserved[id] >= m.request
+}
+
+// fitsFraction reports whether any of the node's devices has fraction of a
+// device and the requested memory left, leaving out the devices in avoid.
+func fitsFraction(usage map[int]float64, devices []int, fraction float64, mem memoryNeed, avoid map[int]bool) bool {
+	for _, id := range devices {
+		if fits(usage[id], fraction) && mem.fits(id) && !avoid[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// describeShare names what a fractional claim needs from one device.
+func describeShare(data *stateData) string {
+	if data.memory > 0 {
+		return fmt.Sprintf("%g of a device and %s of memory", data.fraction, formatBytes(data.memory))
+	}
+	return fmt.Sprintf("%g of a device", data.fraction)
+}
+
+// formatBytes renders a byte count as a binary quantity such as 40Gi.
+func formatBytes(n int64) string {
+	return resource.NewQuantity(n, resource.BinarySI).String()
+}
+
+// reserveFraction leases a share of the device pickShare chooses for a
+// fractional claim.
+func (p *Plugin) reserveFraction(
+	ctx context.Context,
+	cycleState *framework.CycleState,
+	data *stateData,
+	pod *corev1.Pod,
+	nodeName string,
+	gns *apiv1.GpuNodeStatus,
+	held []coordv1.Lease,
+) *framework.Status {
+	var node *corev1.Node
+	if data.memory > 0 {
+		if node = p.snapshotNode(nodeName); node == nil {
+			return framework.NewStatus(framework.Error, fmt.Sprintf("node %s not found in snapshot", nodeName))
+		}
+	}
+	chosen, status := p.pickShare(data, nodeName, node, gns, held)
+	if !status.IsSuccess() {
+		return status
+	}
+
+	if err := lease.AcquireFractionWithLimit(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, string(pod.UID), podKey(pod), data.antiAffinity,
+		chosen, data.fraction, data.fractionLimit, data.memory); err != nil {
+		if errors.Is(err, lease.ErrInsufficientGPUs) {
+			return framework.NewStatus(framework.Unschedulable).WithError(err)
+		}
+		return framework.NewStatus(framework.Error, fmt.Sprintf("acquire GPU share: %v", err))
+	}
+	klog.V(4).InfoS("reserved GPU share", "pod", klog.KObj(pod), "node", nodeName, "gpuID", chosen, "fraction", data.fraction, "limit", data.fractionLimit)
+
+	data.chosenIDs = []int{chosen}
+	cycleState.Write(Name, data)
+	return nil
+}
+
+// pickShare chooses the device for a fractional claim. Binpack picks the
+// fullest device that still fits so shares stack up; spread picks the
+// emptiest. Devices shared with the pod's anti-affinity group are skipped.
+// node is only read for its GPU memory, and may be nil for claims without
+// a memory request.
+func (p *Plugin) pickShare(data *stateData, nodeName string, node *corev1.Node, gns *apiv1.GpuNodeStatus, held []coordv1.Lease) (int, *framework.Status) {
+	usage := lease.DeviceUsage(held)
+	var mem memoryNeed
+	if data.memory > 0 {
+		mem = newMemoryNeed(held, util.NodeGPUMemory(node), data.memory)
+	}
+	avoid := antiAffineDevices(lease.AntiAffine(held, data.antiAffinity), gns)
+	chosen := -1
+	for _, dev := range sortedDevices(gns) {
+		used := usage[dev.ID]
+		if !fits(used, data.fraction) || !mem.fits(dev.ID) || avoid[dev.ID] {
+			continue
+		}
+		if chosen < 0 {
+			chosen = dev.ID
+			continue
+		}
+		best := usage[chosen]
+		if (p.args.PackingStrategy == StrategySpread && used < best) ||
+			(p.args.PackingStrategy != StrategySpread && used > best) {
+			chosen = dev.ID
+		}
+	}
+	if chosen < 0 {
+		msg := fmt.Sprintf("no GPU on node %s has %s free", nodeName, describeShare(data))
+		if data.antiAffinity != "" {
+			msg += fmt.Sprintf(" outside anti-affinity group %q", data.antiAffinity)
+		}
+		return -1, framework.NewStatus(framework.Unschedulable, msg)
+	}
+	return chosen, nil
+}
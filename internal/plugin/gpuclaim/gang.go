@@ -0,0 +1,100 @@
+package gpuclaim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// gangKey identifies a gang within its namespace.
+type gangKey struct {
+	namespace string
+	id        string
+	// podGroup is the API group of the coscheduling PodGroup behind the
+	// gang, so it is not mixed up with an annotated gang of the same id, nor
+	// with a PodGroup of the other API group. It is empty for an annotated
+	// gang.
+	podGroup string
+}
+
+// gangOf returns the gang a pod belongs to: its PodGroup, sized by the
+// group's minMember, when PodGroups are read and the pod names one, and
+// otherwise its gang annotation. ok is false for pods with neither.
+func (p *Plugin) gangOf(pod *corev1.Pod) (key gangKey, size int, ok bool, err error) {
+	if ref, ok := podGroupOf(pod); ok && p.podGroups != nil {
+		key = gangKey{namespace: pod.Namespace, id: ref.name, podGroup: ref.resource.Group}
+		size, err = p.podGroups.minMember(pod.Namespace, ref)
+		return key, size, true, err
+	}
+	id := pod.GetAnnotations()[util.AnnoGang]
+	if id == "" {
+		return gangKey{}, 0, false, nil
+	}
+	key = gangKey{namespace: pod.Namespace, id: id}
+	raw := pod.GetAnnotations()[util.AnnoGangSize]
+	size, err = strconv.Atoi(raw)
+	if err != nil || size <= 0 {
+		return key, 0, true, fmt.Errorf("invalid %s annotation %q: must be a positive integer", util.AnnoGangSize, raw)
+	}
+	return key, size, true, nil
+}
+
+// gangStatus rejects a pod whose gang cannot be sized: as Unschedulable, so
+// it is retried, while its PodGroup cannot be read for now, and as
+// UnschedulableAndUnresolvable otherwise.
+func gangStatus(err error) *framework.Status {
+	if errors.Is(err, errPodGroupsUnavailable) {
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	return framework.NewStatus(framework.UnschedulableAndUnresolvable, err.Error())
+}
+
+// gangStore tracks which members of each gang have passed Reserve.
+type gangStore struct {
+	mu    sync.Mutex
+	gangs map[gangKey]map[types.UID]bool
+}
+
+func newGangStore() *gangStore {
+	return &gangStore{gangs: map[gangKey]map[types.UID]bool{}}
+}
+
+// reserve records uid as reserved and reports whether the gang is now
+// complete. A complete gang is forgotten so a later retry starts afresh.
+func (s *gangStore) reserve(key gangKey, uid types.UID, size int) (reserved int, complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := s.gangs[key]
+	if members == nil {
+		members = map[types.UID]bool{}
+		s.gangs[key] = members
+	}
+	members[uid] = true
+	reserved = len(members)
+	if reserved >= size {
+		delete(s.gangs, key)
+		return reserved, true
+	}
+	return reserved, false
+}
+
+// forget drops the whole gang, e.g. after one member was rejected.
+func (s *gangStore) forget(key gangKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.gangs, key)
+}
+
+// reserved returns the number of members currently waiting in the gang.
+func (s *gangStore) reserved(key gangKey) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.gangs[key])
+}
@@ -0,0 +1,300 @@
+package gpuclaim
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/events"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// fakeHandle keeps the pods parked in Permit, like the framework's waiting
+// pods map, and serves nodes as the scheduler snapshot. Unimplemented Handle
+// methods panic through the nil embed.
+type fakeHandle struct {
+	framework.Handle
+
+	mu      sync.Mutex
+	waiting map[types.UID]*fakeWaitingPod
+	nodes   []*framework.NodeInfo
+	// recorder, when set, receives the plugin's events.
+	recorder events.EventRecorder
+}
+
+func newFakeHandle() *fakeHandle {
+	return &fakeHandle{waiting: map[types.UID]*fakeWaitingPod{}}
+}
+
+func (h *fakeHandle) park(pod *corev1.Pod) *fakeWaitingPod {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	wp := &fakeWaitingPod{pod: pod}
+	h.waiting[pod.UID] = wp
+	return wp
+}
+
+func (h *fakeHandle) unpark(uid types.UID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.waiting, uid)
+}
+
+func (h *fakeHandle) IterateOverWaitingPods(fn func(framework.WaitingPod)) {
+	h.mu.Lock()
+	pods := make([]*fakeWaitingPod, 0, len(h.waiting))
+	for _, wp := range h.waiting {
+		pods = append(pods, wp)
+	}
+	h.mu.Unlock()
+	for _, wp := range pods {
+		fn(wp)
+	}
+}
+
+func (h *fakeHandle) EventRecorder() events.EventRecorder { return h.recorder }
+
+func (h *fakeHandle) SnapshotSharedLister() framework.SharedLister { return h }
+func (h *fakeHandle) NodeInfos() framework.NodeInfoLister          { return h }
+func (h *fakeHandle) StorageInfos() framework.StorageInfoLister    { return nil }
+
+func (h *fakeHandle) List() ([]*framework.NodeInfo, error) { return h.nodes, nil }
+func (h *fakeHandle) HavePodsWithAffinityList() ([]*framework.NodeInfo, error) {
+	return nil, nil
+}
+func (h *fakeHandle) HavePodsWithRequiredAntiAffinityList() ([]*framework.NodeInfo, error) {
+	return nil, nil
+}
+func (h *fakeHandle) Get(name string) (*framework.NodeInfo, error) {
+	for _, ni := range h.nodes {
+		if ni.Node().Name == name {
+			return ni, nil
+		}
+	}
+	return nil, framework.ErrNotFound
+}
+
+type fakeWaitingPod struct {
+	pod      *corev1.Pod
+	allowed  bool
+	rejected string
+}
+
+func (w *fakeWaitingPod) GetPod() *corev1.Pod         { return w.pod }
+func (w *fakeWaitingPod) GetPendingPlugins() []string { return []string{Name} }
+func (w *fakeWaitingPod) Allow(string)                { w.allowed = true }
+func (w *fakeWaitingPod) Reject(_ string, msg string) { w.rejected = msg }
+
+func gangPod(name, gang, size string) *corev1.Pod {
+	pod := testPod(name)
+	pod.Annotations = map[string]string{
+		util.AnnoClaim:    "1",
+		util.AnnoGang:     gang,
+		util.AnnoGangSize: size,
+	}
+	return pod
+}
+
+// reserveAndPermit runs a gang member through Reserve and Permit and parks it
+// when Permit asks it to wait.
+func reserveAndPermit(t *testing.T, p *Plugin, pod *corev1.Pod) (*framework.CycleState, *framework.Status, *fakeWaitingPod) {
+	t.Helper()
+	ctx := context.Background()
+	state := cycleStateFor(1)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve %s: %v", pod.Name, status.Message())
+	}
+	status, timeout := p.Permit(ctx, state, pod, "node-a")
+	if !status.IsWait() {
+		return state, status, nil
+	}
+	if timeout != p.args.gangTimeout() {
+		t.Errorf("Expected timeout %v, got %v", p.args.gangTimeout(), timeout)
+	}
+	return state, status, p.handle.(*fakeHandle).park(pod)
+}
+
+func TestPermitGangLateArrival(t *testing.T) {
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+
+	_, _, w0 := reserveAndPermit(t, p, gangPod("worker-0", "job", "3"))
+	_, _, w1 := reserveAndPermit(t, p, gangPod("worker-1", "job", "3"))
+	if w0 == nil || w1 == nil {
+		t.Fatalf("Expected the first two members to wait")
+	}
+	if w0.allowed || w1.allowed {
+		t.Errorf("Expected no member to be allowed before the gang is complete")
+	}
+
+	_, status, w2 := reserveAndPermit(t, p, gangPod("worker-2", "job", "3"))
+	if w2 != nil || !status.IsSuccess() {
+		t.Fatalf("Expected the last member to be permitted, got %v", status.Message())
+	}
+	if !w0.allowed || !w1.allowed {
+		t.Errorf("Expected waiting members to be allowed, got %v %v", w0.allowed, w1.allowed)
+	}
+	if n := p.gangs.reserved(gangKey{namespace: "default", id: "job"}); n != 0 {
+		t.Errorf("Expected completed gang to be forgotten, got %d members", n)
+	}
+}
+
+func TestPermitGangTimeoutReleasesLeases(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+	h := p.handle.(*fakeHandle)
+
+	pod0, pod1 := gangPod("worker-0", "job", "3"), gangPod("worker-1", "job", "3")
+	state0, _, w0 := reserveAndPermit(t, p, pod0)
+	state1, _, w1 := reserveAndPermit(t, p, pod1)
+	if w0 == nil || w1 == nil {
+		t.Fatalf("Expected both members to wait")
+	}
+
+	// worker-2 never arrives: the framework times out worker-0 and runs its
+	// Unreserve, which must reject worker-1 as well.
+	h.unpark(pod0.UID)
+	p.Unreserve(ctx, state0, pod0, "node-a")
+	if w1.rejected == "" {
+		t.Fatalf("Expected the remaining member to be rejected")
+	}
+	h.unpark(pod1.UID)
+	p.Unreserve(ctx, state1, pod1, "node-a")
+
+	leases, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 0 {
+		t.Errorf("Expected all gang leases to be released, got %d", len(leases.Items))
+	}
+	if n := p.gangs.reserved(gangKey{namespace: "default", id: "job"}); n != 0 {
+		t.Errorf("Expected timed out gang to be forgotten, got %d members", n)
+	}
+}
+
+func TestPermitWithoutGang(t *testing.T) {
+	p := newTestPlugin()
+	status, timeout := p.Permit(context.Background(), cycleStateFor(1), testPod("solo"), "node-a")
+	if !status.IsSuccess() || timeout != 0 {
+		t.Errorf("Expected pods outside a gang to pass, got %v %v", status.Code(), timeout)
+	}
+
+	bad := gangPod("worker-0", "job", "many")
+	status, _ = p.Permit(context.Background(), cycleStateFor(1), bad, "node-a")
+	if status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected invalid gang size to be rejected, got %v", status.Code())
+	}
+}
+
+func podGroupPod(name, group string) *corev1.Pod {
+	pod := testPod(name)
+	pod.Labels = map[string]string{util.LabelPodGroup: group}
+	// The PodGroup's size wins over these.
+	pod.Annotations = map[string]string{
+		util.AnnoClaim:    "1",
+		util.AnnoGang:     group,
+		util.AnnoGangSize: "3",
+	}
+	return pod
+}
+
+func podGroup(t *testing.T, resource schema.GroupVersionResource, name string, minMember int64) *unstructured.Unstructured {
+	t.Helper()
+	group := &unstructured.Unstructured{}
+	group.SetAPIVersion(resource.GroupVersion().String())
+	group.SetKind("PodGroup")
+	group.SetNamespace("default")
+	group.SetName(name)
+	if err := unstructured.SetNestedField(group.Object, minMember, "spec", "minMember"); err != nil {
+		t.Fatalf("SetNestedField: %v", err)
+	}
+	return group
+}
+
+func TestPermitPodGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{podGroupResource: "PodGroupList", legacyPodGroupResource: "PodGroupList"},
+		podGroup(t, podGroupResource, "job", 2), podGroup(t, legacyPodGroupResource, "legacy-job", 2))
+
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3, 4, 5))
+	p.podGroups = newPodGroups(ctx, client)
+	for _, source := range p.podGroups.sources {
+		if !cache.WaitForCacheSync(ctx.Done(), source.synced) {
+			t.Fatalf("PodGroup informer did not sync")
+		}
+	}
+
+	_, _, w0 := reserveAndPermit(t, p, podGroupPod("worker-0", "job"))
+	if w0 == nil {
+		t.Fatalf("Expected the first member to wait")
+	}
+	_, status, w1 := reserveAndPermit(t, p, podGroupPod("worker-1", "job"))
+	if w1 != nil || !status.IsSuccess() {
+		t.Fatalf("Expected minMember 2 to complete the gang, got %v", status.Message())
+	}
+	if !w0.allowed {
+		t.Errorf("Expected the waiting member to be allowed")
+	}
+
+	// Groups of the API group earlier scheduler-plugins releases served are
+	// read through their label.
+	legacy := func(name string) *corev1.Pod {
+		pod := podGroupPod(name, "legacy-job")
+		pod.Labels = map[string]string{util.LabelPodGroupLegacy: "legacy-job"}
+		return pod
+	}
+	_, _, l0 := reserveAndPermit(t, p, legacy("legacy-0"))
+	if l0 == nil {
+		t.Fatalf("Expected the first member of the legacy group to wait")
+	}
+	if _, status, l1 := reserveAndPermit(t, p, legacy("legacy-1")); l1 != nil || !status.IsSuccess() {
+		t.Fatalf("Expected minMember 2 to complete the legacy gang, got %v", status.Message())
+	}
+	if !l0.allowed {
+		t.Errorf("Expected the waiting legacy member to be allowed")
+	}
+
+	// A pod naming a missing PodGroup cannot be sized.
+	missing := podGroupPod("worker-x", "other")
+	if status, _ := p.Permit(ctx, cycleStateFor(1), missing, "node-a"); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected a missing PodGroup to be rejected, got %v", status.Code())
+	}
+
+	// Without the label the annotations still apply.
+	_, _, w := reserveAndPermit(t, p, gangPod("solo-0", "job", "2"))
+	if w == nil {
+		t.Errorf("Expected an annotated member to wait in its own gang")
+	}
+}
+
+func TestPodGroupNotLoaded(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	p.podGroups = &podGroups{sources: map[schema.GroupVersionResource]podGroupSource{
+		podGroupResource: {synced: func() bool { return false }},
+	}}
+
+	// Rejected before Reserve, and retried once the PodGroups load.
+	pod := podGroupPod("worker-0", "job")
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), pod); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected PreFilter to retry while PodGroups load, got %v: %v", status.Code(), status.Message())
+	}
+	if status, _ := p.Permit(ctx, cycleStateFor(1), pod, "node-a"); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected Permit to retry while PodGroups load, got %v: %v", status.Code(), status.Message())
+	}
+
+	bad := gangPod("worker-1", "job", "many")
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), bad); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected PreFilter to reject an invalid gang size, got %v", status.Code())
+	}
+}
@@ -0,0 +1,123 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func memoryNode(name, capacity, memory string) *corev1.Node {
+	node := gpuNode(name, capacity)
+	if memory != "" {
+		node.Labels[util.LabelMemory] = memory
+	}
+	return node
+}
+
+func claimState(t *testing.T, p *Plugin, claim string) *framework.CycleState {
+	t.Helper()
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: claim}
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(context.Background(), state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	return state
+}
+
+func TestFilterGPUMemory(t *testing.T) {
+	p := newTestPlugin()
+	state := claimState(t, p, "1,mem=40Gi")
+	tests := []struct {
+		name string
+		node *corev1.Node
+		want framework.Code
+	}{
+		{name: "enough memory", node: memoryNode("node-a", "2", "80Gi"), want: framework.Success},
+		{name: "too little memory", node: memoryNode("node-b", "2", "24Gi"), want: framework.UnschedulableAndUnresolvable},
+		{name: "memory not advertised", node: memoryNode("node-c", "2", ""), want: framework.UnschedulableAndUnresolvable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Filter(context.Background(), state, testPod("trainer"), nodeInfo(tt.node)).Code(); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFractionalMemoryOvercommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		cotenant int64
+		want     framework.Code
+	}{
+		{name: "fits beside co-tenant", cotenant: 24 << 30, want: framework.Success},
+		{name: "co-tenant leaves too little", cotenant: 48 << 30, want: framework.Unschedulable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			node := memoryNode("node-a", "1", "80Gi")
+			p := newTestPlugin(gpuNodeStatus("node-a", 0))
+			p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+			if err := lease.AcquireFraction(ctx, p.coord, lease.DefaultLabelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), "", 0, 0.25, tt.cotenant); err != nil {
+				t.Fatalf("AcquireFraction: %v", err)
+			}
+
+			// Only a quarter of the device is shared, so memory decides.
+			state := claimState(t, p, "0.25,mem=40Gi")
+			if got := p.Filter(ctx, state, testPod("trainer"), nodeInfo(node)).Code(); got != tt.want {
+				t.Fatalf("Filter: expected %v, got %v", tt.want, got)
+			}
+			if got := p.Reserve(ctx, state, testPod("trainer"), "node-a").Code(); got != tt.want {
+				t.Fatalf("Reserve: expected %v, got %v", tt.want, got)
+			}
+			if tt.want != framework.Success {
+				return
+			}
+			held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "node-a")
+			if reserved := lease.DeviceMemory(held, 80<<30)[0]; reserved != tt.cotenant+40<<30 {
+				t.Errorf("Expected the new share to reserve 40Gi, got %d bytes in total", reserved)
+			}
+		})
+	}
+}
+
+func TestPreFilterReadsGpuClaimSpec(t *testing.T) {
+	mem := resource.MustParse("40Gi")
+	claim := &apiv1.GpuClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "training", Namespace: "default"},
+		Spec:       apiv1.GpuClaimSpec{Devices: apiv1.DeviceRequest{Count: 2, Model: "A100", Memory: &mem}},
+	}
+	tests := []struct {
+		name       string
+		annotation string
+		wantModel  string
+		wantMemory int64
+	}{
+		{name: "spec fills in the qualifiers", annotation: "training", wantModel: "A100", wantMemory: 40 << 30},
+		{name: "annotation qualifiers win", annotation: "training,model=H100,mem=80Gi", wantModel: "H100", wantMemory: 80 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := claimState(t, newTestPlugin(claim), tt.annotation)
+			data, err := readState(state)
+			if err != nil {
+				t.Fatalf("readState: %v", err)
+			}
+			if data.reqCount != 2 || data.model != tt.wantModel || data.memory != tt.wantMemory {
+				t.Errorf("Expected count 2, model %q and memory %d, got %d, %q and %d",
+					tt.wantModel, tt.wantMemory, data.reqCount, data.model, data.memory)
+			}
+		})
+	}
+}
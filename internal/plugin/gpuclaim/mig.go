@@ -0,0 +1,146 @@
+package gpuclaim
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// filterMIG rejects nodes that do not advertise enough free instances of the
+// pod's MIG profile.
+func (p *Plugin) filterMIG(ctx context.Context, data *stateData, node *corev1.Node) *framework.Status {
+	if v := p.nodeVendor(node); !v.MIG {
+		msg := fmt.Sprintf("node %s has %s GPUs, which do not support MIG profiles", node.Name, v.Name)
+		return data.reject(node.Name, reasonNoMIG, framework.UnschedulableAndUnresolvable, msg)
+	}
+	free, capacity, err := p.freeMIG(ctx, node, data.migProfile)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	if capacity == 0 {
+		msg := fmt.Sprintf("node %s has no MIG %s instances", node.Name, data.migProfile)
+		return data.reject(node.Name, reasonNoInstances, framework.UnschedulableAndUnresolvable, msg)
+	}
+	if free < data.reqCount {
+		msg := fmt.Sprintf("insufficient free MIG %s instances on node %s (requested=%d, free=%d, capacity=%d)", data.migProfile, node.Name, data.reqCount, free, capacity)
+		return data.reject(node.Name, reasonMIGBusy, framework.Unschedulable, msg)
+	}
+	return nil
+}
+
+// freeMIG returns the node's unleased instance count for profile alongside
+// the count it advertises through its MIG labels. Instances on a GPU leased
+// whole or in part are not free; which GPU an instance lives on is only in
+// the node's GpuNodeStatus, read when such a lease exists.
+func (p *Plugin) freeMIG(ctx context.Context, node *corev1.Node, profile string) (int, int, error) {
+	capacity := util.NodeMIGCapacity(node, profile)
+	held, err := p.heldLeases(ctx, node.Name)
+	if err != nil {
+		return 0, capacity, err
+	}
+	inUse := lease.MIGInUse(held, profile)
+	free := capacity - len(inUse)
+	if busy := lease.HeldDevices(lease.WithoutMIG(held)); len(busy) > 0 {
+		gns, err := p.getGpuNodeStatus(ctx, node.Name)
+		if err != nil {
+			return 0, capacity, fmt.Errorf("get GpuNodeStatus: %w", err)
+		}
+		for _, inst := range gns.Status.MIGInstances {
+			if inst.Profile == profile && busy[inst.Device] && !inUse[inst.ID] {
+				free--
+			}
+		}
+	}
+	if free < 0 {
+		free = 0
+	}
+	return free, capacity, nil
+}
+
+// reserveMIG leases MIG instances of the pod's profile from the candidates
+// migCandidates lists.
+func (p *Plugin) reserveMIG(
+	ctx context.Context,
+	cycleState *framework.CycleState,
+	data *stateData,
+	pod *corev1.Pod,
+	nodeName string,
+	gns *apiv1.GpuNodeStatus,
+	held []coordv1.Lease,
+) *framework.Status {
+	var ids []int
+	var uuids []string
+	for _, inst := range migCandidates(data, gns, held) {
+		if len(ids) >= data.reqCount {
+			break
+		}
+		ok, err := lease.TryAcquireMIG(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, string(pod.UID), podKey(pod), data.migProfile, data.antiAffinity, inst.ID, inst.Device)
+		if err != nil {
+			klog.V(4).InfoS("MIG lease acquisition failed", "node", nodeName, "migID", inst.ID, "err", err)
+			continue
+		}
+		if ok {
+			ids = append(ids, inst.ID)
+			uuids = append(uuids, inst.UUID)
+		}
+	}
+
+	if len(ids) < data.reqCount {
+		for _, id := range ids {
+			_ = lease.ReleaseMIG(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, id)
+		}
+		err := lease.Shortagef("not enough MIG %s instances available on node %s (requested=%d)", data.migProfile, nodeName, data.reqCount)
+		return framework.NewStatus(framework.Unschedulable).WithError(err)
+	}
+
+	data.chosenIDs = ids
+	data.chosenUUIDs = uuids
+	cycleState.Write(Name, data)
+	return nil
+}
+
+// migCandidates lists, by ascending id, the free instances of the pod's
+// profile the node agent reported, skipping instances on a GPU leased whole
+// or in part, and on a GPU that already holds an instance or share for the
+// pod's anti-affinity group.
+func migCandidates(data *stateData, gns *apiv1.GpuNodeStatus, held []coordv1.Lease) []apiv1.MIGInstance {
+	inUse := lease.MIGInUse(held, data.migProfile)
+	busy := lease.HeldDevices(lease.WithoutMIG(held))
+	avoid := antiAffineDevices(lease.AntiAffine(held, data.antiAffinity), gns)
+
+	instances := slices.SortedFunc(slices.Values(gns.Status.MIGInstances), func(a, b apiv1.MIGInstance) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return slices.DeleteFunc(instances, func(inst apiv1.MIGInstance) bool {
+		// Instances without a UUID cannot be handed to the container runtime.
+		return inst.Profile != data.migProfile || inst.UUID == "" || inUse[inst.ID] || busy[inst.Device] || avoid[inst.Device]
+	})
+}
+
+// antiAffineDevices returns the GPUs the leases of an anti-affinity group
+// hold whole, in part or through MIG instances. MIG instance leases that name
+// no GPU are placed through the instances gns reports; gns may be nil when
+// group has none.
+func antiAffineDevices(group []coordv1.Lease, gns *apiv1.GpuNodeStatus) map[int]bool {
+	avoid := lease.HeldDevices(group)
+	unplaced := lease.UnplacedMIG(group)
+	if len(unplaced) == 0 || gns == nil {
+		return avoid
+	}
+	for _, inst := range gns.Status.MIGInstances {
+		if unplaced[inst.ID] {
+			avoid[inst.Device] = true
+		}
+	}
+	return avoid
+}
@@ -0,0 +1,154 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func migNode(name, profile, count string) *corev1.Node {
+	node := gpuNode(name, "1")
+	node.Labels[util.MIGCountLabel(profile)] = count
+	return node
+}
+
+func migPod(name, profile string) *corev1.Pod {
+	pod := testPod(name)
+	pod.Annotations = map[string]string{
+		util.AnnoClaim:      "1",
+		util.AnnoMIGProfile: profile,
+	}
+	return pod
+}
+
+func TestFilterMIGProfile(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	pod := migPod("infer", "1g.5gb")
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+
+	tests := []struct {
+		name string
+		node *corev1.Node
+		want framework.Code
+	}{
+		{name: "matching profile", node: migNode("node-a", "1g.5gb", "7"), want: framework.Success},
+		{name: "other profile only", node: migNode("node-b", "3g.20gb", "2"), want: framework.UnschedulableAndUnresolvable},
+		{name: "no MIG labels", node: gpuNode("node-c", "8"), want: framework.UnschedulableAndUnresolvable},
+		{name: "zero instances advertised", node: migNode("node-d", "1g.5gb", "0"), want: framework.UnschedulableAndUnresolvable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Filter(ctx, state, pod, nodeInfo(tt.node)).Code(); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPreFilterRejectsInvalidMIGProfile(t *testing.T) {
+	p := newTestPlugin()
+	for _, pod := range []*corev1.Pod{migPod("bad-name", "1g"), {
+		ObjectMeta: metav1.ObjectMeta{Name: "fraction", Annotations: map[string]string{
+			util.AnnoClaim:      "0.5",
+			util.AnnoMIGProfile: "1g.5gb",
+		}},
+	}} {
+		_, status := p.PreFilter(context.Background(), framework.NewCycleState(), pod)
+		if status.Code() != framework.UnschedulableAndUnresolvable {
+			t.Errorf("%s: expected UnschedulableAndUnresolvable, got %v", pod.Name, status.Code())
+		}
+	}
+}
+
+func TestReserveMIGInstances(t *testing.T) {
+	ctx := context.Background()
+	gns := gpuNodeStatus("node-a", 0)
+	gns.Status.MIGInstances = []apiv1.MIGInstance{
+		{ID: 0, Device: 0, Profile: "3g.20gb", UUID: "MIG-aaaa"},
+		{ID: 1, Device: 0, Profile: "1g.5gb", UUID: "MIG-bbbb"},
+		{ID: 2, Device: 0, Profile: "1g.5gb", UUID: "MIG-cccc"},
+	}
+	pod := migPod("infer", "1g.5gb")
+	pod.Spec.Containers = []corev1.Container{{Name: "server"}}
+	p := newTestPlugin(gns, pod)
+	node := migNode("node-a", "1g.5gb", "2")
+
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+	got, _ := p.client.CoreV1().Pods("default").Get(ctx, "infer", metav1.GetOptions{})
+	if v := got.Annotations[util.ContainerAllocatedKey("server")]; v != "MIG-bbbb" {
+		t.Errorf("Expected the first free 1g.5gb UUID, got %q", v)
+	}
+	if v := got.Annotations[util.AnnoAllocated]; v != `{"server":[1]}` {
+		t.Errorf("Expected the instance id in the allocation map, got %q", v)
+	}
+
+	// One of two instances is leased now, and its GPU cannot be leased whole.
+	if free, _, _ := p.freeMIG(ctx, node, "1g.5gb"); free != 1 {
+		t.Errorf("Expected 1 free 1g.5gb instance, got %d", free)
+	}
+	if free, _, _ := p.freeGPUs(ctx, node); free != 0 {
+		t.Errorf("Expected the MIG lease to take its GPU, got %d free", free)
+	}
+
+	p.Unreserve(ctx, state, pod, "node-a")
+	if free, _, _ := p.freeMIG(ctx, node, "1g.5gb"); free != 2 {
+		t.Errorf("Expected the instance to be released, got %d free", free)
+	}
+}
+
+func TestMIGAvoidsLeasedDevices(t *testing.T) {
+	ctx := context.Background()
+	gns := gpuNodeStatus("node-a", 0, 1)
+	gns.Status.MIGInstances = []apiv1.MIGInstance{
+		{ID: 0, Device: 0, Profile: "1g.5gb", UUID: "MIG-aaaa"},
+		{ID: 1, Device: 1, Profile: "1g.5gb", UUID: "MIG-bbbb"},
+	}
+	pod := migPod("infer", "1g.5gb")
+	pod.Spec.Containers = []corev1.Container{{Name: "server"}}
+	p := newTestPlugin(gns, pod)
+	node := migNode("node-a", "1g.5gb", "2")
+	node.Labels[util.LabelCapacity] = "2"
+
+	// A whole claim holds GPU 0 and a share GPU 1.
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-whole", podRef("default", "whole"), 0); err != nil {
+		t.Fatalf("TryAcquire: %v", err)
+	}
+	if free, _, _ := p.freeMIG(ctx, node, "1g.5gb"); free != 1 {
+		t.Errorf("Expected the instance on the leased GPU not to be free, got %d free", free)
+	}
+	if err := lease.AcquireFraction(ctx, p.coord, lease.DefaultLabelPrefix, lease.DefaultNamespace, "node-a", "uid-share", podRef("default", "share"), "", 1, 0.5, 0); err != nil {
+		t.Fatalf("AcquireFraction: %v", err)
+	}
+
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if status := p.Filter(ctx, state, pod, nodeInfo(node)); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected Filter to find no free instance, got %v: %s", status.Code(), status.Message())
+	}
+	if status := p.Reserve(ctx, state, pod, "node-a"); status.IsSuccess() {
+		t.Errorf("Expected Reserve not to lease an instance on a leased GPU")
+	}
+}
@@ -0,0 +1,71 @@
+package gpuclaim
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// namedDeviceID returns the id of the GPU among devices whose UUID, by the
+// node's util.AnnoDeviceUUIDs annotation, is uuid. It reports false when the
+// node lists no such GPU or may not hand it out.
+func namedDeviceID(node *corev1.Node, devices []int, uuid string) (int, bool) {
+	uuids, err := util.NodeDeviceUUIDs(node)
+	if err != nil {
+		klog.V(4).InfoS("ignoring device UUIDs", "node", node.Name, "err", err)
+		return 0, false
+	}
+	for id, u := range uuids {
+		if strings.EqualFold(u, uuid) && slices.Contains(devices, id) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// filterNamed admits node for a claim naming data.device when the node has
+// that GPU and no lease claims any of it.
+func filterNamed(data *stateData, node *corev1.Node, devices []int, usage map[int]float64) *framework.Status {
+	id, ok := namedDeviceID(node, devices, data.device)
+	if !ok {
+		msg := fmt.Sprintf("node %s has no GPU %s", node.Name, data.device)
+		return data.reject(node.Name, reasonNoDevice, framework.UnschedulableAndUnresolvable, msg)
+	}
+	if usage[id] > 0 {
+		msg := fmt.Sprintf("GPU %s (device %d) on node %s is in use", data.device, id, node.Name)
+		return data.reject(node.Name, reasonDeviceBusy, framework.Unschedulable, msg)
+	}
+	return nil
+}
+
+// namedOrder returns the one device Reserve may lease on node for a claim
+// naming data.device, or why it cannot.
+func namedOrder(data *stateData, node *corev1.Node, devices []int, busy map[int]bool) ([]int, *framework.Status) {
+	if node == nil {
+		return nil, framework.NewStatus(framework.Unschedulable, fmt.Sprintf("cannot look up GPU %s: node is not in the snapshot", data.device))
+	}
+	id, ok := namedDeviceID(node, devices, data.device)
+	if !ok {
+		return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, fmt.Sprintf("node %s has no GPU %s", node.Name, data.device))
+	}
+	if busy[id] {
+		return nil, framework.NewStatus(framework.Unschedulable, fmt.Sprintf("GPU %s (device %d) on node %s is in use", data.device, id, node.Name))
+	}
+	return []int{id}, nil
+}
+
+// gnsDeviceIDs returns the ids of the devices gns lists.
+func gnsDeviceIDs(gns *apiv1.GpuNodeStatus) []int {
+	ids := make([]int, len(gns.Status.Devices))
+	for i, dev := range gns.Status.Devices {
+		ids[i] = dev.ID
+	}
+	return ids
+}
@@ -0,0 +1,66 @@
+package gpuclaim
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestNamedDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   string
+		busy     bool
+		wantCode framework.Code
+		wantMsg  string
+	}{
+		{name: "named device free", device: "GPU-cccc"},
+		{name: "UUID case ignored", device: "gpu-CCCC"},
+		{name: "named device busy", device: "GPU-cccc", busy: true, wantCode: framework.Unschedulable, wantMsg: "GPU GPU-cccc (device 2) on node node-a is in use"},
+		{name: "no such device", device: "GPU-ffff", wantCode: framework.UnschedulableAndUnresolvable, wantMsg: "node node-a has no GPU GPU-ffff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			node := gpuNode("node-a", "4")
+			node.Annotations = map[string]string{util.AnnoDeviceUUIDs: "0=GPU-aaaa,1=GPU-bbbb,2=GPU-cccc,3=GPU-dddd"}
+			p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+			p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+			if tt.busy {
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), 2); err != nil {
+					t.Fatalf("TryAcquire: %v", err)
+				}
+			}
+			pod := testPod("bench")
+			pod.Annotations = map[string]string{util.AnnoClaim: "device=" + tt.device}
+			state := framework.NewCycleState()
+			if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+				t.Fatalf("PreFilter: %v", status.Message())
+			}
+
+			filterStatus := p.Filter(ctx, state, pod, nodeInfo(node))
+			reserveStatus := p.Reserve(ctx, state, pod, "node-a")
+			for phase, status := range map[string]*framework.Status{"Filter": filterStatus, "Reserve": reserveStatus} {
+				if status.Code() != tt.wantCode {
+					t.Errorf("Expected %s to return %v, got %v: %s", phase, tt.wantCode, status.Code(), status.Message())
+				}
+				if !strings.Contains(status.Message(), tt.wantMsg) {
+					t.Errorf("Expected %s message containing %q, got %q", phase, tt.wantMsg, status.Message())
+				}
+			}
+			if tt.wantCode != framework.Success {
+				return
+			}
+			data, _ := readState(state)
+			if !slices.Equal(data.chosenIDs, []int{2}) {
+				t.Errorf("Expected device 2 to be reserved, got %v", data.chosenIDs)
+			}
+		})
+	}
+}
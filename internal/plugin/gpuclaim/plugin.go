@@ -1,25 +1,47 @@
 package gpuclaim
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
+	coordv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	coordclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
 	"k8s.io/klog/v2"
 	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/helper"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/audit"
+	"github.com/restack/gpu-scheduler/internal/controller"
 	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/topo"
 	"github.com/restack/gpu-scheduler/internal/util"
 )
 
@@ -29,23 +51,56 @@ const (
 
 	defaultGPUCount = 1
 	maxGPUID        = 16 // MVP assumption: at most 17 devices per host. Can be 64 with virtual GPUs on NVIDIA H200, B200
+
+	// islandTier is added to the score of a node that can place the whole
+	// claim inside one NVLink island. It exceeds any count of free GPUs, so
+	// NormalizeScore can tell the two tiers apart.
+	islandTier = maxGPUID + 1
 )
 
 var (
-	_ framework.PreFilterPlugin = &Plugin{}
-	_ framework.FilterPlugin    = &Plugin{}
-	_ framework.ScorePlugin     = &Plugin{}
-	_ framework.ReservePlugin   = &Plugin{}
-	_ framework.PreBindPlugin   = &Plugin{}
-	_ framework.StateData       = &stateData{}
+	_ framework.PreFilterPlugin  = &Plugin{}
+	_ framework.FilterPlugin     = &Plugin{}
+	_ framework.PostFilterPlugin = &Plugin{}
+	_ framework.PreScorePlugin   = &Plugin{}
+	_ framework.ScorePlugin      = &Plugin{}
+	_ framework.ScoreExtensions  = &Plugin{}
+	_ framework.ReservePlugin    = &Plugin{}
+	_ framework.PermitPlugin     = &Plugin{}
+	_ framework.PreBindPlugin    = &Plugin{}
+	_ framework.BindPlugin       = &Plugin{}
+	_ framework.StateData        = &stateData{}
 )
 
 // stateData is stored in CycleState.
 type stateData struct {
 	claimName  string
 	reqCount   int
-	chosenIDs  []int
-	chosenNode string
+	fraction   float64
+	migProfile string
+	model      string
+	// memory is the GPU memory needed on each device, in bytes.
+	memory int64
+	// fractionLimit is the share a fractional claim may burst to; zero for
+	// none.
+	fractionLimit float64
+	// antiAffinity is the pod's device anti-affinity group, if any.
+	antiAffinity string
+	// device is the UUID of the one GPU the claim names, if any.
+	device    string
+	chosenIDs []int
+	// chosenUUIDs holds the MIG instance UUIDs matching chosenIDs.
+	chosenUUIDs []string
+	chosenNode  string
+	// annotated lists the allocation annotations PreBind sent to the pod,
+	// for Unreserve to take back should the pod not be bound.
+	annotated []string
+	// draClaims are the pod's ResourceClaims the plugin allocates, when the
+	// pod has no claim annotation and DRA support is on.
+	draClaims []draClaim
+	// reasons tallies Filter's rejections for PostFilter. Clones get a copy,
+	// so the Filter calls of a preemption dry run do not add to the cycle's.
+	reasons *filterReasons
 }
 
 func (s *stateData) Clone() framework.StateData {
@@ -54,6 +109,9 @@ func (s *stateData) Clone() framework.StateData {
 	}
 	out := *s
 	out.chosenIDs = append([]int(nil), s.chosenIDs...)
+	out.chosenUUIDs = append([]string(nil), s.chosenUUIDs...)
+	out.annotated = append([]string(nil), s.annotated...)
+	out.reasons = s.reasons.clone()
 	return &out
 }
 
@@ -62,124 +120,1034 @@ type Plugin struct {
 	client    clientset.Interface
 	coord     coordclient.CoordinationV1Interface
 	crcClient crclient.Client
+	args      Args
+	handle    framework.Handle
+	gangs     *gangStore
+	// leaseNamespace is the namespace every lease is created in.
+	leaseNamespace string
+	// labelPrefix names this scheduler instance's leases.
+	labelPrefix string
+	// inventory serves Filter and Score the leases held on each node. When
+	// nil or not yet synced, leases are listed from the API server.
+	inventory *lease.Inventory
+	// quotas is nil unless Args.QuotaConfigMap is set.
+	quotas *quotas
+	// tracer starts the phase spans; nil uses the global tracer provider.
+	tracer trace.Tracer
+	// draClass is the DeviceClass of the ResourceClaims the plugin
+	// allocates; empty leaves ResourceClaims alone.
+	draClass  string
+	draDriver string
+	// vendor decides which extended resource holds node and container GPUs,
+	// except on nodes whose util.LabelVendor names another vendor.
+	vendor util.Vendor
+	// deviceIDFormat is DeviceIDIndex or DeviceIDUUID.
+	deviceIDFormat string
+	// deviceSelection is the DeviceSelection* policy Reserve picks free
+	// devices by.
+	deviceSelection string
+	// maxGPUsPerPod caps the GPUs one pod may claim; 0 sets no cap.
+	maxGPUsPerPod int
+	// callTimeout bounds each API call outside p.coord, which bounds its
+	// own; zero leaves calls bounded by the scheduling context alone.
+	callTimeout time.Duration
+	// defragRebalance lets PostFilter evict pods to gather free GPUs that
+	// are scattered across nodes.
+	defragRebalance bool
+	// podGroups, when set, sizes the gangs of pods in a coscheduling
+	// PodGroup.
+	podGroups *podGroups
+	// auditSink, when set, records every allocation and release.
+	auditSink audit.Sink
+	// pods serves the lease holders PostFilter looks at from the
+	// scheduler's pod informer; nil reads them from the API server.
+	pods corelisters.PodLister
 }
 
 // Name satisfies framework.Plugin interface.
 func (p *Plugin) Name() string { return Name }
 
-// New constructs a Plugin instance.
-// func New(_ context.Context, _ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
-// 	return &Plugin{
-// 		client: handle.ClientSet(),
-// 		coord:  handle.ClientSet().CoordinationV1(),
-// 	}, nil
-// }
+// Options carries settings from the scheduler command line, as opposed to
+// Args, which come from the scheduler profile.
+type Options struct {
+	// LeaseGCInterval is how often orphaned GPU leases are collected.
+	LeaseGCInterval time.Duration
+	// LeaseGCJitter is the fraction of LeaseGCInterval by which each wait
+	// between collections varies either way.
+	LeaseGCJitter float64
+	// LeaseGCGrace is how long a lease's pod must stay missing before the
+	// lease is collected.
+	LeaseGCGrace time.Duration
+	// LeaseGCUnknownGrace is how long a lease's pod may stay in phase Unknown
+	// before the lease is collected.
+	LeaseGCUnknownGrace time.Duration
+	// LeaseGCDeleteQPS and LeaseGCDeleteBurst pace the collector's deletes.
+	LeaseGCDeleteQPS   float64
+	LeaseGCDeleteBurst int
+	// LeaseGCWorkers is how many leases the collector handles at once.
+	LeaseGCWorkers int
+	// LeaseGCLeaderElect runs the collector only on the scheduler replica
+	// holding the LeaseGCElection lease.
+	LeaseGCLeaderElect bool
+	LeaseGCElection    lease.LeaderElection
+	// LeaseGCDryRun logs the leases the collector would delete instead of
+	// deleting them.
+	LeaseGCDryRun bool
+	// LeaseGCStaleRenewals is how many lease durations a renewed lease may
+	// go unrenewed before it is collected; zero disables the check.
+	LeaseGCStaleRenewals int
+	// LeaseGCBindTimeout is how long a reservation is kept for a pod that is
+	// never bound; zero disables the check.
+	LeaseGCBindTimeout time.Duration
+	// LeaseGCClearStaleAllocations removes the old instance's allocation
+	// annotations from a pod whose lease is collected for a UID mismatch.
+	LeaseGCClearStaleAllocations bool
+	// LeaseGCSoftReclaim marks a lease with a UID mismatch for reclaim and
+	// deletes it only on a later pass.
+	LeaseGCSoftReclaim bool
+	// LeaseNamespace is the namespace Reserve creates leases in; empty means
+	// lease.DefaultNamespace.
+	LeaseNamespace string
+	// LeaseGCNamespaces limits the collector to the leases in these
+	// namespaces, and the scheduler's lease reads to them and
+	// LeaseNamespace; empty works cluster-wide.
+	LeaseGCNamespaces []string
+	// ClaimController runs the controller that reports allocations on
+	// GpuClaim status.
+	ClaimController bool
+	// DRAResourceClaims allocates GPUs for pods whose ResourceClaims request
+	// DRADeviceClass, publishing the results under DRADriver.
+	DRAResourceClaims bool
+	DRADeviceClass    string
+	DRADriver         string
+	// GPUVendor names the util.Vendor whose GPUs are scheduled; empty means
+	// util.DefaultVendor.
+	GPUVendor string
+	// DeviceIDFormat is how PreBind names devices to containers: DeviceIDIndex
+	// (default) or DeviceIDUUID.
+	DeviceIDFormat string
+	// DeviceSelectionPolicy is how Reserve picks among a node's free
+	// devices: DeviceSelectionLowest (default), DeviceSelectionMRU or
+	// DeviceSelectionLRU.
+	DeviceSelectionPolicy string
+	// LabelPrefix names this scheduler instance's leases, apart from those
+	// of other instances in the cluster; empty means lease.DefaultLabelPrefix.
+	LabelPrefix string
+	// MaxGPUsPerPod rejects claims for more GPUs than this in PreFilter, as
+	// the webhook should already have; 0 sets no cap.
+	MaxGPUsPerPod int
+	// APICallTimeout bounds each API call the plugin and the lease collector
+	// make; zero leaves calls unbounded.
+	APICallTimeout time.Duration
+	// SimulateAddr, when set, is the listen address of the POST /simulate
+	// endpoint that predicts where a claim would land.
+	SimulateAddr string
+	// AdminAddr, when set, is the listen address of the GET /inventory
+	// endpoint listing each node's devices as the plugin sees them. It may
+	// be the same as SimulateAddr. kube-scheduler builds the mux of its
+	// metrics port itself, so the endpoint needs a listener of its own.
+	AdminAddr string
+	// DefragRebalance lets PostFilter move lower-priority pods onto other
+	// nodes' free GPUs when a claim only fails because those GPUs are
+	// scattered. Without it, PostFilter only reports the fragmentation.
+	DefragRebalance bool
+	// PodGroups has Permit treat the pods of a coscheduling PodGroup
+	// (scheduling.x-k8s.io or the legacy scheduling.sigs.k8s.io) as a gang
+	// of the group's minMember, instead of reading the gang annotations.
+	PodGroups bool
+	// AuditLog is where allocations and releases are recorded as JSON
+	// lines: a file appended to, or "-" for stdout. Empty records nothing.
+	AuditLog string
+	// AuditSink receives the audit records instead of AuditLog, for callers
+	// shipping them elsewhere.
+	AuditSink audit.Sink
+}
+
+const (
+	// DeviceIDIndex hands containers device indices, e.g. "0,3".
+	DeviceIDIndex = "index"
+	// DeviceIDUUID hands containers the UUIDs in the node's
+	// util.AnnoDeviceUUIDs annotation, falling back to indices for a node
+	// that lacks one of the allocated devices.
+	DeviceIDUUID = "uuid"
+)
+
+// New constructs a Plugin instance with default Options.
+func New(ctx context.Context, obj runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	return newPlugin(ctx, obj, handle, Options{
+		LeaseGCInterval:      lease.DefaultGCInterval,
+		LeaseGCJitter:        lease.DefaultGCJitter,
+		LeaseGCGrace:         lease.DefaultGCGrace,
+		LeaseGCUnknownGrace:  lease.DefaultGCUnknownGrace,
+		LeaseGCWorkers:       lease.DefaultGCWorkers,
+		LeaseGCLeaderElect:   true,
+		LeaseGCStaleRenewals: lease.DefaultStaleRenewals,
+		LeaseGCBindTimeout:   lease.DefaultGCBindTimeout,
+		ClaimController:      true,
+		APICallTimeout:       lease.DefaultAPICallTimeout,
+	})
+}
+
+// NewFactory returns a plugin factory bound to opts. opts is only read when
+// the scheduler builds the plugin, so it may be filled in by flag parsing
+// after registration.
+func NewFactory(opts *Options) frameworkruntime.PluginFactory {
+	return func(ctx context.Context, obj runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+		return newPlugin(ctx, obj, handle, *opts)
+	}
+}
 
-func New(_ context.Context, _ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+func newPlugin(ctx context.Context, obj runtime.Object, handle framework.Handle, opts Options) (framework.Plugin, error) {
+	args, err := decodeArgs(obj)
+	if err != nil {
+		return nil, err
+	}
+	if opts.GPUVendor == "" {
+		opts.GPUVendor = util.DefaultVendor
+	}
+	vendor, err := util.LookupVendor(opts.GPUVendor)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.DeviceIDFormat {
+	case "":
+		opts.DeviceIDFormat = DeviceIDIndex
+	case DeviceIDIndex, DeviceIDUUID:
+	default:
+		return nil, fmt.Errorf("invalid device ID format %q: must be %s or %s", opts.DeviceIDFormat, DeviceIDIndex, DeviceIDUUID)
+	}
+	switch opts.DeviceSelectionPolicy {
+	case "":
+		opts.DeviceSelectionPolicy = DeviceSelectionLowest
+	case DeviceSelectionLowest, DeviceSelectionMRU, DeviceSelectionLRU:
+	default:
+		return nil, fmt.Errorf("invalid device selection policy %q: must be %s, %s or %s",
+			opts.DeviceSelectionPolicy, DeviceSelectionLowest, DeviceSelectionMRU, DeviceSelectionLRU)
+	}
+	if err := lease.ValidateLabelPrefix(opts.LabelPrefix); err != nil {
+		return nil, err
+	}
+	if opts.LabelPrefix == "" {
+		opts.LabelPrefix = lease.DefaultLabelPrefix
+	}
+	if opts.LeaseNamespace == "" {
+		opts.LeaseNamespace = lease.DefaultNamespace
+	}
 	cs := handle.ClientSet()
 
 	scheme := runtime.NewScheme()
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(apiv1.AddToScheme(scheme))
 
-	cfg, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, fmt.Errorf("build kube config: %v", err)
-	}
+	// Reach the API server the scheduler does, through its --kubeconfig or
+	// in-cluster config. Its protobuf content type does not work for CRDs.
+	cfg := rest.CopyConfig(handle.KubeConfig())
+	cfg.ContentType, cfg.AcceptContentTypes = "", ""
 
 	c, err := crclient.New(cfg, crclient.Options{Scheme: scheme})
 	if err != nil {
 		return nil, fmt.Errorf("build controller-runtime client: %v", err)
 	}
 
-	// Start the garbage collector
-	lease.StartGC(context.Background(), cs)
+	// The garbage collector starts a pod informer of its own: the
+	// scheduler's leaves out finished pods, whose leases would then only be
+	// collected as missing once the grace period passes.
+	podInformer := handle.SharedInformerFactory().Core().V1().Pods()
+	nodeInformer := handle.SharedInformerFactory().Core().V1().Nodes()
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cs.CoreV1().Events("")})
+	gcOpts := lease.GCOptions{
+		Interval:      opts.LeaseGCInterval,
+		Jitter:        opts.LeaseGCJitter,
+		Grace:         opts.LeaseGCGrace,
+		UnknownGrace:  opts.LeaseGCUnknownGrace,
+		Nodes:         nodeInformer.Lister(),
+		NodesSynced:   nodeInformer.Informer().HasSynced,
+		Recorder:      broadcaster.NewRecorder(clientgoscheme.Scheme, corev1.EventSource{Component: "gpu-scheduler-lease-gc"}),
+		DeleteQPS:     opts.LeaseGCDeleteQPS,
+		DeleteBurst:   opts.LeaseGCDeleteBurst,
+		Workers:       opts.LeaseGCWorkers,
+		DryRun:        opts.LeaseGCDryRun,
+		StaleRenewals: opts.LeaseGCStaleRenewals,
+		BindTimeout:   opts.LeaseGCBindTimeout,
+
+		ClearStaleAllocations: opts.LeaseGCClearStaleAllocations,
+		SoftReclaim:           opts.LeaseGCSoftReclaim,
+		APICallTimeout:        opts.APICallTimeout,
+		Namespaces:            opts.LeaseGCNamespaces,
+		LeaseNamespace:        opts.LeaseNamespace,
+		LabelPrefix:           opts.LabelPrefix,
+	}
+	if opts.LeaseGCLeaderElect {
+		gcOpts.LeaderElection = &opts.LeaseGCElection
+	}
+	// The GpuClaim controller follows the collector's leadership, so
+	// replicas do not race to write the same claim status.
+	if opts.ClaimController {
+		gcOpts.WhileLeading = func(ctx context.Context) {
+			if err := controller.Run(ctx, cfg, scheme, opts.LabelPrefix); err != nil {
+				klog.ErrorS(err, "GpuClaim controller stopped")
+			}
+		}
+	}
+	auditSink := opts.AuditSink
+	if auditSink == nil && opts.AuditLog != "" {
+		if auditSink, err = audit.Open(opts.AuditLog); err != nil {
+			return nil, err
+		}
+	}
+	gcOpts.Audit = auditSink
+	if err := lease.StartGCWithOptions(context.Background(), cs, gcOpts); err != nil {
+		return nil, fmt.Errorf("start lease GC: %v", err)
+	}
+
+	// Leases go through the inventory's tracking client so Reserve and
+	// Unreserve show up in Filter before the informer reports them, and each
+	// lease call is bounded by the API call timeout. With --gc-namespaces the
+	// scheduler watches and lists leases only in those namespaces and the
+	// lease namespace, one informer each, so it never needs to read leases
+	// cluster-wide.
+	scope := leaseScope(opts.LeaseNamespace, opts.LeaseGCNamespaces)
+	var leaseInformers []cache.SharedIndexInformer
+	for _, ns := range scope {
+		leaseInformers = append(leaseInformers, lease.NewInformer(cs, 0, opts.LabelPrefix, ns))
+	}
+	if len(scope) == 0 {
+		leaseInformers = append(leaseInformers, lease.NewInformer(cs, 0, opts.LabelPrefix, metav1.NamespaceAll))
+	}
+	coord := lease.InNamespaces(cs.CoordinationV1(), scope)
+	inventory, err := lease.NewInventory(leaseInformers...)
+	if err != nil {
+		return nil, fmt.Errorf("build lease inventory: %v", err)
+	}
+	nodes := handle.SharedInformerFactory().Core().V1().Nodes().Lister()
+	// The gauge counts the devices Filter may hand out, as nodeDevices reads
+	// them.
+	inventory.SetDevices(func(name string) ([]int, bool) {
+		node, err := nodes.Get(name)
+		if err != nil {
+			return nil, false
+		}
+		ids, _ := util.NodeDeviceIDs(node, util.NodeVendor(node, vendor).NodeCapacity(node))
+		return ids, true
+	})
+	for _, informer := range leaseInformers {
+		go informer.Run(ctx.Done())
+	}
+	go inventory.ObserveWindows(ctx, time.Minute)
+	// After a restart, GPU pods wait in PreFilter until the leases already
+	// held are loaded, so running pods' devices are not handed out again.
+	go adoptLeases(ctx, inventory, lease.WithCallTimeout(coord, opts.APICallTimeout), opts.LabelPrefix)
 
-	return &Plugin{
+	var q *quotas
+	if args.QuotaConfigMap != "" {
+		if q, err = newQuotas(ctx, cs, args.QuotaConfigMap); err != nil {
+			return nil, fmt.Errorf("watch GPU quotas: %v", err)
+		}
+	}
+
+	plugin := &Plugin{
 		client:    cs,
-		coord:     cs.CoordinationV1(),
+		coord:     lease.WithCallTimeout(inventory.Track(coord), opts.APICallTimeout),
 		crcClient: c,
-	}, nil
+		args:      args,
+		handle:    handle,
+		gangs:     newGangStore(),
+		inventory: inventory,
+		quotas:    q,
+		vendor:    vendor,
+
+		leaseNamespace: opts.LeaseNamespace,
+		labelPrefix:    opts.LabelPrefix,
+
+		deviceIDFormat:  opts.DeviceIDFormat,
+		deviceSelection: opts.DeviceSelectionPolicy,
+		maxGPUsPerPod:   opts.MaxGPUsPerPod,
+		callTimeout:     opts.APICallTimeout,
+
+		defragRebalance: opts.DefragRebalance,
+		auditSink:       auditSink,
+		pods:            podInformer.Lister(),
+	}
+	if opts.DRAResourceClaims {
+		plugin.draClass, plugin.draDriver = opts.DRADeviceClass, opts.DRADriver
+		if plugin.draClass == "" {
+			plugin.draClass = DefaultDRADeviceClass
+		}
+		if plugin.draDriver == "" {
+			plugin.draDriver = DefaultDRADriver
+		}
+	}
+	if opts.PodGroups {
+		dc, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("build dynamic client: %v", err)
+		}
+		plugin.podGroups = newPodGroups(ctx, dc)
+	}
+	muxes := map[string]*http.ServeMux{}
+	mount := func(addr, pattern string, h http.Handler) {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(pattern, h)
+	}
+	if opts.SimulateAddr != "" {
+		mount(opts.SimulateAddr, "/simulate", &simulator{p: plugin, nodes: nodes})
+	}
+	if opts.AdminAddr != "" {
+		mount(opts.AdminAddr, "/inventory", &inventoryHandler{p: plugin, nodes: nodes})
+	}
+	for addr, mux := range muxes {
+		go serveAdmin(ctx, addr, mux)
+	}
+	return plugin, nil
 }
 
-// PreFilter reads annotations and seeds scheduler state.
+// PreFilter reads annotations, or with DRA support the pod's ResourceClaims,
+// and seeds scheduler state. Pods without a claim are skipped, so the
+// plugin's Filter does not run for them.
 func (p *Plugin) PreFilter(
 	ctx context.Context,
 	cycleState *framework.CycleState,
 	pod *corev1.Pod,
 ) (*framework.PreFilterResult, *framework.Status) {
-	claimName := pod.GetAnnotations()[util.AnnoClaim]
-	if claimName == "" {
-		return nil, framework.NewStatus(framework.Unschedulable, "gpu claim annotation missing")
+	ctx, span := p.startSpan(ctx, "PreFilter", pod, "")
+	result, status := p.preFilter(ctx, cycleState, pod)
+	endSpan(span, cycleState, status)
+	return result, status
+}
+
+func (p *Plugin) preFilter(
+	ctx context.Context,
+	cycleState *framework.CycleState,
+	pod *corev1.Pod,
+) (*framework.PreFilterResult, *framework.Status) {
+	nodes, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("list nodes: %w", err))
+	}
+	state, status := p.claimState(ctx, pod, nodes)
+	if !status.IsSuccess() {
+		return nil, status
 	}
+	if p.inventory != nil && !p.inventory.Ready() {
+		return nil, framework.NewStatus(framework.Unschedulable, "waiting for the GPU lease inventory to load")
+	}
+	cycleState.Write(Name, state)
+	return nil, nil
+}
 
-	// Fetch the GpuClaim referenced by the pod.
-	claim := &apiv1.GpuClaim{}
-	if err := p.crcClient.Get(ctx, types.NamespacedName{
-		Namespace: pod.Namespace,
-		Name:      claimName,
-	}, claim); err != nil {
-		// Returning Error here would affect the entire scheduler; for now, return Unschedulable
-		msg := fmt.Sprintf("failed to get GpuClaim %q: %v", claimName, err)
-		return nil, framework.NewStatus(framework.Unschedulable, msg)
+// leaseScope returns the namespaces the scheduler reads leases in: none,
+// meaning every namespace, unless gcNamespaces limits it to those and
+// leaseNamespace, where its own leases are made.
+func leaseScope(leaseNamespace string, gcNamespaces []string) []string {
+	if len(gcNamespaces) == 0 {
+		return nil
+	}
+	scope := []string{leaseNamespace}
+	for _, ns := range gcNamespaces {
+		if !slices.Contains(scope, ns) {
+			scope = append(scope, ns)
+		}
+	}
+	return scope
+}
+
+// adoptRetryInterval is how often adoptLeases retries a failed lease list.
+const adoptRetryInterval = 2 * time.Second
+
+// adoptLeases loads the leases of the instance with the given label prefix
+// already held into inv, retrying until that succeeds, the informer syncs
+// first, or ctx is done.
+func adoptLeases(ctx context.Context, inv *lease.Inventory, cli coordclient.CoordinationV1Interface, prefix string) {
+	_ = wait.PollUntilContextCancel(ctx, adoptRetryInterval, true, func(ctx context.Context) (bool, error) {
+		if inv.Ready() {
+			return true, nil
+		}
+		n, err := inv.Adopt(ctx, cli, prefix)
+		if err != nil {
+			klog.ErrorS(err, "failed to adopt GPU leases, retrying", "interval", adoptRetryInterval)
+			return false, nil
+		}
+		klog.InfoS("adopted GPU leases", "leases", n)
+		return true, nil
+	})
+}
+
+// claimState reads the pod's claim into the state the later phases work
+// from, rejecting claims that no node among nodes could ever hold.
+func (p *Plugin) claimState(ctx context.Context, pod *corev1.Pod, nodes []*framework.NodeInfo) (*stateData, *framework.Status) {
+	claimName := pod.GetAnnotations()[util.AnnoClaim]
+	var parsed util.Claim
+	var draClaims []draClaim
+	var err error
+	switch {
+	case claimName != "":
+		if parsed, err = util.ParseClaim(claimName); err != nil {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable).WithError(err)
+		}
+	case p.draClass != "" && len(pod.Spec.ResourceClaims) > 0:
+		var status *framework.Status
+		if draClaims, status = p.resourceClaims(ctx, pod); !status.IsSuccess() {
+			return nil, status
+		}
+		if len(draClaims) == 0 {
+			return nil, framework.NewStatus(framework.Skip)
+		}
+		parsed.Count = draCount(draClaims)
+	default:
+		return nil, framework.NewStatus(framework.Skip)
 	}
 
-	// Use devices.count, default to defaultGPUCount if not specified
-	reqCount := claim.Spec.Devices.Count
-	if reqCount <= 0 {
+	reqCount := parsed.Count
+	if parsed.Name != "" {
+		// Fetch the GpuClaim referenced by the pod.
+		claim := &apiv1.GpuClaim{}
+		callCtx, cancel := p.callContext(ctx)
+		defer cancel()
+		if err := p.crcClient.Get(callCtx, types.NamespacedName{
+			Namespace: pod.Namespace,
+			Name:      parsed.Name,
+		}, claim); err != nil {
+			// Returning Error here would affect the entire scheduler; for now, return Unschedulable
+			msg := fmt.Sprintf("failed to get GpuClaim %q: %v", parsed.Name, err)
+			return nil, framework.NewStatus(framework.Unschedulable, msg)
+		}
+		// Use devices.count, default to defaultGPUCount if not specified
+		reqCount = claim.Spec.Devices.Count
+		// Qualifiers on the annotation win over the claim's spec.
+		if parsed.Model == "" {
+			parsed.Model = claim.Spec.Devices.Model
+		}
+		if parsed.Memory == 0 && claim.Spec.Devices.Memory != nil {
+			parsed.Memory = claim.Spec.Devices.Memory.Value()
+		}
+	}
+	if reqCount <= 0 && parsed.Fraction == 0 {
 		reqCount = defaultGPUCount
 	}
 
+	var migProfile string
+	if v, ok := pod.GetAnnotations()[util.AnnoMIGProfile]; ok {
+		if len(draClaims) > 0 {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, "ResourceClaims cannot target a MIG profile")
+		}
+		if !p.migSupported(nodes) {
+			msg := fmt.Sprintf("%s GPUs do not support MIG profiles", p.vendor.Name)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+		if migProfile, err = util.ParseMIGProfile(v); err != nil {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable).WithError(err)
+		}
+		if parsed.Fraction > 0 {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, "fractional claims cannot target a MIG profile")
+		}
+		if parsed.Memory > 0 {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, "MIG claims cannot set memory; the profile fixes it")
+		}
+		if parsed.Device != "" {
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, "MIG claims cannot name a device")
+		}
+	}
+	// A pod's GPUs all come from one node, so a count no node has would be
+	// retried forever. MIG counts instances, which nodes have more of.
+	if migProfile == "" {
+		if p.maxGPUsPerPod > 0 && reqCount > p.maxGPUsPerPod {
+			msg := fmt.Sprintf("claim requests %d GPUs, above the limit of %d GPUs per pod", reqCount, p.maxGPUsPerPod)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+		if largest := p.largestNodeCapacity(nodes); largest > 0 && reqCount > largest {
+			msg := fmt.Sprintf("claim requests %d GPUs but the largest node has %d; a pod's GPUs must share a node, so split the workload into pods of at most %d GPUs, scheduled together as a gang (%s) if they must start at once",
+				reqCount, largest, largest, util.AnnoGang)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+	}
+
+	// A split that cannot work would only fail in PreBind, after the devices
+	// are reserved.
+	counts, err := util.ContainerDevices(pod)
+	if err != nil {
+		return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable).WithError(err)
+	}
+	if counts != nil {
+		devices, total := reqCount, 0
+		if parsed.Fraction > 0 {
+			devices = 1
+		}
+		for _, n := range counts {
+			total += n
+		}
+		if total != devices {
+			msg := fmt.Sprintf("%s annotation assigns %d devices, but the claim is for %d", util.AnnoContainerDevices, total, devices)
+			return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+		}
+	}
+
 	state := &stateData{
-		claimName: claimName,
-		reqCount:  reqCount,
+		claimName:     claimName,
+		reqCount:      reqCount,
+		fraction:      parsed.Fraction,
+		fractionLimit: parsed.Limit,
+		migProfile:    migProfile,
+		model:         parsed.Model,
+		memory:        parsed.Memory,
+		antiAffinity:  pod.Labels[util.LabelDeviceAntiAffinity],
+		device:        parsed.Device,
+		draClaims:     draClaims,
+		reasons:       newFilterReasons(),
 	}
-	cycleState.Write(Name, state)
-	return nil, nil
+	// Size the gang before Reserve takes devices that Permit would only
+	// give back.
+	if _, _, ok, err := p.gangOf(pod); ok && err != nil {
+		return nil, gangStatus(err)
+	}
+	// Preempting for a pod over quota would not help; leave no state so
+	// PostFilter does not try.
+	if status := p.checkQuota(ctx, pod, state); !status.IsSuccess() {
+		return nil, status
+	}
+	return state, nil
 }
 
 func (p *Plugin) PreFilterExtensions() framework.PreFilterExtensions { return nil }
 
-// Filter enforces node selector requirements (noop for MVP).
+// largestNodeCapacity returns the most GPUs any of nodes has, or zero when
+// none has any.
+func (p *Plugin) largestNodeCapacity(nodes []*framework.NodeInfo) int {
+	var largest int
+	for _, ni := range nodes {
+		if node := ni.Node(); node != nil {
+			largest = max(largest, len(p.nodeDevices(node)))
+		}
+	}
+	return largest
+}
+
+// migSupported reports whether the --gpu-vendor, or the vendor any of nodes
+// is labeled with, can split GPUs into MIG instances. Filter then keeps MIG
+// claims off the nodes whose vendor cannot.
+func (p *Plugin) migSupported(nodes []*framework.NodeInfo) bool {
+	if p.vendor.MIG {
+		return true
+	}
+	for _, ni := range nodes {
+		if node := ni.Node(); node != nil && p.nodeVendor(node).MIG {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeVendor returns the vendor of the node's GPUs: the one its
+// util.LabelVendor names, or the --gpu-vendor.
+func (p *Plugin) nodeVendor(node *corev1.Node) util.Vendor {
+	return util.NodeVendor(node, p.vendor)
+}
+
+// Filter rejects nodes without enough unclaimed GPUs for the pod's claim.
 func (p *Plugin) Filter(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	var nodeName string
+	if node := nodeInfo.Node(); node != nil {
+		nodeName = node.Name
+	}
+	ctx, span := p.startSpan(ctx, "Filter", pod, nodeName)
+	status := p.filter(ctx, cycleState, pod, nodeInfo)
+	endSpan(span, cycleState, status)
+	return status
+}
+
+func (p *Plugin) filter(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	data, err := readState(cycleState)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if status := nodeEligible(pod, node); !status.IsSuccess() {
+		if status.Code() != framework.Error {
+			reason := reasonAffinity
+			if util.NodeCordoned(node) {
+				reason = reasonCordoned
+			}
+			data.reasons.record(node.Name, reason)
+		}
+		return status
+	}
+	if !util.NodeHasModel(node, data.model) {
+		msg := fmt.Sprintf("node %s has GPU model %q, claim requires %q", node.Name, node.Labels[util.LabelModel], data.model)
+		return data.reject(node.Name, reasonModel, framework.UnschedulableAndUnresolvable, msg)
+	}
+	if data.migProfile != "" {
+		return p.filterMIG(ctx, data, node)
+	}
+
+	perDevice := util.NodeGPUMemory(node)
+	if data.memory > perDevice {
+		if perDevice == 0 {
+			msg := fmt.Sprintf("node %s does not advertise GPU memory, claim needs %s", node.Name, formatBytes(data.memory))
+			return data.reject(node.Name, reasonNoMemoryLabel, framework.UnschedulableAndUnresolvable, msg)
+		}
+		msg := fmt.Sprintf("GPUs on node %s have %s of memory, claim needs %s", node.Name, formatBytes(perDevice), formatBytes(data.memory))
+		return data.reject(node.Name, reasonMemory, framework.UnschedulableAndUnresolvable, msg)
+	}
+
+	held, devices, err := p.nodeLeases(ctx, node)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	held = occupying(pod, held)
+	usage := lease.DeviceUsage(held)
+	if data.fraction > 0 {
+		mem := newMemoryNeed(held, perDevice, data.memory)
+		group := lease.AntiAffine(held, data.antiAffinity)
+		var gns *apiv1.GpuNodeStatus
+		if len(lease.UnplacedMIG(group)) > 0 {
+			// Only the node's MIG instances tell which GPU such a lease is on.
+			if gns, err = p.getGpuNodeStatus(ctx, node.Name); err != nil {
+				return framework.AsStatus(err)
+			}
+		}
+		avoid := antiAffineDevices(group, gns)
+		if !fitsFraction(usage, devices, data.fraction, mem, avoid) {
+			msg := fmt.Sprintf("no GPU on node %s has %s free (capacity=%d)", node.Name, describeShare(data), len(devices))
+			return data.reject(node.Name, reasonNoShare, framework.Unschedulable, msg)
+		}
+		return nil
+	}
+	if data.device != "" {
+		return filterNamed(data, node, devices, usage)
+	}
+	if free := freeDevices(usage, devices); free < data.reqCount && free+len(p.contested(pod, held)) < data.reqCount {
+		msg := fmt.Sprintf("insufficient free GPUs on node %s (requested=%d, free=%d, capacity=%d)", node.Name, data.reqCount, free, len(devices))
+		return data.reject(node.Name, reasonInsufficient, framework.Unschedulable, msg)
+	}
+	return nil
+}
+
+// nodeEligible rejects a node whose GPUs are cordoned, or that the pod's
+// nodeSelector or required node affinity rules out, so GPU availability is
+// never weighed, or leased, on a node the pod cannot land on.
+func nodeEligible(pod *corev1.Pod, node *corev1.Node) *framework.Status {
+	if util.NodeCordoned(node) {
+		msg := fmt.Sprintf("GPU allocation on node %s is cordoned", node.Name)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+	}
+	ok, err := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node)
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("match node affinity: %w", err))
+	}
+	if !ok {
+		msg := fmt.Sprintf("node %s does not match the pod's node selector or affinity", node.Name)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+	}
+	return nil
+}
+
+// nodeUsage returns the share of each leased device on the node alongside
+// the ids of the node's GPUs.
+func (p *Plugin) nodeUsage(ctx context.Context, node *corev1.Node) (map[int]float64, []int, error) {
+	held, devices, err := p.nodeLeases(ctx, node)
+	if err != nil {
+		return nil, devices, err
+	}
+	return lease.DeviceUsage(held), devices, nil
+}
+
+// nodeLeases returns the leases held on the node alongside the ids of its
+// GPUs.
+func (p *Plugin) nodeLeases(ctx context.Context, node *corev1.Node) ([]coordv1.Lease, []int, error) {
+	devices := p.nodeDevices(node)
+	held, err := p.heldLeases(ctx, node.Name)
+	if err != nil {
+		return nil, devices, err
+	}
+	return held, devices, nil
+}
+
+// nodeDevices returns the ids of the GPUs the node may hand out: the ones
+// its util.AnnoDeviceIDs annotation lists, or 0 to its capacity minus one.
+func (p *Plugin) nodeDevices(node *corev1.Node) []int {
+	ids, err := util.NodeDeviceIDs(node, p.nodeVendor(node).NodeCapacity(node))
+	if err != nil {
+		klog.V(2).InfoS("Ignoring the GPUs of a node with an invalid device list", "node", klog.KObj(node), "err", err)
+	}
+	return ids
+}
+
+// restrictDevices returns gns without the devices the node's
+// util.AnnoDeviceIDs annotation leaves out, so Reserve only leases the ones
+// passed through. Without the annotation gns is returned as is.
+func (p *Plugin) restrictDevices(gns *apiv1.GpuNodeStatus, node *corev1.Node) *apiv1.GpuNodeStatus {
+	if _, ok := node.Annotations[util.AnnoDeviceIDs]; !ok {
+		return gns
+	}
+	ids := p.nodeDevices(node)
+	out := gns.DeepCopy()
+	out.Status.Devices = slices.DeleteFunc(out.Status.Devices, func(dev apiv1.Device) bool {
+		return !slices.Contains(ids, dev.ID)
+	})
+	return out
+}
+
+// heldLeases returns the leases held on the node from the inventory, or from
+// the API server until the inventory is ready. Reserve lists from the API
+// server regardless, since it must see leases other replicas just took.
+// Reservations outside their window are left out.
+func (p *Plugin) heldLeases(ctx context.Context, nodeName string) ([]coordv1.Lease, error) {
+	if p.inventory != nil && p.inventory.Ready() {
+		return lease.Active(p.inventory.Node(nodeName), time.Now()), nil
+	}
+	held, err := lease.ListNode(ctx, p.coord, p.labelPrefix, nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("list leases for node %s: %w", nodeName, err)
+	}
+	return lease.Active(held, time.Now()), nil
+}
+
+// occupying returns the leases among held that keep their device from pod:
+// reservations count only during their window, and never against the pods
+// using them.
+func occupying(pod *corev1.Pod, held []coordv1.Lease) []coordv1.Lease {
+	return lease.Occupying(held, pod.Namespace, pod.Annotations[util.AnnoReservation], time.Now())
+}
+
+// freeGPUs returns the node's wholly unclaimed GPU count alongside its
+// capacity. Partly shared devices are not free.
+func (p *Plugin) freeGPUs(ctx context.Context, node *corev1.Node) (int, int, error) {
+	usage, devices, err := p.nodeUsage(ctx, node)
+	if err != nil {
+		return 0, len(devices), err
+	}
+	return freeDevices(usage, devices), len(devices), nil
+}
+
+// freeDevices counts the devices with no lease at all.
+func freeDevices(usage map[int]float64, devices []int) int {
+	return len(freeIDs(usage, devices))
+}
+
+// PreScore skips Score for pods without a claim.
+func (p *Plugin) PreScore(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodes []*framework.NodeInfo) *framework.Status {
+	if _, err := readState(cycleState); errors.Is(err, framework.ErrNotFound) {
+		return framework.NewStatus(framework.Skip)
+	} else if err != nil {
+		return framework.AsStatus(err)
+	}
 	return nil
 }
 
-// Score favors nodes with contiguous GPUs. MVP stub returns static score.
+// Score ranks nodes by the free GPUs left after placing the pod. The
+// direction depends on the packing strategy and is applied in NormalizeScore.
 func (p *Plugin) Score(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) (int64, *framework.Status) {
-	return 1, nil
+	data, err := readState(cycleState)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return 0, framework.NewStatus(framework.Error, "node not found")
+	}
+	var free int
+	connected := true
+	if data.migProfile != "" {
+		free, _, err = p.freeMIG(ctx, node, data.migProfile)
+	} else {
+		var usage map[int]float64
+		var devices []int
+		usage, devices, err = p.nodeUsage(ctx, node)
+		free = freeDevices(usage, devices)
+		if err == nil && data.fraction == 0 && data.reqCount > 1 {
+			_, connected = topo.PickIslands(freeIDs(usage, devices), nodeIslands(node), data.reqCount)
+		}
+	}
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	remaining := free - data.reqCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	if connected {
+		remaining += islandTier
+	}
+	return int64(remaining), nil
+}
+
+// freeIDs lists the devices with no lease at all.
+func freeIDs(usage map[int]float64, devices []int) []int {
+	var ids []int
+	for _, id := range devices {
+		if _, ok := usage[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// nodeIslands returns the node's NVLink islands; a malformed topology
+// annotation counts as none.
+func nodeIslands(node *corev1.Node) [][]int {
+	islands, err := util.NodeTopology(node)
+	if err != nil {
+		klog.V(4).InfoS("ignoring GPU topology", "node", node.Name, "err", err)
+	}
+	return islands
+}
+
+// islands returns the NVLink islands of the named node, or nil when the node
+// is not in the scheduler's snapshot.
+func (p *Plugin) islands(nodeName string) [][]int {
+	node := p.snapshotNode(nodeName)
+	if node == nil {
+		return nil
+	}
+	return nodeIslands(node)
+}
+
+// snapshotNode looks the named node up in the scheduler's snapshot.
+func (p *Plugin) snapshotNode(nodeName string) *corev1.Node {
+	if p.handle == nil {
+		return nil
+	}
+	ni, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return nil
+	}
+	return ni.Node()
+}
+
+func (p *Plugin) ScoreExtensions() framework.ScoreExtensions { return p }
+
+// NormalizeScore maps remaining free GPUs onto 0-100 and, when HealthWeight
+// is set, blends in each node's GPU health. Last, nodes of the lifecycle the
+// pod prefers are put ahead of the others.
+func (p *Plugin) NormalizeScore(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, scores framework.NodeScoreList) *framework.Status {
+	if status := p.normalizePacking(scores); !status.IsSuccess() {
+		return status
+	}
+	if w := p.args.HealthWeight; w > 0 {
+		for i := range scores {
+			health := int64(util.MaxHealthScore)
+			if node := p.snapshotNode(scores[i].Name); node != nil {
+				health = util.NodeHealthScore(node)
+			}
+			health = health * framework.MaxNodeScore / util.MaxHealthScore
+			scores[i].Score = (scores[i].Score*(100-w) + health*w) / 100
+		}
+	}
+	p.preferLifecycle(pod, scores)
+	return nil
 }
 
-func (p *Plugin) ScoreExtensions() framework.ScoreExtensions { return nil }
+// normalizePacking maps remaining free GPUs onto 0-100. Binpack reverses the
+// scale so the fullest node wins. When only some nodes can keep the claim
+// inside one NVLink island, those take the upper half of the range.
+func (p *Plugin) normalizePacking(scores framework.NodeScoreList) *framework.Status {
+	connected := make([]bool, len(scores))
+	var nConnected int
+	for i := range scores {
+		if scores[i].Score >= islandTier {
+			connected[i] = true
+			scores[i].Score -= islandTier
+			nConnected++
+		}
+	}
+	if status := helper.DefaultNormalizeScore(framework.MaxNodeScore, p.args.PackingStrategy != StrategySpread, scores); !status.IsSuccess() {
+		return status
+	}
+	if nConnected == 0 || nConnected == len(scores) {
+		return nil
+	}
+	for i := range scores {
+		scores[i].Score /= 2
+		if connected[i] {
+			scores[i].Score += framework.MaxNodeScore / 2
+		}
+	}
+	return nil
+}
 
 // Reserve acquires GPU leases on the chosen node.
 func (p *Plugin) Reserve(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	ctx, span := p.startSpan(ctx, "Reserve", pod, nodeName)
+	status := p.reserve(ctx, cycleState, pod, nodeName)
+	endSpan(span, cycleState, status)
+	if status.IsSuccess() {
+		if data, err := readState(cycleState); err == nil {
+			p.recordAudit(audit.Allocate, pod, nodeName, data.chosenIDs, "")
+		}
+	}
+	return status
+}
+
+func (p *Plugin) reserve(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
 	data, err := readState(cycleState)
+	if errors.Is(err, framework.ErrNotFound) {
+		// No claim: PreFilter skipped the pod.
+		return nil
+	}
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
 	}
 	data.chosenNode = nodeName
 
+	// Check eligibility again before taking any lease, in case the node was
+	// chosen without this plugin's Filter, e.g. through a nomination.
+	node := p.snapshotNode(nodeName)
+	if node != nil {
+		if status := nodeEligible(pod, node); !status.IsSuccess() {
+			return status
+		}
+	}
+
 	// Fetch the GpuNodeStatus to see available devices.
 	gns, err := p.getGpuNodeStatus(ctx, nodeName)
 	if err != nil {
 		return framework.NewStatus(framework.Error, fmt.Sprintf("get GpuNodeStatus: %v", err))
 	}
+	if node != nil {
+		gns = p.restrictDevices(gns, node)
+	}
 
 	// Check if the node has any GPU devices.
 	if len(gns.Status.Devices) == 0 {
 		return framework.NewStatus(framework.Unschedulable, "node has no GPU devices")
 	}
 
+	// Leases from before they shared p.leaseNamespace live in their pods'
+	// namespaces; the node's leases in every namespace count.
+	held, err := lease.ListNode(ctx, p.coord, p.labelPrefix, nodeName)
+	if err != nil {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("list leases: %v", err))
+	}
+	held = occupying(pod, held)
+	if data.migProfile != "" {
+		return p.reserveMIG(ctx, cycleState, data, pod, nodeName, gns, held)
+	}
+	if data.fraction > 0 {
+		return p.reserveFraction(ctx, cycleState, data, pod, nodeName, gns, held)
+	}
+	busy := lease.HeldDevices(held)
+	var order []int
+	if data.device != "" {
+		var status *framework.Status
+		if order, status = namedOrder(data, node, gnsDeviceIDs(gns), busy); !status.IsSuccess() {
+			return status
+		}
+	} else {
+		order = deviceOrder(gns, busy, p.islands(nodeName), data.reqCount, p.deviceSelection, p.freedAt(nodeName))
+	}
+
 	// Try to acquire leases for the requested GPU count.
 	var allocated []int
-	for _, dev := range gns.Status.Devices {
+	for _, id := range order {
 		if len(allocated) >= data.reqCount {
 			break
 		}
-
-		id := dev.ID
-		ok, err := lease.TryAcquire(ctx, p.coord, pod.Namespace, nodeName, string(pod.UID), pod.Name, id)
+		ok, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, string(pod.UID), podKey(pod), id)
 		if err != nil {
 			klog.V(4).InfoS("lease acquisition failed", "node", nodeName, "gpuID", id, "err", err)
 			continue
@@ -188,73 +1156,393 @@ func (p *Plugin) Reserve(ctx context.Context, cycleState *framework.CycleState,
 			allocated = append(allocated, id)
 		}
 	}
+	// A named device is never swapped for another one taken over.
+	if len(allocated) < data.reqCount && data.device == "" {
+		allocated = append(allocated, p.takeOver(ctx, pod, nodeName, held, data.reqCount-len(allocated))...)
+	}
 
 	// Check if we acquired enough GPUs.
 	if len(allocated) < data.reqCount {
 		total := len(gns.Status.Devices)
-		free := 0
-		for _, dev := range gns.Status.Devices {
-			_ = dev
-		}
-		klog.V(4).InfoS("not enough GPUs available", "node", nodeName, "requested", data.reqCount, "allocated", len(allocated), "free", free, "total", total)
+		klog.V(4).InfoS("not enough GPUs available", "node", nodeName, "requested", data.reqCount, "allocated", len(allocated), "busy", len(busy), "total", total)
 		// Release any partial allocations.
 		for _, id := range allocated {
-			_ = lease.Release(ctx, p.coord, pod.Namespace, nodeName, id)
+			_ = lease.Release(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, id)
 		}
-		msg := fmt.Sprintf("not enough GPUs available on node %s (requested=%d, total=%d)", nodeName, data.reqCount, total)
-		return framework.NewStatus(framework.Unschedulable, msg)
+		err := lease.Shortagef("not enough GPUs available on node %s (requested=%d, total=%d)", nodeName, data.reqCount, total)
+		return framework.NewStatus(framework.Unschedulable).WithError(err)
 	}
 
+	slices.Sort(allocated)
 	data.chosenIDs = allocated
 	cycleState.Write(Name, data)
 	return nil
 }
 
+// deviceOrder lists the node's free devices in the order Reserve tries them:
+// the set the node's NVLink topology prefers for count devices first, then
+// the other free devices in case a concurrent Reserve takes one of them.
+// With DeviceSelectionMRU or DeviceSelectionLRU the first set is chosen by
+// when its devices were freed, per freed, rather than by id.
+func deviceOrder(gns *apiv1.GpuNodeStatus, busy map[int]bool, islands [][]int, count int, policy string, freed map[int]time.Time) []int {
+	var free []int
+	for _, dev := range sortedDevices(gns) {
+		if !busy[dev.ID] {
+			free = append(free, dev.ID)
+		}
+	}
+	pick, connected := topo.PickIslands(free, islands, count)
+	if pick != nil && (policy == DeviceSelectionMRU || policy == DeviceSelectionLRU) {
+		pick = policyPick(free, islands, pick, connected, count, policy, freed)
+	}
+	return slices.Concat(pick, slices.DeleteFunc(slices.Clone(free), func(id int) bool {
+		return slices.Contains(pick, id)
+	}))
+}
+
+// sortedDevices returns the node's devices by ascending id, so that Reserve
+// makes the same choice for the same leases whatever order the agent
+// reported the devices in.
+func sortedDevices(gns *apiv1.GpuNodeStatus) []apiv1.Device {
+	return slices.SortedFunc(slices.Values(gns.Status.Devices), func(a, b apiv1.Device) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
 // Unreserve releases leases when scheduling fails.
 func (p *Plugin) Unreserve(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) {
 	data, err := readState(cycleState)
 	if err != nil {
 		return
 	}
+	p.releaseLeases(ctx, pod, nodeName, data, "unreserved")
+	p.releaseAnnotations(ctx, pod, data)
+	p.releaseClaims(ctx, pod, data)
+
+	// All or nothing: once one member gives up its GPUs, the members still
+	// waiting in Permit must release theirs too.
+	if key, _, ok, _ := p.gangOf(pod); ok {
+		p.gangs.forget(key)
+		p.forEachWaitingMember(key, pod.UID, func(wp framework.WaitingPod) {
+			wp.Reject(Name, fmt.Sprintf("gang %s/%s member %s was unreserved", key.namespace, key.id, pod.Name))
+		})
+	}
+}
+
+// releaseLeases drops the leases Reserve took for the pod and forgets them,
+// recording the release for reason in the audit log.
+func (p *Plugin) releaseLeases(ctx context.Context, pod *corev1.Pod, nodeName string, data *stateData, reason string) {
 	for _, id := range data.chosenIDs {
-		_ = lease.Release(ctx, p.coord, pod.Namespace, nodeName, id)
+		var err error
+		switch {
+		case data.migProfile != "":
+			err = lease.ReleaseMIG(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, id)
+		case data.fraction > 0:
+			err = lease.ReleaseFraction(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, string(pod.UID), id)
+		default:
+			// A higher-ranked pod may have taken the device over.
+			err = lease.ReleaseHeld(ctx, p.coord, p.labelPrefix, p.leaseNamespace, nodeName, string(pod.UID), id)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "failed to release GPU lease", "pod", klog.KObj(pod), "node", nodeName, "gpuID", id)
+		}
 	}
+	p.recordAudit(audit.Release, pod, nodeName, data.chosenIDs, reason)
+	data.chosenIDs = nil
+	data.chosenUUIDs = nil
 }
 
-// PreBind persists allocation annotations so the webhook can inject env vars.
+// recordAudit writes an allocation or release of the pod's devices on node
+// to the audit sink, if there is one.
+func (p *Plugin) recordAudit(action audit.Action, pod *corev1.Pod, node string, devices []int, reason string) {
+	if p.auditSink == nil || len(devices) == 0 {
+		return
+	}
+	err := p.auditSink.Write(audit.Record{
+		Action:    action,
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		PodUID:    string(pod.UID),
+		Node:      node,
+		Devices:   devices,
+		User:      pod.Annotations[util.AnnoRequestedBy],
+		Reason:    reason,
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to write audit record", "pod", klog.KObj(pod), "action", action)
+	}
+}
+
+// releaseAnnotations removes the allocation annotations PreBind sent before
+// it failed, so the pod's next attempt does not start out with devices it no
+// longer holds. They are forgotten once removed, so a second Unreserve sends
+// nothing.
+func (p *Plugin) releaseAnnotations(ctx context.Context, pod *corev1.Pod, data *stateData) {
+	if len(data.annotated) == 0 {
+		return
+	}
+	annotations := make(map[string]interface{}, len(data.annotated))
+	for _, k := range data.annotated {
+		annotations[k] = nil
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to encode allocation rollback", "pod", klog.KObj(pod))
+		return
+	}
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	_, err = p.client.CoreV1().Pods(pod.Namespace).Patch(callCtx, pod.Name, types.MergePatchType, b, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "failed to remove allocation annotations", "pod", klog.KObj(pod))
+		return
+	}
+	data.annotated = nil
+}
+
+// Permit holds gang members until every member of the gang has passed
+// Reserve, then admits them together. Pods outside a gang pass through.
+func (p *Plugin) Permit(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) (*framework.Status, time.Duration) {
+	key, size, ok, err := p.gangOf(pod)
+	if !ok {
+		return nil, 0
+	}
+	if err != nil {
+		return gangStatus(err), 0
+	}
+
+	reserved, complete := p.gangs.reserve(key, pod.UID, size)
+	if !complete {
+		klog.V(4).InfoS("waiting for gang", "pod", klog.KObj(pod), "gang", key.id, "reserved", reserved, "size", size)
+		return framework.NewStatus(framework.Wait, fmt.Sprintf("waiting for gang %s (%d/%d reserved)", key.id, reserved, size)), p.args.gangTimeout()
+	}
+
+	klog.V(4).InfoS("gang complete", "pod", klog.KObj(pod), "gang", key.id, "size", size)
+	p.forEachWaitingMember(key, pod.UID, func(wp framework.WaitingPod) {
+		wp.Allow(Name)
+	})
+	return nil, 0
+}
+
+// forEachWaitingMember calls fn for every other gang member waiting in Permit.
+func (p *Plugin) forEachWaitingMember(key gangKey, self types.UID, fn func(framework.WaitingPod)) {
+	if p.handle == nil {
+		return
+	}
+	p.handle.IterateOverWaitingPods(func(wp framework.WaitingPod) {
+		other := wp.GetPod()
+		if other.UID == self {
+			return
+		}
+		if k, _, ok, _ := p.gangOf(other); ok && k == key {
+			fn(wp)
+		}
+	})
+}
+
+// PreBind persists allocation annotations so the webhook can inject env vars,
+// and publishes the allocation of the pod's ResourceClaims.
 func (p *Plugin) PreBind(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	ctx, span := p.startSpan(ctx, "PreBind", pod, nodeName)
+	status := p.preBind(ctx, cycleState, pod, nodeName)
+	endSpan(span, cycleState, status)
+	return status
+}
+
+func (p *Plugin) preBind(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
 	data, err := readState(cycleState)
+	if errors.Is(err, framework.ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
 	}
 
-	util.SetAllocated(pod, nodeName, data.chosenIDs)
+	if len(data.chosenIDs) == 0 {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("no GPUs reserved for pod %s/%s", pod.Namespace, pod.Name))
+	}
+
+	// The pod belongs to the scheduler cache; annotate a copy and send only
+	// the annotations so the values are on the pod before it is bound.
+	annotated := pod.DeepCopy()
+	byUUID := true
+	if data.migProfile != "" {
+		uuids := make(map[int]string, len(data.chosenIDs))
+		for i, id := range data.chosenIDs {
+			if i < len(data.chosenUUIDs) {
+				uuids[id] = data.chosenUUIDs[i]
+			}
+		}
+		alloc, err := util.SplitAllocation(pod, util.MIGResource(data.migProfile), data.chosenIDs)
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		util.SetAllocatedUUIDs(annotated, alloc, uuids)
+	} else {
+		resource := p.vendor.Resource
+		if node := p.snapshotNode(nodeName); node != nil {
+			resource = p.nodeVendor(node).Resource
+		}
+		alloc, err := util.SplitAllocation(pod, resource, data.chosenIDs)
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if uuids := p.deviceUUIDs(nodeName, data.chosenIDs); uuids != nil {
+			util.SetAllocatedUUIDs(annotated, alloc, uuids)
+		} else {
+			util.SetAllocated(annotated, alloc)
+			byUUID = false
+		}
+	}
+	allocated := util.AllocatedAnnotations(annotated)
+	if err := checkAllocated(allocated, byUUID); err != nil {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("refusing to write a malformed allocation for pod %s/%s: %v", pod.Namespace, pod.Name, err))
+	}
 	payload := map[string]interface{}{
 		"metadata": map[string]interface{}{
-			"annotations": map[string]string{
-				util.AnnoAllocated: pod.Annotations[util.AnnoAllocated],
-			},
+			"annotations": allocated,
 		},
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
 	}
+	// A patch that times out may still have been applied, so Unreserve
+	// takes the annotations back whatever the outcome.
+	data.annotated = slices.Sorted(maps.Keys(allocated))
 
-	if _, err := p.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, b, metav1.PatchOptions{}); err != nil {
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	if _, err := p.client.CoreV1().Pods(pod.Namespace).Patch(callCtx, pod.Name, types.MergePatchType, b, metav1.PatchOptions{}); err != nil {
 		return framework.NewStatus(framework.Error, fmt.Sprintf("patch pod annotations: %v", err))
 	}
+	if err := p.confirmAllocated(ctx, pod); err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if err := p.publishAllocation(ctx, pod, nodeName, data); err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	return nil
+}
+
+// checkAllocated parses the allocation annotations PreBind is about to
+// write back, so that a container never resolves its fieldRef to a value
+// its runtime would misread. Each container's annotation must list its
+// devices from the util.AnnoAllocated map, as ids or, with byUUID, as one
+// non-empty UUID per device. A pod that util.ReadsFlatAllocation reads only
+// the flat list, which must then parse.
+func checkAllocated(annotations map[string]string, byUUID bool) error {
+	if annotations[util.AnnoAllocated] == "{}" {
+		// No containers, so nothing to misread.
+		return nil
+	}
+	if !strings.HasPrefix(annotations[util.AnnoAllocated], "{") {
+		_, err := util.ParseAllocation(annotations[util.AnnoAllocated])
+		return err
+	}
+	alloc, err := util.ParseAllocationMap(annotations[util.AnnoAllocated])
+	if err != nil {
+		return err
+	}
+	for _, container := range slices.Sorted(maps.Keys(alloc)) {
+		ids := alloc[container]
+		v, ok := annotations[util.ContainerAllocatedKey(container)]
+		if !ok {
+			return fmt.Errorf("container %q has no %s annotation", container, util.ContainerAllocatedKey(container))
+		}
+		if byUUID {
+			uuids := strings.Split(v, ",")
+			if len(uuids) != len(ids) || slices.Contains(uuids, "") {
+				return fmt.Errorf("container %q: allocation %q does not name a UUID for each of devices %v", container, v, ids)
+			}
+			continue
+		}
+		got, err := util.ParseAllocation(v)
+		if err != nil {
+			return fmt.Errorf("container %q: %w", container, err)
+		}
+		if !slices.Equal(got, ids) {
+			return fmt.Errorf("container %q: allocation %q does not match its devices %v", container, v, ids)
+		}
+	}
+	return nil
+}
+
+// confirmAllocated sets the pod's ConditionAllocated to True, now that its
+// allocation annotations are written, when a readiness gate waits on it.
+// Until then the pod cannot become Ready, even should its containers start
+// before the annotations reach them.
+func (p *Plugin) confirmAllocated(ctx context.Context, pod *corev1.Pod) error {
+	if !util.HasAllocatedGate(pod) {
+		return nil
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.PodCondition{{
+				Type:               util.ConditionAllocated,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+				Reason:             "GPUsAllocated",
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := p.callContext(ctx)
+	defer cancel()
+	if _, err := p.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, b, metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("set pod condition %s: %w", util.ConditionAllocated, err)
+	}
 	return nil
 }
 
+// deviceUUIDs returns the UUIDs of the node's devices when containers are to
+// see ids as UUIDs, or nil to keep the indices: in index mode, or when the
+// node does not map every one of ids to a UUID.
+func (p *Plugin) deviceUUIDs(nodeName string, ids []int) map[int]string {
+	if p.deviceIDFormat != DeviceIDUUID {
+		return nil
+	}
+	node := p.snapshotNode(nodeName)
+	if node == nil {
+		return nil
+	}
+	uuids, err := util.NodeDeviceUUIDs(node)
+	if err != nil {
+		klog.V(2).InfoS("ignoring device UUIDs", "node", nodeName, "err", err)
+		return nil
+	}
+	if _, ok := util.DeviceUUIDs(ids, uuids); !ok {
+		klog.V(2).InfoS("node does not map every allocated device to a UUID, using indices", "node", nodeName, "devices", ids)
+		return nil
+	}
+	return uuids
+}
+
+// podKey identifies the pod on the leases it holds.
+func podKey(pod *corev1.Pod) types.NamespacedName {
+	return types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+}
+
 func (p *Plugin) getGpuNodeStatus(ctx context.Context, nodeName string) (*apiv1.GpuNodeStatus, error) {
 	gns := &apiv1.GpuNodeStatus{}
+	ctx, cancel := p.callContext(ctx)
+	defer cancel()
 	if err := p.crcClient.Get(ctx, types.NamespacedName{Name: nodeName}, gns); err != nil {
 		return nil, err
 	}
 	return gns, nil
 }
 
+// callContext derives the context for one API call made through p.client or
+// p.crcClient, which gives up after the plugin's call timeout.
+func (p *Plugin) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return lease.CallContext(ctx, p.callTimeout)
+}
+
 func readState(cycleState *framework.CycleState) (*stateData, error) {
 	raw, err := cycleState.Read(Name)
 	if err != nil {
@@ -0,0 +1,1358 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/audit"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// newTestPlugin wires fake clients. GPU custom resources go to the
+// controller-runtime client, everything else to the clientset.
+func newTestPlugin(objs ...runtime.Object) *Plugin {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiv1.AddToScheme(scheme))
+
+	var core []runtime.Object
+	var custom []crclient.Object
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *apiv1.GpuNodeStatus, *apiv1.GpuClaim:
+			custom = append(custom, o.(crclient.Object))
+		default:
+			core = append(core, o)
+		}
+	}
+
+	cs := fake.NewSimpleClientset(core...)
+	return &Plugin{
+		client:    cs,
+		coord:     cs.CoordinationV1(),
+		crcClient: crfake.NewClientBuilder().WithScheme(scheme).WithObjects(custom...).Build(),
+		args:      Args{PackingStrategy: StrategyBinpack, GangTimeoutSeconds: defaultGangTimeoutSeconds},
+		handle:    newFakeHandle(),
+		gangs:     newGangStore(),
+		vendor:    util.VendorNVIDIA,
+
+		leaseNamespace: lease.DefaultNamespace,
+	}
+}
+
+// podRef names the pod holding a lease in tests.
+func podRef(ns, name string) types.NamespacedName {
+	return types.NamespacedName{Namespace: ns, Name: name}
+}
+
+func gpuNodeStatus(node string, ids ...int) *apiv1.GpuNodeStatus {
+	gns := &apiv1.GpuNodeStatus{
+		ObjectMeta: metav1.ObjectMeta{Name: node},
+		Spec:       apiv1.GpuNodeStatusSpec{NodeName: node},
+	}
+	for _, id := range ids {
+		gns.Status.Devices = append(gns.Status.Devices, apiv1.Device{ID: id})
+	}
+	gns.Status.Total = len(ids)
+	return gns
+}
+
+func testPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID("uid-" + name),
+		},
+	}
+}
+
+func gpuNode(name string, capacity string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{util.LabelCapacity: capacity},
+		},
+	}
+}
+
+func nodeInfo(node *corev1.Node) *framework.NodeInfo {
+	ni := framework.NewNodeInfo()
+	ni.SetNode(node)
+	return ni
+}
+
+func cycleStateFor(reqCount int) *framework.CycleState {
+	state := framework.NewCycleState()
+	state.Write(Name, &stateData{reqCount: reqCount})
+	return state
+}
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	// node-b has 2 of 4 GPUs leased, node-c all of its 2.
+	for _, held := range []struct {
+		node string
+		id   int
+	}{{"node-b", 0}, {"node-b", 1}, {"node-c", 0}, {"node-c", 1}} {
+		if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, held.node, "uid", podRef("default", "holder"), held.id); err != nil {
+			t.Fatalf("seed lease: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		node     *corev1.Node
+		reqCount int
+		want     framework.Code
+	}{
+		{"empty node", gpuNode("node-a", "4"), 4, framework.Success},
+		{"partially used fits", gpuNode("node-b", "4"), 2, framework.Success},
+		{"partially used too small", gpuNode("node-b", "4"), 3, framework.Unschedulable},
+		{"fully used", gpuNode("node-c", "2"), 1, framework.Unschedulable},
+		{"no capacity label", &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu-node"}}, 1, framework.Unschedulable},
+	}
+	for _, tt := range tests {
+		status := p.Filter(ctx, cycleStateFor(tt.reqCount), &corev1.Pod{}, nodeInfo(tt.node))
+		if got := status.Code(); got != tt.want {
+			t.Errorf("%s: Filter code = %v, want %v (%s)", tt.name, got, tt.want, status.Message())
+		}
+	}
+}
+
+func TestFilterVendorCapacity(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "rocm-node"},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			util.ResourceAMDGPU: resource.MustParse("2"),
+		}},
+	}
+	tests := []struct {
+		vendor   util.Vendor
+		reqCount int
+		want     framework.Code
+	}{
+		{util.VendorAMD, 2, framework.Success},
+		{util.VendorAMD, 3, framework.Unschedulable},
+		{util.VendorNVIDIA, 1, framework.Unschedulable},
+	}
+	for _, tt := range tests {
+		p := newTestPlugin()
+		p.vendor = tt.vendor
+		status := p.Filter(ctx, cycleStateFor(tt.reqCount), &corev1.Pod{}, nodeInfo(node))
+		if got := status.Code(); got != tt.want {
+			t.Errorf("%s, %d GPUs: Filter code = %v, want %v (%s)", tt.vendor.Name, tt.reqCount, got, tt.want, status.Message())
+		}
+	}
+
+	// AMD GPUs have no MIG instances to claim.
+	p := newTestPlugin()
+	p.vendor = util.VendorAMD
+	pod := testPod("mig")
+	pod.Annotations = map[string]string{util.AnnoClaim: "1", util.AnnoMIGProfile: "1g.5gb"}
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), pod); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected a MIG claim on AMD to be unresolvable, got %v", status.Code())
+	}
+}
+
+func TestMixedVendorNodes(t *testing.T) {
+	ctx := context.Background()
+	cuda := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "cuda-node", Labels: map[string]string{util.MIGCountLabel("1g.5gb"): "7"}},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			util.ResourceGPU: resource.MustParse("2"),
+		}},
+	}
+	rocm := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "rocm-node", Labels: map[string]string{util.LabelVendor: "amd"}},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			util.ResourceAMDGPU: resource.MustParse("4"),
+		}},
+	}
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: "3"}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "trainer", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceAMDGPU: resource.MustParse("2")}}},
+		{Name: "loader", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceAMDGPU: resource.MustParse("1")}}},
+	}
+	p := newTestPlugin(pod, gpuNodeStatus("rocm-node", 0, 1, 2, 3))
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(cuda), nodeInfo(rocm)}
+
+	// Each node's capacity is read from its own vendor's resource.
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if got := p.Filter(ctx, state, pod, nodeInfo(cuda)).Code(); got != framework.Unschedulable {
+		t.Errorf("Expected the 2-GPU NVIDIA node to be rejected, got %v", got)
+	}
+	if status := p.Filter(ctx, state, pod, nodeInfo(rocm)); !status.IsSuccess() {
+		t.Fatalf("Expected the 4-GPU AMD node to fit, got %v", status.Message())
+	}
+	if status := p.Reserve(ctx, state, pod, "rocm-node"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "rocm-node"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+	got, err := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get pod: %v", err)
+	}
+	// The containers' amd.com/gpu requests split the devices between them.
+	if a := got.Annotations[util.ContainerAllocatedKey("trainer")]; a != "0,1" {
+		t.Errorf("Expected trainer to get 0,1, got %q", a)
+	}
+	if a := got.Annotations[util.ContainerAllocatedKey("loader")]; a != "2" {
+		t.Errorf("Expected loader to get 2, got %q", a)
+	}
+
+	// MIG claims pass PreFilter on an AMD default while an NVIDIA node
+	// exists, but stay off the AMD nodes.
+	p.vendor = util.VendorAMD
+	cuda.Labels[util.LabelVendor] = "nvidia"
+	mig := testPod("mig")
+	mig.Annotations = map[string]string{util.AnnoClaim: "1", util.AnnoMIGProfile: "1g.5gb"}
+	state = framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, mig); !status.IsSuccess() {
+		t.Fatalf("PreFilter MIG: %v", status.Message())
+	}
+	if status := p.Filter(ctx, state, mig, nodeInfo(cuda)); !status.IsSuccess() {
+		t.Errorf("Expected the NVIDIA node to fit the MIG claim, got %v", status.Message())
+	}
+	if got := p.Filter(ctx, state, mig, nodeInfo(rocm)).Code(); got != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected the AMD node to be unresolvable for a MIG claim, got %v", got)
+	}
+}
+
+func TestScorePackingStrategies(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	// Free GPUs: node-a 8, node-b 4, node-c 2.
+	nodes := []*corev1.Node{gpuNode("node-a", "8"), gpuNode("node-b", "8"), gpuNode("node-c", "4")}
+	for id := 0; id < 4; id++ {
+		if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-b", "uid", podRef("default", "holder"), id); err != nil {
+			t.Fatalf("seed lease: %v", err)
+		}
+	}
+	for id := 0; id < 2; id++ {
+		if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-c", "uid", podRef("default", "holder"), id); err != nil {
+			t.Fatalf("seed lease: %v", err)
+		}
+	}
+
+	score := func(strategy string) map[string]int64 {
+		p.args = Args{PackingStrategy: strategy}
+		state := cycleStateFor(2)
+		var scores framework.NodeScoreList
+		for _, n := range nodes {
+			s, status := p.Score(ctx, state, &corev1.Pod{}, nodeInfo(n))
+			if !status.IsSuccess() {
+				t.Fatalf("Score(%s): %v", n.Name, status.Message())
+			}
+			scores = append(scores, framework.NodeScore{Name: n.Name, Score: s})
+		}
+		if status := p.NormalizeScore(ctx, state, &corev1.Pod{}, scores); !status.IsSuccess() {
+			t.Fatalf("NormalizeScore: %v", status.Message())
+		}
+		out := map[string]int64{}
+		for _, s := range scores {
+			if s.Score < framework.MinNodeScore || s.Score > framework.MaxNodeScore {
+				t.Errorf("%s: score %d out of range", s.Name, s.Score)
+			}
+			out[s.Name] = s.Score
+		}
+		return out
+	}
+
+	binpack := score(StrategyBinpack)
+	if !(binpack["node-c"] > binpack["node-b"] && binpack["node-b"] > binpack["node-a"]) {
+		t.Errorf("binpack should prefer the fullest node, got %v", binpack)
+	}
+	if binpack["node-c"] != framework.MaxNodeScore {
+		t.Errorf("binpack should give the fullest node the max score, got %v", binpack)
+	}
+
+	spread := score(StrategySpread)
+	if !(spread["node-a"] > spread["node-b"] && spread["node-b"] > spread["node-c"]) {
+		t.Errorf("spread should prefer the emptiest node, got %v", spread)
+	}
+}
+
+func TestScoreHealthWeight(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	// Equal capacity and nothing leased, so packing alone ties every node.
+	healthy, worn, bare := gpuNode("healthy", "4"), gpuNode("worn", "4"), gpuNode("bare", "4")
+	healthy.Annotations = map[string]string{util.AnnoHealthScore: "90"}
+	worn.Annotations = map[string]string{util.AnnoHealthScore: "40"}
+	nodes := []*corev1.Node{healthy, worn, bare}
+	infos := make([]*framework.NodeInfo, len(nodes))
+	for i, n := range nodes {
+		infos[i] = nodeInfo(n)
+	}
+	p.handle.(*fakeHandle).nodes = infos
+
+	score := func(weight int64) map[string]int64 {
+		p.args = Args{PackingStrategy: StrategyBinpack, HealthWeight: weight}
+		state := cycleStateFor(1)
+		var scores framework.NodeScoreList
+		for _, ni := range infos {
+			s, status := p.Score(ctx, state, &corev1.Pod{}, ni)
+			if !status.IsSuccess() {
+				t.Fatalf("Score(%s): %v", ni.Node().Name, status.Message())
+			}
+			scores = append(scores, framework.NodeScore{Name: ni.Node().Name, Score: s})
+		}
+		if status := p.NormalizeScore(ctx, state, &corev1.Pod{}, scores); !status.IsSuccess() {
+			t.Fatalf("NormalizeScore: %v", status.Message())
+		}
+		out := map[string]int64{}
+		for _, s := range scores {
+			out[s.Name] = s.Score
+		}
+		return out
+	}
+
+	if got := score(0); got["healthy"] != got["worn"] || got["worn"] != got["bare"] {
+		t.Errorf("Expected health ignored without a weight, got %v", got)
+	}
+	got := score(50)
+	if !(got["bare"] > got["healthy"] && got["healthy"] > got["worn"]) {
+		t.Errorf("Expected healthier nodes to score higher, got %v", got)
+	}
+	// Tied packing scores bottom out under binpack, leaving half of health.
+	if got["bare"] != 50 || got["healthy"] != 45 || got["worn"] != 20 {
+		t.Errorf("Expected scores 50, 45 and 20, got %v", got)
+	}
+}
+
+func TestDecodeArgs(t *testing.T) {
+	args, err := decodeArgs(nil)
+	if err != nil || args.PackingStrategy != StrategyBinpack {
+		t.Errorf("Expected binpack default, got %+v, %v", args, err)
+	}
+	args, err = decodeArgs(&runtime.Unknown{Raw: []byte(`{"packingStrategy":"spread"}`)})
+	if err != nil || args.PackingStrategy != StrategySpread {
+		t.Errorf("Expected spread, got %+v, %v", args, err)
+	}
+	if args.GangTimeoutSeconds != defaultGangTimeoutSeconds {
+		t.Errorf("Expected default gang timeout, got %d", args.GangTimeoutSeconds)
+	}
+	if _, err := decodeArgs(&runtime.Unknown{Raw: []byte(`{"gangTimeoutSeconds":-1}`)}); err == nil {
+		t.Errorf("Expected error for negative gang timeout")
+	}
+	for _, w := range []string{"-1", "101"} {
+		if _, err := decodeArgs(&runtime.Unknown{Raw: []byte(`{"healthWeight":` + w + `}`)}); err == nil {
+			t.Errorf("Expected error for healthWeight %s", w)
+		}
+	}
+	if _, err := decodeArgs(&runtime.Unknown{Raw: []byte(`{"packingStrategy":"random"}`)}); err == nil {
+		t.Errorf("Expected error for unknown strategy")
+	}
+	for _, key := range []string{"gpu-quota", "a/b/c"} {
+		if _, err := decodeArgs(&runtime.Unknown{Raw: []byte(`{"quotaConfigMap":"` + key + `"}`)}); err == nil {
+			t.Errorf("Expected error for quotaConfigMap %q", key)
+		}
+	}
+}
+
+func TestReserveCreatesAndUnreserveDeletesLeases(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+
+	// Device 0 is already held by a pod in another namespace.
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("other", "other"), 0); err != nil {
+		t.Fatalf("seed lease: %v", err)
+	}
+
+	pod := testPod("trainer")
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+
+	data, _ := readState(state)
+	if len(data.chosenIDs) != 2 || data.chosenIDs[0] != 1 || data.chosenIDs[1] != 2 {
+		t.Errorf("Expected devices [1 2], got %v", data.chosenIDs)
+	}
+	trainer := metav1.ListOptions{LabelSelector: "gpu.scheduling/pod-namespace=default"}
+	leases, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, trainer)
+	if len(leases.Items) != 2 {
+		t.Fatalf("Expected 2 leases in the lease namespace, got %d", len(leases.Items))
+	}
+	for _, l := range leases.Items {
+		if l.Labels["gpu.scheduling/managed"] != "true" || l.Labels["gpu.scheduling/pod"] != "trainer" {
+			t.Errorf("Unexpected labels on %s: %v", l.Name, l.Labels)
+		}
+		if l.Spec.HolderIdentity == nil || *l.Spec.HolderIdentity != "uid-trainer" {
+			t.Errorf("Expected holder uid-trainer on %s, got %v", l.Name, l.Spec.HolderIdentity)
+		}
+	}
+
+	p.Unreserve(ctx, state, pod, "node-a")
+	leases, _ = p.coord.Leases(lease.DefaultNamespace).List(ctx, trainer)
+	if len(leases.Items) != 0 {
+		t.Errorf("Expected leases to be deleted on Unreserve, got %d", len(leases.Items))
+	}
+	other, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{LabelSelector: "gpu.scheduling/pod-namespace=other"})
+	if len(other.Items) != 1 {
+		t.Errorf("Expected the other pod's lease to survive, got %d", len(other.Items))
+	}
+}
+
+func TestReserveSameDeviceAcrossNamespaces(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0))
+
+	a := testPod("trainer")
+	if status := p.Reserve(ctx, cycleStateFor(1), a, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	// A pod of the same name in another namespace races for the same GPU:
+	// its Reserve lists the node's leases before the first one exists.
+	p.client.(*fake.Clientset).PrependReactor("list", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &coordv1.LeaseList{}, nil
+	})
+	b := testPod("trainer")
+	b.Namespace, b.UID = "team-b", "uid-team-b"
+	if status := p.Reserve(ctx, cycleStateFor(1), b, "node-a"); status.IsSuccess() {
+		t.Fatalf("Expected the held GPU not to be reserved again from another namespace")
+	}
+
+	l, err := p.coord.Leases(lease.DefaultNamespace).Get(ctx, lease.LeaseName(lease.DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+	if err != nil || *l.Spec.HolderIdentity != string(a.UID) {
+		t.Errorf("Expected GPU 0 still held by %s, got %v", a.UID, err)
+	}
+}
+
+func TestReservePicksLowestFreeDevices(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		held  []int
+		count int
+		want  []int
+	}{
+		{name: "fresh node", count: 2, want: []int{0, 1}},
+		{name: "lowest taken", held: []int{0}, count: 2, want: []int{1, 2}},
+		{name: "gap", held: []int{1}, count: 1, want: []int{0}},
+	}
+	for _, tt := range tests {
+		for run := 0; run < 5; run++ {
+			// The agent may list devices in any order.
+			p := newTestPlugin(gpuNodeStatus("node-a", 3, 1, 0, 2))
+			for _, id := range tt.held {
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), id); err != nil {
+					t.Fatalf("seed lease: %v", err)
+				}
+			}
+			state := cycleStateFor(tt.count)
+			if status := p.Reserve(ctx, state, testPod("trainer"), "node-a"); !status.IsSuccess() {
+				t.Fatalf("%s: Reserve: %v", tt.name, status.Message())
+			}
+			if data, _ := readState(state); !slices.Equal(data.chosenIDs, tt.want) {
+				t.Fatalf("%s, run %d: Expected devices %v, got %v", tt.name, run, tt.want, data.chosenIDs)
+			}
+		}
+	}
+}
+
+func TestReserveNotEnoughDevices(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), 1); err != nil {
+		t.Fatalf("seed lease: %v", err)
+	}
+
+	status := p.Reserve(ctx, cycleStateFor(2), testPod("trainer"), "node-a")
+	if status.Code() != framework.Unschedulable {
+		t.Fatalf("Expected Unschedulable, got %v", status.Code())
+	}
+	if err := fmt.Errorf("reserve: %w", status.AsError()); !errors.Is(err, lease.ErrInsufficientGPUs) {
+		t.Errorf("Expected the status error to wrap ErrInsufficientGPUs, got %v", err)
+	}
+	if want := "not enough GPUs available on node node-a (requested=2, total=2)"; status.Message() != want {
+		t.Errorf("Expected message %q, got %q", want, status.Message())
+	}
+	leases, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 1 {
+		t.Errorf("Expected partial allocation to be rolled back, got %d leases", len(leases.Items))
+	}
+}
+
+func TestPreBindWritesAllocatedAnnotation(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "worker-0", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceGPU: resource.MustParse("1")}}},
+		{Name: "worker-1", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceGPU: resource.MustParse("1")}}},
+		{Name: "sidecar"},
+	}
+	p := newTestPlugin(pod)
+
+	state := cycleStateFor(2)
+	data, _ := readState(state)
+	data.chosenIDs = []int{0, 3}
+
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+	got, err := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get pod: %v", err)
+	}
+	want := map[string]string{
+		util.AnnoAllocated:                     `{"sidecar":[0,3],"worker-0":[0],"worker-1":[3]}`,
+		util.ContainerAllocatedKey("worker-0"): "0",
+		util.ContainerAllocatedKey("worker-1"): "3",
+		util.ContainerAllocatedKey("sidecar"):  "0,3",
+	}
+	for key, value := range want {
+		if v := got.Annotations[key]; v != value {
+			t.Errorf("Expected annotation %s=%q, got %q", key, value, v)
+		}
+	}
+	if _, ok := pod.Annotations[util.AnnoAllocated]; ok {
+		t.Errorf("Expected PreBind not to mutate the cached pod")
+	}
+}
+
+func TestPreBindRefusesMalformedAllocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		ids        []int
+		migProfile string
+		wantErr    string
+	}{
+		{name: "valid", ids: []int{0, 3}},
+		{name: "duplicate device", ids: []int{1, 1}, wantErr: "bad or duplicate device id 1"},
+		{name: "negative device", ids: []int{-1}, wantErr: "bad or duplicate device id -1"},
+		{name: "MIG instance without a UUID", ids: []int{0}, migProfile: "1g.5gb", wantErr: "does not name a UUID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			pod := testPod("trainer")
+			pod.Spec.Containers = []corev1.Container{{Name: "trainer"}}
+			p := newTestPlugin(pod)
+			state := cycleStateFor(len(tt.ids))
+			data, _ := readState(state)
+			data.chosenIDs = tt.ids
+			data.migProfile = tt.migProfile
+
+			status := p.PreBind(ctx, state, pod, "node-a")
+			if tt.wantErr == "" {
+				if !status.IsSuccess() {
+					t.Fatalf("PreBind: %v", status.Message())
+				}
+				return
+			}
+			if status.Code() != framework.Error || !strings.Contains(status.Message(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v: %s", tt.wantErr, status.Code(), status.Message())
+			}
+			for _, action := range p.client.(*fake.Clientset).Actions() {
+				if action.GetVerb() == "patch" {
+					t.Errorf("Expected no pod patch for a malformed allocation, got %v", action)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAllocated(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		byUUID      bool
+		wantErr     bool
+	}{
+		{
+			name:        "ids",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0,3],"b":[1]}`, util.ContainerAllocatedKey("a"): "0,3", util.ContainerAllocatedKey("b"): "1"},
+		},
+		{
+			name:        "UUIDs",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0,3]}`, util.ContainerAllocatedKey("a"): "GPU-aaaa,GPU-dddd"},
+			byUUID:      true,
+		},
+		{
+			name:        "flat list",
+			annotations: map[string]string{util.AnnoAllocated: "0,3", util.ContainerAllocatedKey("a"): "0,3"},
+		},
+		{
+			name:        "map is not JSON",
+			annotations: map[string]string{util.AnnoAllocated: `a=0,3`, util.ContainerAllocatedKey("a"): "0,3"},
+			wantErr:     true,
+		},
+		{
+			name:        "container annotation missing",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0]}`},
+			wantErr:     true,
+		},
+		{
+			name:        "empty id",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0,1]}`, util.ContainerAllocatedKey("a"): "0,,1"},
+			wantErr:     true,
+		},
+		{
+			name:        "container annotation disagrees with the map",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0,1]}`, util.ContainerAllocatedKey("a"): "0,2"},
+			wantErr:     true,
+		},
+		{
+			name:        "UUID missing",
+			annotations: map[string]string{util.AnnoAllocated: `{"a":[0,1]}`, util.ContainerAllocatedKey("a"): "GPU-aaaa,"},
+			byUUID:      true,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		if err := checkAllocated(tt.annotations, tt.byUUID); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestPreBindSetsAllocatedCondition(t *testing.T) {
+	ctx := context.Background()
+	for _, gated := range []bool{true, false} {
+		pod := testPod("trainer")
+		pod.Spec.Containers = []corev1.Container{{Name: "trainer"}}
+		if gated {
+			pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: util.ConditionAllocated}}
+		}
+		p := newTestPlugin(pod)
+		state := cycleStateFor(1)
+		data, _ := readState(state)
+		data.chosenIDs = []int{0}
+
+		if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+			t.Fatalf("PreBind: %v", status.Message())
+		}
+		var statusPatch []byte
+		for _, action := range p.client.(*fake.Clientset).Actions() {
+			if pa, ok := action.(k8stesting.PatchAction); ok && action.GetSubresource() == "status" {
+				if pa.GetPatchType() != types.StrategicMergePatchType {
+					t.Errorf("Expected a strategic merge patch, got %s", pa.GetPatchType())
+				}
+				statusPatch = pa.GetPatch()
+			}
+		}
+		if !gated {
+			if statusPatch != nil {
+				t.Errorf("Expected no status patch without the readiness gate, got %s", statusPatch)
+			}
+			continue
+		}
+		got, _ := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+		var cond *corev1.PodCondition
+		for i := range got.Status.Conditions {
+			if got.Status.Conditions[i].Type == util.ConditionAllocated {
+				cond = &got.Status.Conditions[i]
+			}
+		}
+		if cond == nil || cond.Status != corev1.ConditionTrue {
+			t.Errorf("Expected condition %s=True, got %+v from patch %s", util.ConditionAllocated, got.Status.Conditions, statusPatch)
+		}
+		if got.Annotations[util.AnnoAllocated] == "" {
+			t.Errorf("Expected the allocation written along with the condition")
+		}
+	}
+}
+
+// recordedAudit is an audit.Sink keeping the records in memory.
+type recordedAudit []audit.Record
+
+func (r *recordedAudit) Write(rec audit.Record) error {
+	*r = append(*r, rec)
+	return nil
+}
+
+func TestReserveAndUnreserveAudit(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+	records := &recordedAudit{}
+	p.auditSink = records
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoRequestedBy: "alice"}
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	p.Unreserve(ctx, state, pod, "node-a")
+	// Nothing is left to release a second time.
+	p.Unreserve(ctx, state, pod, "node-a")
+
+	want := []audit.Record{
+		{Action: audit.Allocate, Namespace: "default", Pod: "trainer", PodUID: "uid-trainer", Node: "node-a", Devices: []int{0, 1}, User: "alice"},
+		{Action: audit.Release, Namespace: "default", Pod: "trainer", PodUID: "uid-trainer", Node: "node-a", Devices: []int{0, 1}, User: "alice", Reason: "unreserved"},
+	}
+	if !reflect.DeepEqual([]audit.Record(*records), want) {
+		t.Errorf("Expected audit records %+v, got %+v", want, *records)
+	}
+}
+
+func TestUnreserveRollsBackPreBind(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: "2"}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "worker-0", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceGPU: resource.MustParse("1")}}},
+		{Name: "worker-1", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{util.ResourceGPU: resource.MustParse("1")}}},
+	}
+	// The annotations go through, but the condition that follows them fails.
+	pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: util.ConditionAllocated}}
+	p := newTestPlugin(pod, gpuNodeStatus("node-a", 0, 1))
+	client := p.client.(*fake.Clientset)
+	client.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "status" {
+			return true, nil, errors.New("status patch refused")
+		}
+		return false, nil, nil
+	})
+
+	state := cycleStateFor(2)
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "node-a"); status.IsSuccess() {
+		t.Fatalf("Expected PreBind to fail")
+	}
+	got, _ := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if len(util.AllocatedAnnotations(got)) != 3 {
+		t.Fatalf("Expected PreBind to have written 3 allocation annotations, got %v", got.Annotations)
+	}
+
+	p.Unreserve(ctx, state, pod, "node-a")
+	got, _ = p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if alloc := util.AllocatedAnnotations(got); len(alloc) != 0 {
+		t.Errorf("Expected Unreserve to remove the allocation annotations, got %v", alloc)
+	}
+	if got.Annotations[util.AnnoClaim] != "2" {
+		t.Errorf("Expected the claim annotation to stay, got %v", got.Annotations)
+	}
+	leases, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	if len(leases.Items) != 0 {
+		t.Errorf("Expected Unreserve to release the leases, got %d", len(leases.Items))
+	}
+
+	// A second Unreserve has nothing left to undo.
+	client.ClearActions()
+	p.Unreserve(ctx, state, pod, "node-a")
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("Expected a second Unreserve to make no API calls, got %v", actions)
+	}
+}
+
+func TestPreBindDeviceIDFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		uuids  string
+		want   string
+	}{
+		{name: "index", format: DeviceIDIndex, uuids: "0=GPU-aaa,3=GPU-ddd", want: "0,3"},
+		{name: "uuid", format: DeviceIDUUID, uuids: "0=GPU-aaa,1=GPU-bbb,3=GPU-ddd", want: "GPU-aaa,GPU-ddd"},
+		{name: "uuid missing for a device", format: DeviceIDUUID, uuids: "0=GPU-aaa", want: "0,3"},
+		{name: "uuid without annotation", format: DeviceIDUUID, want: "0,3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			pod := testPod("trainer")
+			pod.Spec.Containers = []corev1.Container{{Name: "trainer"}}
+			p := newTestPlugin(pod)
+			p.deviceIDFormat = tt.format
+			node := gpuNode("node-a", "4")
+			if tt.uuids != "" {
+				node.Annotations = map[string]string{util.AnnoDeviceUUIDs: tt.uuids}
+			}
+			p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+
+			state := cycleStateFor(2)
+			data, _ := readState(state)
+			data.chosenIDs = []int{0, 3}
+			if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+				t.Fatalf("PreBind: %v", status.Message())
+			}
+			got, err := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get pod: %v", err)
+			}
+			if v := got.Annotations[util.ContainerAllocatedKey("trainer")]; v != tt.want {
+				t.Errorf("Expected the container's devices %q, got %q", tt.want, v)
+			}
+			// Leases and tooling keep reading ids from the allocation map.
+			if v := got.Annotations[util.AnnoAllocated]; v != `{"trainer":[0,3]}` {
+				t.Errorf("Expected the allocation map to keep ids, got %q", v)
+			}
+		})
+	}
+}
+
+func TestPreBindFlatAllocation(t *testing.T) {
+	ctx := context.Background()
+	// Admitted by a webhook from before gpu.scheduling/allocated became a
+	// map, so CUDA_VISIBLE_DEVICES reads it directly.
+	pod := testPod("trainer")
+	pod.Spec.Containers = []corev1.Container{{Name: "trainer", Env: []corev1.EnvVar{{
+		Name: "CUDA_VISIBLE_DEVICES",
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{
+			FieldPath: "metadata.annotations['" + util.AnnoAllocated + "']",
+		}},
+	}}}}
+	p := newTestPlugin(pod)
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(gpuNode("node-a", "4"))}
+
+	state := cycleStateFor(2)
+	data, _ := readState(state)
+	data.chosenIDs = []int{0, 3}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+	got, err := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get pod: %v", err)
+	}
+	if v := got.Annotations[util.AnnoAllocated]; v != "0,3" {
+		t.Errorf("Expected the flat device list, got %q", v)
+	}
+	if v := got.Annotations[util.ContainerAllocatedKey("trainer")]; v != "0,3" {
+		t.Errorf("Expected the container's devices too, got %q", v)
+	}
+}
+
+func TestPreBindFailureAbortsBind(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		objs  []runtime.Object
+		chose []int
+	}{
+		{name: "pod missing from API server", chose: []int{1}},
+		{name: "nothing reserved", objs: []runtime.Object{testPod("trainer")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(tt.objs...)
+			state := cycleStateFor(1)
+			data, _ := readState(state)
+			data.chosenIDs = tt.chose
+
+			status := p.PreBind(ctx, state, testPod("trainer"), "node-a")
+			if status.IsSuccess() {
+				t.Errorf("Expected PreBind to fail")
+			}
+		})
+	}
+}
+
+func TestFractionalClaimsShareADevice(t *testing.T) {
+	ctx := context.Background()
+	node := gpuNode("node-a", "1")
+	p := newTestPlugin(gpuNodeStatus("node-a", 0))
+
+	halfState := func() *framework.CycleState {
+		state := framework.NewCycleState()
+		state.Write(Name, &stateData{fraction: 0.5})
+		return state
+	}
+
+	for _, name := range []string{"infer-0", "infer-1"} {
+		state := halfState()
+		if status := p.Filter(ctx, state, testPod(name), nodeInfo(node)); !status.IsSuccess() {
+			t.Fatalf("Filter %s: %v", name, status.Message())
+		}
+		if status := p.Reserve(ctx, state, testPod(name), "node-a"); !status.IsSuccess() {
+			t.Fatalf("Reserve %s: %v", name, status.Message())
+		}
+		data, _ := readState(state)
+		if len(data.chosenIDs) != 1 || data.chosenIDs[0] != 0 {
+			t.Errorf("Expected %s on device 0, got %v", name, data.chosenIDs)
+		}
+	}
+
+	state := halfState()
+	if status := p.Filter(ctx, state, testPod("infer-2"), nodeInfo(node)); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected a third half claim to be filtered out, got %v", status.Code())
+	}
+	if status := p.Reserve(ctx, state, testPod("infer-2"), "node-a"); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected a third half claim to fail Reserve, got %v", status.Code())
+	}
+	if status := p.Filter(ctx, cycleStateFor(1), testPod("train"), nodeInfo(node)); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected a whole claim to skip the shared device, got %v", status.Code())
+	}
+
+	// Releasing one share makes room again.
+	first := halfState()
+	data, _ := readState(first)
+	data.chosenIDs = []int{0}
+	p.Unreserve(ctx, first, testPod("infer-0"), "node-a")
+	if status := p.Filter(ctx, halfState(), testPod("infer-2"), nodeInfo(node)); !status.IsSuccess() {
+		t.Errorf("Expected room after release, got %v", status.Message())
+	}
+}
+
+func TestBurstableSharesPackByRequest(t *testing.T) {
+	ctx := context.Background()
+	node := gpuNode("node-a", "1")
+	p := newTestPlugin(gpuNodeStatus("node-a", 0))
+
+	schedule := func(name, claim string) *framework.Status {
+		pod := testPod(name)
+		pod.Annotations = map[string]string{util.AnnoClaim: claim}
+		state := framework.NewCycleState()
+		if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+			t.Fatalf("PreFilter %s: %v", name, status.Message())
+		}
+		if status := p.Filter(ctx, state, pod, nodeInfo(node)); !status.IsSuccess() {
+			return status
+		}
+		return p.Reserve(ctx, state, pod, "node-a")
+	}
+
+	// Three shares bursting to 0.6 each fit, since only their requests count.
+	for _, name := range []string{"infer-0", "infer-1", "infer-2"} {
+		if status := schedule(name, "req=0.3,lim=0.6"); !status.IsSuccess() {
+			t.Fatalf("Expected %s to fit, got %v", name, status.Message())
+		}
+	}
+	if status := schedule("infer-3", "0.3,lim=0.6"); status.Code() != framework.Unschedulable {
+		t.Errorf("Expected a fourth 0.3 request not to fit, got %v", status.Code())
+	}
+	if status := schedule("small", "0.1"); !status.IsSuccess() {
+		t.Errorf("Expected the remaining 0.1 to fit, got %v", status.Message())
+	}
+
+	leases, _ := p.coord.Leases(lease.DefaultNamespace).List(ctx, metav1.ListOptions{})
+	limits := map[string]string{}
+	for _, l := range leases.Items {
+		if l.Annotations["gpu.scheduling/fraction"] == "" {
+			t.Errorf("Expected lease %s to record its fraction", l.Name)
+		}
+		limits[l.Labels["gpu.scheduling/pod"]] = l.Annotations["gpu.scheduling/fraction-limit"]
+	}
+	want := map[string]string{"infer-0": "0.6", "infer-1": "0.6", "infer-2": "0.6", "small": ""}
+	if !maps.Equal(limits, want) {
+		t.Errorf("Expected limits %v, got %v", want, limits)
+	}
+}
+
+func TestFilterGPUModel(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	modelNode := func(name, model string) *corev1.Node {
+		node := gpuNode(name, "4")
+		if model != "" {
+			node.Labels[util.LabelModel] = model
+		}
+		return node
+	}
+	nodes := []*corev1.Node{
+		modelNode("a100-node", "A100"),
+		modelNode("v100-node", "V100"),
+		modelNode("unlabeled", ""),
+	}
+
+	tests := []struct {
+		claim string
+		want  map[string]framework.Code
+	}{
+		{claim: "2,model=A100", want: map[string]framework.Code{
+			"a100-node": framework.Success,
+			"v100-node": framework.UnschedulableAndUnresolvable,
+			"unlabeled": framework.UnschedulableAndUnresolvable,
+		}},
+		{claim: "2,model=v100", want: map[string]framework.Code{
+			"a100-node": framework.UnschedulableAndUnresolvable,
+			"v100-node": framework.Success,
+			"unlabeled": framework.UnschedulableAndUnresolvable,
+		}},
+		{claim: "2", want: map[string]framework.Code{
+			"a100-node": framework.Success,
+			"v100-node": framework.Success,
+			"unlabeled": framework.Success,
+		}},
+	}
+	for _, tt := range tests {
+		pod := testPod("trainer")
+		pod.Annotations = map[string]string{util.AnnoClaim: tt.claim}
+		state := framework.NewCycleState()
+		if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+			t.Fatalf("PreFilter(%q): %v", tt.claim, status.Message())
+		}
+		for _, node := range nodes {
+			if got := p.Filter(ctx, state, pod, nodeInfo(node)).Code(); got != tt.want[node.Name] {
+				t.Errorf("claim %q on %s: expected %v, got %v", tt.claim, node.Name, tt.want[node.Name], got)
+			}
+		}
+	}
+}
+
+func TestPreFilterInvalidClaim(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	for _, annotations := range []map[string]string{
+		{util.AnnoClaim: "2,vendor=nvidia"},
+		{util.AnnoClaim: "1", util.AnnoMIGProfile: "1g"},
+	} {
+		pod := testPod("trainer")
+		pod.Annotations = annotations
+		_, status := p.PreFilter(ctx, framework.NewCycleState(), pod)
+		if status.Code() != framework.UnschedulableAndUnresolvable {
+			t.Errorf("%v: Expected UnschedulableAndUnresolvable, got %v", annotations, status.Code())
+		}
+		if !errors.Is(status.AsError(), util.ErrInvalidClaim) {
+			t.Errorf("%v: Expected the status error to wrap ErrInvalidClaim, got %v", annotations, status.AsError())
+		}
+	}
+}
+
+func TestPreFilterSkipsPodsWithoutClaim(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+	node := gpuNode("node-a", "4")
+
+	pod := testPod("web")
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSkip() {
+		t.Fatalf("Expected PreFilter to skip a pod without a claim, got %v: %s", status.Code(), status.Message())
+	}
+	if _, err := state.Read(Name); err == nil {
+		t.Errorf("Expected no claim state for a skipped pod")
+	}
+	if status := p.PreScore(ctx, state, pod, []*framework.NodeInfo{nodeInfo(node)}); !status.IsSkip() {
+		t.Errorf("Expected PreScore to skip, got %v", status.Code())
+	}
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Errorf("Expected Reserve to pass, got %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Errorf("Expected PreBind to pass, got %v", status.Message())
+	}
+	if held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "node-a"); len(held) != 0 {
+		t.Errorf("Expected no leases for a pod without a claim, got %d", len(held))
+	}
+
+	gpuPod := testPod("trainer")
+	gpuPod.Annotations = map[string]string{util.AnnoClaim: "2,model=A100"}
+	state = framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, gpuPod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	data, err := readState(state)
+	if err != nil {
+		t.Fatalf("readState: %v", err)
+	}
+	if data.reqCount != 2 || data.model != "A100" {
+		t.Errorf("Expected 2 A100 GPUs in state, got %d %q", data.reqCount, data.model)
+	}
+	if status := p.PreScore(ctx, state, gpuPod, []*framework.NodeInfo{nodeInfo(node)}); !status.IsSuccess() {
+		t.Errorf("Expected PreScore to pass for a GPU pod, got %v", status.Code())
+	}
+}
+
+func TestPreFilterRejectsClaimLargerThanAnyNode(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(gpuNode("node-a", "8")), nodeInfo(gpuNode("node-b", "4"))}
+
+	tests := []struct {
+		claim string
+		want  framework.Code
+	}{
+		{claim: "8", want: framework.Success},
+		{claim: "12", want: framework.UnschedulableAndUnresolvable},
+	}
+	for _, tt := range tests {
+		pod := testPod("trainer")
+		pod.Annotations = map[string]string{util.AnnoClaim: tt.claim}
+		_, status := p.PreFilter(ctx, framework.NewCycleState(), pod)
+		if got := status.Code(); got != tt.want {
+			t.Errorf("claim %s: Expected %v, got %v (%s)", tt.claim, tt.want, got, status.Message())
+		}
+		if tt.want == framework.Success {
+			continue
+		}
+		msg := status.Message()
+		if !strings.Contains(msg, "requests 12 GPUs but the largest node has 8") || !strings.Contains(msg, util.AnnoGang) {
+			t.Errorf("Expected the message to name the largest node and suggest a gang, got %q", msg)
+		}
+	}
+}
+
+func TestPreFilterMaxGPUsPerPod(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+	p.maxGPUsPerPod = 4
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(gpuNode("node-a", "8"))}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        framework.Code
+	}{
+		{name: "at the cap", annotations: map[string]string{util.AnnoClaim: "4"}, want: framework.Success},
+		{name: "above the cap", annotations: map[string]string{util.AnnoClaim: "6"}, want: framework.UnschedulableAndUnresolvable},
+		{name: "MIG instances", annotations: map[string]string{util.AnnoClaim: "6", util.AnnoMIGProfile: "1g.5gb"}, want: framework.Success},
+	}
+	for _, tt := range tests {
+		pod := testPod("trainer")
+		pod.Annotations = tt.annotations
+		_, status := p.PreFilter(ctx, framework.NewCycleState(), pod)
+		if got := status.Code(); got != tt.want {
+			t.Errorf("%s: Expected %v, got %v (%s)", tt.name, tt.want, got, status.Message())
+		}
+		if tt.want != framework.Success && !strings.Contains(status.Message(), "requests 6 GPUs, above the limit of 4 GPUs per pod") {
+			t.Errorf("%s: Expected the message to name the cap, got %q", tt.name, status.Message())
+		}
+	}
+}
+
+func TestContainerDevices(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	pod.Spec.Containers = []corev1.Container{{Name: "trainer"}, {Name: "evaluator"}}
+	p := newTestPlugin(pod)
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(gpuNode("node-a", "4"))}
+
+	for devices, want := range map[string]framework.Code{
+		`{"trainer":2,"evaluator":1}`: framework.Success,
+		`{"trainer":2,"evaluator":2}`: framework.UnschedulableAndUnresolvable,
+		`{"trainer":3,"ghost":1}`:     framework.UnschedulableAndUnresolvable,
+	} {
+		claiming := pod.DeepCopy()
+		claiming.Annotations = map[string]string{util.AnnoClaim: "3", util.AnnoContainerDevices: devices}
+		_, status := p.PreFilter(ctx, framework.NewCycleState(), claiming)
+		if got := status.Code(); got != want {
+			t.Errorf("%s: Expected %v, got %v (%s)", devices, want, got, status.Message())
+		}
+		if devices == `{"trainer":2,"evaluator":2}` && !strings.Contains(status.Message(), "assigns 4 devices, but the claim is for 3") {
+			t.Errorf("Expected the message to give both counts, got %q", status.Message())
+		}
+	}
+
+	// PreBind gives each container its own slice of the reserved devices.
+	pod.Annotations = map[string]string{util.AnnoClaim: "3", util.AnnoContainerDevices: `{"trainer":2,"evaluator":1}`}
+	state := cycleStateFor(3)
+	data, _ := readState(state)
+	data.chosenIDs = []int{1, 2, 3}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+	got, err := p.client.CoreV1().Pods("default").Get(ctx, "trainer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get pod: %v", err)
+	}
+	for container, want := range map[string]string{"trainer": "1,2", "evaluator": "3"} {
+		if v := got.Annotations[util.ContainerAllocatedKey(container)]; v != want {
+			t.Errorf("Expected %s to be given devices %s, got %q", container, want, v)
+		}
+	}
+}
+
+func TestFilterReadsLeaseInventory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+	cs := p.client.(*fake.Clientset)
+	informer := lease.NewInformer(cs, 0, lease.DefaultLabelPrefix, metav1.NamespaceAll)
+	inventory, err := lease.NewInventory(informer)
+	if err != nil {
+		t.Fatalf("NewInventory: %v", err)
+	}
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), inventory.HasSynced) {
+		t.Fatalf("inventory did not sync")
+	}
+	p.inventory = inventory
+	p.coord = inventory.Track(cs.CoordinationV1())
+	node := gpuNode("node-a", "4")
+
+	if status := p.Reserve(ctx, cycleStateFor(3), testPod("trainer"), "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	cs.ClearActions()
+	// The inventory reflects Reserve at once, without waiting on the informer.
+	if code := p.Filter(ctx, cycleStateFor(2), testPod("next"), nodeInfo(node)).Code(); code != framework.Unschedulable {
+		t.Errorf("Expected Unschedulable with 1 of 4 GPUs free, got %v", code)
+	}
+	if _, status := p.Score(ctx, cycleStateFor(1), testPod("next"), nodeInfo(node)); !status.IsSuccess() {
+		t.Errorf("Score: %v", status.Message())
+	}
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "leases" {
+			t.Errorf("Expected Filter and Score to read the inventory, got a lease list")
+		}
+	}
+}
+
+func TestPreFilterWaitsForLeaseAdoption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3))
+	cs := p.client.(*fake.Clientset)
+	// Leases held by running pods before the scheduler restarted.
+	for i := 0; i < 3; i++ {
+		if _, err := lease.TryAcquire(ctx, cs.CoordinationV1(), lease.DefaultLabelPrefix, "default", "node-a", "uid-running-"+strconv.Itoa(i), podRef("default", "running-"+strconv.Itoa(i)), i); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	informer := lease.NewInformer(cs, 0, lease.DefaultLabelPrefix, metav1.NamespaceAll)
+	inventory, err := lease.NewInventory(informer)
+	if err != nil {
+		t.Fatalf("NewInventory: %v", err)
+	}
+	p.inventory = inventory
+	p.coord = inventory.Track(cs.CoordinationV1())
+	node := gpuNode("node-a", "4")
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: "2"}
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), pod); status.Code() != framework.Unschedulable {
+		t.Fatalf("Expected GPU pods to wait for the inventory, got %v", status.Code())
+	}
+	cpuPod := testPod("web")
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), cpuPod); status.Code() != framework.Skip {
+		t.Errorf("Expected pods without a claim to be skipped, got %v", status.Code())
+	}
+
+	// The informer never runs: adoption alone must make the held devices count.
+	adoptLeases(ctx, inventory, cs.CoordinationV1(), lease.DefaultLabelPrefix)
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter after adoption: %v", status.Message())
+	}
+	cs.ClearActions()
+	if code := p.Filter(ctx, state, pod, nodeInfo(node)).Code(); code != framework.Unschedulable {
+		t.Errorf("Expected Unschedulable with 1 of 4 GPUs free, got %v", code)
+	}
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "leases" {
+			t.Errorf("Expected Filter to read the adopted inventory, got a lease list")
+		}
+	}
+}
+
+func TestNodeAffinityRestrictsFilterAndReserve(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("zone-a-1", 0, 1), gpuNodeStatus("zone-b-1", 0, 1))
+	zoneA, zoneB := gpuNode("zone-a-1", "2"), gpuNode("zone-b-1", "2")
+	zoneA.Labels[corev1.LabelTopologyZone] = "zone-a"
+	zoneB.Labels[corev1.LabelTopologyZone] = "zone-b"
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(zoneA), nodeInfo(zoneB)}
+
+	pinned := func(name string) *corev1.Pod {
+		pod := testPod(name)
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      corev1.LabelTopologyZone,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"zone-a"},
+					}},
+				}},
+			},
+		}}
+		return pod
+	}
+	selected := testPod("selected")
+	selected.Spec.NodeSelector = map[string]string{corev1.LabelTopologyZone: "zone-a"}
+
+	for _, pod := range []*corev1.Pod{pinned("pinned"), selected} {
+		if got := p.Filter(ctx, cycleStateFor(1), pod, nodeInfo(zoneA)).Code(); got != framework.Success {
+			t.Errorf("%s on zone-a: expected Success, got %v", pod.Name, got)
+		}
+		if got := p.Filter(ctx, cycleStateFor(1), pod, nodeInfo(zoneB)).Code(); got != framework.UnschedulableAndUnresolvable {
+			t.Errorf("%s on zone-b: expected UnschedulableAndUnresolvable, got %v", pod.Name, got)
+		}
+	}
+
+	pod := pinned("pinned")
+	if status := p.Reserve(ctx, cycleStateFor(1), pod, "zone-b-1"); status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected Reserve on zone-b to be refused, got %v", status.Code())
+	}
+	if held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "zone-b-1"); len(held) != 0 {
+		t.Errorf("Expected no leases on zone-b, got %d", len(held))
+	}
+	if status := p.Reserve(ctx, cycleStateFor(1), pod, "zone-a-1"); !status.IsSuccess() {
+		t.Errorf("Reserve on zone-a: %v", status.Message())
+	}
+}
+
+func TestCordonedNodeRejected(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	node := gpuNode("node-a", "2")
+	node.Labels[util.LabelCordoned] = "true"
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+
+	if got := p.Filter(ctx, cycleStateFor(1), testPod("trainer"), nodeInfo(node)).Code(); got != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected Filter to reject a cordoned node, got %v", got)
+	}
+	if got := p.Reserve(ctx, cycleStateFor(1), testPod("trainer"), "node-a").Code(); got != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected Reserve to refuse a cordoned node, got %v", got)
+	}
+	if held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "node-a"); len(held) != 0 {
+		t.Errorf("Expected no leases on a cordoned node, got %d", len(held))
+	}
+}
+
+func TestUnschedulableLabelRejectsGPUPodsOnly(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	node := gpuNode("node-a", "2")
+	node.Labels[util.LabelUnschedulable] = "true"
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+
+	gpuPod := testPod("trainer")
+	gpuPod.Annotations = map[string]string{util.AnnoClaim: "1"}
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, gpuPod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if got := p.Filter(ctx, state, gpuPod, nodeInfo(node)).Code(); got != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Expected Filter to reject the GPU pod, got %v", got)
+	}
+
+	// Without a claim the plugin skips the pod, so it never filters the node
+	// and the rest of the framework is free to place the pod there.
+	if _, status := p.PreFilter(ctx, framework.NewCycleState(), testPod("web")); status.Code() != framework.Skip {
+		t.Errorf("Expected PreFilter to skip a CPU pod, got %v", status.Code())
+	}
+}
@@ -0,0 +1,124 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// podGroupSyncTimeout bounds how long the plugin waits for the PodGroups to
+// load when it starts, so a cluster without the CRD still gets a scheduler.
+const podGroupSyncTimeout = 30 * time.Second
+
+// errPodGroupsUnavailable marks a PodGroup that could not be read for now,
+// e.g. before the informer has loaded; the pod is retried rather than
+// rejected for good.
+var errPodGroupsUnavailable = errors.New("PodGroups unavailable")
+
+// podGroupResource and legacyPodGroupResource are the coscheduling plugin's
+// PodGroup, as current scheduler-plugins releases serve it and as earlier
+// ones did. They are read as unstructured, so the plugin does not depend on
+// scheduler-plugins.
+var (
+	podGroupResource       = schema.GroupVersionResource{Group: "scheduling.x-k8s.io", Version: "v1alpha1", Resource: "podgroups"}
+	legacyPodGroupResource = schema.GroupVersionResource{Group: "scheduling.sigs.k8s.io", Version: "v1alpha1", Resource: "podgroups"}
+)
+
+// podGroupLabels maps the pod label naming a PodGroup to the resource the
+// group is read from.
+var podGroupLabels = map[string]schema.GroupVersionResource{
+	util.LabelPodGroup:       podGroupResource,
+	util.LabelPodGroupLegacy: legacyPodGroupResource,
+}
+
+// podGroupRef names the PodGroup a pod belongs to.
+type podGroupRef struct {
+	resource schema.GroupVersionResource
+	name     string
+}
+
+// podGroupOf returns the PodGroup pod's labels name. LabelPodGroup wins
+// over LabelPodGroupLegacy.
+func podGroupOf(pod *corev1.Pod) (podGroupRef, bool) {
+	for _, label := range []string{util.LabelPodGroup, util.LabelPodGroupLegacy} {
+		if name := pod.GetLabels()[label]; name != "" {
+			return podGroupRef{resource: podGroupLabels[label], name: name}, true
+		}
+	}
+	return podGroupRef{}, false
+}
+
+// podGroupSource reads the PodGroups of one API group from an informer
+// cache.
+type podGroupSource struct {
+	lister cache.GenericLister
+	synced cache.InformerSynced
+}
+
+// podGroups reads PodGroups of either API group.
+type podGroups struct {
+	sources map[schema.GroupVersionResource]podGroupSource
+}
+
+// newPodGroups starts an informer on every namespace's PodGroups, of both
+// API groups, and waits up to podGroupSyncTimeout for them to load. A
+// cluster usually serves only one; pods naming a group of the other are
+// retried until it loads.
+func newPodGroups(ctx context.Context, client dynamic.Interface) *podGroups {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	g := &podGroups{sources: map[schema.GroupVersionResource]podGroupSource{}}
+	var synced []cache.InformerSynced
+	for _, resource := range []schema.GroupVersionResource{podGroupResource, legacyPodGroupResource} {
+		informer := factory.ForResource(resource)
+		g.sources[resource] = podGroupSource{lister: informer.Lister(), synced: informer.Informer().HasSynced}
+		synced = append(synced, informer.Informer().HasSynced)
+	}
+	factory.Start(ctx.Done())
+	syncCtx, cancel := context.WithTimeout(ctx, podGroupSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		for resource, source := range g.sources {
+			if !source.synced() {
+				klog.InfoS("PodGroups not loaded yet; pods naming one are retried until they are", "group", resource.Group, "timeout", podGroupSyncTimeout)
+			}
+		}
+	}
+	return g
+}
+
+// minMember returns the spec.minMember of PodGroup ref in namespace. The
+// error wraps errPodGroupsUnavailable when the group may yet be read.
+func (g *podGroups) minMember(namespace string, ref podGroupRef) (int, error) {
+	source := g.sources[ref.resource]
+	if !source.synced() {
+		return 0, fmt.Errorf("%w: %s not loaded yet", errPodGroupsUnavailable, ref.resource.Group)
+	}
+	obj, err := source.lister.ByNamespace(namespace).Get(ref.name)
+	if apierrors.IsNotFound(err) {
+		return 0, fmt.Errorf("PodGroup %s/%s does not exist", namespace, ref.name)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: get PodGroup %s/%s: %v", errPodGroupsUnavailable, namespace, ref.name, err)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return 0, fmt.Errorf("unexpected PodGroup object %T", obj)
+	}
+	n, found, err := unstructured.NestedInt64(u.Object, "spec", "minMember")
+	if err != nil || !found || n <= 0 {
+		return 0, fmt.Errorf("PodGroup %s/%s has no positive spec.minMember", namespace, ref.name)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,282 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// victim is a pod holding GPU leases on a preemption candidate node.
+type victim struct {
+	pod    *corev1.Pod
+	leases []coordv1.Lease
+}
+
+// preemption is the set of victims that frees enough GPUs on one node.
+type preemption struct {
+	node    string
+	victims []victim
+}
+
+// maxPriority is the highest priority among the victims.
+func (c *preemption) maxPriority() int32 {
+	var highest int32
+	for i, v := range c.victims {
+		if pr := podPriority(v.pod); i == 0 || pr > highest {
+			highest = pr
+		}
+	}
+	return highest
+}
+
+// better prefers the preemption that evicts the least important pods, then
+// the one that evicts fewer of them.
+func (c *preemption) better(other *preemption) bool {
+	if a, b := c.maxPriority(), other.maxPriority(); a != b {
+		return a < b
+	}
+	if len(c.victims) != len(other.victims) {
+		return len(c.victims) < len(other.victims)
+	}
+	return c.node < other.node
+}
+
+// PostFilter makes room for a whole-device claim that fits on no node by
+// evicting lower-priority pods that hold GPU leases on a single node. Victims
+// whose PodDisruptionBudget allows no further disruption are never chosen.
+// The pod is nominated to the node, whose devices the next cycles reserve
+// once the victims are gone and the lease GC has released their leases.
+func (p *Plugin) PostFilter(ctx context.Context, cycleState *framework.CycleState, pod *corev1.Pod, filteredNodeStatusMap framework.NodeToStatusReader) (*framework.PostFilterResult, *framework.Status) {
+	data, err := readState(cycleState)
+	if errors.Is(err, framework.ErrNotFound) {
+		return nil, framework.NewStatus(framework.Unschedulable, "no GPU claim state to preempt for")
+	}
+	if err != nil {
+		return nil, framework.AsStatus(err)
+	}
+	if data.fraction > 0 || data.migProfile != "" {
+		return nil, framework.NewStatus(framework.Unschedulable, data.withReasons("GPU preemption only supports whole-device claims"))
+	}
+	if p.handle == nil {
+		return nil, framework.NewStatus(framework.Unschedulable, data.withReasons("GPU preemption needs a framework handle"))
+	}
+	// Nodes rejected as unresolvable, e.g. for the wrong GPU model, cannot
+	// be fixed by evicting pods.
+	nodes, err := filteredNodeStatusMap.NodesForStatusCode(p.handle.SnapshotSharedLister().NodeInfos(), framework.Unschedulable)
+	if err != nil {
+		return nil, framework.AsStatus(err)
+	}
+
+	budgets := newPDBBudgets(p)
+	var best *preemption
+	var candidates []*corev1.Node
+	for _, ni := range nodes {
+		node := ni.Node()
+		if node == nil || !util.NodeHasModel(node, data.model) {
+			continue
+		}
+		candidates = append(candidates, node)
+		c, err := p.selectVictims(ctx, pod, node, data.reqCount, budgets)
+		if err != nil {
+			klog.V(4).InfoS("skipping preemption candidate", "pod", klog.KObj(pod), "node", node.Name, "err", err)
+			continue
+		}
+		if c != nil && (best == nil || c.better(best)) {
+			best = c
+		}
+	}
+	if best == nil {
+		return p.postFilterFragmented(ctx, pod, data, candidates, budgets)
+	}
+
+	if err := p.preempt(ctx, pod, best); err != nil {
+		return nil, framework.AsStatus(err)
+	}
+	return framework.NewPostFilterResultWithNominatedNode(best.node), framework.NewStatus(framework.Success)
+}
+
+// selectVictims picks the lowest-priority lease holders on node whose
+// eviction frees need whole devices. It returns nil when no such set exists.
+func (p *Plugin) selectVictims(ctx context.Context, preemptor *corev1.Pod, node *corev1.Node, need int, budgets *pdbBudgets) (*preemption, error) {
+	devices := p.nodeDevices(node)
+	if len(devices) < need {
+		return nil, nil
+	}
+	held, err := lease.ListNode(ctx, p.coord, p.labelPrefix, node.Name)
+	if err != nil {
+		return nil, fmt.Errorf("list leases: %w", err)
+	}
+	held = occupying(preemptor, held)
+
+	var candidates []victim
+	for key, leases := range lease.Holders(held) {
+		pod, err := p.getPod(ctx, key)
+		if apierrors.IsNotFound(err) {
+			// Left for the lease GC.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get pod %s: %w", key, err)
+		}
+		if podPriority(pod) >= podPriority(preemptor) {
+			continue
+		}
+		candidates = append(candidates, victim{pod: pod, leases: leases})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := podPriority(candidates[i].pod), podPriority(candidates[j].pod)
+		if pi != pj {
+			return pi < pj
+		}
+		return candidates[i].pod.Name < candidates[j].pod.Name
+	})
+
+	// Evict the least important pods first until enough devices are free,
+	// then spare any victim the others already make room without.
+	var chosen []victim
+	enough := func(victims []victim) bool {
+		return freeDevices(lease.DeviceUsage(remainingLeases(held, victims)), devices) >= need
+	}
+	for _, v := range candidates {
+		if enough(chosen) {
+			break
+		}
+		allowed, err := budgets.allows(ctx, v.pod, chosen)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+		chosen = append(chosen, v)
+	}
+	if len(chosen) == 0 || !enough(chosen) {
+		return nil, nil
+	}
+	for i := len(chosen) - 1; i >= 0; i-- {
+		without := append(append([]victim(nil), chosen[:i]...), chosen[i+1:]...)
+		if enough(without) {
+			chosen = without
+		}
+	}
+	return &preemption{node: node.Name, victims: chosen}, nil
+}
+
+// remainingLeases drops the victims' leases from held.
+func remainingLeases(held []coordv1.Lease, victims []victim) []coordv1.Lease {
+	gone := map[types.NamespacedName]bool{}
+	for _, v := range victims {
+		for _, l := range v.leases {
+			gone[types.NamespacedName{Namespace: l.Namespace, Name: l.Name}] = true
+		}
+	}
+	var out []coordv1.Lease
+	for _, l := range held {
+		if !gone[types.NamespacedName{Namespace: l.Namespace, Name: l.Name}] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// preempt evicts the victims through the Eviction API, which enforces their
+// disruption budgets again, stopping at the first eviction that fails. The
+// victims keep their leases while they shut down, since they may still be
+// using the devices; the lease GC releases them once the pods are gone.
+func (p *Plugin) preempt(ctx context.Context, preemptor *corev1.Pod, c *preemption) error {
+	for _, v := range c.victims {
+		klog.InfoS("preempting pod for GPUs", "preemptor", klog.KObj(preemptor), "victim", klog.KObj(v.pod), "node", c.node)
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: v.pod.Name, Namespace: v.pod.Namespace}}
+		callCtx, cancel := p.callContext(ctx)
+		err := p.client.CoreV1().Pods(v.pod.Namespace).EvictV1(callCtx, eviction)
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("evict %s/%s: %w", v.pod.Namespace, v.pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// getPod returns the pod from p.pods, or from the API server without one.
+func (p *Plugin) getPod(ctx context.Context, key types.NamespacedName) (*corev1.Pod, error) {
+	if p.pods != nil {
+		return p.pods.Pods(key.Namespace).Get(key.Name)
+	}
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	return p.client.CoreV1().Pods(key.Namespace).Get(callCtx, key.Name, metav1.GetOptions{})
+}
+
+// pdbBudgets caches PodDisruptionBudgets per namespace for one PostFilter.
+type pdbBudgets struct {
+	p    *Plugin
+	pdbs map[string][]policyv1.PodDisruptionBudget
+}
+
+func newPDBBudgets(p *Plugin) *pdbBudgets {
+	return &pdbBudgets{p: p, pdbs: map[string][]policyv1.PodDisruptionBudget{}}
+}
+
+// allows reports whether every budget covering pod can absorb one more
+// disruption on top of the victims already chosen.
+func (b *pdbBudgets) allows(ctx context.Context, pod *corev1.Pod, chosen []victim) (bool, error) {
+	pdbs, ok := b.pdbs[pod.Namespace]
+	if !ok {
+		callCtx, cancel := b.p.callContext(ctx)
+		list, err := b.p.client.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(callCtx, metav1.ListOptions{})
+		cancel()
+		if err != nil {
+			return false, fmt.Errorf("list PodDisruptionBudgets in %s: %w", pod.Namespace, err)
+		}
+		pdbs = list.Items
+		b.pdbs[pod.Namespace] = pdbs
+	}
+	for i := range pdbs {
+		pdb := &pdbs[i]
+		if !pdbCovers(pdb, pod) {
+			continue
+		}
+		used := int32(0)
+		for _, v := range chosen {
+			if pdbCovers(pdb, v.pod) {
+				used++
+			}
+		}
+		if pdb.Status.DisruptionsAllowed-used <= 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func pdbCovers(pdb *policyv1.PodDisruptionBudget, pod *corev1.Pod) bool {
+	if pdb.Namespace != pod.Namespace || pdb.Spec.Selector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil || selector.Empty() {
+		return false
+	}
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+func podPriority(pod *corev1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	return 0
+}
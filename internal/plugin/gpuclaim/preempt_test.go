@@ -0,0 +1,182 @@
+package gpuclaim
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func priorityPod(name string, priority int32) *corev1.Pod {
+	pod := testPod(name)
+	pod.Labels = map[string]string{"app": name}
+	pod.Spec.Priority = &priority
+	return pod
+}
+
+func TestPostFilterPreemptsLowerPriority(t *testing.T) {
+	tests := []struct {
+		name        string
+		preemptor   int32
+		pdbs        []runtime.Object
+		wantNode    string
+		wantEvicted []string
+	}{
+		{
+			name:        "evicts the lowest priority holder",
+			preemptor:   1000,
+			wantNode:    "node-a",
+			wantEvicted: []string{"batch-low"},
+		},
+		{
+			name:      "PDB blocks the only candidates",
+			preemptor: 1000,
+			pdbs: []runtime.Object{
+				pdb("low", "batch-low", 0),
+				pdb("high", "batch-high", 0),
+			},
+		},
+		{
+			name:      "PDB on the lowest moves to the next",
+			preemptor: 1000,
+			pdbs: []runtime.Object{
+				pdb("low", "batch-low", 0),
+				pdb("high", "batch-high", 1),
+			},
+			wantNode:    "node-a",
+			wantEvicted: []string{"batch-high"},
+		},
+		{
+			name:      "equal priority is not preempted",
+			preemptor: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			low, high := priorityPod("batch-low", 10), priorityPod("batch-high", 100)
+			p := newTestPlugin(append([]runtime.Object{low, high}, tt.pdbs...)...)
+			cs := p.client.(*fake.Clientset)
+			for id, pod := range []*corev1.Pod{low, high} {
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, pod.Namespace, "node-a", string(pod.UID), podKey(pod), id); err != nil {
+					t.Fatalf("TryAcquire: %v", err)
+				}
+			}
+			node := gpuNode("node-a", "2")
+			p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(node)}
+			statuses := framework.NewDefaultNodeToStatus()
+			statuses.Set("node-a", framework.NewStatus(framework.Unschedulable, "insufficient free GPUs"))
+			cs.ClearActions()
+
+			result, status := p.PostFilter(ctx, cycleStateFor(1), priorityPod("interactive", tt.preemptor), statuses)
+
+			var evicted []string
+			for _, action := range cs.Actions() {
+				if action.GetSubresource() == "eviction" {
+					evicted = append(evicted, action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+				}
+			}
+			if !slices.Equal(evicted, tt.wantEvicted) {
+				t.Errorf("Expected evictions %v, got %v", tt.wantEvicted, evicted)
+			}
+			if tt.wantNode == "" {
+				if status.Code() != framework.Unschedulable {
+					t.Errorf("Expected Unschedulable, got %v: %s", status.Code(), status.Message())
+				}
+				return
+			}
+			if !status.IsSuccess() {
+				t.Fatalf("PostFilter: %v", status.Message())
+			}
+			if result == nil || result.NominatedNodeName != tt.wantNode {
+				t.Errorf("Expected nomination to %s, got %+v", tt.wantNode, result)
+			}
+			// The victim may still be using its device while it shuts down;
+			// its lease is the lease GC's to release.
+			held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "node-a")
+			if len(held) != 2 {
+				t.Errorf("Expected the victim's lease kept, got %d leases", len(held))
+			}
+		})
+	}
+}
+
+func TestPostFilterPreemptReadsVictimsFromLister(t *testing.T) {
+	ctx := context.Background()
+	low, high := priorityPod("batch-low", 10), priorityPod("batch-high", 100)
+	// The API server is not asked for the victims: only the lister has them.
+	p := newTestPlugin()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for id, pod := range []*corev1.Pod{low, high} {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("seed pod cache: %v", err)
+		}
+		if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, pod.Namespace, "node-a", string(pod.UID), podKey(pod), id); err != nil {
+			t.Fatalf("TryAcquire: %v", err)
+		}
+	}
+	p.pods = corelisters.NewPodLister(indexer)
+	p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(gpuNode("node-a", "2"))}
+	statuses := framework.NewDefaultNodeToStatus()
+	statuses.Set("node-a", framework.NewStatus(framework.Unschedulable, "insufficient free GPUs"))
+	cs := p.client.(*fake.Clientset)
+	cs.ClearActions()
+
+	result, status := p.PostFilter(ctx, cycleStateFor(1), priorityPod("interactive", 1000), statuses)
+	if !status.IsSuccess() || result == nil || result.NominatedNodeName != "node-a" {
+		t.Fatalf("Expected nomination to node-a, got %+v: %v", result, status.Message())
+	}
+	for _, action := range cs.Actions() {
+		if action.Matches("get", "pods") {
+			t.Errorf("Expected the victims read from the lister, got %s %s", action.GetVerb(), action.(k8stesting.GetAction).GetName())
+		}
+	}
+}
+
+func TestPreemptStopsAtFailedEviction(t *testing.T) {
+	ctx := context.Background()
+	a, b := priorityPod("batch-a", 10), priorityPod("batch-b", 10)
+	p := newTestPlugin(a, b)
+	cs := p.client.(*fake.Clientset)
+	cs.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewTooManyRequests("disruption budget exhausted", 10)
+	})
+	plan := &preemption{node: "node-a", victims: []victim{{pod: a}, {pod: b}}}
+	if err := p.preempt(ctx, priorityPod("interactive", 1000), plan); err == nil {
+		t.Fatalf("Expected the failed eviction reported")
+	}
+	var evictions int
+	for _, action := range cs.Actions() {
+		if action.GetSubresource() == "eviction" {
+			evictions++
+		}
+	}
+	if evictions != 1 {
+		t.Errorf("Expected eviction to stop at the first failure, got %d attempts", evictions)
+	}
+}
+
+func pdb(name, app string, allowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+	}
+}
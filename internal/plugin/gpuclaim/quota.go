@@ -0,0 +1,105 @@
+package gpuclaim
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// quotas reads per-namespace GPU limits from a ConfigMap whose keys are
+// namespaces and whose values are GPU counts, e.g. team-a: "8" or "2.5".
+// Namespaces without a key are not limited.
+type quotas struct {
+	namespace  string
+	name       string
+	configMaps corelisters.ConfigMapLister
+	synced     cache.InformerSynced
+}
+
+// newQuotas watches the ConfigMap key names, in namespace/name form, and
+// returns once its informer is started.
+func newQuotas(ctx context.Context, client clientset.Interface, key string) (*quotas, error) {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps()
+	q := &quotas{namespace: ns, name: name, configMaps: informer.Lister(), synced: informer.Informer().HasSynced}
+	factory.Start(ctx.Done())
+	return q, nil
+}
+
+// limit returns the GPU quota of namespace ns, and false when it has none.
+func (q *quotas) limit(ns string) (float64, bool, error) {
+	if q.synced != nil && !q.synced() {
+		return 0, false, fmt.Errorf("GPU quota ConfigMap %s/%s not loaded yet", q.namespace, q.name)
+	}
+	cm, err := q.configMaps.ConfigMaps(q.namespace).Get(q.name)
+	if apierrors.IsNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("get GPU quota ConfigMap: %w", err)
+	}
+	v, ok := cm.Data[ns]
+	if !ok {
+		return 0, false, nil
+	}
+	limit, err := strconv.ParseFloat(v, 64)
+	if err != nil || limit < 0 {
+		return 0, false, fmt.Errorf("invalid GPU quota %q for namespace %s in ConfigMap %s/%s", v, ns, q.namespace, q.name)
+	}
+	return limit, true, nil
+}
+
+// claimGPUs is how much of a GPU quota the claim takes: its share for a
+// fractional claim, otherwise one per device or MIG instance.
+func claimGPUs(data *stateData) float64 {
+	if data.fraction > 0 {
+		return data.fraction
+	}
+	return float64(data.reqCount)
+}
+
+// checkQuota rejects the pod when its claim would take its namespace past the
+// namespace's GPU quota. Usage counts every lease the namespace holds,
+// including those of pods still waiting in Permit.
+func (p *Plugin) checkQuota(ctx context.Context, pod *corev1.Pod, data *stateData) *framework.Status {
+	if p.quotas == nil {
+		return nil
+	}
+	limit, ok, err := p.quotas.limit(pod.Namespace)
+	if err != nil {
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	if !ok {
+		return nil
+	}
+	held, err := lease.ListNamespace(ctx, p.coord, p.labelPrefix, p.leaseNamespace, pod.Namespace)
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("list leases in %s: %w", pod.Namespace, err))
+	}
+	used, want := lease.GPUs(held), claimGPUs(data)
+	if used+want > limit+fractionEpsilon {
+		msg := fmt.Sprintf("namespace %s GPU quota exceeded (requested=%g, used=%g, quota=%g)", pod.Namespace, want, used, limit)
+		return framework.NewStatus(framework.Unschedulable, msg)
+	}
+	return nil
+}
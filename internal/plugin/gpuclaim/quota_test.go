@@ -0,0 +1,81 @@
+package gpuclaim
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// withQuotas serves the plugin's quotas from a ConfigMap with data limits;
+// nil limits leave the ConfigMap out.
+func withQuotas(p *Plugin, limits map[string]string) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if limits != nil {
+		_ = indexer.Add(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "gpu-quota", Namespace: "gpu-system"},
+			Data:       limits,
+		})
+	}
+	p.quotas = &quotas{namespace: "gpu-system", name: "gpu-quota", configMaps: corelisters.NewConfigMapLister(indexer)}
+}
+
+func TestPreFilterNamespaceQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  map[string]string
+		claim   string
+		want    framework.Code
+		wantMsg string
+	}{
+		{name: "under quota", limits: map[string]string{"default": "4"}, claim: "1", want: framework.Success},
+		{name: "claim reaches quota", limits: map[string]string{"default": "4"}, claim: "2", want: framework.Success},
+		{name: "claim exceeds quota", limits: map[string]string{"default": "4"}, claim: "3", want: framework.Unschedulable,
+			wantMsg: "namespace default GPU quota exceeded (requested=3, used=2, quota=4)"},
+		{name: "at quota rejects a share", limits: map[string]string{"default": "2"}, claim: "0.5", want: framework.Unschedulable,
+			wantMsg: "namespace default GPU quota exceeded (requested=0.5, used=2, quota=2)"},
+		{name: "fractional quota admits a share", limits: map[string]string{"default": "2.5"}, claim: "0.5", want: framework.Success},
+		{name: "namespace without quota", limits: map[string]string{"team-b": "1"}, claim: "3", want: framework.Success},
+		{name: "no quota ConfigMap", claim: "3", want: framework.Success},
+		{name: "invalid quota", limits: map[string]string{"default": "lots"}, claim: "1", want: framework.Unschedulable,
+			wantMsg: `invalid GPU quota "lots"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := newTestPlugin()
+			withQuotas(p, tt.limits)
+			// default holds 2 GPUs; team-b's lease does not count against it.
+			for id := 0; id < 2; id++ {
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-train", podRef("default", "train"), id); err != nil {
+					t.Fatalf("TryAcquire: %v", err)
+				}
+			}
+			if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("team-b", "other"), 2); err != nil {
+				t.Fatalf("TryAcquire: %v", err)
+			}
+
+			pod := testPod("next")
+			pod.Annotations = map[string]string{util.AnnoClaim: tt.claim}
+			state := framework.NewCycleState()
+			_, status := p.PreFilter(ctx, state, pod)
+			if status.Code() != tt.want {
+				t.Fatalf("Expected %v, got %v: %s", tt.want, status.Code(), status.Message())
+			}
+			if !strings.Contains(status.Message(), tt.wantMsg) {
+				t.Errorf("Expected message containing %q, got %q", tt.wantMsg, status.Message())
+			}
+			if _, err := readState(state); (err == nil) != status.IsSuccess() {
+				t.Errorf("Expected claim state only for admitted pods, got err=%v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package gpuclaim
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// filterReason is why Filter turned a node down, worded to follow a count.
+type filterReason string
+
+const (
+	reasonCordoned      filterReason = "cordoned"
+	reasonAffinity      filterReason = "node selector mismatch"
+	reasonModel         filterReason = "wrong model"
+	reasonNoMemoryLabel filterReason = "GPU memory not advertised"
+	reasonMemory        filterReason = "memory too small"
+	reasonInsufficient  filterReason = "insufficient GPUs"
+	reasonNoShare       filterReason = "no GPU share free"
+	reasonNoMIG         filterReason = "no MIG support"
+	reasonNoInstances   filterReason = "no such MIG instances"
+	reasonMIGBusy       filterReason = "insufficient MIG instances"
+	reasonNoDevice      filterReason = "named GPU missing"
+	reasonDeviceBusy    filterReason = "named GPU in use"
+)
+
+// filterReasons collects the reason Filter rejected each node for during one
+// scheduling cycle. Filter runs on many nodes at once, so it is locked.
+type filterReasons struct {
+	mu     sync.Mutex
+	byNode map[string]filterReason
+}
+
+func newFilterReasons() *filterReasons {
+	return &filterReasons{byNode: map[string]filterReason{}}
+}
+
+// record notes why node was rejected. It is a no-op on a nil receiver, as in
+// tests that build stateData by hand.
+func (r *filterReasons) record(node string, reason filterReason) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byNode[node] = reason
+}
+
+// clone copies the tally. A nil receiver stays nil.
+func (r *filterReasons) clone() *filterReasons {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &filterReasons{byNode: maps.Clone(r.byNode)}
+}
+
+// summary counts the rejected nodes by reason, most common first, e.g.
+// "3 nodes: 2 insufficient GPUs, 1 wrong model". It is "" when Filter
+// rejected no node.
+func (r *filterReasons) summary() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	counts := map[filterReason]int{}
+	for _, reason := range r.byNode {
+		counts[reason]++
+	}
+	total := len(r.byNode)
+	r.mu.Unlock()
+	if total == 0 {
+		return ""
+	}
+
+	reasons := make([]filterReason, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	slices.SortFunc(reasons, func(a, b filterReason) int {
+		if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%d %s", counts[reason], reason)
+	}
+	noun := "nodes"
+	if total == 1 {
+		noun = "node"
+	}
+	return fmt.Sprintf("%d %s: %s", total, noun, strings.Join(parts, ", "))
+}
+
+// reject records reason for node and returns the status rejecting it.
+func (s *stateData) reject(node string, reason filterReason, code framework.Code, msg string) *framework.Status {
+	s.reasons.record(node, reason)
+	return framework.NewStatus(code, msg)
+}
+
+// withReasons appends the summary of Filter's rejections to a PostFilter
+// message, so the pod's Unschedulable condition says why no node fit.
+func (s *stateData) withReasons(msg string) string {
+	if summary := s.reasons.summary(); summary != "" {
+		return msg + " (" + summary + ")"
+	}
+	return msg
+}
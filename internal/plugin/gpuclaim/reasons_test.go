@@ -0,0 +1,82 @@
+package gpuclaim
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestPostFilterSummarizesFilterReasons(t *testing.T) {
+	ctx := context.Background()
+	modelNode := func(name, capacity, model string) *corev1.Node {
+		node := gpuNode(name, capacity)
+		node.Labels[util.LabelModel] = model
+		return node
+	}
+	cordoned := modelNode("node-d", "8", "A100")
+	cordoned.Labels[util.LabelCordoned] = "true"
+	nodes := []*corev1.Node{
+		modelNode("node-a", "8", "H100"),
+		modelNode("node-b", "1", "A100"),
+		modelNode("node-c", "1", "A100"),
+		cordoned,
+	}
+	p := newTestPlugin()
+	for _, node := range nodes {
+		p.handle.(*fakeHandle).nodes = append(p.handle.(*fakeHandle).nodes, nodeInfo(node))
+	}
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: "2,model=A100"}
+
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	statuses := framework.NewDefaultNodeToStatus()
+	for _, node := range nodes {
+		status := p.Filter(ctx, state, pod, nodeInfo(node))
+		if status.IsSuccess() {
+			t.Fatalf("Expected Filter to reject %s", node.Name)
+		}
+		statuses.Set(node.Name, status)
+	}
+
+	_, status := p.PostFilter(ctx, state, pod, statuses)
+	want := "(4 nodes: 2 insufficient GPUs, 1 cordoned, 1 wrong model)"
+	if status.IsSuccess() || !strings.HasSuffix(status.Message(), want) {
+		t.Errorf("Expected the PostFilter message to end in %q, got %q", want, status.Message())
+	}
+}
+
+func TestFilterReasonsSummary(t *testing.T) {
+	r := newFilterReasons()
+	if got := r.summary(); got != "" {
+		t.Errorf("Expected no summary without rejections, got %q", got)
+	}
+	r.record("node-a", reasonMemory)
+	r.record("node-a", reasonInsufficient)
+	if got := r.summary(); got != "1 node: 1 insufficient GPUs" {
+		t.Errorf("Expected a node's latest reason to count once, got %q", got)
+	}
+
+	// A clone, as a preemption dry run makes, tallies on its own.
+	clone := (&stateData{reasons: r}).Clone().(*stateData)
+	clone.reasons.record("node-b", reasonModel)
+	if got := r.summary(); got != "1 node: 1 insufficient GPUs" {
+		t.Errorf("Expected a clone's rejections kept out of the cycle's, got %q", got)
+	}
+	if got := clone.reasons.summary(); got != "2 nodes: 1 insufficient GPUs, 1 wrong model" {
+		t.Errorf("Expected the clone to start from the cycle's rejections, got %q", got)
+	}
+
+	var unset *filterReasons
+	unset.record("node-a", reasonModel)
+	if got := unset.summary(); got != "" {
+		t.Errorf("Expected a nil tally to stay empty, got %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package gpuclaim
+
+import (
+	"slices"
+	"time"
+)
+
+// Device selection policies, for how Reserve picks among a node's free
+// devices.
+const (
+	// DeviceSelectionLowest prefers the lowest device ids.
+	DeviceSelectionLowest = "lowest"
+	// DeviceSelectionMRU prefers the devices freed most recently.
+	DeviceSelectionMRU = "mru"
+	// DeviceSelectionLRU prefers the devices freed longest ago, or never,
+	// spreading use over all of them.
+	DeviceSelectionLRU = "lru"
+)
+
+// freedAt returns when the devices of node were last freed, as far as the
+// lease inventory has seen.
+func (p *Plugin) freedAt(node string) map[int]time.Time {
+	if p.inventory == nil {
+		return nil
+	}
+	return p.inventory.FreedAt(node)
+}
+
+// policyPick re-picks count of the free devices in the order policy prefers,
+// given when each was last freed. A connected pick, inside one NVLink
+// island, is only replaced by devices of that island, so topology still
+// comes first. Devices freed at the same time, or never, go by id.
+func policyPick(free []int, islands [][]int, pick []int, connected bool, count int, policy string, freed map[int]time.Time) []int {
+	candidates := free
+	if connected {
+		if i := slices.IndexFunc(islands, func(island []int) bool { return containsAll(island, pick) }); i >= 0 {
+			candidates = slices.DeleteFunc(slices.Clone(free), func(id int) bool { return !slices.Contains(islands[i], id) })
+		}
+	}
+	ordered := slices.Sorted(slices.Values(candidates))
+	slices.SortStableFunc(ordered, func(a, b int) int {
+		if policy == DeviceSelectionMRU {
+			return freed[b].Compare(freed[a])
+		}
+		return freed[a].Compare(freed[b])
+	})
+	return ordered[:count]
+}
+
+func containsAll(set, ids []int) bool {
+	for _, id := range ids {
+		if !slices.Contains(set, id) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package gpuclaim
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDeviceSelectionPolicy(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	// Devices 1, 3 and 5 were freed in that order; the others never were.
+	freed := map[int]time.Time{1: t0, 3: t0.Add(time.Minute), 5: t0.Add(2 * time.Minute)}
+	tests := []struct {
+		name    string
+		policy  string
+		devices []int
+		islands [][]int
+		want    []int
+	}{
+		{name: "lowest", policy: DeviceSelectionLowest, devices: []int{0, 1, 2, 3}, want: []int{0, 1, 2, 3}},
+		{name: "mru", policy: DeviceSelectionMRU, devices: []int{0, 1, 2, 3}, want: []int{3, 1, 0, 2}},
+		{name: "lru", policy: DeviceSelectionLRU, devices: []int{0, 1, 2, 3}, want: []int{0, 2, 1, 3}},
+		{
+			name:    "mru stays inside the island",
+			policy:  DeviceSelectionMRU,
+			devices: []int{0, 1, 2, 3, 4, 5},
+			islands: [][]int{{0, 1, 2}, {3, 4, 5}},
+			// Both islands fit; the first wins the tie, so 5 and 3 are not
+			// picked although they were freed last.
+			want: []int{1, 0, 2, 3, 4, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deviceOrder(gpuNodeStatus("node-a", tt.devices...), nil, tt.islands, 2, tt.policy, freed)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected order %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
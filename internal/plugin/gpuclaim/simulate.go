@@ -0,0 +1,206 @@
+package gpuclaim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// simulateRequest is the body of POST /simulate: the claim of a pod that
+// does not exist.
+type simulateRequest struct {
+	// Namespace is where the pod would run, for GpuClaim references and
+	// quotas. Empty means "default".
+	Namespace string `json:"namespace,omitempty"`
+	// Claim is a gpu.scheduling/claim value, e.g. "4,model=A100".
+	Claim string `json:"claim"`
+	// MIGProfile is a gpu.scheduling/mig-profile value.
+	MIGProfile string `json:"migProfile,omitempty"`
+	// NodeSelector narrows the nodes like a pod's nodeSelector.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// simulateResult is where the claim would land, or why it would not.
+type simulateResult struct {
+	Node string `json:"node,omitempty"`
+	// Devices are the device ids, or MIG instance ids, Reserve would lease.
+	Devices []int  `json:"devices,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Rejected says why each node that cannot hold the claim was filtered.
+	Rejected map[string]string `json:"rejected,omitempty"`
+}
+
+// simulator answers what-if placement requests with the plugin's Filter,
+// Score and device choice against the current leases, without taking any.
+// Only this plugin's view is simulated; other plugins, e.g. taints or CPU
+// and memory fit, may still rule a node out.
+type simulator struct {
+	p     *Plugin
+	nodes corelisters.NodeLister
+}
+
+func (s *simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req simulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Claim) == "" {
+		http.Error(w, "claim is required", http.StatusBadRequest)
+		return
+	}
+	result, err := s.simulate(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// simulate runs the claim through PreFilter, Filter and Score on every node,
+// picks the best scoring node, lowest name first on a tie, and previews the
+// devices Reserve would lease there.
+func (s *simulator) simulate(ctx context.Context, req simulateRequest) (simulateResult, error) {
+	ns := req.Namespace
+	if ns == "" {
+		ns = metav1.NamespaceDefault
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "simulate",
+			Namespace:   ns,
+			Annotations: map[string]string{util.AnnoClaim: req.Claim},
+		},
+		Spec: corev1.PodSpec{NodeSelector: req.NodeSelector},
+	}
+	if req.MIGProfile != "" {
+		pod.Annotations[util.AnnoMIGProfile] = req.MIGProfile
+	}
+
+	list, err := s.nodes.List(labels.Everything())
+	if err != nil {
+		return simulateResult{}, fmt.Errorf("list nodes: %w", err)
+	}
+	slices.SortFunc(list, func(a, b *corev1.Node) int { return strings.Compare(a.Name, b.Name) })
+	infos := make([]*framework.NodeInfo, 0, len(list))
+	for _, node := range list {
+		ni := framework.NewNodeInfo()
+		ni.SetNode(node)
+		infos = append(infos, ni)
+	}
+
+	data, status := s.p.claimState(ctx, pod, infos)
+	if !status.IsSuccess() {
+		return simulateResult{Reason: status.Message()}, nil
+	}
+	state := framework.NewCycleState()
+	state.Write(Name, data)
+
+	result := simulateResult{Rejected: map[string]string{}}
+	var scores framework.NodeScoreList
+	for _, ni := range infos {
+		name := ni.Node().Name
+		if status := s.p.filter(ctx, state, pod, ni); !status.IsSuccess() {
+			if status.Code() == framework.Error {
+				return simulateResult{}, status.AsError()
+			}
+			result.Rejected[name] = status.Message()
+			continue
+		}
+		score, status := s.p.Score(ctx, state, pod, ni)
+		if !status.IsSuccess() {
+			return simulateResult{}, status.AsError()
+		}
+		scores = append(scores, framework.NodeScore{Name: name, Score: score})
+	}
+	if len(scores) == 0 {
+		result.Reason = fmt.Sprintf("no node fits the claim (%d nodes rejected)", len(result.Rejected))
+		return result, nil
+	}
+	if status := s.p.NormalizeScore(ctx, state, pod, scores); !status.IsSuccess() {
+		return simulateResult{}, status.AsError()
+	}
+	best := scores[0]
+	for _, sc := range scores[1:] {
+		if sc.Score > best.Score {
+			best = sc
+		}
+	}
+
+	node, err := s.nodes.Get(best.Name)
+	if err != nil {
+		return simulateResult{}, fmt.Errorf("get node %s: %w", best.Name, err)
+	}
+	devices, status := s.p.previewDevices(ctx, pod, data, node)
+	if !status.IsSuccess() {
+		if status.Code() == framework.Error {
+			return simulateResult{}, status.AsError()
+		}
+		result.Reason = status.Message()
+		return result, nil
+	}
+	result.Node, result.Devices = best.Name, devices
+	return result, nil
+}
+
+// previewDevices returns the devices Reserve would lease on node for the
+// pod's claim, in the order it records them, without leasing any.
+func (p *Plugin) previewDevices(ctx context.Context, pod *corev1.Pod, data *stateData, node *corev1.Node) ([]int, *framework.Status) {
+	gns, err := p.getGpuNodeStatus(ctx, node.Name)
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, fmt.Sprintf("get GpuNodeStatus: %v", err))
+	}
+	gns = p.restrictDevices(gns, node)
+	held, err := lease.ListNode(ctx, p.coord, p.labelPrefix, node.Name)
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, fmt.Sprintf("list leases: %v", err))
+	}
+	held = occupying(pod, held)
+	switch {
+	case data.migProfile != "":
+		candidates := migCandidates(data, gns, held)
+		if len(candidates) < data.reqCount {
+			msg := fmt.Sprintf("not enough MIG %s instances available on node %s (requested=%d)", data.migProfile, node.Name, data.reqCount)
+			return nil, framework.NewStatus(framework.Unschedulable, msg)
+		}
+		ids := make([]int, data.reqCount)
+		for i, inst := range candidates[:data.reqCount] {
+			ids[i] = inst.ID
+		}
+		return ids, nil
+	case data.fraction > 0:
+		id, status := p.pickShare(data, node.Name, node, gns, held)
+		if !status.IsSuccess() {
+			return nil, status
+		}
+		return []int{id}, nil
+	case data.device != "":
+		return namedOrder(data, node, gnsDeviceIDs(gns), lease.HeldDevices(held))
+	}
+	order := deviceOrder(gns, lease.HeldDevices(held), nodeIslands(node), data.reqCount, p.deviceSelection, p.freedAt(node.Name))
+	if len(order) < data.reqCount {
+		msg := fmt.Sprintf("not enough GPUs available on node %s (requested=%d, total=%d)", node.Name, data.reqCount, len(gns.Status.Devices))
+		return nil, framework.NewStatus(framework.Unschedulable, msg)
+	}
+	ids := slices.Clone(order[:data.reqCount])
+	slices.Sort(ids)
+	return ids, nil
+}
@@ -0,0 +1,103 @@
+package gpuclaim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func newTestSimulator(t *testing.T, p *Plugin, nodes ...*corev1.Node) *simulator {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, n := range nodes {
+		if err := indexer.Add(n); err != nil {
+			t.Fatalf("add node: %v", err)
+		}
+	}
+	return &simulator{p: p, nodes: corelisters.NewNodeLister(indexer)}
+}
+
+func postSimulate(t *testing.T, s *simulator, req simulateRequest) simulateResult {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/simulate", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var result simulateResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	return result
+}
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3), gpuNodeStatus("node-b", 0, 1, 2, 3, 4, 5, 6, 7))
+	s := newTestSimulator(t, p, gpuNode("node-a", "4"), gpuNode("node-b", "8"))
+	// node-a keeps three GPUs free, node-b seven.
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), 0); err != nil {
+		t.Fatalf("seed lease: %v", err)
+	}
+	if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-b", "uid-other", podRef("default", "other"), 0); err != nil {
+		t.Fatalf("seed lease: %v", err)
+	}
+
+	// Binpack prefers the fuller node when it fits.
+	got := postSimulate(t, s, simulateRequest{Claim: "2"})
+	if got.Node != "node-a" || !slices.Equal(got.Devices, []int{1, 2}) || got.Reason != "" {
+		t.Errorf("Expected node-a devices [1 2], got %+v", got)
+	}
+
+	got = postSimulate(t, s, simulateRequest{Claim: "4"})
+	if got.Node != "node-b" || !slices.Equal(got.Devices, []int{1, 2, 3, 4}) {
+		t.Errorf("Expected node-b devices [1 2 3 4], got %+v", got)
+	}
+	if got.Rejected["node-a"] == "" {
+		t.Errorf("Expected node-a to be reported as rejected, got %v", got.Rejected)
+	}
+
+	got = postSimulate(t, s, simulateRequest{Claim: "8"})
+	if got.Node != "" || got.Reason == "" || len(got.Rejected) != 2 {
+		t.Errorf("Expected no placement with both nodes rejected, got %+v", got)
+	}
+
+	// Simulating takes no leases.
+	if held, _ := lease.ListNode(ctx, p.coord, p.labelPrefix, "node-b"); len(held) != 1 {
+		t.Errorf("Expected simulate to leave the leases alone, got %d on node-b", len(held))
+	}
+}
+
+func TestSimulateRejectsBadRequests(t *testing.T) {
+	s := newTestSimulator(t, newTestPlugin())
+	tests := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{method: http.MethodGet, want: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, body: "{", want: http.StatusBadRequest},
+		{method: http.MethodPost, body: `{"namespace":"ml"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(tt.method, "/simulate", bytes.NewBufferString(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s %q: Expected %d, got %d", tt.method, tt.body, tt.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package gpuclaim
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// preferLifecycle moves the nodes matching the pod's lifecycle preference
+// into the upper half of the score range and the rest into the lower half,
+// keeping their order within each half. A pod annotated with
+// util.AnnoPreferSpot prefers spot nodes; any other pod prefers the rest,
+// since it may not survive a spot node being reclaimed. Scores are left as
+// they are when every node, or none, matches.
+func (p *Plugin) preferLifecycle(pod *corev1.Pod, scores framework.NodeScoreList) {
+	wantSpot := util.PrefersSpot(pod)
+	preferred := make([]bool, len(scores))
+	var n int
+	for i := range scores {
+		node := p.snapshotNode(scores[i].Name)
+		if spot := node != nil && util.NodeSpot(node); spot == wantSpot {
+			preferred[i] = true
+			n++
+		}
+	}
+	if n == 0 || n == len(scores) {
+		return
+	}
+	for i := range scores {
+		scores[i].Score /= 2
+		if preferred[i] {
+			scores[i].Score += framework.MaxNodeScore / 2
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestScoreSpotPreference(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+	p.args = Args{PackingStrategy: StrategySpread}
+
+	// Spread prefers the bigger on-demand node on packing alone.
+	onDemand, spot := gpuNode("on-demand", "8"), gpuNode("spot", "4")
+	spot.Labels[util.LabelLifecycle] = util.LifecycleSpot
+	infos := []*framework.NodeInfo{nodeInfo(onDemand), nodeInfo(spot)}
+	p.handle.(*fakeHandle).nodes = infos
+
+	score := func(pod *corev1.Pod) map[string]int64 {
+		state := cycleStateFor(1)
+		var scores framework.NodeScoreList
+		for _, ni := range infos {
+			s, status := p.Score(ctx, state, pod, ni)
+			if !status.IsSuccess() {
+				t.Fatalf("Score(%s): %v", ni.Node().Name, status.Message())
+			}
+			scores = append(scores, framework.NodeScore{Name: ni.Node().Name, Score: s})
+		}
+		if status := p.NormalizeScore(ctx, state, pod, scores); !status.IsSuccess() {
+			t.Fatalf("NormalizeScore: %v", status.Message())
+		}
+		out := map[string]int64{}
+		for _, s := range scores {
+			if s.Score < framework.MinNodeScore || s.Score > framework.MaxNodeScore {
+				t.Errorf("%s: score %d out of range", s.Name, s.Score)
+			}
+			out[s.Name] = s.Score
+		}
+		return out
+	}
+
+	batch := testPod("batch")
+	batch.Annotations = map[string]string{util.AnnoPreferSpot: "true"}
+	if got := score(batch); got["spot"] <= got["on-demand"] {
+		t.Errorf("Expected the batch pod to prefer the spot node, got %v", got)
+	}
+	if got := score(testPod("notebook")); got["on-demand"] <= got["spot"] {
+		t.Errorf("Expected the interactive pod to avoid the spot node, got %v", got)
+	}
+
+	// With spot nodes only, the preference has nothing to choose between.
+	onDemand.Labels[util.LabelLifecycle] = util.LifecycleSpot
+	if got := score(testPod("notebook")); got["on-demand"] != framework.MaxNodeScore {
+		t.Errorf("Expected packing alone to decide among spot nodes, got %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package gpuclaim
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+// A device reserved by a pod still waiting in Permit, such as a gang member
+// whose gang is incomplete, is not settled yet. When a pod that outranks the
+// waiting one contends for it, the device goes to the pod ranked higher, and
+// the waiting pod is rejected, releasing the rest of its reservation and its
+// gang's with it.
+
+// outranks reports whether pod a wins a contested device over pod b: the
+// higher priority wins, and on equal priority the pod created first. A full
+// tie leaves the device with its holder.
+func outranks(a, b *corev1.Pod) bool {
+	if pa, pb := podPriority(a), podPriority(b); pa != pb {
+		return pa > pb
+	}
+	return a.CreationTimestamp.Before(&b.CreationTimestamp)
+}
+
+// contestedDevice is a device reserved by a waiting pod that a contender
+// outranks.
+type contestedDevice struct {
+	id     int
+	lease  coordv1.Lease
+	holder framework.WaitingPod
+}
+
+// contested returns the devices among the node's leases held that pod could
+// take over, the lowest-ranked holder's first, then by device id.
+func (p *Plugin) contested(pod *corev1.Pod, held []coordv1.Lease) []contestedDevice {
+	reserved := lease.Reservations(held)
+	if len(reserved) == 0 || p.handle == nil {
+		return nil
+	}
+	waiting := map[types.UID]framework.WaitingPod{}
+	p.handle.IterateOverWaitingPods(func(wp framework.WaitingPod) {
+		waiting[wp.GetPod().UID] = wp
+	})
+
+	var out []contestedDevice
+	for id, l := range reserved {
+		wp, ok := waiting[types.UID(*l.Spec.HolderIdentity)]
+		if !ok || wp.GetPod().UID == pod.UID || !outranks(pod, wp.GetPod()) {
+			continue
+		}
+		// The taker releases its leases in p.leaseNamespace only.
+		if l.Namespace != p.leaseNamespace {
+			continue
+		}
+		out = append(out, contestedDevice{id: id, lease: l, holder: wp})
+	}
+	slices.SortFunc(out, func(a, b contestedDevice) int {
+		pa, pb := a.holder.GetPod(), b.holder.GetPod()
+		switch {
+		case outranks(pa, pb):
+			return 1
+		case outranks(pb, pa):
+			return -1
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+	return out
+}
+
+// takeOver takes need of the devices contested by pod on nodeName from the
+// waiting pods holding them. It takes none, and returns nil, unless it can
+// take all need: devices taken before a shortfall are given back. Only once
+// pod holds them all are the pods it took them from rejected.
+func (p *Plugin) takeOver(ctx context.Context, pod *corev1.Pod, nodeName string, held []coordv1.Lease, need int) []int {
+	candidates := p.contested(pod, held)
+	if len(candidates) < need {
+		return nil
+	}
+	type takenDevice struct {
+		contestedDevice
+		now *coordv1.Lease
+	}
+	var taken []takenDevice
+	for _, c := range candidates {
+		if len(taken) >= need {
+			break
+		}
+		now, err := lease.Take(ctx, p.coord, &c.lease, string(pod.UID), podKey(pod))
+		if err != nil {
+			klog.V(4).InfoS("could not take over GPU lease", "pod", klog.KObj(pod), "holder", klog.KObj(c.holder.GetPod()), "node", nodeName, "gpuID", c.id, "err", err)
+			continue
+		}
+		taken = append(taken, takenDevice{contestedDevice: c, now: now})
+	}
+
+	ids := make([]int, 0, len(taken))
+	rejected := map[types.UID]bool{}
+	for _, t := range taken {
+		victim := t.holder.GetPod()
+		if len(taken) < need {
+			err := lease.GiveBack(ctx, p.coord, &t.lease, t.now)
+			if err == nil {
+				continue
+			}
+			// The holder lost the device after all.
+			klog.ErrorS(err, "could not give back GPU lease", "pod", klog.KObj(pod), "holder", klog.KObj(victim), "node", nodeName, "gpuID", t.id)
+			_ = lease.ReleaseHeld(ctx, p.coord, p.labelPrefix, t.now.Namespace, nodeName, string(pod.UID), t.id)
+		} else {
+			ids = append(ids, t.id)
+		}
+		if !rejected[victim.UID] {
+			rejected[victim.UID] = true
+			klog.V(2).InfoS("higher-ranked pod took over reserved GPU", "pod", klog.KObj(pod), "holder", klog.KObj(victim), "node", nodeName, "gpuID", t.id)
+			t.holder.Reject(Name, fmt.Sprintf("GPU %d on node %s was taken by higher-ranked pod %s/%s", t.id, nodeName, pod.Namespace, pod.Name))
+		}
+	}
+	if len(taken) < need {
+		return nil
+	}
+	return ids
+}
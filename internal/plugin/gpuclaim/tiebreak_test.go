@@ -0,0 +1,146 @@
+package gpuclaim
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+)
+
+func rankedPod(pod *corev1.Pod, priority int32, created time.Time) *corev1.Pod {
+	pod.Spec.Priority = &priority
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
+
+func TestReserveContestedDevice(t *testing.T) {
+	early := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Minute)
+
+	tests := []struct {
+		name       string
+		holderPrio int32
+		holderAt   time.Time
+		podPrio    int32
+		podAt      time.Time
+		wantTaken  bool
+	}{
+		{name: "higher priority wins", holderPrio: 0, holderAt: early, podPrio: 100, podAt: late, wantTaken: true},
+		{name: "lower priority loses", holderPrio: 100, holderAt: late, podPrio: 0, podAt: early},
+		{name: "equal priority, older wins", holderPrio: 10, holderAt: late, podPrio: 10, podAt: early, wantTaken: true},
+		{name: "equal priority, newer loses", holderPrio: 10, holderAt: early, podPrio: 10, podAt: late},
+		{name: "full tie keeps the holder", holderPrio: 10, holderAt: early, podPrio: 10, podAt: early},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := newTestPlugin(gpuNodeStatus("node-a", 0))
+
+			holder := rankedPod(gangPod("worker-0", "job", "2"), tt.holderPrio, tt.holderAt)
+			_, _, wp := reserveAndPermit(t, p, holder)
+			if wp == nil {
+				t.Fatalf("Expected the gang member to wait in Permit")
+			}
+
+			pod := rankedPod(testPod("contender"), tt.podPrio, tt.podAt)
+			state := cycleStateFor(1)
+			filter := p.Filter(ctx, state, pod, nodeInfo(gpuNode("node-a", "1")))
+			reserve := p.Reserve(ctx, state, pod, "node-a")
+			if filter.IsSuccess() != tt.wantTaken || reserve.IsSuccess() != tt.wantTaken {
+				t.Fatalf("Expected Filter and Reserve success %v, got %v / %v", tt.wantTaken, filter.Message(), reserve.Message())
+			}
+			if rejected := wp.rejected != ""; rejected != tt.wantTaken {
+				t.Errorf("Expected holder rejected=%v, got %q", tt.wantTaken, wp.rejected)
+			}
+
+			want := holder.UID
+			if tt.wantTaken {
+				want = pod.UID
+			}
+			l, err := p.coord.Leases(lease.DefaultNamespace).Get(ctx, lease.LeaseName(lease.DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get lease: %v", err)
+			}
+			if got := *l.Spec.HolderIdentity; got != string(want) {
+				t.Errorf("Expected GPU 0 held by %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestUnreserveAfterTakeOver(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0))
+	now := time.Now()
+
+	holder := rankedPod(gangPod("worker-0", "job", "2"), 0, now)
+	holderState, _, wp := reserveAndPermit(t, p, holder)
+	if wp == nil {
+		t.Fatalf("Expected the gang member to wait in Permit")
+	}
+	pod := rankedPod(testPod("urgent"), 1000, now)
+	if status := p.Reserve(ctx, cycleStateFor(1), pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+
+	// The framework runs the rejected holder's Unreserve, which must leave
+	// the device to the pod that took it over.
+	p.handle.(*fakeHandle).unpark(holder.UID)
+	p.Unreserve(ctx, holderState, holder, "node-a")
+
+	l, err := p.coord.Leases(lease.DefaultNamespace).Get(ctx, lease.LeaseName(lease.DefaultLabelPrefix, "node-a", 0), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the new holder's lease to survive, got %v", err)
+	}
+	if got := *l.Spec.HolderIdentity; got != string(pod.UID) {
+		t.Errorf("Expected GPU 0 held by %s, got %s", pod.UID, got)
+	}
+}
+
+func TestTakeOverGivesBackOnShortfall(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+	now := time.Now()
+
+	w0 := rankedPod(gangPod("worker-0", "job", "3"), 0, now)
+	w1 := rankedPod(gangPod("worker-1", "job", "3"), 0, now)
+	_, _, wp0 := reserveAndPermit(t, p, w0)
+	_, _, wp1 := reserveAndPermit(t, p, w1)
+	if wp0 == nil || wp1 == nil {
+		t.Fatalf("Expected both gang members to wait in Permit")
+	}
+	// GPU 1 changes hands before the contender can take it.
+	p.client.(*fake.Clientset).PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if l := action.(k8stesting.UpdateAction).GetObject().(*coordv1.Lease); l.Name == lease.LeaseName(lease.DefaultLabelPrefix, "node-a", 1) {
+			return true, nil, apierrors.NewConflict(coordv1.Resource("leases"), l.Name, errors.New("modified"))
+		}
+		return false, nil, nil
+	})
+
+	pod := rankedPod(testPod("urgent"), 1000, now)
+	if status := p.Reserve(ctx, cycleStateFor(2), pod, "node-a"); status.IsSuccess() {
+		t.Fatalf("Expected Reserve to fail with one of two GPUs taken")
+	}
+	if wp0.rejected != "" || wp1.rejected != "" {
+		t.Errorf("Expected no gang member rejected, got %q / %q", wp0.rejected, wp1.rejected)
+	}
+	for id, holder := range []*corev1.Pod{w0, w1} {
+		l, err := p.coord.Leases(lease.DefaultNamespace).Get(ctx, lease.LeaseName(lease.DefaultLabelPrefix, "node-a", id), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get lease: %v", err)
+		}
+		if got := *l.Spec.HolderIdentity; got != string(holder.UID) {
+			t.Errorf("Expected GPU %d given back to %s, got %s", id, holder.UID, got)
+		}
+	}
+}
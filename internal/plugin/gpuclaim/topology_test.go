@@ -0,0 +1,93 @@
+package gpuclaim
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// islandNode has 8 GPUs in two 4-GPU NVLink islands.
+func islandNode(name string) *corev1.Node {
+	node := gpuNode(name, "8")
+	node.Annotations = map[string]string{util.AnnoTopology: "0,1,2,3;4,5,6,7"}
+	return node
+}
+
+func TestReserveNVLinkIslands(t *testing.T) {
+	tests := []struct {
+		name  string
+		held  []int
+		count int
+		want  []int
+	}{
+		{name: "fits the fuller island", held: []int{1}, count: 3, want: []int{0, 2, 3}},
+		{name: "fits the whole other island", held: []int{1}, count: 4, want: []int{4, 5, 6, 7}},
+		{name: "spans islands contiguously", held: []int{1, 5}, count: 4, want: []int{0, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3, 4, 5, 6, 7))
+			p.handle.(*fakeHandle).nodes = []*framework.NodeInfo{nodeInfo(islandNode("node-a"))}
+			for _, id := range tt.held {
+				if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), id); err != nil {
+					t.Fatalf("seed lease: %v", err)
+				}
+			}
+
+			state := cycleStateFor(tt.count)
+			if status := p.Reserve(ctx, state, testPod("trainer"), "node-a"); !status.IsSuccess() {
+				t.Fatalf("Reserve: %v", status.Message())
+			}
+			data, _ := readState(state)
+			got := slices.Sorted(slices.Values(data.chosenIDs))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected devices %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScorePrefersNVLinkIsland(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPlugin()
+
+	// Both nodes have 6 free GPUs, but only node-a keeps 4 of them in one
+	// island; node-b's free GPUs are split 3 and 3.
+	nodes := []*corev1.Node{islandNode("node-a"), islandNode("node-b")}
+	for _, held := range []struct {
+		node string
+		ids  []int
+	}{{"node-a", []int{0, 1}}, {"node-b", []int{0, 4}}} {
+		for _, id := range held.ids {
+			if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, held.node, "uid", podRef("default", "holder"), id); err != nil {
+				t.Fatalf("seed lease: %v", err)
+			}
+		}
+	}
+
+	for _, strategy := range []string{StrategyBinpack, StrategySpread} {
+		p.args = Args{PackingStrategy: strategy}
+		state := cycleStateFor(4)
+		var scores framework.NodeScoreList
+		for _, n := range nodes {
+			s, status := p.Score(ctx, state, &corev1.Pod{}, nodeInfo(n))
+			if !status.IsSuccess() {
+				t.Fatalf("Score(%s): %v", n.Name, status.Message())
+			}
+			scores = append(scores, framework.NodeScore{Name: n.Name, Score: s})
+		}
+		if status := p.NormalizeScore(ctx, state, &corev1.Pod{}, scores); !status.IsSuccess() {
+			t.Fatalf("NormalizeScore: %v", status.Message())
+		}
+		if scores[0].Score <= scores[1].Score {
+			t.Errorf("%s: expected the fully connected node to score higher, got %v", strategy, scores)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package gpuclaim
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/tracing"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// tracerName identifies the plugin's spans.
+const tracerName = "github.com/restack/gpu-scheduler/internal/plugin/gpuclaim"
+
+// Span attribute keys.
+const (
+	attrNode       = attribute.Key("k8s.node.name")
+	attrPod        = attribute.Key("k8s.pod.name")
+	attrNamespace  = attribute.Key("k8s.namespace.name")
+	attrClaimCount = attribute.Key("gpu.claim.count")
+	attrDevices    = attribute.Key("gpu.devices")
+	attrStatus     = attribute.Key("gpu.scheduling.status")
+)
+
+// startSpan starts the span of one scheduling phase for pod. Spans of the
+// same pod share the trace tracing.PodContext derives from its UID, or the
+// one its util.AnnoTraceparent annotation names. Unless
+// an exporter is configured, the global tracer provider makes this a no-op.
+func (p *Plugin) startSpan(ctx context.Context, phase string, pod *corev1.Pod, node string) (context.Context, trace.Span) {
+	tracer := p.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+	attrs := []attribute.KeyValue{attrPod.String(pod.Name), attrNamespace.String(pod.Namespace)}
+	if node != "" {
+		attrs = append(attrs, attrNode.String(node))
+	}
+	return tracer.Start(tracing.PodContext(ctx, pod.UID, pod.Annotations[util.AnnoTraceparent]), Name+"/"+phase, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the claim read from cycleState and the phase's outcome,
+// then ends the span.
+func endSpan(span trace.Span, cycleState *framework.CycleState, status *framework.Status) {
+	if !span.IsRecording() {
+		span.End()
+		return
+	}
+	if data, err := readState(cycleState); err == nil {
+		span.SetAttributes(attrClaimCount.Int(data.reqCount))
+		if len(data.chosenIDs) > 0 {
+			span.SetAttributes(attrDevices.IntSlice(data.chosenIDs))
+		}
+	}
+	span.SetAttributes(attrStatus.String(status.Code().String()))
+	if status.Code() == framework.Error {
+		span.SetStatus(codes.Error, status.Message())
+	}
+	span.End()
+}
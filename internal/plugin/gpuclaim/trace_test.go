@@ -0,0 +1,80 @@
+package gpuclaim
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/tracing"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestPhasesEmitSpans(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("trainer")
+	pod.Annotations = map[string]string{util.AnnoClaim: "2"}
+	p := newTestPlugin(gpuNodeStatus("node-a", 0, 1, 2, 3), pod)
+	recorder := tracetest.NewSpanRecorder()
+	p.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	state := framework.NewCycleState()
+	if _, status := p.PreFilter(ctx, state, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter: %v", status.Message())
+	}
+	if status := p.Filter(ctx, state, pod, nodeInfo(gpuNode("node-a", "4"))); !status.IsSuccess() {
+		t.Fatalf("Filter: %v", status.Message())
+	}
+	if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("Reserve: %v", status.Message())
+	}
+	if status := p.PreBind(ctx, state, pod, "node-a"); !status.IsSuccess() {
+		t.Fatalf("PreBind: %v", status.Message())
+	}
+
+	tests := []struct {
+		name    string
+		node    string
+		devices string
+	}{
+		{name: Name + "/PreFilter"},
+		{name: Name + "/Filter", node: "node-a"},
+		{name: Name + "/Reserve", node: "node-a", devices: "[0,1]"},
+		{name: Name + "/PreBind", node: "node-a", devices: "[0,1]"},
+	}
+	spans := recorder.Ended()
+	if len(spans) != len(tests) {
+		t.Fatalf("Expected %d spans, got %d", len(tests), len(spans))
+	}
+	trace := tracing.PodSpanContext(pod.UID).TraceID()
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name() != tt.name {
+			t.Errorf("span %d: Expected name %q, got %q", i, tt.name, span.Name())
+		}
+		if span.SpanContext().TraceID() != trace {
+			t.Errorf("%s: Expected the trace derived from the pod UID, got %s", tt.name, span.SpanContext().TraceID())
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		if got := attrs[attrClaimCount].AsInt64(); got != 2 {
+			t.Errorf("%s: Expected claim count 2, got %d", tt.name, got)
+		}
+		if got := attrs[attrNode].AsString(); got != tt.node {
+			t.Errorf("%s: Expected node %q, got %q", tt.name, tt.node, got)
+		}
+		var devices string
+		if v, ok := attrs[attrDevices]; ok {
+			devices = fmt.Sprintf("[%d,%d]", v.AsInt64Slice()[0], v.AsInt64Slice()[1])
+		}
+		if devices != tt.devices {
+			t.Errorf("%s: Expected devices %q, got %q", tt.name, tt.devices, devices)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package gpuclaim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	framework "k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+func TestFilterReserveWindow(t *testing.T) {
+	now := time.Now().UTC()
+	span := func(from, to time.Duration) string {
+		return now.Add(from).Format(time.RFC3339) + "/" + now.Add(to).Format(time.RFC3339)
+	}
+	tests := []struct {
+		name        string
+		window      string
+		reservation string
+		want        framework.Code
+	}{
+		{name: "in window", window: span(-time.Hour, time.Hour), want: framework.Unschedulable},
+		{name: "before window", window: span(time.Hour, 2*time.Hour), want: framework.Success},
+		{name: "after window", window: span(-2*time.Hour, -time.Hour), want: framework.Success},
+		{name: "in window, pod using the reservation", window: span(-time.Hour, time.Hour), reservation: "nightly", want: framework.Success},
+		{name: "in window, pod using another reservation", window: span(-time.Hour, time.Hour), reservation: "weekly", want: framework.Unschedulable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := newTestPlugin(gpuNodeStatus("node-a", 0, 1))
+
+			// The reservation holds GPU 0, a pod GPU 1.
+			if err := lease.ReserveWindow(ctx, p.coord, lease.DefaultLabelPrefix, lease.DefaultNamespace, "default", "node-a", "nightly", tt.window, 0); err != nil {
+				t.Fatalf("ReserveWindow: %v", err)
+			}
+			if _, err := lease.TryAcquire(ctx, p.coord, p.labelPrefix, lease.DefaultNamespace, "node-a", "uid-other", podRef("default", "other"), 1); err != nil {
+				t.Fatalf("seed lease: %v", err)
+			}
+
+			pod := testPod("batch")
+			if tt.reservation != "" {
+				pod.Annotations = map[string]string{util.AnnoReservation: tt.reservation}
+			}
+			status := p.Filter(ctx, cycleStateFor(1), pod, nodeInfo(gpuNode("node-a", "2")))
+			if got := status.Code(); got != tt.want {
+				t.Fatalf("Filter code = %v, want %v (%s)", got, tt.want, status.Message())
+			}
+			if tt.want != framework.Success {
+				return
+			}
+
+			state := cycleStateFor(1)
+			if status := p.Reserve(ctx, state, pod, "node-a"); !status.IsSuccess() {
+				t.Fatalf("Reserve: %v", status.Message())
+			}
+			data, _ := readState(state)
+			if len(data.chosenIDs) != 1 || data.chosenIDs[0] != 0 {
+				t.Errorf("Expected the reserved GPU 0, got %v", data.chosenIDs)
+			}
+		})
+	}
+}
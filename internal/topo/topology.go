@@ -52,3 +52,46 @@ func ids(devs []DeviceInfo) []int {
 	}
 	return out
 }
+
+// PickIslands chooses count of the free device ids, preferring a set inside
+// one NVLink island. Among islands that fit, the one with the fewest free
+// devices is used so larger islands stay whole for later claims. When no
+// island fits, it falls back to the free ids spanning the narrowest id range.
+// connected reports whether the pick lies within one island; pick is nil when
+// fewer than count devices are free.
+func PickIslands(free []int, islands [][]int, count int) (pick []int, connected bool) {
+	if count <= 0 || len(free) < count {
+		return nil, false
+	}
+	isFree := make(map[int]bool, len(free))
+	for _, id := range free {
+		isFree[id] = true
+	}
+
+	var best []int
+	for _, island := range islands {
+		var avail []int
+		for _, id := range island {
+			if isFree[id] {
+				avail = append(avail, id)
+			}
+		}
+		if len(avail) >= count && (best == nil || len(avail) < len(best)) {
+			best = avail
+		}
+	}
+	if best != nil {
+		sort.Ints(best)
+		return best[:count], true
+	}
+
+	sorted := append([]int(nil), free...)
+	sort.Ints(sorted)
+	start := 0
+	for i := 1; i+count <= len(sorted); i++ {
+		if sorted[i+count-1]-sorted[i] < sorted[start+count-1]-sorted[start] {
+			start = i
+		}
+	}
+	return sorted[start : start+count], count == 1
+}
@@ -0,0 +1,124 @@
+// Package tracing exports the scheduler plugin's OpenTelemetry spans over
+// OTLP and ties the spans of one pod's scheduling phases into one trace.
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultServiceName names the scheduler in traces unless OTEL_SERVICE_NAME
+// says otherwise.
+const DefaultServiceName = "gpu-scheduler"
+
+// Setup installs a global tracer provider exporting over OTLP, configured
+// through the standard OTEL_EXPORTER_OTLP_* environment variables. Unless an
+// OTLP endpoint is set, nothing is installed and spans stay no-ops; the
+// same holds for OTEL_SDK_DISABLED=true and OTEL_TRACES_EXPORTER=none. The
+// returned function flushes and stops the exporter.
+func Setup(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if !Enabled() {
+		return noop, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch protocol := otlpProtocol(); protocol {
+	case "grpc":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return noop, fmt.Errorf("unsupported OTLP protocol %q: must be grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return noop, fmt.Errorf("build OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(DefaultServiceName)),
+		// Reads OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+		resource.Environment(),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("build trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Enabled reports whether the environment configures an OTLP trace exporter.
+func Enabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") ||
+		strings.EqualFold(os.Getenv("OTEL_TRACES_EXPORTER"), "none") {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+}
+
+// otlpProtocol returns the configured OTLP transport, http/protobuf by
+// default as the OpenTelemetry specification recommends.
+func otlpProtocol() string {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return "http/protobuf"
+}
+
+// PodContext returns ctx with the pod's parent span context, so the spans
+// started for the pod in separate scheduling phases, and across scheduling
+// attempts, share one trace. The parent is the W3C traceparent, flags and
+// all, when it is valid, and otherwise derived from the pod UID. A span
+// context already on ctx is kept.
+func PodContext(ctx context.Context, uid types.UID, traceparent string) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	if traceparent != "" {
+		carrier := propagation.MapCarrier{"traceparent": traceparent}
+		parent := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+		if parent.IsValid() {
+			return trace.ContextWithRemoteSpanContext(ctx, parent)
+		}
+	}
+	if uid == "" {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, PodSpanContext(uid))
+}
+
+// PodSpanContext is the span context PodContext derives from uid. Without a
+// traceparent there is no caller's decision to follow, so it is sampled.
+func PodSpanContext(uid types.UID) trace.SpanContext {
+	sum := sha256.Sum256([]byte(uid))
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	copy(traceID[:], sum[:16])
+	copy(spanID[:], sum[16:24])
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPodContext(t *testing.T) {
+	ctx := context.Background()
+	a := trace.SpanContextFromContext(PodContext(ctx, "uid-a", ""))
+	if !a.IsValid() || !a.IsSampled() || !a.IsRemote() {
+		t.Fatalf("Expected a valid, sampled remote span context, got %+v", a)
+	}
+	if again := trace.SpanContextFromContext(PodContext(ctx, "uid-a", "")); !again.Equal(a) {
+		t.Errorf("Expected the same pod to get the same trace, got %s and %s", a.TraceID(), again.TraceID())
+	}
+	if b := trace.SpanContextFromContext(PodContext(ctx, "uid-b", "")); b.TraceID() == a.TraceID() {
+		t.Errorf("Expected pods to get different traces")
+	}
+
+	// A trace already on the context is kept.
+	parent := trace.ContextWithSpanContext(ctx, PodSpanContext("uid-b"))
+	if got := trace.SpanContextFromContext(PodContext(parent, "uid-a", "")); got.TraceID() == a.TraceID() {
+		t.Errorf("Expected the existing span context to be kept")
+	}
+	if PodContext(ctx, "", "") != ctx {
+		t.Errorf("Expected a pod without a UID to get no span context")
+	}
+}
+
+func TestPodContextTraceparent(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name        string
+		traceparent string
+		wantTrace   string
+		wantSampled bool
+	}{
+		{name: "sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736", wantSampled: true},
+		{name: "not sampled", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "invalid falls back to the UID", traceparent: "not-a-traceparent", wantTrace: PodSpanContext("uid-a").TraceID().String(), wantSampled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trace.SpanContextFromContext(PodContext(ctx, "uid-a", tt.traceparent))
+			if got.TraceID().String() != tt.wantTrace || got.IsSampled() != tt.wantSampled {
+				t.Errorf("Expected trace %s sampled=%v, got %s sampled=%v", tt.wantTrace, tt.wantSampled, got.TraceID(), got.IsSampled())
+			}
+		})
+	}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if Enabled() {
+		t.Errorf("Expected tracing off without an endpoint")
+	}
+	shutdown, err := Setup(context.Background())
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	if !Enabled() {
+		t.Errorf("Expected tracing on with an endpoint")
+	}
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if Enabled() {
+		t.Errorf("Expected OTEL_TRACES_EXPORTER=none to turn tracing off")
+	}
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Claim is the parsed form of the gpu.scheduling/claim annotation. The value
+// either names a GpuClaim in the pod's namespace or carries an inline GPU
+// count such as "2" or a time-sliced share of one GPU such as "0.5". Either
+// form may be followed by comma-separated qualifiers, e.g.
+// "2,model=A100,memory=40Gi". mem is accepted as a short form of memory.
+// A share may burst above what it is guaranteed: "0.3,lim=0.6", also written
+// "req=0.3,lim=0.6", packs by 0.3 and lets the workload use up to 0.6.
+// "device=GPU-8f3c..." asks for the one GPU with that UUID.
+type Claim struct {
+	// Name references a GpuClaim object. Empty for inline claims.
+	Name string
+	// Count is the number of GPUs requested inline.
+	Count int
+	// Fraction is the share of a single GPU, in (0, 1). Zero for whole-GPU
+	// claims.
+	Fraction float64
+	// Limit is the share, in [Fraction, 1], a fractional claim may burst
+	// to. Zero means no burst beyond Fraction.
+	Limit float64
+	// Model restricts the claim to nodes whose LabelModel matches. Empty
+	// matches any node.
+	Model string
+	// Memory is the GPU memory the pod needs on each device, in bytes. Zero
+	// means any.
+	Memory int64
+	// Device is the UUID of the one GPU the claim asks for, as nodes list
+	// it in AnnoDeviceUUIDs. Count is 1 when it is set.
+	Device string
+}
+
+// ErrInvalidClaim is the error ParseClaim and ParseMIGProfile return, and
+// errors.Is finds, for a malformed annotation value.
+var ErrInvalidClaim = errors.New("invalid GPU claim")
+
+// claimError is a parse error. Its message stays the one callers have always
+// shown, yet it matches ErrInvalidClaim.
+type claimError struct{ msg string }
+
+func (e *claimError) Error() string { return e.msg }
+
+func (e *claimError) Is(target error) bool { return target == ErrInvalidClaim }
+
+func claimErrorf(format string, args ...any) error {
+	return &claimError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseClaim validates a claim annotation value.
+func ParseClaim(s string) (Claim, error) {
+	head, qualifiers, _ := strings.Cut(s, ",")
+	var c Claim
+	if strings.Contains(head, "=") {
+		// The amount is given as the req qualifier.
+		qualifiers = s
+	} else {
+		var err error
+		if c, err = parseAmount(strings.TrimSpace(head)); err != nil {
+			return Claim{}, err
+		}
+	}
+	if qualifiers == "" {
+		return c, nil
+	}
+	for _, q := range strings.Split(qualifiers, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(q), "=")
+		if !ok {
+			return Claim{}, claimErrorf("invalid claim qualifier %q: expected key=value", q)
+		}
+		switch key {
+		case "model":
+			if value == "" {
+				return Claim{}, claimErrorf("claim qualifier model is empty")
+			}
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return Claim{}, claimErrorf("invalid model %q: %s", value, strings.Join(errs, "; "))
+			}
+			c.Model = value
+		case "memory", "mem":
+			q, err := resource.ParseQuantity(value)
+			if err != nil || q.Sign() <= 0 {
+				return Claim{}, claimErrorf("invalid memory %q: expected a positive quantity such as 40Gi", value)
+			}
+			c.Memory = q.Value()
+		case "req", "request":
+			if c.Name != "" || c.Count > 0 || c.Fraction > 0 {
+				return Claim{}, claimErrorf("claim sets its amount twice")
+			}
+			amount, err := parseAmount(value)
+			if err != nil || amount.Fraction == 0 {
+				return Claim{}, claimErrorf("invalid request %q: expected a share of a GPU below 1", value)
+			}
+			c.Fraction = amount.Fraction
+		case "device":
+			if value == "" {
+				return Claim{}, claimErrorf("claim qualifier device is empty")
+			}
+			c.Device = value
+		case "lim", "limit":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(f > 0 && f <= 1) {
+				return Claim{}, claimErrorf("invalid limit %q: expected a share of a GPU up to 1", value)
+			}
+			c.Limit = f
+		default:
+			return Claim{}, claimErrorf("unknown claim qualifier %q", key)
+		}
+	}
+	if c.Device != "" {
+		if c.Name != "" || c.Fraction > 0 || c.Count > 1 {
+			return Claim{}, claimErrorf("claim names device %s, so it can only ask for that one whole GPU", c.Device)
+		}
+		c.Count = 1
+	}
+	switch {
+	case c.Name == "" && c.Count == 0 && c.Fraction == 0:
+		return Claim{}, claimErrorf("claim has no amount")
+	case c.Limit > 0 && c.Fraction == 0:
+		return Claim{}, claimErrorf("claim limit only applies to a share of a GPU")
+	case c.Limit > 0 && c.Limit < c.Fraction:
+		return Claim{}, claimErrorf("claim limit %g is below its request %g", c.Limit, c.Fraction)
+	}
+	return c, nil
+}
+
+// parseAmount parses the part of the claim before any qualifiers.
+func parseAmount(s string) (Claim, error) {
+	if s == "" {
+		return Claim{}, claimErrorf("claim is empty")
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return Claim{}, claimErrorf("GPU count must be positive, got %d", n)
+		}
+		return Claim{Count: n}, nil
+	}
+	// Names may start with a digit too, e.g. "8x-a100": only a value that
+	// does not parse as a number is taken for one.
+	f, err := strconv.ParseFloat(s, 64)
+	if !isNumeric(s) || (err != nil && !errors.Is(err, strconv.ErrRange)) {
+		if errs := validation.IsDNS1123Subdomain(s); len(errs) > 0 {
+			if isNumeric(s) {
+				return Claim{}, claimErrorf("invalid GPU count %q", s)
+			}
+			return Claim{}, claimErrorf("invalid claim name %q: %s", s, strings.Join(errs, "; "))
+		}
+		return Claim{Name: s}, nil
+	}
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Claim{}, claimErrorf("invalid GPU count %q", s)
+	}
+	switch {
+	case f <= 0:
+		return Claim{}, claimErrorf("GPU count must be positive, got %s", s)
+	case f < 1:
+		return Claim{Fraction: f}, nil
+	case f == math.Trunc(f):
+		return Claim{Count: int(f)}, nil
+	}
+	return Claim{}, claimErrorf("fractional GPU claims must be below 1, got %s", s)
+}
+
+// isNumeric reports whether the value starts like a number. ParseFloat also
+// reads words such as "inf" and "nan", which are names.
+func isNumeric(s string) bool {
+	switch c := s[0]; {
+	case c >= '0' && c <= '9', c == '-', c == '+', c == '.':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseClaim(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Claim
+		wantErr bool
+	}{
+		{in: "2", want: Claim{Count: 2}},
+		{in: " 1 ", want: Claim{Count: 1}},
+		{in: "0.5", want: Claim{Fraction: 0.5}},
+		{in: ".25", want: Claim{Fraction: 0.25}},
+		{in: "2.0", want: Claim{Count: 2}},
+		{in: "2,model=A100", want: Claim{Count: 2, Model: "A100"}},
+		{in: "0.5, model=V100", want: Claim{Fraction: 0.5, Model: "V100"}},
+		{in: "1,memory=40Gi", want: Claim{Count: 1, Memory: 40 << 30}},
+		{in: "0.5,mem=40Gi", want: Claim{Fraction: 0.5, Memory: 40 << 30}},
+		{in: "4,model=A100,memory=80G", want: Claim{Count: 4, Model: "A100", Memory: 80e9}},
+		{in: "single-gpu,model=A100", want: Claim{Name: "single-gpu", Model: "A100"}},
+		{in: "single-gpu", want: Claim{Name: "single-gpu"}},
+		{in: "0.3,lim=0.6", want: Claim{Fraction: 0.3, Limit: 0.6}},
+		{in: "req=0.3,lim=0.6", want: Claim{Fraction: 0.3, Limit: 0.6}},
+		{in: "request=0.25,limit=1,mem=8Gi", want: Claim{Fraction: 0.25, Limit: 1, Memory: 8 << 30}},
+		{in: "req=0.5", want: Claim{Fraction: 0.5}},
+		{in: "0.5,lim=0.5", want: Claim{Fraction: 0.5, Limit: 0.5}},
+		{in: "team.training-gpus", want: Claim{Name: "team.training-gpus"}},
+		{in: "2gpus", want: Claim{Name: "2gpus"}},
+		{in: "8x-a100,model=A100", want: Claim{Name: "8x-a100", Model: "A100"}},
+		{in: "2x", want: Claim{Name: "2x"}},
+		{in: "inf", want: Claim{Name: "inf"}},
+		{in: "device=GPU-8f3c2d1e", want: Claim{Count: 1, Device: "GPU-8f3c2d1e"}},
+		{in: "1,device=GPU-8f3c2d1e,model=A100", want: Claim{Count: 1, Device: "GPU-8f3c2d1e", Model: "A100"}},
+		{in: "", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "2x!", wantErr: true},
+		{in: "-2gpus", wantErr: true},
+		{in: "1.5", wantErr: true},
+		{in: ",model=A100", wantErr: true},
+		{in: "2,model=", wantErr: true},
+		{in: "2,model", wantErr: true},
+		{in: "2,vendor=nvidia", wantErr: true},
+		{in: "2,memory=lots", wantErr: true},
+		{in: "2,memory=0", wantErr: true},
+		{in: "2,model=A100 80GB", wantErr: true},
+		{in: "0.0", wantErr: true},
+		{in: "1e400", wantErr: true},
+		{in: "Single_GPU", wantErr: true},
+		{in: "lim=0.6", wantErr: true},
+		{in: "0.3,req=0.3", wantErr: true},
+		{in: "req=2", wantErr: true},
+		{in: "2,lim=0.5", wantErr: true},
+		{in: "single-gpu,lim=0.5", wantErr: true},
+		{in: "0.6,lim=0.3", wantErr: true},
+		{in: "0.3,lim=1.5", wantErr: true},
+		{in: "0.3,lim=NaN", wantErr: true},
+		{in: "device=", wantErr: true},
+		{in: "2,device=GPU-8f3c2d1e", wantErr: true},
+		{in: "0.5,device=GPU-8f3c2d1e", wantErr: true},
+		{in: "single-gpu,device=GPU-8f3c2d1e", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseClaim(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseClaim(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidClaim) {
+			t.Errorf("ParseClaim(%q) error = %v, want ErrInvalidClaim", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseClaim(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidClaimWrapped(t *testing.T) {
+	for _, parse := range []func() error{
+		func() error { _, err := ParseClaim("2,vendor=nvidia"); return err },
+		func() error { _, err := ParseMIGProfile("1g"); return err },
+	} {
+		err := parse()
+		wrapped := fmt.Errorf("pod default/trainer: %w", err)
+		if !errors.Is(wrapped, ErrInvalidClaim) {
+			t.Errorf("Expected %q to wrap ErrInvalidClaim", wrapped)
+		}
+		if got := err.Error(); got == ErrInvalidClaim.Error() {
+			t.Errorf("Expected the error to describe the problem, got %q", got)
+		}
+	}
+}
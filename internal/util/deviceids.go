@@ -0,0 +1,48 @@
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnoDeviceIDs lists the ids of the GPUs pods may be given on a node, as
+// comma-separated indices, e.g. "1,2,5" on a node passing only some of its
+// devices through. Without it a node's GPUs are 0 to its capacity minus one.
+const AnnoDeviceIDs = "gpu.scheduling/device-ids"
+
+// ParseDeviceIDs parses an AnnoDeviceIDs value into ascending ids.
+func ParseDeviceIDs(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid device ids %q: expected non-negative indices, got %q", s, field)
+		}
+		if slices.Contains(ids, id) {
+			return nil, fmt.Errorf("invalid device ids %q: device %d is listed twice", s, id)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// NodeDeviceIDs returns the ids of the GPUs pods may be given on node, which
+// has capacity GPUs: those its AnnoDeviceIDs annotation lists, or 0 to
+// capacity-1 without one. An annotation that does not parse yields no ids
+// and the parse error, so a typo never hands out a device that is not
+// passed through.
+func NodeDeviceIDs(node *corev1.Node, capacity int) ([]int, error) {
+	if v, ok := node.Annotations[AnnoDeviceIDs]; ok {
+		return ParseDeviceIDs(v)
+	}
+	ids := make([]int, capacity)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids, nil
+}
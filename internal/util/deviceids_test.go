@@ -0,0 +1,45 @@
+package util
+
+import (
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeDeviceIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		capacity    int
+		want        []int
+		wantErr     bool
+	}{
+		{name: "no annotation", capacity: 3, want: []int{0, 1, 2}},
+		{name: "no GPUs", capacity: 0, want: []int{}},
+		{name: "explicit ids", annotations: map[string]string{AnnoDeviceIDs: "1,2,5"}, capacity: 3, want: []int{1, 2, 5}},
+		{name: "unsorted with spaces", annotations: map[string]string{AnnoDeviceIDs: " 5, 1,2"}, capacity: 3, want: []int{1, 2, 5}},
+		{name: "duplicate", annotations: map[string]string{AnnoDeviceIDs: "1,1"}, capacity: 2, wantErr: true},
+		{name: "negative", annotations: map[string]string{AnnoDeviceIDs: "-1,2"}, capacity: 2, wantErr: true},
+		{name: "not a number", annotations: map[string]string{AnnoDeviceIDs: "1,gpu2"}, capacity: 2, wantErr: true},
+		{name: "empty", annotations: map[string]string{AnnoDeviceIDs: ""}, capacity: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Annotations: tt.annotations}}
+		got, err := NodeDeviceIDs(node, tt.capacity)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			if len(got) != 0 {
+				t.Errorf("%s: Expected no ids with the error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"math"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnoHealthScore carries a node's GPU health as 0-100, 100 being fully
+// healthy, as published by an external DCGM exporter.
+const AnnoHealthScore = "gpu.scheduling/health-score"
+
+// MaxHealthScore is the score of a healthy node.
+const MaxHealthScore = 100
+
+// NodeHealthScore returns the node's AnnoHealthScore. A node without the
+// annotation, or with a malformed one, counts as healthy; values outside
+// 0-100 are clamped.
+func NodeHealthScore(node *corev1.Node) int64 {
+	v, ok := node.Annotations[AnnoHealthScore]
+	if !ok {
+		return MaxHealthScore
+	}
+	score, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(score) {
+		return MaxHealthScore
+	}
+	return int64(min(max(score, 0), MaxHealthScore))
+}
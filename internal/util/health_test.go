@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeHealthScore(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int64
+	}{
+		{name: "unannotated", want: 100},
+		{name: "integer", annotations: map[string]string{AnnoHealthScore: "70"}, want: 70},
+		{name: "fraction truncated", annotations: map[string]string{AnnoHealthScore: "85.9"}, want: 85},
+		{name: "below range", annotations: map[string]string{AnnoHealthScore: "-5"}, want: 0},
+		{name: "above range", annotations: map[string]string{AnnoHealthScore: "250"}, want: 100},
+		{name: "malformed", annotations: map[string]string{AnnoHealthScore: "degraded"}, want: 100},
+		{name: "NaN", annotations: map[string]string{AnnoHealthScore: "NaN"}, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if got := NodeHealthScore(node); got != tt.want {
+				t.Errorf("Expected health %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnoMIGProfile asks for MIG instances of one profile, e.g. "1g.5gb",
+// instead of whole devices.
+const AnnoMIGProfile = "gpu.scheduling/mig-profile"
+
+// migProfileRE matches NVIDIA MIG profile names such as 1g.5gb, 3g.20gb or
+// 1g.10gb+me.
+var migProfileRE = regexp.MustCompile(`^[1-9][0-9]*g\.[1-9][0-9]*gb(\+me)?$`)
+
+// ParseMIGProfile validates a mig-profile annotation value.
+func ParseMIGProfile(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !migProfileRE.MatchString(s) {
+		return "", claimErrorf("invalid MIG profile %q: expected a name like 1g.5gb", s)
+	}
+	return s, nil
+}
+
+// MIGCountLabel is the GPU feature discovery label advertising how many
+// instances of profile a node has.
+func MIGCountLabel(profile string) string {
+	return "nvidia.com/mig-" + profile + ".count"
+}
+
+// MIGResource is the extended resource containers request for profile.
+func MIGResource(profile string) corev1.ResourceName {
+	return corev1.ResourceName("nvidia.com/mig-" + profile)
+}
+
+// NodeMIGCapacity returns how many instances of profile the node advertises.
+func NodeMIGCapacity(node *corev1.Node, profile string) int {
+	n, err := strconv.Atoi(node.Labels[MIGCountLabel(profile)])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseMIGProfile(t *testing.T) {
+	for _, ok := range []string{"1g.5gb", "3g.20gb", " 7g.80gb ", "1g.10gb+me"} {
+		if _, err := ParseMIGProfile(ok); err != nil {
+			t.Errorf("ParseMIGProfile(%q): unexpected error %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "1g", "0g.5gb", "1g.5GB", "mig-1g.5gb"} {
+		if _, err := ParseMIGProfile(bad); err == nil {
+			t.Errorf("ParseMIGProfile(%q): expected error", bad)
+		}
+	}
+}
+
+func TestNodeMIGCapacity(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"nvidia.com/mig-1g.5gb.count": "7",
+	}}}
+	if n := NodeMIGCapacity(node, "1g.5gb"); n != 7 {
+		t.Errorf("Expected 7 1g.5gb instances, got %d", n)
+	}
+	if n := NodeMIGCapacity(node, "3g.20gb"); n != 0 {
+		t.Errorf("Expected no 3g.20gb instances, got %d", n)
+	}
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// LabelCapacity advertises the number of schedulable GPUs on a node.
+	LabelCapacity = "gpu.scheduling/capacity"
+	// LabelModel names the GPU model installed on a node, e.g. A100.
+	LabelModel = "gpu.scheduling/model"
+	// LabelMemory advertises the memory of each GPU on a node as a quantity,
+	// e.g. 80Gi.
+	LabelMemory = "gpu.scheduling/memory"
+	// LabelGFDMemory is GPU feature discovery's per-GPU memory in MiB, used
+	// when LabelMemory is absent.
+	LabelGFDMemory = "nvidia.com/gpu.memory"
+	// LabelCordoned set to "true" stops the scheduler from allocating GPUs on
+	// a node, e.g. while `gpuctl drain-gpu` empties it for maintenance.
+	LabelCordoned = "gpu.scheduling/cordoned"
+	// LabelUnschedulable set to "true" cordons a node's GPUs like
+	// LabelCordoned, for maintenance tooling other than gpuctl.
+	LabelUnschedulable = "gpu.scheduling/unschedulable"
+	// LabelVendor names the vendor of a node's GPUs, e.g. amd, in clusters
+	// mixing vendors. Nodes without it have the --gpu-vendor's GPUs.
+	LabelVendor = "gpu.scheduling/vendor"
+	// LabelLifecycle set to LifecycleSpot marks a preemptible node, which
+	// batch pods are steered to and other pods away from.
+	LabelLifecycle = "gpu.scheduling/lifecycle"
+	// LifecycleSpot is the LabelLifecycle value of a preemptible node.
+	LifecycleSpot = "spot"
+	// ResourceGPU is the NVIDIA device plugin's extended resource.
+	ResourceGPU corev1.ResourceName = "nvidia.com/gpu"
+	// ResourceAMDGPU is the AMD device plugin's extended resource.
+	ResourceAMDGPU corev1.ResourceName = "amd.com/gpu"
+)
+
+// NodeHasModel reports whether the node's LabelModel matches model, ignoring
+// case. An empty model matches every node.
+func NodeHasModel(node *corev1.Node, model string) bool {
+	return model == "" || strings.EqualFold(node.Labels[LabelModel], model)
+}
+
+// NodeCordoned reports whether GPU allocation on the node is cordoned by
+// either LabelCordoned or LabelUnschedulable.
+func NodeCordoned(node *corev1.Node) bool {
+	return node.Labels[LabelCordoned] == "true" || node.Labels[LabelUnschedulable] == "true"
+}
+
+// NodeSpot reports whether the node is a preemptible spot node.
+func NodeSpot(node *corev1.Node) bool {
+	return node.Labels[LabelLifecycle] == LifecycleSpot
+}
+
+// NodeGPUMemory returns the memory of each GPU on the node in bytes, or 0 when
+// the node does not advertise it.
+func NodeGPUMemory(node *corev1.Node) int64 {
+	if v, ok := node.Labels[LabelMemory]; ok {
+		if q, err := resource.ParseQuantity(v); err == nil && q.Sign() > 0 {
+			return q.Value()
+		}
+	}
+	if v, ok := node.Labels[LabelGFDMemory]; ok {
+		if mib, err := strconv.ParseInt(v, 10, 64); err == nil && mib > 0 {
+			return mib << 20
+		}
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeGPUMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   int64
+	}{
+		{name: "quantity label", labels: map[string]string{LabelMemory: "80Gi"}, want: 80 << 30},
+		{name: "feature discovery MiB", labels: map[string]string{LabelGFDMemory: "40960"}, want: 40 << 30},
+		{name: "own label wins", labels: map[string]string{LabelMemory: "24Gi", LabelGFDMemory: "40960"}, want: 24 << 30},
+		{name: "invalid falls back", labels: map[string]string{LabelMemory: "lots", LabelGFDMemory: "16384"}, want: 16 << 30},
+		{name: "unknown", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tt.labels}}
+			if got := NodeGPUMemory(node); got != tt.want {
+				t.Errorf("Expected %d bytes, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -2,6 +2,11 @@ package util
 
 import (
 	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -9,24 +14,333 @@ import (
 const (
 	// AnnoClaim stores the claim name a pod references.
 	AnnoClaim = "gpu.scheduling/claim"
-	// AnnoAllocated stores the resolved `node:ids` payload for webhook consumption.
+	// AnnoAllocated maps each container to the device ids it was given, as a
+	// JSON object, e.g. {"trainer":[0,3],"sidecar":[0,3]}. It used to hold
+	// the flat FormatAllocation list, and pods admitted by the webhook of
+	// that time point their env vars at it; those still get the list, see
+	// ReadsFlatAllocation.
 	AnnoAllocated = "gpu.scheduling/allocated"
+	// AnnoContainerAllocatedPrefix prefixes the per-container annotations,
+	// keyed by container name, that hold the container's devices in the
+	// CUDA_VISIBLE_DEVICES format ("0,3", or GPU or MIG UUIDs). The webhook points
+	// each container's env vars at its own annotation through a fieldRef.
+	AnnoContainerAllocatedPrefix = "allocated.gpu.scheduling/"
+	// AnnoContainerDevices splits the pod's devices between its containers,
+	// as a JSON object of counts, e.g. {"trainer":2,"evaluator":1}. The counts
+	// must add up to the devices the pod claims; containers it does not name
+	// see all of them.
+	AnnoContainerDevices = "gpu.scheduling/container-devices"
+	// AnnoInjectContainers lists containers (comma-separated) that receive the
+	// device env vars even without requesting the GPU resource.
+	AnnoInjectContainers = "gpu.scheduling/inject-containers"
+	// AnnoExtraEnv holds a JSON list of env vars, e.g.
+	// [{"name":"NCCL_DEBUG","value":"INFO"}], that the webhook adds to every
+	// GPU container next to the device variables.
+	AnnoExtraEnv = "gpu.scheduling/extra-env"
+	// AnnoGang groups pods that must be admitted together.
+	AnnoGang = "gpu.scheduling/gang"
+	// AnnoGangSize is the number of pods in the gang.
+	AnnoGangSize = "gpu.scheduling/gang-size"
+	// LabelPodGroup is the coscheduling plugin's pod label naming the
+	// pod's PodGroup, of the scheduling.x-k8s.io API group, which then sets
+	// the gang instead of AnnoGang.
+	LabelPodGroup = "scheduling.x-k8s.io/pod-group"
+	// LabelPodGroupLegacy is LabelPodGroup as scheduler-plugins releases
+	// before scheduling.x-k8s.io set it, naming a PodGroup of the
+	// scheduling.sigs.k8s.io API group.
+	LabelPodGroupLegacy = "pod-group.scheduling.sigs.k8s.io"
+	// LabelDeviceAntiAffinity is a pod label. Pods with the same value never
+	// share a physical GPU, whether through fractions or MIG instances.
+	LabelDeviceAntiAffinity = "gpu.scheduling/device-anti-affinity"
+	// AnnoReservation names the GPU reservation, in the pod's namespace, whose
+	// devices the pod may use while the reservation holds them for its window.
+	AnnoReservation = "gpu.scheduling/reservation"
+	// AnnoPreferSpot set to "true" marks a batch pod that would rather run on
+	// cheaper spot nodes. Pods without it avoid them.
+	AnnoPreferSpot = "gpu.scheduling/prefer-spot"
+	// AnnoRequestedBy is the user who created a claiming pod, set by the
+	// webhook from the admission request for the scheduler's audit log.
+	AnnoRequestedBy = "gpu.scheduling/requested-by"
+	// AnnoUseDefaultClaim set to "true" on a pod without AnnoClaim asks the
+	// webhook for its namespace's AnnoDefaultClaim.
+	AnnoUseDefaultClaim = "gpu.scheduling/use-default-claim"
+	// AnnoDefaultClaim is a Namespace annotation holding the claim value,
+	// e.g. "1", given to the namespace's pods that carry AnnoUseDefaultClaim.
+	AnnoDefaultClaim = "gpu.scheduling/default-claim"
+	// AnnoTraceparent holds a W3C traceparent, e.g. set by the tooling that
+	// created the pod. The scheduler's spans for the pod join that trace and
+	// follow its sampling decision.
+	AnnoTraceparent = "gpu.scheduling/traceparent"
+	// AnnoWebhookNotes describes, in words, how the webhook changed a pod's
+	// claim at create, e.g. lowering it to the per-pod cap.
+	AnnoWebhookNotes = "gpu.scheduling/webhook-notes"
+	// ConditionAllocated is the pod condition, and readiness gate, the
+	// scheduler sets to True once the pod's allocation annotations are
+	// written.
+	ConditionAllocated corev1.PodConditionType = "gpu.scheduling/Allocated"
 )
 
-// SetAllocated annotates the pod with the resolved node and GPU ids.
-func SetAllocated(p *corev1.Pod, node string, ids []int) {
+// PrefersSpot reports whether the pod asks for spot nodes.
+func PrefersSpot(p *corev1.Pod) bool {
+	return p.Annotations[AnnoPreferSpot] == "true"
+}
+
+// HasAllocatedGate reports whether the pod's readiness waits on
+// ConditionAllocated.
+func HasAllocatedGate(p *corev1.Pod) bool {
+	for _, g := range p.Spec.ReadinessGates {
+		if g.ConditionType == ConditionAllocated {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerAllocatedKey is the annotation holding container's devices.
+func ContainerAllocatedKey(container string) string {
+	return AnnoContainerAllocatedPrefix + container
+}
+
+// SetAllocated annotates the pod with the allocation map and each
+// container's devices.
+func SetAllocated(p *corev1.Pod, alloc map[string][]int) {
+	setAllocated(p, alloc, FormatAllocation)
+}
+
+// SetAllocatedUUIDs annotates the pod with the allocation map of device,
+// e.g. MIG instance, ids, while each container's annotation lists the UUIDs
+// of its devices in place of the ids.
+func SetAllocatedUUIDs(p *corev1.Pod, alloc map[string][]int, uuids map[int]string) {
+	setAllocated(p, alloc, func(ids []int) string {
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = uuids[id]
+		}
+		return strings.Join(parts, ",")
+	})
+}
+
+func setAllocated(p *corev1.Pod, alloc map[string][]int, format func([]int) string) {
 	m := p.GetAnnotations()
 	if m == nil {
 		m = map[string]string{}
 	}
-	b, _ := json.Marshal(ids)
-	m[AnnoAllocated] = trimList(b)
+	m[AnnoAllocated] = FormatAllocationMap(alloc)
+	if ReadsFlatAllocation(p) {
+		var ids []int
+		for _, container := range alloc {
+			for _, id := range container {
+				if !slices.Contains(ids, id) {
+					ids = append(ids, id)
+				}
+			}
+		}
+		sort.Ints(ids)
+		m[AnnoAllocated] = FormatAllocation(ids)
+	}
+	for container, ids := range alloc {
+		m[ContainerAllocatedKey(container)] = format(ids)
+	}
 	p.Annotations = m
 }
 
-func trimList(b []byte) string {
-	if len(b) >= 2 && b[0] == '[' && b[len(b)-1] == ']' {
-		return string(b[1 : len(b)-1])
+// flatAllocationFieldPath is the fieldRef path the webhook pointed env vars
+// at while AnnoAllocated held a flat list.
+const flatAllocationFieldPath = "metadata.annotations['" + AnnoAllocated + "']"
+
+// ReadsFlatAllocation reports whether one of the pod's containers reads
+// AnnoAllocated through a fieldRef, as pods admitted by the webhook did
+// before AnnoAllocated became a map. Such a pod is given the flat
+// FormatAllocation list of all its devices there instead, so an upgrade
+// does not hand it a JSON object for CUDA_VISIBLE_DEVICES.
+func ReadsFlatAllocation(p *corev1.Pod) bool {
+	for _, containers := range [][]corev1.Container{p.Spec.InitContainers, p.Spec.Containers} {
+		for _, c := range containers {
+			for _, e := range c.Env {
+				if e.ValueFrom != nil && e.ValueFrom.FieldRef != nil && e.ValueFrom.FieldRef.FieldPath == flatAllocationFieldPath {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// AllocatedAnnotations returns the pod's AnnoAllocated and per-container
+// annotations, as written by SetAllocated.
+func AllocatedAnnotations(p *corev1.Pod) map[string]string {
+	out := map[string]string{}
+	for k, v := range p.Annotations {
+		if k == AnnoAllocated || strings.HasPrefix(k, AnnoContainerAllocatedPrefix) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// SplitAllocation assigns the pod's devices to its containers. With
+// AnnoContainerDevices, each container it names gets its own slice of ids,
+// in container order, and the counts must add up to the allocation. Without
+// it, when the containers' requests for resource add up to the allocation,
+// each requesting container gets its own slice likewise; otherwise every
+// container sees all of them. Containers given no count see all of them
+// either way. Init containers run before the others, so each one gets the
+// first ids it requests.
+func SplitAllocation(pod *corev1.Pod, resource corev1.ResourceName, ids []int) (map[string][]int, error) {
+	counts, err := ContainerDevices(pod)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	if counts != nil {
+		for _, n := range counts {
+			total += n
+		}
+		if total != len(ids) {
+			return nil, fmt.Errorf("%s annotation assigns %d devices, but the pod has %d", AnnoContainerDevices, total, len(ids))
+		}
+	} else {
+		counts = map[string]int{}
+		for _, c := range pod.Spec.Containers {
+			counts[c.Name] = containerRequest(c, resource)
+			total += counts[c.Name]
+		}
+	}
+	out := map[string][]int{}
+	next := 0
+	for _, c := range pod.Spec.Containers {
+		n := counts[c.Name]
+		if n == 0 || total != len(ids) {
+			out[c.Name] = ids
+			continue
+		}
+		out[c.Name] = ids[next : next+n]
+		next += n
 	}
+	for _, c := range pod.Spec.InitContainers {
+		if n := containerRequest(c, resource); n > 0 && n <= len(ids) {
+			out[c.Name] = ids[:n]
+		} else {
+			out[c.Name] = ids
+		}
+	}
+	return out, nil
+}
+
+// ContainerDevices returns the counts of the pod's AnnoContainerDevices
+// annotation, or nil when it has none. Each count must be positive and name
+// one of the pod's containers; init containers cannot be named.
+func ContainerDevices(pod *corev1.Pod) (map[string]int, error) {
+	v, ok := pod.Annotations[AnnoContainerDevices]
+	if !ok {
+		return nil, nil
+	}
+	var counts map[string]int
+	if err := json.Unmarshal([]byte(v), &counts); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %v", AnnoContainerDevices, v, err)
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("%s annotation names no containers", AnnoContainerDevices)
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.ContainsFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("%s annotation names %q, which is not a container of the pod", AnnoContainerDevices, name)
+		}
+		if counts[name] <= 0 {
+			return nil, fmt.Errorf("%s annotation gives container %q %d devices; counts must be positive", AnnoContainerDevices, name, counts[name])
+		}
+	}
+	return counts, nil
+}
+
+// containerRequest returns the count of resource the container asks for,
+// from its limits or else its requests.
+func containerRequest(c corev1.Container, resource corev1.ResourceName) int {
+	if q, ok := c.Resources.Limits[resource]; ok {
+		return int(q.Value())
+	}
+	if q, ok := c.Resources.Requests[resource]; ok {
+		return int(q.Value())
+	}
+	return 0
+}
+
+// FormatAllocationMap encodes a container-to-devices map as the
+// AnnoAllocated value. Containers are sorted by name.
+func FormatAllocationMap(alloc map[string][]int) string {
+	// Marshaling a map of int slices cannot fail; encoding/json sorts the keys.
+	b, _ := json.Marshal(alloc)
 	return string(b)
 }
+
+// ParseAllocationMap decodes an AnnoAllocated value written by
+// FormatAllocationMap.
+func ParseAllocationMap(s string) (map[string][]int, error) {
+	var alloc map[string][]int
+	if err := json.Unmarshal([]byte(s), &alloc); err != nil {
+		return nil, fmt.Errorf("invalid allocation map %q: %v", s, err)
+	}
+	if len(alloc) == 0 {
+		return nil, fmt.Errorf("allocation map is empty")
+	}
+	containers := make([]string, 0, len(alloc))
+	for container := range alloc {
+		containers = append(containers, container)
+	}
+	sort.Strings(containers)
+	for _, container := range containers {
+		ids := alloc[container]
+		if container == "" || len(ids) == 0 {
+			return nil, fmt.Errorf("invalid allocation map %q: container %q has no devices", s, container)
+		}
+		seen := make(map[int]bool, len(ids))
+		for _, id := range ids {
+			if id < 0 || seen[id] {
+				return nil, fmt.Errorf("invalid allocation map %q: bad or duplicate device id %d for container %q", s, id, container)
+			}
+			seen[id] = true
+		}
+	}
+	return alloc, nil
+}
+
+// FormatAllocation encodes device ids as a comma-separated list, e.g. "0,3",
+// as a container's ContainerAllocatedKey annotation and a lease's devices
+// hold them.
+func FormatAllocation(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseAllocation decodes a device list written by FormatAllocation. MIG
+// allocations and the UUIDs of --device-id-format=uuid are rejected, as is
+// an AnnoAllocated map, which ParseAllocationMap decodes; AnnoAllocated only
+// holds this list for pods that ReadsFlatAllocation.
+func ParseAllocation(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("allocation is empty")
+	}
+	parts := strings.Split(s, ",")
+	ids := make([]int, 0, len(parts))
+	seen := make(map[int]bool, len(parts))
+	for _, part := range parts {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid device id %q in allocation %q", part, s)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate device id %d in allocation %q", id, s)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAllocationRoundTrip(t *testing.T) {
+	for _, ids := range [][]int{{0}, {0, 3}, {7, 1, 2}} {
+		s := FormatAllocation(ids)
+		got, err := ParseAllocation(s)
+		if err != nil {
+			t.Errorf("ParseAllocation(%q): %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, ids) {
+			t.Errorf("Round trip of %v gave %v via %q", ids, got, s)
+		}
+	}
+	if s := FormatAllocation([]int{0, 3}); s != "0,3" {
+		t.Errorf("Expected \"0,3\", got %q", s)
+	}
+}
+
+func TestParseAllocationErrors(t *testing.T) {
+	for _, in := range []string{"", " ", "0,", "a,b", "-1", "1,1", "MIG-4f1c"} {
+		if ids, err := ParseAllocation(in); err == nil {
+			t.Errorf("ParseAllocation(%q) = %v, expected error", in, ids)
+		}
+	}
+	if ids, err := ParseAllocation(" 2, 5 "); err != nil || !reflect.DeepEqual(ids, []int{2, 5}) {
+		t.Errorf("Expected spaces to be tolerated, got %v, %v", ids, err)
+	}
+}
+
+func TestAllocationMapRoundTrip(t *testing.T) {
+	for _, alloc := range []map[string][]int{
+		{"trainer": {0}},
+		{"worker-0": {0, 1}, "worker-1": {2, 3}},
+		{"main": {7, 1}, "sidecar": {7, 1}},
+	} {
+		s := FormatAllocationMap(alloc)
+		got, err := ParseAllocationMap(s)
+		if err != nil {
+			t.Errorf("ParseAllocationMap(%q): %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, alloc) {
+			t.Errorf("Round trip of %v gave %v via %q", alloc, got, s)
+		}
+	}
+	if s := FormatAllocationMap(map[string][]int{"b": {1}, "a": {0, 3}}); s != `{"a":[0,3],"b":[1]}` {
+		t.Errorf("Expected containers sorted by name, got %q", s)
+	}
+}
+
+func TestParseAllocationMapErrors(t *testing.T) {
+	for _, in := range []string{"", "0,3", "{}", `{"a":[]}`, `{"a":[-1]}`, `{"a":[1,1]}`, `{"":[0]}`, `{"a":["MIG-4f1c"]}`} {
+		if alloc, err := ParseAllocationMap(in); err == nil {
+			t.Errorf("ParseAllocationMap(%q) = %v, expected error", in, alloc)
+		}
+	}
+}
+
+func TestSplitAllocation(t *testing.T) {
+	gpus := func(n string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Limits: corev1.ResourceList{ResourceGPU: resource.MustParse(n)}}
+	}
+	tests := []struct {
+		name    string
+		devices string
+		spec    corev1.PodSpec
+		want    map[string][]int
+		wantErr string
+	}{
+		{
+			name: "one container",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+			want: map[string][]int{"main": {0, 3, 5}},
+		},
+		{
+			name: "requests add up",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "warm", Resources: gpus("1")}},
+				Containers: []corev1.Container{
+					{Name: "a", Resources: gpus("2")},
+					{Name: "sidecar"},
+					{Name: "b", Resources: gpus("1")},
+				},
+			},
+			want: map[string][]int{"warm": {0}, "a": {0, 3}, "sidecar": {0, 3, 5}, "b": {5}},
+		},
+		{
+			name: "requests do not add up",
+			spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "a", Resources: gpus("2")},
+				{Name: "b", Resources: gpus("2")},
+			}},
+			want: map[string][]int{"a": {0, 3, 5}, "b": {0, 3, 5}},
+		},
+		{
+			name:    "container devices split two containers",
+			devices: `{"evaluator":1,"trainer":2}`,
+			spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "trainer", Resources: gpus("3")},
+				{Name: "evaluator", Resources: gpus("3")},
+				{Name: "sidecar"},
+			}},
+			want: map[string][]int{"trainer": {0, 3}, "evaluator": {5}, "sidecar": {0, 3, 5}},
+		},
+		{
+			name:    "container devices do not add up",
+			devices: `{"trainer":2,"evaluator":2}`,
+			spec:    corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer"}, {Name: "evaluator"}}},
+			wantErr: "assigns 4 devices, but the pod has 3",
+		},
+		{
+			name:    "container devices name an unknown container",
+			devices: `{"trainer":3,"ghost":0}`,
+			spec:    corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer"}}},
+			wantErr: `names "ghost", which is not a container`,
+		},
+		{
+			name:    "container devices with a zero count",
+			devices: `{"trainer":3,"evaluator":0}`,
+			spec:    corev1.PodSpec{Containers: []corev1.Container{{Name: "trainer"}, {Name: "evaluator"}}},
+			wantErr: "counts must be positive",
+		},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{Spec: tt.spec}
+		if tt.devices != "" {
+			pod.Annotations = map[string]string{AnnoContainerDevices: tt.devices}
+		}
+		got, err := SplitAllocation(pod, ResourceGPU, []int{0, 3, 5})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Expected an error containing %q, got %v", tt.name, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: SplitAllocation: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSetAllocated(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AnnoClaim: "2"}}}
+	SetAllocated(pod, map[string][]int{"a": {1}, "b": {2}})
+	want := map[string]string{
+		AnnoAllocated:              `{"a":[1],"b":[2]}`,
+		ContainerAllocatedKey("a"): "1",
+		ContainerAllocatedKey("b"): "2",
+	}
+	if got := AllocatedAnnotations(pod); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected annotations %v, got %v", want, got)
+	}
+
+	SetAllocatedUUIDs(pod, map[string][]int{"a": {4, 6}}, map[int]string{4: "MIG-aaaa", 6: "MIG-bbbb"})
+	if v := pod.Annotations[ContainerAllocatedKey("a")]; v != "MIG-aaaa,MIG-bbbb" {
+		t.Errorf("Expected the container's MIG UUIDs, got %q", v)
+	}
+}
+
+func TestSetAllocatedFlat(t *testing.T) {
+	// Admitted by a webhook from before AnnoAllocated became a map.
+	env := []corev1.EnvVar{{Name: "CUDA_VISIBLE_DEVICES", ValueFrom: &corev1.EnvVarSource{
+		FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.annotations['gpu.scheduling/allocated']"},
+	}}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a", Env: env}, {Name: "b"}}}}
+	if !ReadsFlatAllocation(pod) {
+		t.Fatalf("Expected the pod to read the flat allocation")
+	}
+	SetAllocatedUUIDs(pod, map[string][]int{"a": {3, 1}, "b": {1}}, map[int]string{1: "GPU-aaaa", 3: "GPU-bbbb"})
+	want := map[string]string{
+		AnnoAllocated:              "1,3",
+		ContainerAllocatedKey("a"): "GPU-bbbb,GPU-aaaa",
+		ContainerAllocatedKey("b"): "GPU-aaaa",
+	}
+	if got := AllocatedAnnotations(pod); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected annotations %v, got %v", want, got)
+	}
+
+	pod.Spec.Containers[0].Env[0].ValueFrom.FieldRef.FieldPath = "metadata.annotations['" + ContainerAllocatedKey("a") + "']"
+	if ReadsFlatAllocation(pod) {
+		t.Errorf("Expected a per-container fieldRef not to read the flat allocation")
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnoTopology describes a node's NVLink islands: groups of device ids that
+// are fully connected over NVLink, separated by ";", e.g. "0,1,2,3;4,5,6,7".
+const AnnoTopology = "gpu.scheduling/topology"
+
+// ParseTopology parses an AnnoTopology value into its islands. A device may
+// belong to one island only.
+func ParseTopology(s string) ([][]int, error) {
+	var islands [][]int
+	seen := map[int]bool{}
+	for _, group := range strings.Split(s, ";") {
+		ids, err := ParseAllocation(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topology %q: %w", s, err)
+		}
+		for _, id := range ids {
+			if seen[id] {
+				return nil, fmt.Errorf("invalid topology %q: device %d is in more than one island", s, id)
+			}
+			seen[id] = true
+		}
+		islands = append(islands, ids)
+	}
+	return islands, nil
+}
+
+// NodeTopology returns the node's NVLink islands, or nil when the node has no
+// topology annotation.
+func NodeTopology(node *corev1.Node) ([][]int, error) {
+	v, ok := node.Annotations[AnnoTopology]
+	if !ok {
+		return nil, nil
+	}
+	return ParseTopology(v)
+}
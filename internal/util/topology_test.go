@@ -0,0 +1,35 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseTopology(t *testing.T) {
+	got, err := ParseTopology("0,1,2,3; 4,5,6,7")
+	if err != nil {
+		t.Fatalf("ParseTopology: %v", err)
+	}
+	if want := [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "0,1;", "0,1;1,2", "0,x"} {
+		if _, err := ParseTopology(bad); err == nil {
+			t.Errorf("ParseTopology(%q): expected error", bad)
+		}
+	}
+}
+
+func TestNodeTopology(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	if islands, err := NodeTopology(node); islands != nil || err != nil {
+		t.Errorf("Expected no topology without the annotation, got %v %v", islands, err)
+	}
+	node.Annotations = map[string]string{AnnoTopology: "0,1;2,3"}
+	if islands, err := NodeTopology(node); err != nil || len(islands) != 2 {
+		t.Errorf("Expected 2 islands, got %v %v", islands, err)
+	}
+}
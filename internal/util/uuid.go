@@ -0,0 +1,55 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AnnoDeviceUUIDs maps a node's device ids to the GPUs' UUIDs, which unlike
+// the ids survive a reboot that renumbers the devices, as comma-separated
+// id=uuid pairs, e.g. "0=GPU-8f3c...,1=GPU-1a2b...".
+const AnnoDeviceUUIDs = "gpu.scheduling/device-uuids"
+
+// ParseDeviceUUIDs parses an AnnoDeviceUUIDs value.
+func ParseDeviceUUIDs(s string) (map[int]string, error) {
+	uuids := map[int]string{}
+	for _, pair := range strings.Split(s, ",") {
+		idStr, uuid, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		id, err := strconv.Atoi(idStr)
+		if !ok || err != nil || id < 0 || uuid == "" {
+			return nil, fmt.Errorf("invalid device UUIDs %q: expected id=uuid, got %q", s, pair)
+		}
+		if _, dup := uuids[id]; dup {
+			return nil, fmt.Errorf("invalid device UUIDs %q: device %d is listed twice", s, id)
+		}
+		uuids[id] = uuid
+	}
+	return uuids, nil
+}
+
+// NodeDeviceUUIDs returns the node's device UUIDs by id, or nil when the node
+// has no AnnoDeviceUUIDs annotation.
+func NodeDeviceUUIDs(node *corev1.Node) (map[int]string, error) {
+	v, ok := node.Annotations[AnnoDeviceUUIDs]
+	if !ok {
+		return nil, nil
+	}
+	return ParseDeviceUUIDs(v)
+}
+
+// DeviceUUIDs translates device ids to their UUIDs. It reports false when
+// any id has no UUID, in which case the ids should be used as they are.
+func DeviceUUIDs(ids []int, uuids map[int]string) ([]string, bool) {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		uuid, ok := uuids[id]
+		if !ok {
+			return nil, false
+		}
+		out[i] = uuid
+	}
+	return out, true
+}
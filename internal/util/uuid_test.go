@@ -0,0 +1,45 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseDeviceUUIDs(t *testing.T) {
+	got, err := ParseDeviceUUIDs("0=GPU-aaa, 1=GPU-bbb")
+	if err != nil {
+		t.Fatalf("ParseDeviceUUIDs: %v", err)
+	}
+	if want := map[int]string{0: "GPU-aaa", 1: "GPU-bbb"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "0", "0=", "x=GPU-aaa", "-1=GPU-aaa", "0=GPU-aaa,0=GPU-bbb"} {
+		if _, err := ParseDeviceUUIDs(bad); err == nil {
+			t.Errorf("ParseDeviceUUIDs(%q): expected error", bad)
+		}
+	}
+}
+
+func TestNodeDeviceUUIDs(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	if uuids, err := NodeDeviceUUIDs(node); uuids != nil || err != nil {
+		t.Errorf("Expected no UUIDs without the annotation, got %v %v", uuids, err)
+	}
+	node.Annotations = map[string]string{AnnoDeviceUUIDs: "0=GPU-aaa,1=GPU-bbb"}
+	if uuids, err := NodeDeviceUUIDs(node); err != nil || len(uuids) != 2 {
+		t.Errorf("Expected 2 UUIDs, got %v %v", uuids, err)
+	}
+}
+
+func TestDeviceUUIDs(t *testing.T) {
+	uuids := map[int]string{0: "GPU-aaa", 3: "GPU-ddd"}
+	if got, ok := DeviceUUIDs([]int{3, 0}, uuids); !ok || !reflect.DeepEqual(got, []string{"GPU-ddd", "GPU-aaa"}) {
+		t.Errorf("Expected the UUIDs in id order, got %v %v", got, ok)
+	}
+	if got, ok := DeviceUUIDs([]int{0, 1}, uuids); ok {
+		t.Errorf("Expected a device without a UUID to fail the translation, got %v", got)
+	}
+}
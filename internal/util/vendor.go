@@ -0,0 +1,91 @@
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Vendor holds what differs between GPU vendors: the extended resource their
+// device plugin advertises and the environment variables their runtime
+// selects devices by. A new vendor only needs an entry in vendors.
+type Vendor struct {
+	// Name is the vendor's --gpu-vendor value.
+	Name string
+	// Resource is the extended resource containers request GPUs with and
+	// nodes advertise them under.
+	Resource corev1.ResourceName
+	// EnvVars are pointed at the allocated device ids.
+	EnvVars []string
+	// MIG reports whether the vendor's GPUs can be split into MIG instances.
+	MIG bool
+}
+
+// DefaultVendor names the vendor used unless --gpu-vendor says otherwise.
+const DefaultVendor = "nvidia"
+
+var (
+	// VendorNVIDIA targets the NVIDIA device plugin and CUDA.
+	VendorNVIDIA = Vendor{
+		Name:     "nvidia",
+		Resource: ResourceGPU,
+		EnvVars:  []string{"CUDA_VISIBLE_DEVICES"},
+		MIG:      true,
+	}
+	// VendorAMD targets the AMD device plugin and ROCm. ROCR_VISIBLE_DEVICES
+	// covers the ROCm runtime and HIP_VISIBLE_DEVICES HIP applications.
+	VendorAMD = Vendor{
+		Name:     "amd",
+		Resource: ResourceAMDGPU,
+		EnvVars:  []string{"ROCR_VISIBLE_DEVICES", "HIP_VISIBLE_DEVICES"},
+	}
+
+	vendors = map[string]Vendor{
+		VendorNVIDIA.Name: VendorNVIDIA,
+		VendorAMD.Name:    VendorAMD,
+	}
+)
+
+// LookupVendor returns the vendor called name.
+func LookupVendor(name string) (Vendor, error) {
+	if v, ok := vendors[name]; ok {
+		return v, nil
+	}
+	return Vendor{}, fmt.Errorf("unknown GPU vendor %q: must be one of %s", name, strings.Join(VendorNames(), ", "))
+}
+
+// VendorNames lists the known vendors in order.
+func VendorNames() []string {
+	names := make([]string, 0, len(vendors))
+	for name := range vendors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NodeVendor returns the vendor the node's LabelVendor names, or fallback
+// when the label is missing or names no known vendor.
+func NodeVendor(node *corev1.Node, fallback Vendor) Vendor {
+	if v, ok := vendors[node.Labels[LabelVendor]]; ok {
+		return v
+	}
+	return fallback
+}
+
+// NodeCapacity returns the GPU count from the capacity label, falling back
+// to the node's allocatable count of the vendor's resource.
+func (v Vendor) NodeCapacity(node *corev1.Node) int {
+	if s, ok := node.Labels[LabelCapacity]; ok {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			return n
+		}
+	}
+	if q, ok := node.Status.Allocatable[v.Resource]; ok {
+		return int(q.Value())
+	}
+	return 0
+}
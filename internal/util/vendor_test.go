@@ -0,0 +1,69 @@
+package util
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLookupVendor(t *testing.T) {
+	for _, name := range []string{"nvidia", "amd"} {
+		v, err := LookupVendor(name)
+		if err != nil || v.Name != name {
+			t.Errorf("Expected vendor %q, got %+v, %v", name, v, err)
+		}
+	}
+	if v, _ := LookupVendor(DefaultVendor); v.Resource != ResourceGPU {
+		t.Errorf("Expected the default vendor to use %s, got %s", ResourceGPU, v.Resource)
+	}
+	if _, err := LookupVendor("intel"); err == nil {
+		t.Errorf("Expected an error for an unknown vendor")
+	}
+}
+
+func TestVendorNodeCapacity(t *testing.T) {
+	amdNode := &corev1.Node{Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+		ResourceAMDGPU: resource.MustParse("8"),
+	}}}
+	tests := []struct {
+		name   string
+		vendor Vendor
+		node   *corev1.Node
+		want   int
+	}{
+		{name: "amd resource", vendor: VendorAMD, node: amdNode, want: 8},
+		{name: "other vendor's resource", vendor: VendorNVIDIA, node: amdNode, want: 0},
+		{name: "label wins", vendor: VendorAMD, node: &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelCapacity: "4"}},
+			Status:     amdNode.Status,
+		}, want: 4},
+		{name: "nothing advertised", vendor: VendorAMD, node: &corev1.Node{}, want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.vendor.NodeCapacity(tt.node); got != tt.want {
+			t.Errorf("%s: Expected capacity %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNodeVendor(t *testing.T) {
+	labeled := func(v string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelVendor: v}}}
+	}
+	tests := []struct {
+		name string
+		node *corev1.Node
+		want string
+	}{
+		{name: "labeled", node: labeled("amd"), want: "amd"},
+		{name: "unlabeled", node: &corev1.Node{}, want: "nvidia"},
+		{name: "unknown vendor", node: labeled("intel"), want: "nvidia"},
+	}
+	for _, tt := range tests {
+		if got := NodeVendor(tt.node, VendorNVIDIA); got.Name != tt.want {
+			t.Errorf("%s: Expected vendor %q, got %q", tt.name, tt.want, got.Name)
+		}
+	}
+}
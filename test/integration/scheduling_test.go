@@ -0,0 +1,137 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	coordv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/lease"
+	"github.com/restack/gpu-scheduler/internal/util"
+)
+
+// createGPUNode registers a ready node with gpus devices and the
+// GpuNodeStatus the node agent would publish for it.
+func createGPUNode(ctx context.Context, t *testing.T, name string, gpus int) {
+	t.Helper()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	node, err := clientset.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("8"),
+		corev1.ResourceMemory: resource.MustParse("32Gi"),
+		corev1.ResourcePods:   resource.MustParse("110"),
+		"nvidia.com/gpu":      *resource.NewQuantity(int64(gpus), resource.DecimalSI),
+	}
+	node.Status = corev1.NodeStatus{
+		Capacity:    resources,
+		Allocatable: resources,
+		Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+	}
+	if _, err := clientset.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update node status: %v", err)
+	}
+
+	gns := &apiv1.GpuNodeStatus{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       apiv1.GpuNodeStatusSpec{NodeName: name},
+	}
+	if err := crc.Create(ctx, gns); err != nil {
+		t.Fatalf("create GpuNodeStatus: %v", err)
+	}
+	for id := 0; id < gpus; id++ {
+		gns.Status.Devices = append(gns.Status.Devices, apiv1.Device{ID: id, Health: "Healthy"})
+	}
+	gns.Status.Total = gpus
+	if err := crc.Status().Update(ctx, gns); err != nil {
+		t.Fatalf("update GpuNodeStatus: %v", err)
+	}
+}
+
+func TestClaimedPodIsAllocatedAndReleased(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	createGPUNode(ctx, t, "gpu-node-a", 2)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "trainer",
+			Namespace:   "default",
+			Annotations: map[string]string{util.AnnoClaim: "1"},
+		},
+		Spec: corev1.PodSpec{
+			SchedulerName: schedulerName,
+			Containers: []corev1.Container{{
+				Name:  "main",
+				Image: "busybox",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")},
+				},
+			}},
+		},
+	}
+	created, err := clientset.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+	env := created.Spec.Containers[0].Env
+	if !slices.ContainsFunc(env, func(e corev1.EnvVar) bool { return e.Name == "CUDA_VISIBLE_DEVICES" }) {
+		t.Errorf("Expected the webhook to inject CUDA_VISIBLE_DEVICES, got %v", env)
+	}
+
+	// The scheduler binds the pod and writes the devices it leased.
+	var bound *corev1.Pod
+	err = wait.PollUntilContextCancel(ctx, 200*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		bound, err = clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return bound.Spec.NodeName != "" && bound.Annotations[util.AnnoAllocated] != "", nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the pod to be bound with an allocation: %v (pod %+v)", err, bound)
+	}
+	if bound.Spec.NodeName != "gpu-node-a" {
+		t.Errorf("Expected the pod on gpu-node-a, got %s", bound.Spec.NodeName)
+	}
+	leases, err := clientset.CoordinationV1().Leases(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list leases: %v", err)
+	}
+	var held []coordv1.Lease
+	for _, l := range leases.Items {
+		if l.Spec.HolderIdentity != nil && *l.Spec.HolderIdentity == string(bound.UID) {
+			held = append(held, l)
+		}
+	}
+	if len(held) != 1 || held[0].Name != lease.LeaseName(lease.DefaultLabelPrefix, "gpu-node-a", 0) {
+		t.Fatalf("Expected the pod to hold lease %s, got %v", lease.LeaseName(lease.DefaultLabelPrefix, "gpu-node-a", 0), held)
+	}
+
+	// No kubelet runs, so only a zero grace period removes the bound pod.
+	if err := clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, *metav1.NewDeleteOptions(0)); err != nil {
+		t.Fatalf("delete pod: %v", err)
+	}
+	err = wait.PollUntilContextCancel(ctx, 500*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		_, err := clientset.CoordinationV1().Leases(pod.Namespace).Get(ctx, held[0].Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		t.Errorf("Expected the lease to be collected after the pod was deleted: %v", err)
+	}
+}
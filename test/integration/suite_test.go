@@ -0,0 +1,322 @@
+//go:build integration
+
+// Package integration runs the webhook and the scheduler plugin against a
+// real API server and etcd started by envtest, to catch what the fake
+// clientsets do not model: informer sync, admission, binding. It needs the
+// envtest binaries:
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path) make test-integration
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app/options"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/yaml"
+
+	apiv1 "github.com/restack/gpu-scheduler/api/v1"
+	"github.com/restack/gpu-scheduler/internal/plugin/gpuclaim"
+)
+
+// schedulerName is the profile the test pods ask for, as in the chart.
+const schedulerName = "gpu-scheduler"
+
+var (
+	clientset kubernetes.Interface
+	crc       crclient.Client
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		fmt.Println("KUBEBUILDER_ASSETS is not set, skipping the integration tests")
+		os.Exit(0)
+	}
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	crds, err := chartCRDs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load CRDs: %v\n", err)
+		return 1
+	}
+	env := &envtest.Environment{
+		CRDs: crds,
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			MutatingWebhooks:   []*admregv1.MutatingWebhookConfiguration{mutatingWebhook()},
+			ValidatingWebhooks: []*admregv1.ValidatingWebhookConfiguration{validatingWebhook()},
+		},
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "start envtest: %v\n", err)
+		return 1
+	}
+	defer func() { _ = env.Stop() }()
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = apiv1.AddToScheme(scheme)
+	if clientset, err = kubernetes.NewForConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "build clientset: %v\n", err)
+		return 1
+	}
+	if crc, err = crclient.New(cfg, crclient.Options{Scheme: scheme}); err != nil {
+		fmt.Fprintf(os.Stderr, "build client: %v\n", err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "gpu-scheduler-integration")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "temp dir: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig, err := writeKubeconfig(env, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write kubeconfig: %v\n", err)
+		return 1
+	}
+	if err := startWebhook(ctx, env, dir, kubeconfig); err != nil {
+		fmt.Fprintf(os.Stderr, "start webhook: %v\n", err)
+		return 1
+	}
+	if err := startScheduler(ctx, dir, kubeconfig); err != nil {
+		fmt.Fprintf(os.Stderr, "start scheduler: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// chartCRDs reads the CRDs the chart installs, dropping the template lines
+// around them.
+func chartCRDs() ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	raw, err := os.ReadFile(filepath.Join("..", "..", "charts", "gpu-scheduler", "templates", "crds.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "{{") {
+			lines = append(lines, line)
+		}
+	}
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	for _, doc := range strings.Split(strings.Join(lines, "\n"), "\n---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.UnmarshalStrict([]byte(doc), crd); err != nil {
+			return nil, err
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// mutatingWebhook mirrors the chart's pod webhook. envtest points it at
+// its local serving address, where startWebhook listens. Failures are not
+// ignored, so a webhook that is down fails the test.
+func mutatingWebhook() *admregv1.MutatingWebhookConfiguration {
+	fail, none := admregv1.Fail, admregv1.SideEffectClassNone
+	return &admregv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu-scheduler-webhook"},
+		Webhooks: []admregv1.MutatingWebhook{{
+			Name:                    "pods.gpu-scheduler.svc",
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects:             &none,
+			FailurePolicy:           &fail,
+			ClientConfig:            webhookClientConfig("/mutate"),
+			Rules:                   podCreateRules(),
+		}},
+	}
+}
+
+// validatingWebhook mirrors the chart's pod validation webhook.
+func validatingWebhook() *admregv1.ValidatingWebhookConfiguration {
+	fail, none := admregv1.Fail, admregv1.SideEffectClassNone
+	return &admregv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu-scheduler-webhook"},
+		Webhooks: []admregv1.ValidatingWebhook{{
+			Name:                    "validate.pods.gpu-scheduler.svc",
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects:             &none,
+			FailurePolicy:           &fail,
+			ClientConfig:            webhookClientConfig("/validate"),
+			Rules:                   podCreateRules(),
+		}},
+	}
+}
+
+func webhookClientConfig(path string) admregv1.WebhookClientConfig {
+	return admregv1.WebhookClientConfig{
+		Service: &admregv1.ServiceReference{Name: "gpu-scheduler-webhook", Namespace: "default", Path: &path},
+	}
+}
+
+func podCreateRules() []admregv1.RuleWithOperations {
+	scope := admregv1.NamespacedScope
+	return []admregv1.RuleWithOperations{{
+		Operations: []admregv1.OperationType{admregv1.Create},
+		Rule: admregv1.Rule{
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+			Scope:       &scope,
+		},
+	}}
+}
+
+// writeKubeconfig writes a kubeconfig for an admin of the test API server,
+// for the webhook and the scheduler to connect with as they would in a
+// cluster.
+func writeKubeconfig(env *envtest.Environment, dir string) (string, error) {
+	user, err := env.AddUser(envtest.User{Name: "gpu-scheduler", Groups: []string{"system:masters"}}, nil)
+	if err != nil {
+		return "", err
+	}
+	raw, err := user.KubeConfig()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "kubeconfig")
+	return path, os.WriteFile(path, raw, 0o600)
+}
+
+// startWebhook builds the webhook binary and runs it on envtest's webhook
+// serving address with the certificate envtest generated, until ctx is done.
+func startWebhook(ctx context.Context, env *envtest.Environment, dir, kubeconfig string) error {
+	bin := filepath.Join(dir, "webhook")
+	var out bytes.Buffer
+	build := exec.CommandContext(ctx, "go", "build", "-o", bin, "github.com/restack/gpu-scheduler/cmd/webhook")
+	build.Stdout, build.Stderr = &out, &out
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("go build: %v\n%s", err, out.String())
+	}
+
+	opts := env.WebhookInstallOptions
+	addr := opts.LocalServingHost + ":" + strconv.Itoa(opts.LocalServingPort)
+	cmd := exec.CommandContext(ctx, bin,
+		"--addr="+addr,
+		"--tls-cert-file="+filepath.Join(opts.LocalServingCertDir, "tls.crt"),
+		"--tls-private-key-file="+filepath.Join(opts.LocalServingCertDir, "tls.key"),
+		"--metrics-addr=127.0.0.1:0",
+		"--kubeconfig="+kubeconfig,
+	)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+
+	// The API server must reach the webhook before the first pod is created.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("webhook not serving on %s: %v", addr, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// schedulerConfig enables the plugin at every extension point, as the
+// chart's scheduler ConfigMap does.
+const schedulerConfig = `apiVersion: kubescheduler.config.k8s.io/v1
+kind: KubeSchedulerConfiguration
+clientConnection:
+  kubeconfig: %s
+leaderElection:
+  leaderElect: false
+profiles:
+  - schedulerName: ` + schedulerName + `
+    plugins:
+      preFilter:
+        enabled:
+          - name: GpuClaimPlugin
+      filter:
+        enabled:
+          - name: GpuClaimPlugin
+      postFilter:
+        enabled:
+          - name: GpuClaimPlugin
+      preScore:
+        enabled:
+          - name: GpuClaimPlugin
+      score:
+        enabled:
+          - name: GpuClaimPlugin
+      reserve:
+        enabled:
+          - name: GpuClaimPlugin
+      permit:
+        enabled:
+          - name: GpuClaimPlugin
+      preBind:
+        enabled:
+          - name: GpuClaimPlugin
+      bind:
+        disabled:
+          - name: DefaultBinder
+        enabled:
+          - name: GpuClaimPlugin
+          - name: DefaultBinder
+    pluginConfig:
+      - name: GpuClaimPlugin
+        args:
+          packingStrategy: binpack
+`
+
+// startScheduler runs kube-scheduler with the plugin in this process until
+// ctx is done. The lease GC runs every second, so deleted pods' leases go
+// quickly.
+func startScheduler(ctx context.Context, dir, kubeconfig string) error {
+	path := filepath.Join(dir, "scheduler-config.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(schedulerConfig, kubeconfig)), 0o600); err != nil {
+		return err
+	}
+	opts := options.NewOptions()
+	opts.ConfigFile = path
+	opts.SecureServing.BindPort = 0
+	plugin := gpuclaim.NewFactory(&gpuclaim.Options{
+		LeaseGCInterval: time.Second,
+		LeaseGCGrace:    time.Second,
+	})
+	cc, sched, err := app.Setup(ctx, opts, app.WithPlugin(gpuclaim.Name, plugin))
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := app.Run(ctx, cc, sched); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "scheduler stopped: %v\n", err)
+		}
+	}()
+	return nil
+}